@@ -0,0 +1,22 @@
+package main
+
+import "strings"
+
+// parseBridged turns a bridged event, "<channel> <name>|<content>", into the chat message of the remote user it relays.
+// Returns false for anything else.
+func parseBridged(msg Message) (Message, bool) {
+	if msg.SenderName != "system" || msg.Channel != "bridged" {
+		return Message{}, false
+	}
+
+	channel, rest, ok := strings.Cut(msg.Content, " ")
+	if !ok {
+		return Message{}, false
+	}
+	name, content, ok := strings.Cut(rest, "|")
+	if !ok || channel == "" || name == "" {
+		return Message{}, false
+	}
+
+	return Message{SenderName: name, Channel: channel, Content: content, SentAt: msg.SentAt, Bridged: true}, true
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+var (
+	browserTitleStyle    = lipgloss.NewStyle().Bold(true)
+	browserSelectedStyle = lipgloss.NewStyle().Reverse(true)
+	browserHintStyle     = lipgloss.NewStyle().Faint(true)
+)
+
+// Replies of servers that can't send the channel list as an event, parsed instead
+const (
+	channelListHeader = "Available channels:"
+	noChannelsReply   = "No channels available."
+)
+
+var (
+	ansiPattern             = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+	channelListEntryPattern = regexp.MustCompile(`^(\S+) \((\d+)\)(.*)$`) // "general (3) [locked] [hidden]"
+)
+
+// channelListing is a channel in the browser, as listed by the server's channel-list event
+type channelListing struct {
+	Name     string `json:"name"`
+	Members  int    `json:"members"`
+	Topic    string `json:"topic"`
+	Locked   bool   `json:"locked"`
+	Password bool   `json:"password"`
+	Hidden   bool   `json:"hidden"`
+	Joined   bool   `json:"joined"`
+}
+
+// channelBrowser lists the server's channels in place of the chat, filtered by what's typed, to join one with Enter
+type channelBrowser struct {
+	channels   []channelListing // As last listed by the server
+	matches    []channelListing // Channels matching the filter, best match first
+	filter     string
+	cursor     int
+	loading    bool   // Waiting for the server to list the channels
+	structured bool   // The server lists channels as an event rather than as text, so listings know about passwords and topics
+	status     string // Why the list is missing or outdated, such as the server's reply while loading
+	passwordOf string // Channel whose password is being typed, empty while browsing
+	password   []rune
+}
+
+// openBrowser shows the channel browser and asks the server for the channel list
+func (m *model) openBrowser() {
+	m.browser = &channelBrowser{structured: m.server.enabled(FeatureChannelList)}
+	m.refreshBrowser()
+}
+
+// refreshBrowser asks the server for the channel list again, keeping the current one until it arrives
+func (m *model) refreshBrowser() {
+	request := "/channels"
+	if m.browser.structured {
+		request = "/channels --event"
+	}
+	if err := m.outbox.send(outgoingLine{Text: request}); err != nil {
+		m.browser.status = err.Error()
+		return
+	}
+
+	m.pacer.spend(time.Now())
+	m.browser.loading = true
+	m.browser.status = ""
+}
+
+// updateBrowser handles the keys pressed while the channel browser is open
+func (m model) updateBrowser(key tea.KeyMsg) (tea.Model, tea.Cmd) {
+	b := m.browser
+	if b.passwordOf != "" {
+		return m.updatePasswordPrompt(key)
+	}
+
+	switch key.Type {
+	case tea.KeyCtrlC:
+		return m, tea.Quit
+	case tea.KeyEsc, tea.KeyCtrlJ:
+		m.browser = nil
+	case tea.KeyCtrlR:
+		m.refreshBrowser()
+	case tea.KeyUp, tea.KeyCtrlP:
+		b.cursor = max(0, b.cursor-1)
+	case tea.KeyDown, tea.KeyCtrlN:
+		b.cursor = max(0, min(len(b.matches)-1, b.cursor+1))
+	case tea.KeyBackspace:
+		if b.filter != "" {
+			runes := []rune(b.filter)
+			b.setFilter(string(runes[:len(runes)-1]))
+		}
+	case tea.KeyRunes, tea.KeySpace:
+		b.setFilter(b.filter + string(key.Runes))
+	case tea.KeyEnter:
+		if len(b.matches) == 0 {
+			break
+		}
+
+		// Joined channels are only switched to, so their password isn't needed
+		selected := b.matches[b.cursor]
+		if selected.Password && !selected.Joined {
+			b.passwordOf = selected.Name
+			break
+		}
+		m.joinFromBrowser(selected.Name, "")
+	}
+	return m, nil
+}
+
+// updatePasswordPrompt handles the keys pressed while typing the password of the selected channel
+func (m model) updatePasswordPrompt(key tea.KeyMsg) (tea.Model, tea.Cmd) {
+	b := m.browser
+	switch key.Type {
+	case tea.KeyCtrlC:
+		return m, tea.Quit
+	case tea.KeyEsc:
+		b.passwordOf, b.password = "", nil
+	case tea.KeyBackspace:
+		if len(b.password) > 0 {
+			b.password = b.password[:len(b.password)-1]
+		}
+	case tea.KeyRunes:
+		b.password = append(b.password, key.Runes...)
+	case tea.KeyEnter:
+		if len(b.password) == 0 {
+			break
+		}
+		m.joinFromBrowser(b.passwordOf, string(b.password))
+	}
+	return m, nil
+}
+
+// joinFromBrowser joins the channel selected in the browser, or switches to it if it's joined already, and closes the browser
+func (m *model) joinFromBrowser(channel, password string) {
+	line := "/join " + channel
+	if password != "" {
+		line += " " + password
+	}
+	if err := m.outbox.send(outgoingLine{Text: line}); err != nil {
+		m.browser.status = err.Error()
+		return
+	}
+
+	m.pacer.spend(time.Now())
+	m.browser = nil
+}
+
+// setChannels replaces the listed channels, keeping the selection on the same channel if it's still listed
+func (b *channelBrowser) setChannels(channels []channelListing) {
+	selected := ""
+	if b.cursor < len(b.matches) {
+		selected = b.matches[b.cursor].Name
+	}
+
+	b.channels = channels
+	b.loading = false
+	b.status = ""
+	b.match()
+	if index := slices.IndexFunc(b.matches, func(c channelListing) bool { return c.Name == selected }); index != -1 {
+		b.cursor = index
+	}
+}
+
+// setFilter filters the channels again, moving the selection back to the best match
+func (b *channelBrowser) setFilter(filter string) {
+	b.filter = filter
+	b.cursor = 0
+	b.match()
+}
+
+// match keeps the channels whose name fuzzy matches the filter, best match first and then by name
+func (b *channelBrowser) match() {
+	type scored struct {
+		channel channelListing
+		score   int
+	}
+
+	var matches []scored
+	for _, channel := range b.channels {
+		if score, ok := fuzzyMatch(b.filter, channel.Name); ok {
+			matches = append(matches, scored{channel, score})
+		}
+	}
+	slices.SortStableFunc(matches, func(a, b scored) int {
+		if a.score != b.score {
+			return b.score - a.score
+		}
+		return strings.Compare(a.channel.Name, b.channel.Name)
+	})
+
+	b.matches = b.matches[:0]
+	for _, match := range matches {
+		b.matches = append(b.matches, match.channel)
+	}
+	b.cursor = max(0, min(len(b.matches)-1, b.cursor))
+}
+
+// fuzzyMatch reports whether the letters of the pattern appear in the text in order, ignoring case, and scores the match:
+// letters following each other and matching at the start of the text or a word score higher
+func fuzzyMatch(pattern, text string) (int, bool) {
+	if pattern == "" {
+		return 0, true
+	}
+
+	patternRunes := []rune(strings.ToLower(pattern))
+	textRunes := []rune(strings.ToLower(text))
+	score, matched, previous := 0, 0, -2
+	for i, r := range textRunes {
+		if matched == len(patternRunes) {
+			break
+		}
+		if r != patternRunes[matched] {
+			continue
+		}
+
+		score++
+		if i == previous+1 {
+			score += 3
+		}
+		if i == 0 || !unicode.IsLetter(textRunes[i-1]) && !unicode.IsDigit(textRunes[i-1]) {
+			score += 2
+		}
+		previous = i
+		matched++
+	}
+	return score, matched == len(patternRunes)
+}
+
+// parseChannelListEvent parses the argument of the channel-list event, a JSON array of channels
+func parseChannelListEvent(argument string) ([]channelListing, error) {
+	var channels []channelListing
+	if err := json.Unmarshal([]byte(argument), &channels); err != nil {
+		return nil, fmt.Errorf("invalid channel list: %w", err)
+	}
+	return channels, nil
+}
+
+// parseChannelListReply parses the reply to /channels of servers that can't send the channel list as an event,
+// reporting whether it was one. These don't tell topics or which channels need a password.
+func parseChannelListReply(content string) ([]channelListing, bool) {
+	content = ansiPattern.ReplaceAllString(content, "")
+	if strings.TrimSpace(content) == noChannelsReply {
+		return []channelListing{}, true
+	}
+
+	rest, ok := strings.CutPrefix(content, channelListHeader)
+	if !ok {
+		return nil, false
+	}
+
+	channels := []channelListing{}
+	for _, line := range strings.Split(rest, "\n") {
+		parts := channelListEntryPattern.FindStringSubmatch(strings.TrimSpace(line))
+		if parts == nil {
+			continue
+		}
+
+		members, _ := strconv.Atoi(parts[2])
+		channels = append(channels, channelListing{
+			Name:    parts[1],
+			Members: members,
+			Locked:  strings.Contains(parts[3], "[locked]"),
+			Hidden:  strings.Contains(parts[3], "[hidden]"),
+		})
+	}
+	return channels, true
+}
+
+// handleBrowserReply shows a reply from the server in the browser while it waits for the channel list,
+// reporting whether the reply was the list and shouldn't be shown in the chat
+func (m *model) handleBrowserReply(content string) bool {
+	b := m.browser
+	if b == nil || !b.loading {
+		return false
+	}
+
+	if !b.structured {
+		if channels, ok := parseChannelListReply(content); ok {
+			b.setChannels(channels)
+			return true
+		}
+	}
+
+	// Such as the cooldown of /channels, which would otherwise go unnoticed behind the browser
+	b.status = ansiPattern.ReplaceAllString(content, "")
+	b.loading = false
+	return false
+}
+
+// View renders the browser in the given size, with the filter on top, the channels under it and the keys at the bottom
+func (b *channelBrowser) View(width, height int) string {
+	lines := []string{browserTitleStyle.Render("Channels") + " " + browserHintStyle.Render("(type to filter)")}
+	if b.passwordOf != "" {
+		lines = append(lines, fmt.Sprintf("Password for '%s': %s", b.passwordOf, strings.Repeat("*", len(b.password))))
+	} else {
+		lines = append(lines, "> "+b.filter)
+	}
+	lines = append(lines, "")
+
+	switch {
+	case b.loading && b.channels == nil:
+		lines = append(lines, "Loading the channels...")
+	case len(b.channels) == 0 && b.channels != nil:
+		lines = append(lines, "There are no channels on the server yet. Use /join <channel> to create one.")
+	case len(b.matches) == 0 && b.channels != nil:
+		lines = append(lines, fmt.Sprintf("No channels match '%s'.", b.filter))
+	}
+
+	// The list scrolls to keep the selected channel in view
+	listHeight := max(1, height-len(lines)-2)
+	start := max(0, b.cursor-listHeight+1)
+	for i := start; i < len(b.matches) && i < start+listHeight; i++ {
+		line := b.matches[i].describe()
+		if runes := []rune(line); len(runes) > width && width > 1 {
+			line = string(runes[:width-1]) + "…"
+		}
+		if i == b.cursor {
+			line = browserSelectedStyle.Render(line)
+		}
+		lines = append(lines, line)
+	}
+
+	for len(lines) < height-2 {
+		lines = append(lines, "")
+	}
+	if b.status != "" {
+		lines = append(lines, b.status)
+	} else if b.loading && b.channels != nil {
+		lines = append(lines, "Refreshing...")
+	} else {
+		lines = append(lines, "")
+	}
+
+	hints := "↑/↓ select · Enter join · Ctrl+R refresh · Esc close"
+	if b.passwordOf != "" {
+		hints = "Enter join · Esc cancel"
+	}
+	lines = append(lines, browserHintStyle.Render(hints))
+	return strings.Join(lines, "\n")
+}
+
+// describe formats a channel as a line of the browser, e.g. "ops (3) [password] — Deploys and incidents"
+func (c channelListing) describe() string {
+	line := fmt.Sprintf("%s (%d)", c.Name, c.Members)
+	var flags []string
+	if c.Joined {
+		flags = append(flags, "joined")
+	}
+	if c.Locked {
+		flags = append(flags, "locked")
+	}
+	if c.Password {
+		flags = append(flags, "password")
+	}
+	if c.Hidden {
+		flags = append(flags, "hidden")
+	}
+	if len(flags) > 0 {
+		line += " [" + strings.Join(flags, "] [") + "]"
+	}
+	if c.Topic != "" {
+		line += " — " + c.Topic
+	}
+	return line
+}
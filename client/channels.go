@@ -0,0 +1,56 @@
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// parseChannelPrefix splits a message like "#ops deploy is done" into the channel the server routes it to and its content
+func parseChannelPrefix(text string) (channel, content string, ok bool) {
+	after, found := strings.CutPrefix(strings.TrimSpace(text), "#")
+	if !found {
+		return "", "", false
+	}
+
+	channel, content, found = strings.Cut(after, " ")
+	content = strings.TrimSpace(content)
+	if !found || channel == "" || content == "" {
+		return "", "", false
+	}
+	return channel, content, true
+}
+
+// joinChannel records a channel we joined, as told by the server
+func (m *model) joinChannel(channel string) {
+	if !slices.Contains(m.joinedChannels, channel) {
+		m.joinedChannels = append(m.joinedChannels, channel)
+	}
+}
+
+// leaveChannel forgets a channel we left or were removed from
+func (m *model) leaveChannel(channel string) {
+	m.joinedChannels = slices.DeleteFunc(m.joinedChannels, func(joined string) bool {
+		return joined == channel
+	})
+}
+
+// showChannelTags tells whether incoming messages are prefixed with their channel.
+// With a single channel joined every message comes from it, so the tag is left out.
+func (m *model) showChannelTags() bool {
+	return len(m.joinedChannels) != 1
+}
+
+// completeChannel autocompletes a "#prefix" typed at the start of the input with the first joined channel it matches
+func (m *model) completeChannel(input string) (string, bool) {
+	prefix, ok := strings.CutPrefix(input, "#")
+	if !ok || strings.Contains(prefix, " ") {
+		return "", false
+	}
+
+	for _, channel := range slices.Sorted(slices.Values(m.joinedChannels)) {
+		if strings.HasPrefix(channel, prefix) {
+			return "#" + channel + " ", true
+		}
+	}
+	return "", false
+}
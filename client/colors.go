@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
+)
+
+var (
+	// basicColors are the colors available on terminals that only support the standard ANSI palette
+	basicColors = []string{"1", "2", "3", "4", "5", "6"}
+
+	// monochromeStyles differentiate clients on terminals without color support
+	monochromeStyles = []lipgloss.Style{
+		lipgloss.NewStyle().Bold(true),
+		lipgloss.NewStyle().Underline(true),
+		lipgloss.NewStyle().Italic(true),
+		lipgloss.NewStyle().Bold(true).Underline(true),
+		lipgloss.NewStyle().Bold(true).Italic(true),
+		lipgloss.NewStyle().Underline(true).Italic(true),
+	}
+)
+
+// setupColors selects the color profile used to render the UI based on the --color flag.
+// In auto mode the profile is detected from the terminal, which disables styling when the output is piped.
+func setupColors(mode string) error {
+	switch mode {
+	case "auto":
+		if os.Getenv("NO_COLOR") != "" {
+			lipgloss.SetColorProfile(termenv.Ascii)
+		}
+	case "always":
+		if lipgloss.ColorProfile() == termenv.Ascii {
+			lipgloss.SetColorProfile(termenv.ANSI256)
+		}
+	case "never":
+		lipgloss.SetColorProfile(termenv.Ascii)
+	default:
+		return fmt.Errorf("invalid color mode '%s', must be one of auto, always or never", mode)
+	}
+
+	if lipgloss.ColorProfile() == termenv.Ascii {
+		// Without colors, use text attributes to tell the different kinds of messages apart
+		senderStyle = senderStyle.Bold(true)
+		serverStyle = serverStyle.Bold(true).Underline(true)
+		timeStyle = timeStyle.Faint(true)
+	}
+
+	return nil
+}
+
+// newClientStyle returns the style for the next client based on the number of clients seen so far
+func newClientStyle() lipgloss.Style {
+	return paletteStyle(len(clients))
+}
+
+// paletteStyle returns the style at the given palette index,
+// using only what the terminal's color profile is able to display
+func paletteStyle(index int) lipgloss.Style {
+	switch lipgloss.ColorProfile() {
+	case termenv.Ascii:
+		return monochromeStyles[index%len(monochromeStyles)]
+	case termenv.ANSI:
+		// Alternate between normal and bold to double the number of distinguishable styles
+		style := lipgloss.NewStyle().Foreground(lipgloss.Color(basicColors[index%len(basicColors)]))
+		if (index/len(basicColors))%2 == 1 {
+			style = style.Bold(true)
+		}
+		return style
+	default:
+		return lipgloss.NewStyle().Foreground(lipgloss.Color(brightColors[index%len(brightColors)]))
+	}
+}
+
+// setChannelColor records the palette color the server assigned to a member of the channel.
+// Only messages rendered afterwards use it, lines already in the transcript keep their color.
+func setChannelColor(channel, username string, index int) {
+	if channelColors[channel] == nil {
+		channelColors[channel] = make(map[string]int)
+	}
+	channelColors[channel][username] = index
+}
+
+// channelSenderStyle returns the style of a sender in the channel, if the server assigned it a color.
+// Sender names may start with the member's icon, so the username is looked up without it too.
+func channelSenderStyle(channel, senderName string) (lipgloss.Style, bool) {
+	colors, ok := channelColors[channel]
+	if !ok {
+		return lipgloss.Style{}, false
+	}
+
+	index, ok := colors[senderName]
+	if !ok {
+		_, username, found := strings.Cut(senderName, " ")
+		if !found {
+			return lipgloss.Style{}, false
+		}
+		if index, ok = colors[username]; !ok {
+			return lipgloss.Style{}, false
+		}
+	}
+	return paletteStyle(index), true
+}
+
+var (
+	// colorTagPattern matches the color the server appends to its messages about a channel, e.g. "You have joined channel 'general' [color:42]"
+	colorTagPattern = regexp.MustCompile(` ?\[color:(none|#[0-9a-fA-F]{6}|\d{1,3})\]`)
+	// quotedChannelPattern matches the channel quoted in those messages, whose name the server may have styled
+	quotedChannelPattern = regexp.MustCompile(`'(?:\x1b\[[0-9;]*m)*([^'\x1b]+)(?:\x1b\[[0-9;]*m)*'`)
+)
+
+// parseColorTag returns the color of the message's color tag, "none" if the color was removed,
+// along with the message without the tag
+func parseColorTag(content string) (string, string, bool) {
+	match := colorTagPattern.FindStringSubmatchIndex(content)
+	if match == nil {
+		return "", content, false
+	}
+	return content[match[2]:match[3]], content[:match[0]] + content[match[1]:], true
+}
+
+// applyColorTag records the color of the channel quoted in a message from the server, returning the message without its color tag
+func applyColorTag(content string) string {
+	color, rest, ok := parseColorTag(content)
+	if !ok {
+		return content
+	}
+
+	quoted := quotedChannelPattern.FindStringSubmatch(rest)
+	if quoted == nil {
+		return rest
+	}
+
+	if color == "none" {
+		delete(channelNames, quoted[1])
+	} else {
+		channelNames[quoted[1]] = lipgloss.Color(color)
+	}
+	return rest
+}
+
+// channelNameStyle returns the style of the channel's name in the prefix of its messages
+func channelNameStyle(channel string) lipgloss.Style {
+	if color, ok := channelNames[channel]; ok {
+		return lipgloss.NewStyle().Foreground(color)
+	}
+	return channelStyle
+}
+
+// colorProfileName returns the name of the color profile sent to the server with TERMCOLOR
+func colorProfileName(profile termenv.Profile) string {
+	switch profile {
+	case termenv.TrueColor:
+		return "truecolor"
+	case termenv.ANSI256:
+		return "ansi256"
+	case termenv.ANSI:
+		return "ansi"
+	default:
+		return "ascii"
+	}
+}
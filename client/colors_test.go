@@ -0,0 +1,149 @@
+package main
+
+import (
+	"maps"
+	"testing"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
+)
+
+// withChannelNames runs the test with the given channel colors, and colors rendered as on a 256 color terminal
+func withChannelNames(t *testing.T, colors map[string]lipgloss.Color) {
+	t.Helper()
+	previousNames, previousProfile := channelNames, lipgloss.ColorProfile()
+	channelNames = maps.Clone(colors)
+	if channelNames == nil {
+		channelNames = make(map[string]lipgloss.Color)
+	}
+	lipgloss.SetColorProfile(termenv.ANSI256)
+	t.Cleanup(func() {
+		channelNames = previousNames
+		lipgloss.SetColorProfile(previousProfile)
+	})
+}
+
+func TestParseColorTag(t *testing.T) {
+	tests := []struct {
+		content   string
+		wantColor string
+		wantRest  string
+		wantOK    bool
+	}{
+		{content: "You have joined channel 'general' [color:42]", wantColor: "42", wantRest: "You have joined channel 'general'", wantOK: true},
+		{content: "Channel 'general' is now #ff8800 [color:#ff8800]", wantColor: "#ff8800", wantRest: "Channel 'general' is now #ff8800", wantOK: true},
+		{content: "Color of 'general' removed [color:none]", wantColor: "none", wantRest: "Color of 'general' removed", wantOK: true},
+		{content: "[color:7] 'general'", wantColor: "7", wantRest: " 'general'", wantOK: true},
+		{content: "You have joined channel 'general'", wantRest: "You have joined channel 'general'"},
+		{content: "too long [color:4200]", wantRest: "too long [color:4200]"},
+		{content: "not a hex color [color:#ff88]", wantRest: "not a hex color [color:#ff88]"},
+		{content: "not a color [color:red]", wantRest: "not a color [color:red]"},
+	}
+
+	for _, test := range tests {
+		color, rest, ok := parseColorTag(test.content)
+		if color != test.wantColor || rest != test.wantRest || ok != test.wantOK {
+			t.Errorf("parseColorTag(%q) = %q, %q, %t, want %q, %q, %t", test.content, color, rest, ok, test.wantColor, test.wantRest, test.wantOK)
+		}
+	}
+}
+
+func TestApplyColorTag(t *testing.T) {
+	styledName := channelStyle.Render("general")
+
+	tests := []struct {
+		name     string
+		existing map[string]lipgloss.Color
+		content  string
+		want     string
+		wantName map[string]lipgloss.Color
+	}{
+		{
+			name:     "set",
+			content:  "You have joined channel 'general' [color:42]",
+			want:     "You have joined channel 'general'",
+			wantName: map[string]lipgloss.Color{"general": "42"},
+		},
+		{
+			name:     "styled channel name",
+			content:  "You have joined channel '" + styledName + "' [color:#ff8800]",
+			want:     "You have joined channel '" + styledName + "'",
+			wantName: map[string]lipgloss.Color{"general": "#ff8800"},
+		},
+		{
+			name:     "changed",
+			existing: map[string]lipgloss.Color{"general": "42", "random": "7"},
+			content:  "Channel 'general' color set [color:9]",
+			want:     "Channel 'general' color set",
+			wantName: map[string]lipgloss.Color{"general": "9", "random": "7"},
+		},
+		{
+			name:     "removed",
+			existing: map[string]lipgloss.Color{"general": "42", "random": "7"},
+			content:  "Channel 'general' color removed [color:none]",
+			want:     "Channel 'general' color removed",
+			wantName: map[string]lipgloss.Color{"random": "7"},
+		},
+		{
+			name:     "no channel",
+			content:  "Colors are on [color:42]",
+			want:     "Colors are on",
+			wantName: map[string]lipgloss.Color{},
+		},
+		{
+			name:     "no tag",
+			existing: map[string]lipgloss.Color{"general": "42"},
+			content:  "You have joined channel 'general'",
+			want:     "You have joined channel 'general'",
+			wantName: map[string]lipgloss.Color{"general": "42"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			withChannelNames(t, test.existing)
+
+			if got := applyColorTag(test.content); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+			if !maps.Equal(channelNames, test.wantName) {
+				t.Errorf("channel colors are %v, want %v", channelNames, test.wantName)
+			}
+		})
+	}
+}
+
+func TestRenderChannelColor(t *testing.T) {
+	tests := []struct {
+		name       string
+		msg        Message
+		tagged     bool
+		wantPrefix func() string // Rendered once colors are set up
+	}{
+		{
+			name:       "colored channel",
+			msg:        Message{SenderName: "Server", Channel: "general", Content: "hi"},
+			tagged:     true,
+			wantPrefix: func() string { return lipgloss.NewStyle().Foreground(lipgloss.Color("42")).Render("#general") + " " },
+		},
+		{
+			name:       "uncolored channel",
+			msg:        Message{SenderName: "Server", Channel: "random", Content: "hi"},
+			tagged:     true,
+			wantPrefix: func() string { return channelStyle.Render("#random") + " " },
+		},
+		{name: "untagged", msg: Message{SenderName: "Server", Channel: "general", Content: "hi"}, wantPrefix: func() string { return "" }},
+		{name: "no channel", msg: Message{SenderName: "Server", Content: "hi"}, tagged: true, wantPrefix: func() string { return "" }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			withChannelNames(t, map[string]lipgloss.Color{"general": "42"})
+
+			got := renderTaggedMessage(test.msg, test.tagged)
+			if want := test.wantPrefix() + serverStyle.Render("[Server]: ") + "hi"; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
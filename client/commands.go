@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	defaultPrompt      = "| "
+	defaultPlaceholder = "Send a message..."
+	maxPromptLength    = 10
+)
+
+// handleLocalCommand runs commands that are handled by the client and never sent to the server.
+// It returns false if the input is not a local command.
+func (m *model) handleLocalCommand(input string) bool {
+	command := strings.Fields(input)[0]
+
+	switch command {
+	case "/setprompt":
+		prompt, ok := strings.CutPrefix(input, "/setprompt ")
+		if !ok {
+			m.err = errors.New("usage: /setprompt <text>")
+			return true
+		}
+
+		if err := validatePrompt(prompt); err != nil {
+			m.err = err
+			return true
+		}
+
+		m.setPrompt(prompt)
+		m.addNotice(fmt.Sprintf("Prompt changed to '%s'", prompt))
+	case "/resetprompt":
+		m.setPrompt(defaultPrompt)
+		m.addNotice("Prompt reset")
+	case "/version":
+		m.addNotice(m.server.describe())
+	case "/dm":
+		args := strings.Fields(input)[1:]
+		switch {
+		case len(args) == 1:
+			m.openDM(args[0])
+		case len(args) == 0 && m.dmPeer != "":
+			m.closeDM()
+		default:
+			m.err = errors.New("usage: /dm <username>, or /dm alone to go back to the channels")
+		}
+	case "/server":
+		m.switchServer(strings.Fields(input)[1:])
+	case "/help":
+		// The server lists its commands, only the keys handled by the client are shown here
+		m.addNotice("Scroll keys: " + describeScrollKeys(m.viewport.KeyMap))
+		m.addNotice("Whispers: /dm <username> or Ctrl+O opens a conversation, where messages are whispered to that user; Esc goes back to the channels")
+		m.addNotice("Channels: Ctrl+J browses the server's channels, type to filter them and press Enter to join one")
+		return false
+	default:
+		return false
+	}
+
+	return true
+}
+
+// validatePrompt checks that the prompt fits in the input area
+func validatePrompt(prompt string) error {
+	if prompt == "" {
+		return errors.New("the prompt cannot be empty")
+	}
+
+	if strings.ContainsAny(prompt, "\r\n") {
+		return errors.New("the prompt cannot contain newlines")
+	}
+
+	if utf8.RuneCountInString(prompt) > maxPromptLength {
+		return fmt.Errorf("the prompt cannot exceed %d characters", maxPromptLength)
+	}
+
+	return nil
+}
+
+// setPrompt changes the input prompt and saves it to the config
+func (m *model) setPrompt(prompt string) {
+	m.promptText = prompt
+	m.textarea.Prompt = m.promptText
+	m.textarea.SetWidth(m.viewport.Width) // Recalculates the space taken by the prompt
+
+	m.config.Prompt = prompt
+	if prompt == defaultPrompt {
+		m.config.Prompt = ""
+	}
+
+	if err := saveConfig(m.config); err != nil {
+		m.err = fmt.Errorf("failed to save config: %w", err)
+	}
+}
+
+// addNotice adds a message from the client itself to the transcript
+func (m *model) addNotice(text string) {
+	m.addMessage(time.Now(), renderMessage(Message{SenderName: ".", Content: text}))
+	m.syncTranscript()
+	m.viewport.GotoBottom()
+}
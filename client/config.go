@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Config holds the client settings persisted between sessions
+type Config struct {
+	Timezone string             `json:"timezone"`           // IANA timezone used to display message times, defaults to the local timezone
+	Prompt   string             `json:"prompt,omitempty"`   // Text shown before the input, set with /setprompt
+	Profiles map[string]Profile `json:"profiles,omitempty"` // Connection settings by name, see profiles.go
+	NoTitle  bool               `json:"no_title,omitempty"` // Like -no-title, leave the terminal title alone
+	Bell     bool               `json:"bell,omitempty"`     // Like -bell, ring the bell for whispers while the terminal isn't focused
+}
+
+func configPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "go-tcp-chat", "config.json"), nil
+}
+
+// loadConfig reads the config file, returning the default config if it doesn't exist yet
+func loadConfig() (Config, error) {
+	var config Config
+
+	path, err := configPath()
+	if err != nil {
+		return config, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return config, nil
+		}
+		return config, err
+	}
+
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, err
+	}
+
+	return config, nil
+}
+
+func saveConfig(config Config) error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0o644)
+}
@@ -0,0 +1,503 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxDaemonFrames    = 10000            // Messages kept to replay when a terminal attaches, older ones are dropped
+	daemonStartTimeout = 10 * time.Second // How long starting a daemon waits for its socket to accept connections
+	daemonQuitTimeout  = 2 * time.Second  // How long a killed daemon waits for the server to close the connection after /quit
+	resumeTimeout      = 60 * time.Second // How long the daemon keeps trying to resume the session once the connection drops
+	resumeRetry        = 3 * time.Second  // Time between attempts to reconnect to the server
+)
+
+// daemonFeatures are the features the daemon uses, it's the only client that reconnects on its own to resume the session
+const daemonFeatures = supportedFeatures | FeatureResume
+
+// welcomePrompt starts the message that ends the server's greeting of a new connection
+const welcomePrompt = "Welcome! Please set your username"
+
+// Lines a terminal sends to the daemon's socket first, to say what it wants
+const (
+	daemonAttach = "ATTACH"
+	daemonKill   = "KILL"
+)
+
+// stickyEvents are the events replayed before the buffered messages, so dropping old messages never loses them.
+// Only the latest of each is kept.
+var stickyEvents = []string{"features", "rate-limit", "max-message-length", "active-channel"}
+
+// daemon holds a chat session in the background: it keeps the connection to the server and a transcript,
+// which terminals attaching to its Unix socket are sent before the live messages. Terminals talk to it
+// with the same protocol as to the server, so the TUI works the same attached or not.
+type daemon struct {
+	dialer   Dialer
+	address  string
+	listener net.Listener
+	stopOnce sync.Once
+	done     chan struct{} // Closed once the connection to the server is gone
+
+	// Only accessed from readServer
+	resumeToken string // Token to resume the session with if the connection drops, empty if the server didn't give one
+	closing     bool   // The server said it's closing the connection, so the session can't be resumed
+	greeting    bool   // Set while the server greets the new connection of a resumed session, which terminals already saw
+
+	mu       sync.Mutex
+	server   net.Conn // Replaced when the session is resumed on a new connection
+	quitting bool     // Set once the user quits, so the session isn't resumed when the server closes the connection
+	protocol serverProtocol
+	sticky   map[string]frame // Latest of each sticky event
+	frames   []frame          // Buffered messages, oldest first
+	attached net.Conn         // Terminal attached to the session, nil while detached
+}
+
+// defaultSocketPath is where the daemon listens unless told otherwise, one per user
+func defaultSocketPath() string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf("go-tcp-chat-%d.sock", os.Getuid()))
+}
+
+// runDaemon starts a session daemon, in the background unless --foreground is given. It returns the exit status.
+func runDaemon(args []string) int {
+	flags := flag.NewFlagSet("daemon", flag.ContinueOnError)
+	address := flags.String("host", host, "Address of the server")
+	proxyURL := flags.String("proxy", "", "Connect through a SOCKS5 proxy, e.g. socks5://localhost:1080")
+	socket := flags.String("socket", defaultSocketPath(), "Unix socket terminals attach to")
+	foreground := flags.Bool("foreground", false, "Run the daemon in the foreground instead of detaching it from the terminal")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if err := checkSocketFree(*socket); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	dialer, err := newDialer(*proxyURL)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if !*foreground {
+		return startDaemon(*address, *proxyURL, *socket)
+	}
+
+	listener, err := net.Listen("unix", *socket)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to listen on the session socket:", err)
+		return 1
+	}
+
+	conn, frames, err := connectToServer(dialer, *address, daemonStartTimeout, daemonFeatures)
+	if err != nil {
+		listener.Close()
+		return 1
+	}
+
+	d := &daemon{
+		dialer:   dialer,
+		address:  *address,
+		server:   conn,
+		listener: listener,
+		done:     make(chan struct{}),
+		sticky:   make(map[string]frame),
+	}
+	for _, f := range frames {
+		d.receive(f)
+	}
+	go d.readServer()
+	d.acceptTerminals()
+	return 0
+}
+
+// checkSocketFree makes sure no daemon is listening on the socket, removing it if it was left behind by one that crashed
+func checkSocketFree(socket string) error {
+	if _, err := os.Stat(socket); err != nil {
+		return nil
+	}
+
+	if conn, err := net.Dial("unix", socket); err == nil {
+		conn.Close()
+		return fmt.Errorf("a session is already running on %s, use 'attach' to open it or 'kill' to stop it", socket)
+	}
+	return os.Remove(socket)
+}
+
+// startDaemon runs the daemon again in the foreground of a detached process, returning once its socket accepts connections
+func startDaemon(address, proxyURL, socket string) int {
+	executable, err := os.Executable()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to find the client executable:", err)
+		return 1
+	}
+
+	logPath := socket + ".log"
+	logFile, err := os.Create(logPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to create the daemon log:", err)
+		return 1
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(executable, "daemon", "--foreground", "--host", address, "--proxy", proxyURL, "--socket", socket)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = detachedProcAttr()
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to start the daemon:", err)
+		return 1
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+
+	deadline := time.After(daemonStartTimeout)
+	for {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			conn.Close()
+			fmt.Printf("Session started in the background (pid %d). Open it with 'attach'.\n", cmd.Process.Pid)
+			return 0
+		}
+
+		select {
+		case <-exited:
+			fmt.Fprintf(os.Stderr, "The daemon exited while starting, see %s\n", logPath)
+			return 1
+		case <-deadline:
+			fmt.Fprintf(os.Stderr, "The daemon didn't start in time, see %s\n", logPath)
+			return 1
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// readServer buffers what the server sends and forwards it to the attached terminal, answering pings itself
+// so the session stays alive while detached
+func (d *daemon) readServer() {
+	defer d.stop()
+
+	for {
+		body, sentAt, err := readFrame(d.serverConn())
+		if err != nil {
+			if d.resumeSession() {
+				continue
+			}
+			return
+		}
+		d.receive(frame{Body: body, SentAt: sentAt})
+	}
+}
+
+// serverConn returns the current connection to the server
+func (d *daemon) serverConn() net.Conn {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.server
+}
+
+// resumeSession reconnects to the server after the connection dropped and asks it to resume the session,
+// retrying for up to resumeTimeout. Returns false if the session can't be resumed, which ends the daemon.
+// The server tells the attached terminal whether it resumed the session or it expired.
+func (d *daemon) resumeSession() bool {
+	d.mu.Lock()
+	quitting := d.quitting
+	d.mu.Unlock()
+	if d.resumeToken == "" || d.closing || quitting {
+		return false
+	}
+
+	d.notify("Lost the connection to the server, trying to resume the session...")
+	deadline := time.Now().Add(resumeTimeout)
+	for time.Now().Before(deadline) {
+		conn, frames, err := connectToServer(d.dialer, d.address, resumeRetry, daemonFeatures)
+		if err == nil {
+			if _, err := fmt.Fprintf(conn, "RESUME %s\n", d.resumeToken); err == nil {
+				d.mu.Lock()
+				d.server.Close()
+				d.server = conn
+				d.mu.Unlock()
+
+				d.greeting = true
+				for _, f := range frames {
+					d.receive(f)
+				}
+				return true
+			}
+			conn.Close()
+		}
+
+		select {
+		case <-d.done:
+			return false
+		case <-time.After(resumeRetry):
+		}
+	}
+
+	d.notify("Failed to resume the session.")
+	return false
+}
+
+// notify shows a message from the daemon itself in the session, like one from the server
+func (d *daemon) notify(text string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f := frame{Body: "Server||" + text, SentAt: time.Now()}
+	d.record(f, Message{})
+	d.forward(f)
+}
+
+// receive answers pings from the server and passes everything else on to the terminals
+func (d *daemon) receive(f frame) {
+	msg, ok := parseMessage(f.Body, f.SentAt)
+	if d.greeting && ok && msg.SenderName != "system" {
+		// The greeting of a new connection ends by asking for a username, which the resumed session already has
+		d.greeting = !strings.HasPrefix(msg.Content, welcomePrompt)
+		return
+	}
+	if ok && msg.SenderName == "system" {
+		switch msg.Channel {
+		case "ping":
+			if d.protocol.enabled(FeatureHeartbeat) {
+				d.serverConn().Write([]byte("PONG\n"))
+			}
+			return
+		case "session":
+			// Kept to resume the session with, terminals have no use for it
+			d.resumeToken = msg.Content
+			return
+		case "close":
+			d.closing = true
+		case "features":
+			if protocol, err := parseFeatures(msg.Content); err == nil {
+				d.protocol = protocol
+			}
+		}
+	}
+
+	d.mu.Lock()
+	d.record(f, msg)
+	d.forward(f)
+	d.mu.Unlock()
+}
+
+// record buffers a message to replay to terminals that attach later. The caller must hold mu.
+func (d *daemon) record(f frame, msg Message) {
+	if msg.SenderName == "system" && slices.Contains(stickyEvents, msg.Channel) {
+		d.sticky[msg.Channel] = f
+		return
+	}
+
+	d.frames = append(d.frames, f)
+	if len(d.frames) > maxDaemonFrames {
+		d.frames = d.frames[len(d.frames)-maxDaemonFrames:]
+	}
+}
+
+// forward sends a message to the attached terminal, detaching it if it can't keep up. The caller must hold mu.
+func (d *daemon) forward(f frame) {
+	if d.attached == nil {
+		return
+	}
+
+	if err := writeFrame(d.attached, f.Body, f.SentAt); err != nil {
+		d.attached.Close()
+		d.attached = nil
+	}
+}
+
+// acceptTerminals handles the terminals connecting to the socket until the daemon stops
+func (d *daemon) acceptTerminals() {
+	for {
+		conn, err := d.listener.Accept()
+		if err != nil {
+			return
+		}
+		go d.handleTerminal(conn)
+	}
+}
+
+func (d *daemon) handleTerminal(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	hello, err := reader.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	switch strings.TrimSpace(hello) {
+	case daemonKill:
+		conn.Close()
+		d.quit()
+	case daemonAttach:
+		d.attach(conn, reader)
+	default:
+		conn.Close()
+	}
+}
+
+// attach replays the session to the terminal and relays what it sends to the server until it detaches.
+// Only one terminal can be attached at a time, others are turned away.
+func (d *daemon) attach(conn net.Conn, reader *bufio.Reader) {
+	d.mu.Lock()
+	if d.attached != nil {
+		d.mu.Unlock()
+		writeFrame(conn, "system|close|Another terminal is attached to this session.", time.Now())
+		conn.Close()
+		return
+	}
+
+	d.attached = conn
+	for _, event := range stickyEvents {
+		if f, ok := d.sticky[event]; ok {
+			d.forward(f)
+		}
+	}
+	for _, f := range d.frames {
+		d.forward(f)
+	}
+	d.mu.Unlock()
+
+	defer func() {
+		d.mu.Lock()
+		if d.attached == conn {
+			d.attached = nil
+		}
+		d.mu.Unlock()
+		conn.Close()
+	}()
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return // Detached
+		}
+
+		// Pings are answered by the daemon, so the terminal's answers aren't needed
+		if strings.TrimSpace(line) == "PONG" {
+			continue
+		}
+
+		// Keep what the user typed for the next terminal that attaches, the TUI shows it right away
+		if typed := strings.TrimSpace(line); typed != "" && !isAutomaticLine(typed) {
+			d.mu.Lock()
+			d.record(frame{Body: ".||You: " + typed, SentAt: time.Now()}, Message{})
+			d.mu.Unlock()
+		}
+
+		if _, err := d.serverConn().Write([]byte(line)); err != nil {
+			return
+		}
+	}
+}
+
+// isAutomaticLine reports whether the line was sent by the client on its own rather than typed by the user
+func isAutomaticLine(line string) bool {
+	return strings.HasPrefix(line, "READ_ACK ") || strings.HasPrefix(line, "TERMCOLOR ")
+}
+
+// quit leaves the server with /quit, giving it a moment to close the connection before closing it anyway
+func (d *daemon) quit() {
+	d.mu.Lock()
+	d.quitting = true
+	d.mu.Unlock()
+	d.serverConn().Write([]byte("/quit\n"))
+
+	select {
+	case <-d.done:
+	case <-time.After(daemonQuitTimeout):
+		d.stop()
+	}
+}
+
+// stop closes the session, making the daemon exit
+func (d *daemon) stop() {
+	d.stopOnce.Do(func() {
+		close(d.done)
+		d.listener.Close()
+
+		d.mu.Lock()
+		d.server.Close()
+		if d.attached != nil {
+			d.attached.Close()
+			d.attached = nil
+		}
+		d.mu.Unlock()
+	})
+}
+
+// writeFrame writes a message with the same header the server uses: its length and the time it was sent
+func writeFrame(conn net.Conn, body string, sentAt time.Time) error {
+	header := make([]byte, 12)
+	binary.LittleEndian.PutUint32(header[0:4], uint32(len(body)))
+	binary.LittleEndian.PutUint64(header[4:12], uint64(sentAt.UnixMilli()))
+
+	conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	_, err := conn.Write(append(header, body...))
+	return err
+}
+
+// attachToDaemon connects to the session daemon listening on the socket
+func attachToDaemon(socket string) (net.Conn, error) {
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("no session is running on %s, start one with 'daemon': %w", socket, err)
+	}
+
+	if _, err := conn.Write([]byte(daemonAttach + "\n")); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// runKill stops the session daemon, which leaves the server with /quit. It returns the exit status.
+func runKill(args []string) int {
+	flags := flag.NewFlagSet("kill", flag.ContinueOnError)
+	socket := flags.String("socket", defaultSocketPath(), "Unix socket of the daemon")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	conn, err := net.Dial("unix", *socket)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "No session is running on %s\n", *socket)
+		return 1
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(daemonKill + "\n")); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to stop the session:", err)
+		return 1
+	}
+
+	// The daemon removes its socket once it has stopped
+	deadline := time.Now().Add(daemonQuitTimeout + time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(*socket); errors.Is(err, os.ErrNotExist) {
+			fmt.Println("Session stopped.")
+			return 0
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	fmt.Fprintln(os.Stderr, "The session didn't stop in time.")
+	return 1
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "syscall"
+
+// detachedProcAttr has nothing to set where there are no sessions, the daemon runs as a plain background process
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{}
+}
@@ -0,0 +1,10 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+// detachedProcAttr starts the daemon in a session of its own, so it keeps running when the terminal is closed
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
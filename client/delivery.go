@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"slices"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// deliveryTimeout is how long a message can wait for the server to relay it before it's marked as stale
+const deliveryTimeout = 10 * time.Second
+
+// outboxSize is how many typed lines can wait to be written to the server
+const outboxSize = 64
+
+// deliveryState tells how far one of our messages got on its way to the channel
+type deliveryState int
+
+const (
+	deliveryNone   deliveryState = iota // Not tracked, e.g. commands and other people's messages
+	deliveryQueued                      // Waiting in the outbox
+	deliverySent                        // Written to the connection
+	deliveryAcked                       // Relayed by the server, which echoed it back
+	deliveryFailed                      // Refused by the server or never written
+	deliveryStale                       // No reply from the server within the delivery timeout
+)
+
+var (
+	pendingMarkerStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
+	ackedMarkerStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("2"))
+	failedMarkerStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("1"))
+	staleMarkerStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("3"))
+)
+
+var errOutboxFull = errors.New("too many messages waiting to be sent")
+
+// deliveryMarker renders the state shown after one of our messages, with the reason it failed if it did
+func deliveryMarker(state deliveryState, reason string) string {
+	switch state {
+	case deliveryQueued:
+		return " " + pendingMarkerStyle.Render("○")
+	case deliverySent:
+		return " " + pendingMarkerStyle.Render("✓")
+	case deliveryAcked:
+		return " " + ackedMarkerStyle.Render("✔")
+	case deliveryFailed:
+		return " " + failedMarkerStyle.Render("✗ "+reason)
+	case deliveryStale:
+		return " " + staleMarkerStyle.Render("⚠ no reply from the server")
+	}
+	return ""
+}
+
+// outgoingLine is a line typed by the user, along with the ID of its transcript entry, 0 if it has none
+type outgoingLine struct {
+	ID   int
+	Text string
+}
+
+// writtenMsg reports that a line from the outbox was written to the connection, or why it couldn't be
+type writtenMsg struct {
+	ID  int
+	Err error
+}
+
+// deliveryTimeoutMsg checks whether the server replied to a message within the delivery timeout
+type deliveryTimeoutMsg struct {
+	ID int
+}
+
+// outbox writes the lines typed by the user to the server in the order they were typed, without blocking the TUI
+type outbox struct {
+	conn  net.Conn
+	lines chan outgoingLine
+}
+
+func newOutbox(conn net.Conn) *outbox {
+	return &outbox{conn: conn, lines: make(chan outgoingLine, outboxSize)}
+}
+
+// send queues a line to be written, failing if the outbox is full
+func (o *outbox) send(line outgoingLine) error {
+	select {
+	case o.lines <- line:
+		return nil
+	default:
+		return errOutboxFull
+	}
+}
+
+// run writes the queued lines, telling the program once each of them is written
+func (o *outbox) run(p *tea.Program) {
+	for line := range o.lines {
+		_, err := o.conn.Write([]byte(line.Text + "\n"))
+		p.Send(writtenMsg{ID: line.ID, Err: err})
+	}
+}
+
+// setDelivery updates the state shown after the message with the ID. Acked and failed messages keep their state.
+func (m *model) setDelivery(id int, state deliveryState, reason string) {
+	if id == 0 {
+		return // Untracked, e.g. a command
+	}
+
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		entry := &m.messages[i]
+		if entry.ID != id {
+			continue
+		}
+
+		if entry.Delivery == deliveryAcked || entry.Delivery == deliveryFailed {
+			return
+		}
+		entry.Delivery = state
+		entry.Reason = reason
+		m.invalidateEntry(i)
+		return
+	}
+}
+
+// expireDelivery marks the message as stale if the server still hasn't relayed or refused it
+func (m *model) expireDelivery(id int) {
+	for _, entry := range m.messages {
+		if entry.ID == id && (entry.Delivery == deliveryQueued || entry.Delivery == deliverySent) {
+			m.setDelivery(id, deliveryStale, "")
+			return
+		}
+	}
+}
+
+// applyReject marks the oldest message waiting to be relayed to the channel as failed with the reason the server gave.
+// An empty channel matches any message, the server doesn't know where it was meant to go.
+func (m *model) applyReject(channel, reason string) {
+	for i, pending := range m.pendingEchoes {
+		if channel != "" && pending.Channel != channel {
+			continue
+		}
+
+		m.pendingEchoes = slices.Delete(m.pendingEchoes, i, i+1)
+		m.setDelivery(pending.ID, deliveryFailed, reason)
+		m.syncTranscript()
+		return
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// Dialer opens connections to the server. It's a net.Dialer unless the client goes through a proxy,
+// and anything with the same method can be plugged in, e.g. an SSH tunnel, or net.Pipe in tests.
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// newDialer returns the dialer for the -proxy flag: direct connections if it's empty,
+// or connections through the SOCKS5 proxy at the URL, e.g. socks5://localhost:1080
+func newDialer(proxyURL string) (Dialer, error) {
+	if proxyURL == "" {
+		return &net.Dialer{}, nil
+	}
+
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	if u.Scheme != "socks5" && u.Scheme != "socks5h" {
+		return nil, fmt.Errorf("unsupported proxy '%s', only socks5:// proxies are supported", u.Scheme)
+	}
+
+	dialer, err := proxy.FromURL(u, &net.Dialer{})
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy: %w", err)
+	}
+
+	contextDialer, ok := dialer.(proxy.ContextDialer)
+	if !ok {
+		return nil, fmt.Errorf("the %s proxy doesn't support timeouts", u.Scheme)
+	}
+	return contextDialer, nil
+}
+
+// dial connects to the address with the dialer, giving up after the timeout unless it's 0
+func dial(dialer Dialer, address string, timeout time.Duration) (net.Conn, error) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return dialer.DialContext(ctx, "tcp", address)
+}
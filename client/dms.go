@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+var (
+	dmHeaderStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("5")).Bold(true)
+	dmBadgeStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("5"))
+)
+
+// conversation holds the whispers exchanged with one user, shown in the DMs view
+type conversation struct {
+	Peer     string
+	entries  []transcriptEntry // Sorted by the time each whisper was sent
+	unread   int               // Whispers received since the conversation was last open
+	lastSeen time.Time         // Time of the latest whisper, to open the most recent conversation
+}
+
+// conversation returns the conversation with the user, starting it if there's none yet
+func (m *model) conversation(peer string) *conversation {
+	if m.conversations == nil {
+		m.conversations = make(map[string]*conversation)
+	}
+
+	c, ok := m.conversations[peer]
+	if !ok {
+		c = &conversation{Peer: peer}
+		m.conversations[peer] = c
+	}
+	return c
+}
+
+// addWhisper adds a whisper to the conversation with the user, counting it as unread unless the conversation is open
+func (m *model) addWhisper(peer string, at time.Time, text string, incoming bool) {
+	c := m.conversation(peer)
+	index := sort.Search(len(c.entries), func(i int) bool {
+		return c.entries[i].At.After(at)
+	})
+	c.entries = slices.Insert(c.entries, index, transcriptEntry{At: at, Text: text})
+	if at.After(c.lastSeen) {
+		c.lastSeen = at
+	}
+
+	// Entries after the new one may lose their day divider
+	for i := index; i < len(c.entries); i++ {
+		c.entries[i].lines = nil
+	}
+
+	if incoming && m.dmPeer != peer {
+		c.unread++
+	}
+	m.syncDMView()
+}
+
+// recordWhisperCommand adds a whisper typed with /whisper to its conversation
+func (m *model) recordWhisperCommand(input string, at time.Time) {
+	fields := strings.SplitN(input, " ", 3)
+	if len(fields) < 3 || fields[0] != "/whisper" || strings.TrimSpace(fields[2]) == "" {
+		return
+	}
+	m.addWhisper(fields[1], at, senderStyle.Render("You: ")+strings.TrimSpace(fields[2]), false)
+}
+
+// openDM shows the conversation with the user instead of the channels, where plain messages are whispered to them
+func (m *model) openDM(peer string) {
+	c := m.conversation(peer)
+	c.unread = 0
+	m.dmPeer = peer
+	m.textarea.Placeholder = fmt.Sprintf("Whisper to %s...", peer)
+	m.syncDMView()
+}
+
+// closeDM goes back to the channels, as they were scrolled when the conversation was opened
+func (m *model) closeDM() {
+	m.dmPeer = ""
+	m.textarea.Placeholder = defaultPlaceholder
+}
+
+// toggleDM opens the conversation with unread whispers received last, or the latest one if all are read,
+// or goes back to the channels if a conversation is open
+func (m *model) toggleDM() {
+	if m.dmPeer != "" {
+		m.closeDM()
+		return
+	}
+
+	var latest *conversation
+	for _, c := range m.conversations {
+		switch {
+		case latest == nil:
+			latest = c
+		case (c.unread > 0) != (latest.unread > 0):
+			if c.unread > 0 {
+				latest = c
+			}
+		case c.lastSeen.After(latest.lastSeen):
+			latest = c
+		}
+	}
+
+	if latest == nil {
+		m.err = errors.New("there are no conversations yet, use /dm <username> to start one")
+		return
+	}
+	m.openDM(latest.Peer)
+}
+
+// syncDMView renders the open conversation into the DMs view
+func (m *model) syncDMView() {
+	if m.dmPeer == "" {
+		return
+	}
+
+	c := m.conversation(m.dmPeer)
+	var lines []string
+	for i := range c.entries {
+		if c.entries[i].lines == nil {
+			c.entries[i].lines = renderTranscriptEntry(c.entries, i, m.dmView.Width, m.location)
+		}
+		lines = append(lines, c.entries[i].lines...)
+	}
+	if len(lines) == 0 {
+		lines = []string{fmt.Sprintf("No whispers with %s yet. Type a message to whisper to them.", m.dmPeer)}
+	}
+
+	m.dmView.SetLines(lines)
+	m.dmView.GotoBottom()
+}
+
+// invalidateConversations renders every whisper again, e.g. after the width changed
+func (m *model) invalidateConversations() {
+	for _, c := range m.conversations {
+		for i := range c.entries {
+			c.entries[i].lines = nil
+		}
+	}
+}
+
+// sendWhisper whispers a line typed in the DMs view to the user the conversation is with
+func (m *model) sendWhisper(text string) tea.Cmd {
+	if err := m.outbox.send(outgoingLine{Text: "/whisper " + m.dmPeer + " " + text}); err != nil {
+		m.err = err
+		return nil
+	}
+
+	sentAt := time.Now()
+	m.pacer.spend(sentAt)
+	m.addWhisper(m.dmPeer, sentAt, senderStyle.Render("You: ")+text, false)
+	m.textarea.Reset()
+
+	// Typing means the user has seen the whispers
+	return m.markRead()
+}
+
+// dmStatus shows the open conversation and the unread whispers of the others, e.g. "DM with bob (Esc to go back) · ✉ alice 2"
+func (m *model) dmStatus() string {
+	var parts []string
+	if m.dmPeer != "" {
+		parts = append(parts, dmHeaderStyle.Render(fmt.Sprintf("DM with %s (Esc to go back)", m.dmPeer)))
+	}
+
+	var unread []string
+	for _, peer := range slices.Sorted(maps.Keys(m.conversations)) {
+		if c := m.conversations[peer]; c.unread > 0 {
+			unread = append(unread, fmt.Sprintf("%s %d", peer, c.unread))
+		}
+	}
+	if len(unread) > 0 {
+		parts = append(parts, dmBadgeStyle.Render("✉ "+strings.Join(unread, ", ")+" (Ctrl+O)"))
+	}
+	return strings.Join(parts, " · ")
+}
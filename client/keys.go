@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// scrollKeyMap binds the keys that scroll the chat, replacing the viewport's defaults, which are plain letters
+// typed into the input. Terminals don't all report modified arrows, such as Windows Terminal with the shifted ones,
+// so each action is bound to several keys at once rather than guessing what the terminal supports.
+func scrollKeyMap() viewport.KeyMap {
+	return viewport.KeyMap{
+		Up: key.NewBinding(
+			key.WithKeys(tea.KeyShiftUp.String()),
+			key.WithHelp("shift+↑", "line up"),
+		),
+		Down: key.NewBinding(
+			key.WithKeys(tea.KeyShiftDown.String()),
+			key.WithHelp("shift+↓", "line down"),
+		),
+		PageUp: key.NewBinding(
+			key.WithKeys(tea.KeyCtrlShiftUp.String(), tea.KeyPgUp.String()),
+			key.WithHelp("pgup/ctrl+shift+↑", "page up"),
+		),
+		PageDown: key.NewBinding(
+			key.WithKeys(tea.KeyCtrlShiftDown.String(), tea.KeyPgDown.String()),
+			key.WithHelp("pgdown/ctrl+shift+↓", "page down"),
+		),
+		HalfPageUp: key.NewBinding(
+			key.WithKeys(tea.KeyCtrlU.String()),
+			key.WithHelp("ctrl+u", "half page up"),
+		),
+		HalfPageDown: key.NewBinding(
+			key.WithKeys(tea.KeyCtrlD.String()),
+			key.WithHelp("ctrl+d", "half page down"),
+		),
+		Left:  key.NewBinding(key.WithDisabled()),
+		Right: key.NewBinding(key.WithDisabled()),
+	}
+}
+
+// describeScrollKeys lists the keys that scroll the chat, e.g. "shift+↑ line up, ctrl+u half page up"
+func describeScrollKeys(keyMap viewport.KeyMap) string {
+	bindings := []key.Binding{keyMap.Up, keyMap.Down, keyMap.PageUp, keyMap.PageDown, keyMap.HalfPageUp, keyMap.HalfPageDown}
+
+	descriptions := make([]string, 0, len(bindings))
+	for _, binding := range bindings {
+		if binding.Enabled() {
+			descriptions = append(descriptions, binding.Help().Key+" "+binding.Help().Desc)
+		}
+	}
+	return strings.Join(descriptions, ", ")
+}
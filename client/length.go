@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// defaultMaxMessageLength is the message length allowed until the server advertises its own,
+// kept for servers that predate the max-message-length event
+const defaultMaxMessageLength = 280
+
+// parseMaxMessageLength parses the argument of the max-message-length event: "<characters>"
+func parseMaxMessageLength(argument string) (int, error) {
+	limit, err := strconv.Atoi(argument)
+	if err != nil || limit < 1 {
+		return 0, fmt.Errorf("invalid max-message-length event '%s'", argument)
+	}
+	return limit, nil
+}
+
+// checkMessageLength refuses chat messages longer than the server's limit, with how far over they are.
+// The limit counts the characters of the message as typed, without the "#channel " it may be routed with,
+// and doesn't apply to commands.
+func checkMessageLength(input string, limit int) error {
+	if strings.HasPrefix(input, "/") {
+		return nil
+	}
+	if _, content, ok := parseChannelPrefix(input); ok {
+		input = content
+	}
+
+	if length := utf8.RuneCountInString(strings.TrimSpace(input)); length > limit {
+		return fmt.Errorf("the message is %d characters over the limit of %d", length-limit, limit)
+	}
+	return nil
+}
+
+// setMaxMessageLength applies the server's message length limit to the input
+func (m *model) setMaxMessageLength(limit int) {
+	m.messageLimit = limit
+	m.textarea.CharLimit = limit
+}
+
+// lengthStatus counts the characters typed against the server's limit while there's input, e.g. "212/1024"
+func (m *model) lengthStatus() string {
+	length := m.textarea.Length()
+	if length == 0 {
+		return ""
+	}
+
+	status := fmt.Sprintf("%d/%d", length, m.messageLimit)
+	if length > m.messageLimit {
+		return failedMarkerStyle.Render(status)
+	}
+	return meterStyle.Render(status)
+}
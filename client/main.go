@@ -3,15 +3,19 @@ package main
 import (
 	"encoding/binary"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"os"
 	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/bubbles/textarea"
-	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 )
@@ -21,16 +25,93 @@ var (
 	host          = "localhost:3000"
 	senderStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("5"))
 	serverStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("2"))
+	timeStyle     = lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
+	channelStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("6"))
+	bridgedStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("4")).Italic(true)
+	dividerStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("8")).Bold(true)
+	streamStyle   = lipgloss.NewStyle().Border(lipgloss.NormalBorder(), false, false, false, true).BorderForeground(lipgloss.Color("8")).PaddingLeft(1)
 	clients       = make(map[string]lipgloss.Style) // clientID -> style color
+	channelColors = make(map[string]map[string]int) // channel -> username -> palette index assigned by the server
+	channelNames  = make(map[string]lipgloss.Color) // channel -> color of its name, set by its operators
 	slashCommands = []string{
 		"/help",
+		"/quit",
+		"/server",
 		"/name",
+		"/nick",
 		"/channels",
+		"/top",
 		"/join",
 		"/leave",
+		"/switch",
 		"/members",
 		"/clients",
 		"/whisper",
+		"/group",
+		"/dm",
+		"/ignorechannel",
+		"/unignorechannel",
+		"/ignoredchannels",
+		"/whois",
+		"/channel",
+		"/channelinfo",
+		"/channelage",
+		"/lock",
+		"/unlock",
+		"/hidechannel",
+		"/unhidechannel",
+		"/invite",
+		"/op",
+		"/deop",
+		"/inspect",
+		"/setchannelcolor",
+		"/nospam",
+		"/invitecode",
+		"/invitecodes",
+		"/revokecode",
+		"/pin",
+		"/unpin",
+		"/sethint",
+		"/removehint",
+		"/formatting",
+		"/pins",
+		"/history",
+		"/export",
+		"/topic",
+		"/topic-clear",
+		"/stats",
+		"/echo",
+		"/echo-delay",
+		"/channelcount",
+		"/seticon",
+		"/clearicon",
+		"/away",
+		"/set",
+		"/register",
+		"/ghost",
+		"/login",
+		"/rejoin",
+		"/setprompt",
+		"/resetprompt",
+		"/version",
+		"/motd",
+		"/goroutines",
+		"/admin",
+		"/setdefaultchannel",
+		"/say",
+		"/announce",
+		"/shuffle",
+		"/masswhisper",
+		"/banip",
+		"/unbanip",
+		"/reloadbans",
+		"/shadowmute",
+		"/unshadowmute",
+		"/exportusers",
+		"/slowclients",
+		"/sessions",
+		"/bridge",
+		"/unbridge",
 	}
 	brightColors = []string{
 		"9",
@@ -57,30 +138,99 @@ var (
 	}
 )
 
+const (
+	streamStartMarker = ".|.|STREAM_START"
+	streamEndMarker   = ".|.|STREAM_END"
+)
+
+var errDisconnected = errors.New("disconnected from server")
+
 type errMsg error
+type streamStartMsg struct {
+	SentAt time.Time
+}
+type streamEndMsg struct{}
 type Message struct {
 	Content    string
 	SenderName string
+	Channel    string // Empty if the message wasn't sent to a channel
+	SentAt     time.Time
+	Bridged    bool // Relayed by a bridge for a user of another chat system
+}
+
+// pendingEcho is a message typed by the user that the server hasn't echoed back yet
+type pendingEcho struct {
+	ID      int    // ID of the message's transcript entry
+	Channel string // Channel the message was routed to, the active one unless it was prefixed with another
+	Prefix  string // "#channel " the message was typed with, if any, kept when it's echoed
+	Text    string
+}
+
+// transcriptEntry is a rendered line of the transcript along with the time it was sent
+type transcriptEntry struct {
+	At       time.Time
+	Text     string
+	ID       int           // Nonzero for the messages we typed whose delivery is tracked
+	Delivery deliveryState // How far the message got, shown after it
+	Reason   string        // Why the message failed, if it did
+	lines    []string      // Text rendered with its time and wrapped, nil until rendered
 }
 
 type model struct {
-	viewport        viewport.Model
-	messages        []string
+	viewport        transcriptView
+	messages        []transcriptEntry // Sorted by the time each message was sent
 	textarea        textarea.Model
 	conn            net.Conn
+	outbox          *outbox // Writes the lines typed by the user
+	nextID          int     // ID of the last tracked message
 	err             error
 	commandsHistory []string
 	historyIndex    int
+	streaming       bool     // True while receiving the chunks of a stream
+	streamLines     []string // Rendered chunks of the current stream
+	streamStartedAt time.Time
+	location        *time.Location // Timezone used to display message times
+	config          Config
+	promptText      string
+	closeReason     string        // Why the server closed the connection, if it said so
+	activeChannel   string        // Channel plain messages are sent to, as told by the server
+	joinedChannels  []string      // Channels we're a member of, in the order they were joined
+	messageLimit    int           // Longest message the server accepts, in characters
+	unread          int           // Messages received while the terminal wasn't focused, and whispers since the user last typed
+	focused         bool          // The terminal has focus, as far as it reports it
+	termTitle       bool          // Show the active channel and unread messages in the terminal title
+	bell            bool          // Ring the bell for whispers received while the terminal isn't focused
+	pendingEchoes   []pendingEcho // Messages shown as typed until the server echoes what it relayed, oldest first
+	server          serverProtocol
+	pacer           pacer                    // Estimate of the server's rate limit, shown under the input
+	splash          *splash                  // Message of the day shown instead of the chat when we connect, nil once dismissed
+	motdShown       bool                     // The first MOTD is shown as a splash, later ones from /motd inline
+	width, height   int                      // Size of the terminal
+	renderedCount   int                      // Messages appended to the viewport so far
+	transcriptDirty bool                     // Messages before the last rendered one changed, so the viewport must be rebuilt
+	attached        bool                     // Connected to a session daemon rather than the server, see daemon.go
+	profile         string                   // Profile we're connected with, empty without one
+	profileErrors   map[string]string        // Why connecting with a profile failed, by profile
+	switchTo        string                   // Profile to connect with once the TUI quits, set by /server
+	dmView          transcriptView           // Shows the open conversation instead of the channels
+	dmPeer          string                   // User whose conversation is open, empty while the channels are shown
+	conversations   map[string]*conversation // Whispers by the user they were exchanged with
+	detachPrefix    bool                     // Ctrl+B was pressed while attached, so d detaches
+	browser         *channelBrowser          // Channel browser shown instead of the chat, nil while closed
 }
 
-func initialModel(c net.Conn) model {
+func initialModel(c net.Conn, config Config, location *time.Location, termTitle, bell bool) model {
 	ta := textarea.New()
-	ta.Placeholder = "Send a message..."
+	ta.Placeholder = defaultPlaceholder
 
 	ta.Focus()
 
-	ta.Prompt = "| "
-	ta.CharLimit = 280
+	promptText := defaultPrompt
+	if config.Prompt != "" {
+		promptText = config.Prompt
+	}
+	ta.Prompt = promptText
+	ta.CharLimit = defaultMaxMessageLength
 
 	ta.SetWidth(30)
 	ta.SetHeight(3)
@@ -88,36 +238,36 @@ func initialModel(c net.Conn) model {
 	ta.FocusedStyle.CursorLine = lipgloss.NewStyle()
 	ta.ShowLineNumbers = false
 
-	vp := viewport.New(30, 10)
-	// vp.SetContent("Welcome back, type /help for commands")
-
-	// Disable default key bindings for scrolling
-	vp.KeyMap.Up.SetKeys(tea.KeyShiftUp.String())
-	vp.KeyMap.Down.SetKeys(tea.KeyShiftDown.String())
-	vp.KeyMap.PageUp.SetKeys(tea.KeyCtrlShiftUp.String())
-	vp.KeyMap.PageDown.SetKeys(tea.KeyCtrlShiftDown.String())
+	vp := newTranscriptView(30, 10)
+	dmView := newTranscriptView(30, 10)
 
+	// Ctrl+U and Ctrl+D scroll by half a page instead of editing the input
 	ta.KeyMap.InsertNewline.SetEnabled(false)
+	ta.KeyMap.DeleteBeforeCursor.SetEnabled(false)
+	ta.KeyMap.DeleteCharacterForward.SetKeys("delete")
 
 	return model{
 		viewport:        vp,
+		dmView:          dmView,
 		textarea:        ta,
-		messages:        make([]string, 0),
+		messages:        make([]transcriptEntry, 0),
 		conn:            c,
+		outbox:          newOutbox(c),
 		commandsHistory: make([]string, 0),
 		historyIndex:    0,
 		err:             nil,
+		messageLimit:    defaultMaxMessageLength,
+		location:        location,
+		config:          config,
+		promptText:      promptText,
+		focused:         true,
+		termTitle:       termTitle,
+		bell:            bell,
 	}
 }
 
-func getForegroundColor() lipgloss.Color {
-	// Gets a color from the list based on the number of connected clients
-	colorIndex := len(clients) % len(brightColors)
-	return lipgloss.Color(brightColors[colorIndex])
-}
-
 func (m model) Init() tea.Cmd {
-	return textarea.Blink
+	return tea.Batch(textarea.Blink, m.updateTitle())
 }
 
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -126,26 +276,75 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		vpCmd tea.Cmd
 	)
 
+	// Keys only dismiss or scroll the splash while it's shown
+	if key, ok := msg.(tea.KeyMsg); ok && m.splash != nil {
+		return m.updateSplash(key)
+	}
+
+	// Ctrl+B then d detaches from the session daemon, leaving the session running
+	if key, ok := msg.(tea.KeyMsg); ok && m.attached {
+		if m.detachPrefix {
+			m.detachPrefix = false
+			if key.String() == "d" {
+				return m, tea.Quit
+			}
+		} else if key.Type == tea.KeyCtrlB {
+			m.detachPrefix = true
+			return m, nil
+		}
+	}
+
+	// Keys only go to the channel browser while it's open
+	if key, ok := msg.(tea.KeyMsg); ok && m.browser != nil {
+		return m.updateBrowser(key)
+	}
+
 	m.err = nil
 	m.textarea, tiCmd = m.textarea.Update(msg)
-	m.viewport, vpCmd = m.viewport.Update(msg)
+	if m.dmPeer != "" {
+		m.dmView, vpCmd = m.dmView.Update(msg)
+	} else {
+		m.viewport, vpCmd = m.viewport.Update(msg)
+	}
 
 	switch msg := msg.(type) {
 	case tea.WindowSizeMsg:
-		m.viewport.Width = msg.Width
-		m.textarea.SetWidth(msg.Width)
-		m.viewport.Height = msg.Height - m.textarea.Height() - lipgloss.Height(gap)
+		m.width, m.height = msg.Width, msg.Height
+		if m.splash != nil {
+			m.splash.resize(msg.Width, msg.Height)
+		}
 
-		// Rerender messages to fit new width
-		if len(m.messages) > 0 {
-			m.viewport.SetContent(lipgloss.NewStyle().Width(m.viewport.Width).Render(strings.Join(m.messages, "\n")))
+		// Rerender messages to fit the new width
+		if msg.Width != m.viewport.Width {
+			m.invalidateTranscript()
+			m.invalidateConversations()
 		}
 
+		m.viewport.Width = msg.Width
+		m.textarea.SetWidth(msg.Width)
+		m.viewport.Height = msg.Height - m.textarea.Height() - lipgloss.Height(gap) - 1 // Leave room for the status line
+		m.dmView.Width, m.dmView.Height = m.viewport.Width, m.viewport.Height
+		m.syncTranscript()
+		m.syncDMView()
+
 		m.viewport.GotoBottom()
 	case tea.KeyMsg:
 		switch msg.Type {
-		case tea.KeyCtrlC, tea.KeyEsc:
+		case tea.KeyEsc:
+			// Esc leaves the open conversation before it quits
+			if m.dmPeer != "" {
+				m.closeDM()
+				return m, nil
+			}
 			return m, tea.Quit
+		case tea.KeyCtrlC:
+			return m, tea.Quit
+		case tea.KeyCtrlO:
+			m.toggleDM()
+			return m, nil
+		case tea.KeyCtrlJ:
+			m.openBrowser()
+			return m, nil
 		case tea.KeyEnter:
 			inputValue := m.textarea.Value()
 
@@ -159,6 +358,17 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				return m, nil
 			}
 
+			// The input may hold more than the limit if the server lowered it, e.g. after attaching to a daemon
+			if err := checkMessageLength(inputValue, m.messageLimit); err != nil {
+				m.err = err
+				return m, nil
+			}
+
+			// Plain messages typed in a conversation are whispered to the other user
+			if m.dmPeer != "" && !strings.HasPrefix(inputValue, "/") {
+				return m, tea.Batch(tiCmd, vpCmd, m.sendWhisper(inputValue))
+			}
+
 			// Check if it is a command
 			if strings.HasPrefix(inputValue, "/") {
 				if slices.Contains(slashCommands, strings.Split(inputValue, " ")[0]) {
@@ -166,22 +376,68 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					m.commandsHistory = append(m.commandsHistory, inputValue)
 					m.historyIndex = len(m.commandsHistory)
 				}
+
+				// Commands handled by the client are never sent to the server
+				if m.handleLocalCommand(inputValue) {
+					m.textarea.Reset()
+					if m.switchTo != "" {
+						return m, tea.Quit // main connects with the new profile
+					}
+					return m, nil
+				}
+			}
+
+			// Messages typed outside of a channel, such as the username, are never echoed, so only chat messages are tracked
+			line := outgoingLine{Text: inputValue}
+			// Messages prefixed with "#channel " are routed to that channel rather than the active one
+			pending := pendingEcho{Channel: m.activeChannel, Text: inputValue}
+			if channel, content, ok := parseChannelPrefix(inputValue); ok {
+				pending = pendingEcho{Channel: channel, Prefix: "#" + channel + " ", Text: content}
+			}
+			tracked := !strings.HasPrefix(inputValue, "/") && pending.Channel != ""
+			if tracked {
+				m.nextID++
+				line.ID = m.nextID
 			}
 
-			_, err := m.conn.Write([]byte(inputValue + "\n"))
-			if err != nil {
-				// Error sending message to the serve
+			if err := m.outbox.send(line); err != nil {
 				m.err = err
 				return m, nil
 			}
 
-			m.messages = append(m.messages, senderStyle.Render("You: ")+m.textarea.Value())
-			m.viewport.SetContent(lipgloss.NewStyle().Width(m.viewport.Width).Render(strings.Join(m.messages, "\n")))
+			sentAt := time.Now()
+			m.pacer.spend(sentAt)
+			entry := transcriptEntry{At: sentAt, Text: senderStyle.Render("You: ") + inputValue}
+			if tracked {
+				entry.ID = line.ID
+				entry.Delivery = deliveryQueued
+				pending.ID = line.ID
+				m.pendingEchoes = append(m.pendingEchoes, pending)
+			}
+			m.addEntry(entry)
+			m.recordWhisperCommand(inputValue, sentAt)
+			m.syncTranscript()
 			m.textarea.Reset()
 			m.viewport.GotoBottom()
+
+			// Typing means the user has seen the whispers
+			cmds := []tea.Cmd{tiCmd, vpCmd, m.markRead()}
+			if tracked {
+				cmds = append(cmds, tea.Tick(deliveryTimeout, func(time.Time) tea.Msg {
+					return deliveryTimeoutMsg{ID: line.ID}
+				}))
+			}
+			return m, tea.Batch(cmds...)
 		case tea.KeyTab:
 			inputValue := m.textarea.Value()
 
+			// "#prefix" autocompletes with the channels we joined
+			if completed, ok := m.completeChannel(inputValue); ok {
+				m.textarea.SetValue(completed)
+				m.textarea.SetCursor(len(completed))
+				return m, nil
+			}
+
 			// Only autocomplete if it starts with a slash (for commands)
 			if !strings.HasPrefix(inputValue, "/") {
 				return m, nil
@@ -225,130 +481,600 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			// Ignore all unhandled keys to prevent unintended behavior
 			return m, nil
 		}
+	case tea.FocusMsg:
+		m.focused = true
+		return m, tea.Batch(tiCmd, vpCmd, m.markRead())
+	case tea.BlurMsg:
+		m.focused = false
+	case streamStartMsg:
+		m.streaming = true
+		m.streamLines = make([]string, 0)
+		m.streamStartedAt = msg.SentAt
+	case streamEndMsg:
+		if !m.streaming {
+			return m, nil
+		}
+
+		// Render all the chunks of the stream together as a single block
+		m.streaming = false
+		m.addMessage(m.streamStartedAt, streamStyle.Render(strings.Join(m.streamLines, "\n")))
+		m.streamLines = nil
+
+		m.syncTranscript()
+		m.viewport.GotoBottom()
 	case Message:
-		// If the sender name is "Server", use the server style
-		// Otherwise, use or create a style for the client
-		switch msg.SenderName {
-		case "Server":
-			m.messages = append(m.messages, serverStyle.Render("[Server]: ")+msg.Content)
-		case ".":
-			m.messages = append(m.messages, msg.Content)
-		default:
-			newStyle, ok := clients[msg.SenderName]
-			if !ok {
-				// If the sender ID is not in the clients map, create a new style for it
-				newStyle = lipgloss.NewStyle().Foreground(getForegroundColor())
-				clients[msg.SenderName] = newStyle
-			}
-			m.messages = append(m.messages, newStyle.Render("["+msg.SenderName+"]: ")+msg.Content)
+		// Bridged events are chat messages of users on another chat system
+		if bridged, ok := parseBridged(msg); ok {
+			msg = bridged
+		}
+
+		// Messages from "system" are control events, with the event in the channel field
+		if msg.SenderName == "system" {
+			return m, m.handleEvent(msg.Channel, msg.Content)
+		}
+
+		// The server tells the color of a channel's name when joining it or changing it
+		if msg.SenderName == "Server" {
+			msg.Content = applyColorTag(msg.Content)
+		}
+
+		// The channel list the browser asked for isn't shown in the chat
+		if msg.SenderName == "Server" && m.handleBrowserReply(msg.Content) {
+			return m, nil
+		}
+
+		if m.streaming {
+			m.streamLines = append(m.streamLines, renderTaggedMessage(msg, m.showChannelTags()))
+			return m, nil
 		}
 
-		m.viewport.SetContent(lipgloss.NewStyle().Width(m.viewport.Width).Render(strings.Join(m.messages, "\n")))
+		m.addMessage(msg.SentAt, renderTaggedMessage(msg, m.showChannelTags()))
+		m.syncTranscript()
 		m.viewport.GotoBottom()
+
+		if peer, ok := strings.CutPrefix(msg.SenderName, "DM from "); ok {
+			m.addWhisper(peer, msg.SentAt, renderMessage(Message{SenderName: peer, Content: msg.Content}), true)
+			return m, tea.Batch(tiCmd, vpCmd, m.countUnread(true), m.ringBell())
+		}
+		if msg.SenderName != "Server" {
+			return m, tea.Batch(tiCmd, vpCmd, m.countUnread(false))
+		}
+	case writtenMsg:
+		if msg.Err != nil {
+			m.err = fmt.Errorf("failed to send message: %w", msg.Err)
+			m.setDelivery(msg.ID, deliveryFailed, "not sent")
+		} else {
+			m.setDelivery(msg.ID, deliverySent, "")
+		}
+		m.syncTranscript()
+	case deliveryTimeoutMsg:
+		m.expireDelivery(msg.ID)
+		m.syncTranscript()
 	case errMsg:
+		// Keep showing the reason the server gave instead of the resulting read error
 		m.err = msg
+		if m.closeReason != "" {
+			m.err = fmt.Errorf("disconnected by the server: %s", m.closeReason)
+		}
 		return m, nil
 	}
 
 	return m, tea.Batch(tiCmd, vpCmd)
 }
 
+// addMessage inserts a message into the transcript keeping it sorted by the time it was sent,
+// since replayed and live messages can arrive out of order
+func (m *model) addMessage(at time.Time, text string) {
+	m.addEntry(transcriptEntry{At: at, Text: text})
+}
+
+// addEntry inserts an entry into the transcript, keeping it sorted like addMessage
+func (m *model) addEntry(entry transcriptEntry) {
+	index := sort.Search(len(m.messages), func(i int) bool {
+		return m.messages[i].At.After(entry.At)
+	})
+	m.messages = slices.Insert(m.messages, index, entry)
+
+	// Messages inserted before the end shift the lines after them, and may move the day divider of the next one
+	if index < m.renderedCount {
+		m.invalidateEntry(index + 1)
+	}
+}
+
+// applyEcho replaces the line shown when a message was typed with the content the server relayed, marking it as delivered.
+// Messages the server refused are never echoed, so pending messages typed before the echoed one are marked as failed.
+func (m *model) applyEcho(channel, content string) {
+	// The server trims messages and may change them further, so fall back to the oldest pending message of the channel
+	index := slices.IndexFunc(m.pendingEchoes, func(pending pendingEcho) bool {
+		return pending.Channel == channel && strings.TrimSpace(pending.Text) == content
+	})
+	if index == -1 {
+		index = slices.IndexFunc(m.pendingEchoes, func(pending pendingEcho) bool {
+			return pending.Channel == channel
+		})
+	}
+	if index == -1 {
+		return
+	}
+
+	pending := m.pendingEchoes[index]
+	for _, skipped := range m.pendingEchoes[:index] {
+		m.setDelivery(skipped.ID, deliveryFailed, "not relayed")
+	}
+	m.pendingEchoes = m.pendingEchoes[index+1:]
+
+	for i, entry := range m.messages {
+		if entry.ID == pending.ID {
+			m.messages[i].Text = senderStyle.Render("You: ") + pending.Prefix + content
+			m.invalidateEntry(i)
+			break
+		}
+	}
+	m.setDelivery(pending.ID, deliveryAcked, "")
+	m.syncTranscript()
+}
+
+func sameDay(a, b time.Time) bool {
+	aYear, aMonth, aDay := a.Date()
+	bYear, bMonth, bDay := b.Date()
+	return aYear == bYear && aMonth == bMonth && aDay == bDay
+}
+
+func formatDivider(t time.Time) string {
+	if t.Year() != time.Now().In(t.Location()).Year() {
+		return t.Format("— January 2, 2006 —")
+	}
+	return t.Format("— January 2 —")
+}
+
+// handleEvent handles a control event from the server, returning a command if it needs one
+func (m *model) handleEvent(event, argument string) tea.Cmd {
+	switch event {
+	case "disconnect":
+		// A member left one of our channels, so its color can be released
+		delete(clients, argument)
+	case "palette":
+		// The server assigned a color to a member of one of our channels
+		parts := strings.SplitN(argument, " ", 3)
+		if len(parts) == 3 {
+			if index, err := strconv.Atoi(parts[1]); err == nil {
+				setChannelColor(parts[0], parts[2], index)
+			}
+		}
+	case "active-channel":
+		m.activeChannel = argument
+		return m.updateTitle()
+	case "joined":
+		m.joinChannel(argument)
+	case "left":
+		m.leaveChannel(argument)
+	case "echo":
+		// The server relayed one of our messages, show it as it was sent to the channel
+		parts := strings.SplitN(argument, " ", 3)
+		if len(parts) == 3 {
+			m.applyEcho(parts[1], parts[2])
+		}
+	case "reject":
+		// The server refused one of our messages, telling the channel it was sent to and why
+		channel, reason, _ := strings.Cut(argument, " ")
+		m.applyReject(channel, reason)
+	case "features":
+		// Sent when we connect, so we only use features the server supports
+		server, err := parseFeatures(argument)
+		if err != nil {
+			m.err = err
+			break
+		}
+		m.server = server
+	case "rate-limit":
+		// The server's rate limit, so we can tell the user to slow down before it rejects messages
+		pacer, err := parseRateLimit(argument, time.Now())
+		if err != nil {
+			m.err = err
+			break
+		}
+		m.pacer = pacer
+	case "max-message-length":
+		// Sent when we connect, so the input stops at the longest message the server accepts
+		limit, err := parseMaxMessageLength(argument)
+		if err != nil {
+			m.err = err
+			break
+		}
+		m.setMaxMessageLength(limit)
+	case "motd":
+		// Sent when we connect and in reply to /motd
+		if !m.motdShown {
+			m.motdShown = true
+			m.splash = newSplash(m.motdTitle(), argument, m.width, m.height)
+			break
+		}
+		m.addMessage(time.Now(), renderMOTD(m.motdTitle(), argument, m.viewport.Width))
+		m.syncTranscript()
+		m.viewport.GotoBottom()
+	case "rate-limited":
+		// The server rejected a line, telling how many milliseconds until it accepts the next one
+		if retryAfter, err := strconv.Atoi(argument); err == nil {
+			m.pacer.resync(time.Now(), time.Duration(retryAfter)*time.Millisecond)
+		}
+	case "ping":
+		// Let the server know the connection is still alive
+		if !m.server.enabled(FeatureHeartbeat) {
+			break
+		}
+		if _, err := m.conn.Write([]byte("PONG\n")); err != nil {
+			m.err = err
+		}
+	case "channel-list":
+		// Sent in reply to the channel browser's /channels --event
+		if m.browser == nil || !m.browser.loading {
+			break
+		}
+		channels, err := parseChannelListEvent(argument)
+		if err != nil {
+			m.browser.status, m.browser.loading = err.Error(), false
+			break
+		}
+		m.browser.setChannels(channels)
+	case "close":
+		// The server is about to close the connection
+		m.closeReason = argument
+	case "read-receipt":
+		// The whisper preceding this event has been displayed, let the sender know
+		if _, err := m.conn.Write([]byte("READ_ACK " + argument + "\n")); err != nil {
+			m.err = err
+		}
+	}
+	return nil
+}
+
+func renderMessage(msg Message) string {
+	return renderTaggedMessage(msg, true)
+}
+
+// renderTaggedMessage renders a message, prefixed with the channel it was sent to if tagged
+func renderTaggedMessage(msg Message, tagged bool) string {
+	prefix := ""
+	if tagged && msg.Channel != "" {
+		prefix = channelNameStyle(msg.Channel).Render("#"+msg.Channel) + " "
+	}
+
+	// Bridged users aren't members of the channel, so they share a style that sets them apart
+	if msg.Bridged {
+		return prefix + bridgedStyle.Render("["+msg.SenderName+" (via bridge)]: ") + msg.Content
+	}
+
+	// If the sender name is "Server", use the server style
+	// Otherwise, use or create a style for the client
+	switch msg.SenderName {
+	case "Server":
+		return prefix + serverStyle.Render("[Server]: ") + msg.Content
+	case ".":
+		return prefix + msg.Content
+	default:
+		// Channel members use the color the server assigned them in the channel
+		if style, ok := channelSenderStyle(msg.Channel, msg.SenderName); ok {
+			return prefix + style.Render("["+msg.SenderName+"]: ") + msg.Content
+		}
+
+		newStyle, ok := clients[msg.SenderName]
+		if !ok {
+			// If the sender ID is not in the clients map, create a new style for it
+			newStyle = newClientStyle()
+			clients[msg.SenderName] = newStyle
+		}
+		return prefix + newStyle.Render("["+msg.SenderName+"]: ") + msg.Content
+	}
+}
+
 func (m model) View() string {
+	if m.splash != nil {
+		return m.splash.View()
+	}
+
 	errMsg := ""
 	if m.err != nil {
 		errMsg = lipgloss.NewStyle().Foreground(lipgloss.Color("1")).Render(fmt.Sprintf("Error: %v", m.err)) + "\n"
 	}
 
+	transcript := m.viewport.View()
+	if m.dmPeer != "" {
+		transcript = m.dmView.View()
+	}
+	if m.browser != nil {
+		transcript = m.browser.View(m.viewport.Width, m.viewport.Height)
+	}
+
+	var status []string
+	for _, part := range []string{m.lengthStatus(), m.pacer.status(time.Now()), m.dmStatus()} {
+		if part != "" {
+			status = append(status, part)
+		}
+	}
+
 	return fmt.Sprintf(
-		"%s%s%s%s",
-		m.viewport.View(),
+		"%s%s%s%s\n%s",
+		transcript,
 		gap,
 		errMsg,
 		m.textarea.View(),
+		strings.Join(status, "  "),
 	)
 }
 
-func connectToServer() (net.Conn, error) {
-	conn, err := net.Dial("tcp", host)
+// connectToServer dials the server with the dialer and does the handshake, giving up after the timeout unless it's 0.
+// Returns what the server sent during the handshake.
+func connectToServer(dialer Dialer, address string, timeout time.Duration, features Feature) (net.Conn, []frame, error) {
+	conn, err := dial(dialer, address, timeout)
 	if err != nil {
-		fmt.Println("Error connecting to server:", err)
-		return nil, err
+		fmt.Fprintln(os.Stderr, "Error connecting to server:", err)
+		return nil, nil, err
+	}
+
+	frames, err := handshake(conn, features)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error connecting to server:", err)
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, frames, nil
+}
+
+// readFrame reads the next message sent by the server, returning its body and when it was sent.
+// Empty frames are keepalives the server sends over idle connections, which are skipped.
+func readFrame(conn net.Conn) (string, time.Time, error) {
+	for {
+		body, sentAt, err := readAnyFrame(conn)
+		if err != nil || body != "" {
+			return body, sentAt, err
+		}
+	}
+}
+
+// readAnyFrame reads the next frame sent by the server, keepalives included
+func readAnyFrame(conn net.Conn) (string, time.Time, error) {
+	// Read the header first (4 bytes for the size and 8 bytes for the time it was sent)
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		if errors.Is(err, io.EOF) {
+			// The server closed the connection
+			return "", time.Time{}, errDisconnected
+		}
+		return "", time.Time{}, fmt.Errorf("error reading header from server: %w", err)
+	}
+
+	// Get the message size from the header, which lets us know how many bytes to read next
+	msgSize := binary.LittleEndian.Uint32(header[0:4])
+	sentAt := time.UnixMilli(int64(binary.LittleEndian.Uint64(header[4:12])))
+
+	// Use io.ReadFull to ensure all bytes are read
+	body := make([]byte, msgSize)
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return "", time.Time{}, fmt.Errorf("error reading from server: %w", err)
+	}
+
+	return string(body), sentAt, nil
+}
+
+// parseMessage splits a message from the server into its sender, channel and content
+func parseMessage(body string, sentAt time.Time) (Message, bool) {
+	parts := strings.SplitN(body, "|", 3) // Expects three parts: senderName, channel, content
+	if len(parts) != 3 {
+		return Message{}, false
 	}
 
-	return conn, nil
+	return Message{
+		SenderName: parts[0],
+		Channel:    parts[1],
+		Content:    parts[2],
+		SentAt:     sentAt,
+	}, true
 }
 
-func listener(conn net.Conn, p *tea.Program) {
+// listener sends the frames read while connecting to the program, then what the server sends until the connection is closed
+func listener(conn net.Conn, p *tea.Program, replay []frame) {
 	defer func() {
 		p.Quit()
 	}()
 
+	for _, f := range replay {
+		sendFrame(p, f.Body, f.SentAt)
+	}
+
 	for {
-		// Read the header first (4 bytes)
-		header := make([]byte, 4)
-		_, err := io.ReadFull(conn, header)
+		message, sentAt, err := readFrame(conn)
 		if err != nil {
 			if errors.Is(err, net.ErrClosed) {
 				return // Connection closed
 			}
 
-			if errors.Is(err, io.EOF) {
-				// The server closed the connection
-				p.Send(errMsg(fmt.Errorf("disconnected from server")))
-				return
-			}
-
-			p.Send(errMsg(fmt.Errorf("error reading header from server: %w", err)))
+			p.Send(errMsg(err))
 			return
 		}
 
-		// Get the message size from the header, which lets us know how many bytes to read next
-		msgSize := binary.LittleEndian.Uint32(header)
+		sendFrame(p, message, sentAt)
+	}
+}
 
-		// Create a buffer to hold the incoming message
-		body := make([]byte, msgSize)
-		// Use io.ReadFull to ensure all bytes are read
-		_, err = io.ReadFull(conn, body)
-		if err != nil {
-			if errors.Is(err, net.ErrClosed) {
-				return // Connection closed
-			}
+// sendFrame sends a frame read from the server to the program as the matching message
+func sendFrame(p *tea.Program, message string, sentAt time.Time) {
+	// Stream markers bracket a group of messages that are rendered together
+	switch message {
+	case streamStartMarker:
+		p.Send(streamStartMsg{SentAt: sentAt})
+		return
+	case streamEndMarker:
+		p.Send(streamEndMsg{})
+		return
+	}
 
-			p.Send(errMsg(fmt.Errorf("error reading from server: %w", err)))
-			return
-		}
+	msg, ok := parseMessage(message, sentAt)
+	if !ok {
+		fmt.Println("Invalid message format, skipping:", message)
+		return // Skip processing this message
+	}
 
-		// Use lipgloss to style incoming messages
-		message := string(body)
-		parts := strings.SplitN(message, "|", 2) // Expects two parts: senderName, content
+	p.Send(msg)
+}
 
-		if len(parts) != 2 {
-			fmt.Println("Invalid message format, skipping:", message)
-			continue // Skip processing this message
-		}
+// sendColorProfile lets the server know which colors it can use in its messages
+func sendColorProfile(conn net.Conn) error {
+	if _, err := conn.Write([]byte("TERMCOLOR " + colorProfileName(lipgloss.ColorProfile()) + "\n")); err != nil {
+		return fmt.Errorf("failed to send color profile: %w", err)
+	}
+	return nil
+}
 
-		senderName := parts[0]
-		content := parts[1]
+// runChat runs the TUI until it quits, returning its final state
+func runChat(m model, replay []frame) (model, error) {
+	p := tea.NewProgram(m, tea.WithReportFocus())
 
-		p.Send(Message{
-			Content:    content,
-			SenderName: senderName,
-		})
+	go m.outbox.run(p)
+
+	go listener(m.conn, p, replay)
+
+	final, err := p.Run()
+	close(m.outbox.lines)
+	if err != nil {
+		return model{}, err
 	}
+	return final.(model), nil
 }
 
 func main() {
-	conn, err := connectToServer()
-	if err != nil {
-		log.Fatal("Failed to connect to server:", err)
+	// Subcommands run instead of the chat
+	args := os.Args[1:]
+	if len(args) > 0 {
+		switch args[0] {
+		case "send":
+			os.Exit(runSend(args[1:]))
+		case "daemon":
+			os.Exit(runDaemon(args[1:]))
+		case "kill":
+			os.Exit(runKill(args[1:]))
+		}
 	}
-	defer conn.Close() // Close the connection once the program ends
 
-	p := tea.NewProgram(initialModel(conn))
+	// attach opens the chat of a session daemon instead of connecting to the server, with the same flags
+	attach := len(args) > 0 && args[0] == "attach"
+	if attach {
+		args = args[1:]
+	}
+
+	colorMode := flag.String("color", "auto", "When to use colors: auto, always or never")
+	noTitle := flag.Bool("no-title", false, "Don't show the active channel and unread messages in the terminal title")
+	bell := flag.Bool("bell", false, "Ring the terminal bell for whispers received while the terminal isn't focused")
+	plain := flag.Bool("plain", false, "Print messages as plain lines and read input line by line instead of using the full screen interface, for screen readers")
+	socket := flag.String("socket", defaultSocketPath(), "Unix socket of the session daemon to attach to, with attach")
+	profileName := flag.String("profile", "", "Connect with a profile from the config file instead of to the default host")
+	proxyURL := flag.String("proxy", "", "Connect through a SOCKS5 proxy, e.g. socks5://localhost:1080")
+	flag.CommandLine.Parse(args)
+
+	dialer, err := newDialer(*proxyURL)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	go listener(conn, p)
+	// Plain output has no styling, so the server shouldn't color its messages either
+	if *plain {
+		*colorMode = "never"
+	}
 
-	if _, err := p.Run(); err != nil {
+	if err := setupColors(*colorMode); err != nil {
 		log.Fatal(err)
 	}
+
+	config, err := loadConfig()
+	if err != nil {
+		log.Fatal("Failed to load config:", err)
+	}
+
+	location := time.Local
+	if config.Timezone != "" {
+		location, err = time.LoadLocation(config.Timezone)
+		if err != nil {
+			log.Fatal("Invalid timezone in config:", err)
+		}
+	}
+
+	profile := defaultProfile()
+	if *profileName != "" {
+		var ok bool
+		if profile, ok = config.Profiles[*profileName]; !ok {
+			log.Fatalf("There is no profile named '%s' in the config", *profileName)
+		}
+	}
+
+	var (
+		conn   net.Conn
+		replay []frame // What the server sent while registering the profile's username
+	)
+	if attach {
+		conn, err = attachToDaemon(*socket)
+		if err != nil {
+			log.Fatal("Failed to attach to session: ", err)
+		}
+		if err := sendColorProfile(conn); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		conn, replay, err = connectProfile(profile, dialer)
+		if err != nil {
+			log.Fatal("Failed to connect to server: ", err)
+		}
+	}
+
+	if *plain {
+		defer conn.Close() // Close the connection once the program ends
+
+		client := newPlainClient(conn, location)
+		for _, f := range replay {
+			client.handleFrame(f)
+		}
+		if err := client.run(os.Stdin); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// /server quits the TUI to connect with another profile, which starts a new session
+	current := *profileName
+	profileErrors := make(map[string]string)
+	notice := ""
+	for {
+		termTitle := !*noTitle && !config.NoTitle && supportsTitle(os.Stdout, os.Getenv("TERM"))
+		m := initialModel(conn, config, location, termTitle, *bell || config.Bell)
+		m.attached = attach
+		m.profile = current
+		m.profileErrors = profileErrors
+		if notice != "" {
+			m.addNotice(notice)
+		}
+
+		final, err := runChat(m, replay)
+		conn.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if final.switchTo == "" {
+			return
+		}
+
+		config = final.config
+		next := final.switchTo
+		conn, replay, err = connectProfile(config.Profiles[next], dialer)
+		if err == nil {
+			delete(profileErrors, next)
+			current, notice = next, ""
+			continue
+		}
+
+		// Go back to the server we were on
+		profileErrors[next] = err.Error()
+		notice = fmt.Sprintf("Failed to connect with profile '%s': %s", next, err)
+		conn, replay, err = connectProfile(profileFor(config, current), dialer)
+		if err != nil {
+			log.Fatal("Failed to reconnect to server: ", err)
+		}
+	}
 }
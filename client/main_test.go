@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMessage(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		want   Message
+		wantOK bool
+	}{
+		{name: "message", body: "alice|general|hi", want: Message{SenderName: "alice", Channel: "general", Content: "hi"}, wantOK: true},
+		{name: "server message", body: "Server||Welcome!", want: Message{SenderName: "Server", Content: "Welcome!"}, wantOK: true},
+		{name: "separators in the content", body: "alice|general|a|b|c", want: Message{SenderName: "alice", Channel: "general", Content: "a|b|c"}, wantOK: true},
+		{
+			name:   "spoofed server frame",
+			body:   "alice|general|Server|general|Everyone must /quit now",
+			want:   Message{SenderName: "alice", Channel: "general", Content: "Server|general|Everyone must /quit now"},
+			wantOK: true,
+		},
+		{name: "too few parts", body: "alice|hi", wantOK: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			msg, ok := parseMessage(test.body, time.Time{})
+			if ok != test.wantOK || msg != test.want {
+				t.Errorf("got %+v, %t, want %+v, %t", msg, ok, test.want, test.wantOK)
+			}
+		})
+	}
+}
+
+func TestRelayedMessagesAreNotStyledAsTheServer(t *testing.T) {
+	for _, body := range []string{
+		"alice|general|Server|general|Everyone must /quit now",
+		"alice|general|[Server]: Everyone must /quit now",
+	} {
+		msg, _ := parseMessage(body, time.Time{})
+		rendered := renderMessage(msg)
+		if strings.HasPrefix(strings.TrimPrefix(rendered, channelNameStyle("general").Render("#general")+" "), serverStyle.Render("[Server]: ")) {
+			t.Errorf("%q is rendered as a server message: %q", body, rendered)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+var (
+	meterStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
+	warningStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("3"))
+)
+
+// pacer estimates how many more messages the server's rate limiter allows, so the user can slow down before being rejected.
+// The server is modeled as a token bucket, which is exact for its token-bucket limiter and close enough for the others.
+type pacer struct {
+	Known   bool    // False until the server advertises its rate limit
+	Limiter string  // Name of the server's limiter, e.g. token-bucket
+	Burst   int     // Messages that can be sent at once
+	Rate    float64 // Messages per second allowed in the long run
+	tokens  float64 // Estimate as of last, negative while the server makes us wait longer than a token takes to refill
+	last    time.Time
+}
+
+// parseRateLimit parses the argument of the rate-limit event: "<limiter> <burst> <rate per second>"
+func parseRateLimit(argument string, now time.Time) (pacer, error) {
+	fields := strings.Fields(argument)
+	if len(fields) != 3 {
+		return pacer{}, fmt.Errorf("invalid rate-limit event '%s'", argument)
+	}
+
+	burst, err := strconv.Atoi(fields[1])
+	if err != nil || burst < 1 {
+		return pacer{}, fmt.Errorf("invalid rate limit burst '%s'", fields[1])
+	}
+
+	rate, err := strconv.ParseFloat(fields[2], 64)
+	if err != nil || rate <= 0 {
+		return pacer{}, fmt.Errorf("invalid rate limit rate '%s'", fields[2])
+	}
+
+	return pacer{Known: true, Limiter: fields[0], Burst: burst, Rate: rate, tokens: float64(burst), last: now}, nil
+}
+
+// remaining returns the estimated number of tokens at the given time
+func (p pacer) remaining(now time.Time) float64 {
+	return math.Min(p.tokens+now.Sub(p.last).Seconds()*p.Rate, float64(p.Burst))
+}
+
+// spend records a line sent to the server, which takes a token whether it's a message or a command
+func (p *pacer) spend(now time.Time) {
+	if !p.Known {
+		return
+	}
+	p.tokens = p.remaining(now) - 1
+	p.last = now
+}
+
+// resync corrects the estimate after the server rejected a line, saying how long until the next one is accepted
+func (p *pacer) resync(now time.Time, retryAfter time.Duration) {
+	if !p.Known {
+		return
+	}
+	p.tokens = 1 - retryAfter.Seconds()*p.Rate
+	p.last = now
+}
+
+// status renders the meter shown under the input, with a warning once the user is about to be rate limited
+func (p pacer) status(now time.Time) string {
+	if !p.Known {
+		return ""
+	}
+
+	tokens := p.remaining(now)
+	left := max(0, int(tokens))
+	meter := meterStyle.Render(strings.Repeat("▮", left) + strings.Repeat("▯", p.Burst-left))
+
+	switch {
+	case left == 0:
+		wait := time.Duration((1 - tokens) / p.Rate * float64(time.Second))
+		return meter + " " + warningStyle.Render(fmt.Sprintf("slow down — wait %s", (wait+time.Second-1).Truncate(time.Second)))
+	case left == 1:
+		return meter + " " + warningStyle.Render("slow down — 1 message left")
+	default:
+		return meter
+	}
+}
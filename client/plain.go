@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// plainClient runs the chat without the TUI, for screen readers: messages are printed as lines as they arrive,
+// without redrawing the screen, and input is read line by line. Everything happens in the goroutine running run.
+type plainClient struct {
+	conn        net.Conn
+	location    *time.Location // Timezone used to display message times
+	out         io.Writer
+	server      serverProtocol
+	closeReason string    // Why the server closed the connection, if it said so
+	lastDay     time.Time // Day of the last printed message, to tell when the day changes
+
+	maxMessageLength int // Longest message the server accepts, in characters
+}
+
+// frame is a message read from the server
+type frame struct {
+	Body   string
+	SentAt time.Time
+}
+
+func newPlainClient(conn net.Conn, location *time.Location) *plainClient {
+	return &plainClient{conn: conn, location: location, out: os.Stdout, maxMessageLength: defaultMaxMessageLength}
+}
+
+// run relays the lines read from input to the server and prints what the server sends until either side is done
+func (p *plainClient) run(input io.Reader) error {
+	frames := make(chan frame)
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			body, sentAt, err := readFrame(p.conn)
+			if err != nil {
+				readErr <- err
+				return
+			}
+			frames <- frame{Body: body, SentAt: sentAt}
+		}
+	}()
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+
+		scanner := bufio.NewScanner(input)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	for {
+		select {
+		case f := <-frames:
+			p.handleFrame(f)
+		case line, ok := <-lines:
+			if !ok {
+				return nil // End of input
+			}
+			if err := p.handleInput(line); err != nil {
+				return err
+			}
+		case err := <-readErr:
+			// Keep the reason the server gave instead of the resulting read error
+			if p.closeReason != "" {
+				p.println("Disconnected by the server: " + p.closeReason)
+				return nil
+			}
+			if errors.Is(err, errDisconnected) {
+				p.println("Disconnected from server")
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// handleInput sends a line typed by the user to the server, unless the client handles it
+func (p *plainClient) handleInput(line string) error {
+	if strings.TrimSpace(line) == "" {
+		return nil
+	}
+
+	if strings.Contains(line, "|") {
+		p.println("Error: the '|' character is not allowed")
+		return nil
+	}
+
+	if err := checkMessageLength(line, p.maxMessageLength); err != nil {
+		p.println("Error: " + err.Error())
+		return nil
+	}
+
+	switch strings.Fields(line)[0] {
+	case "/version":
+		p.println(p.server.describe())
+		return nil
+	case "/setprompt", "/resetprompt":
+		p.println("There's no prompt in plain mode.")
+		return nil
+	case "/dm":
+		p.println("There are no conversations in plain mode, use /whisper <username> <message>.")
+		return nil
+	case "/server":
+		p.println("Servers can't be switched in plain mode, restart the client with --profile instead.")
+		return nil
+	}
+
+	_, err := p.conn.Write([]byte(line + "\n"))
+	return err
+}
+
+func (p *plainClient) handleFrame(f frame) {
+	// Stream chunks are printed as they arrive, there's nothing to group them in
+	if f.Body == streamStartMarker || f.Body == streamEndMarker {
+		return
+	}
+
+	msg, ok := parseMessage(f.Body, f.SentAt)
+	if !ok {
+		return
+	}
+	if bridged, ok := parseBridged(msg); ok {
+		msg = bridged
+	}
+
+	// Messages from "system" are control events, with the event in the channel field
+	if msg.SenderName == "system" {
+		p.handleEvent(msg.Channel, msg.Content)
+		return
+	}
+
+	// Plain output has no colors, so the color of channels is dropped
+	if msg.SenderName == "Server" {
+		_, msg.Content, _ = parseColorTag(msg.Content)
+	}
+
+	at := msg.SentAt.In(p.location)
+	if !sameDay(at, p.lastDay) {
+		p.println(formatDivider(at))
+	}
+	p.lastDay = at
+
+	p.println(at.Format("15:04") + " " + formatPlainMessage(msg))
+}
+
+// handleEvent handles the control events that matter without the TUI
+func (p *plainClient) handleEvent(event, argument string) {
+	switch event {
+	case "features":
+		if server, err := parseFeatures(argument); err == nil {
+			p.server = server
+		}
+	case "motd":
+		p.println("Message of the day from " + host + ":")
+		p.println(argument)
+		p.println("End of the message of the day.")
+	case "active-channel":
+		if argument != "" {
+			p.println("Your messages now go to #" + argument)
+		}
+	case "max-message-length":
+		if limit, err := parseMaxMessageLength(argument); err == nil {
+			p.maxMessageLength = limit
+		}
+	case "ping":
+		if p.server.enabled(FeatureHeartbeat) {
+			p.conn.Write([]byte("PONG\n"))
+		}
+	case "read-receipt":
+		// Whispers are read as soon as they are printed
+		p.conn.Write([]byte("READ_ACK " + argument + "\n"))
+	case "close":
+		p.closeReason = argument
+	}
+}
+
+// formatPlainMessage formats a message as "#channel sender: content", without any styling
+func formatPlainMessage(msg Message) string {
+	prefix := ""
+	if msg.Channel != "" {
+		prefix = "#" + msg.Channel + " "
+	}
+
+	switch {
+	case msg.SenderName == ".":
+		return prefix + msg.Content
+	case msg.Bridged:
+		return prefix + msg.SenderName + " (via bridge): " + msg.Content
+	}
+	return prefix + msg.SenderName + ": " + msg.Content
+}
+
+func (p *plainClient) println(text string) {
+	fmt.Fprintln(p.out, text)
+}
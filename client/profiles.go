@@ -0,0 +1,255 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// profileTimeout is how long connecting with a profile can take, including registering its username and joining its channels
+const profileTimeout = 10 * time.Second
+
+// Profile is a named set of connection settings from the config, picked with --profile or /server
+type Profile struct {
+	Host        string   `json:"host"`                   // Address of the server, e.g. "chat.example.org:3000"
+	TLS         bool     `json:"tls,omitempty"`          // Connect with TLS, e.g. to a server behind a TLS proxy
+	TLSCAFile   string   `json:"tls_ca_file,omitempty"`  // PEM file of the certificate authorities to trust instead of the system's
+	Username    string   `json:"username,omitempty"`     // Username registered when connecting, typed by hand if empty
+	PasswordEnv string   `json:"password_env,omitempty"` // Environment variable holding the password to /login with, never stored in the config
+	Channels    []string `json:"channels,omitempty"`     // Channels joined once registered
+}
+
+// defaultProfile connects to the default host without registering, as when no profile is used
+func defaultProfile() Profile {
+	return Profile{Host: host}
+}
+
+// profileFor returns the profile with the name, or the default one for an empty name
+func profileFor(config Config, name string) Profile {
+	if name == "" {
+		return defaultProfile()
+	}
+	return config.Profiles[name]
+}
+
+// profileNames lists the profiles of the config in alphabetical order, the order /server numbers them in
+func profileNames(config Config) []string {
+	names := make([]string, 0, len(config.Profiles))
+	for name := range config.Profiles {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// findProfile looks a profile up by name or by its number in /server
+func findProfile(config Config, nameOrNumber string) (string, bool) {
+	if _, ok := config.Profiles[nameOrNumber]; ok {
+		return nameOrNumber, true
+	}
+
+	names := profileNames(config)
+	if number, err := strconv.Atoi(nameOrNumber); err == nil && number >= 1 && number <= len(names) {
+		return names[number-1], true
+	}
+	return "", false
+}
+
+// password reads the profile's password from its environment variable, if it has one
+func (p Profile) password() (string, error) {
+	if p.PasswordEnv == "" {
+		return "", nil
+	}
+
+	password, ok := os.LookupEnv(p.PasswordEnv)
+	if !ok || password == "" {
+		return "", fmt.Errorf("the environment variable %s holding the password is not set", p.PasswordEnv)
+	}
+	return password, nil
+}
+
+// dialProfile connects to the profile's server with the dialer, over TLS if the profile asks for it
+func dialProfile(profile Profile, dialer Dialer) (net.Conn, error) {
+	conn, err := dial(dialer, profile.Host, profileTimeout)
+	if err != nil || !profile.TLS {
+		return conn, err
+	}
+
+	serverName, _, err := net.SplitHostPort(profile.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: serverName}
+	if profile.TLSCAFile != "" {
+		pem, err := os.ReadFile(profile.TLSCAFile)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to read the CA file: %w", err)
+		}
+
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			conn.Close()
+			return nil, fmt.Errorf("no certificates found in %s", profile.TLSCAFile)
+		}
+	}
+
+	// The handshake is done here so its errors are reported as connection errors
+	tlsConn := tls.Client(conn, config)
+	tlsConn.SetDeadline(time.Now().Add(profileTimeout))
+	defer tlsConn.SetDeadline(time.Time{})
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// connectProfile connects with the profile and registers its username, returning what the server sent meanwhile
+// so it can be shown once the chat starts
+func connectProfile(profile Profile, dialer Dialer) (net.Conn, []frame, error) {
+	conn, err := dialProfile(profile, dialer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	handshakeFrames, err := handshake(conn, supportedFeatures)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if err := sendColorProfile(conn); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	frames, err := registerProfile(conn, profile)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, append(handshakeFrames, frames...), nil
+}
+
+// registerProfile sets the profile's username, logging in with its password if the username is registered, and joins its channels.
+// Channels that can't be joined are skipped, the server's reply shows why.
+func registerProfile(conn net.Conn, profile Profile) ([]frame, error) {
+	if profile.Username == "" {
+		return nil, nil
+	}
+
+	password, err := profile.password()
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(profileTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	s := &sender{conn: conn, keepFrames: true}
+	if err := s.writeLine(profile.Username); err != nil {
+		return nil, err
+	}
+
+	needsLogin := false
+	err = s.await(func(text string) (bool, error) {
+		switch {
+		case strings.HasPrefix(text, "Your username has been set to"):
+			return true, nil
+		case strings.HasPrefix(text, "Failed to set username") && strings.Contains(text, "/login"):
+			needsLogin = true
+			return true, nil
+		case strings.HasPrefix(text, "Failed to set username"):
+			return false, fmt.Errorf("%w: %s", errRejected, text)
+		}
+		return false, nil // The welcome message
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if needsLogin {
+		if password == "" {
+			return nil, fmt.Errorf("'%s' is registered, set password_env in the profile to log in as it", profile.Username)
+		}
+		if err := s.writeLine("/login " + password); err != nil {
+			return nil, err
+		}
+		if err := s.await(confirmedBy("You are logged in as"), nil); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, channel := range profile.Channels {
+		if err := s.writeLine("/join " + channel); err != nil {
+			return nil, err
+		}
+
+		err := s.await(confirmedBy("You have joined channel"), nil)
+		if err != nil && !errors.Is(err, errRejected) {
+			return nil, err
+		}
+	}
+	return s.frames, nil
+}
+
+// describeProfiles lists the profiles for /server, numbered, with their address and whether we're connected with them
+func (m *model) describeProfiles() string {
+	names := profileNames(m.config)
+	if len(names) == 0 {
+		return "There are no profiles. Add them under \"profiles\" in the config file."
+	}
+
+	lines := []string{"Profiles (use /server <name|number> to connect with one):"}
+	for i, name := range names {
+		profile := m.config.Profiles[name]
+
+		address := profile.Host
+		if profile.TLS {
+			address += " (TLS)"
+		}
+
+		status := "not connected"
+		switch {
+		case name == m.profile:
+			status = "connected"
+		case m.profileErrors[name] != "":
+			status = "failed: " + m.profileErrors[name]
+		}
+		lines = append(lines, fmt.Sprintf("%d. %s — %s, %s", i+1, name, address, status))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// switchServer ends the session to connect with another profile, which main does once the TUI has quit
+func (m *model) switchServer(args []string) {
+	if m.attached {
+		m.err = errors.New("the session daemon stays connected to its server, start another daemon to use a profile")
+		return
+	}
+
+	if len(args) == 0 {
+		m.addNotice(m.describeProfiles())
+		return
+	}
+
+	name, ok := findProfile(m.config, args[0])
+	if !ok {
+		m.err = fmt.Errorf("there is no profile named '%s'", args[0])
+		return
+	}
+	if name == m.profile {
+		m.addNotice(fmt.Sprintf("Already connected with profile '%s'", name))
+		return
+	}
+
+	m.switchTo = name
+}
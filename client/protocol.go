@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Build version of the client, set with -ldflags "-X main.version=..."
+var version = "dev"
+
+// Version of the protocol spoken by the client
+const protocolVersion = 3
+
+// Feature is a bitmask of optional protocol features, matching the server's
+type Feature uint32
+
+const (
+	FeatureHeartbeat   Feature = 1 << iota // Pings answered with PONG
+	FeatureCompression                     // Compressed message bodies
+	FeatureE2E                             // End-to-end encrypted whispers
+	FeatureThreading                       // Replies threaded under a message
+	FeatureResume                          // Sessions resumed with a token after the connection drops, only used by the daemon
+	FeatureChannelList                     // The channel list sent as an event, for the channel browser
+	FeatureKeepalive                       // Empty frames sent by the server to keep idle connections open, dropped by readFrame
+)
+
+// supportedFeatures are the features this client implements
+const supportedFeatures = FeatureHeartbeat | FeatureChannelList | FeatureKeepalive
+
+var featureNames = []struct {
+	Feature Feature
+	Name    string
+}{
+	{FeatureHeartbeat, "heartbeat"},
+	{FeatureCompression, "compression"},
+	{FeatureE2E, "e2e"},
+	{FeatureThreading, "threading"},
+	{FeatureResume, "resume"},
+	{FeatureChannelList, "channel-list"},
+	{FeatureKeepalive, "keepalive"},
+}
+
+// Has reports whether all the given features are set
+func (f Feature) Has(features Feature) bool {
+	return f&features == features
+}
+
+// String lists the names of the features that are set, e.g. "heartbeat, threading"
+func (f Feature) String() string {
+	var names []string
+	for _, feature := range featureNames {
+		if f.Has(feature.Feature) {
+			names = append(names, feature.Name)
+		}
+	}
+
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ", ")
+}
+
+// handshakeTimeout is how long we wait for the features event the server answers HELLO with
+const handshakeTimeout = 2 * time.Second
+
+// handshake starts the connection with HELLO, our protocol version and the features we use. The server waits for it
+// before writing anything, since clients that don't send it are legacy clients it writes frames to differently.
+// Returns the frame the server answered with so it can still be handled, or none if the server was silent.
+func handshake(conn net.Conn, features Feature) ([]frame, error) {
+	if _, err := fmt.Fprintf(conn, "HELLO %d %d\n", protocolVersion, features); err != nil {
+		return nil, fmt.Errorf("failed to send the handshake: %w", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	body, sentAt, err := readFrame(conn)
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read the server's features: %w", err)
+	}
+	return []frame{{Body: body, SentAt: sentAt}}, nil
+}
+
+// serverProtocol is what the server advertised with the features event when we connected
+type serverProtocol struct {
+	Version  int
+	Features Feature
+	Known    bool // False until the server sends the features event, which older servers don't
+}
+
+// parseFeatures parses the argument of the features event: "<protocol version> <feature bitmask>"
+func parseFeatures(argument string) (serverProtocol, error) {
+	versionText, featuresText, ok := strings.Cut(argument, " ")
+	if !ok {
+		return serverProtocol{}, fmt.Errorf("invalid features event '%s'", argument)
+	}
+
+	protocolVersion, err := strconv.Atoi(versionText)
+	if err != nil {
+		return serverProtocol{}, fmt.Errorf("invalid protocol version '%s'", versionText)
+	}
+
+	features, err := strconv.ParseUint(featuresText, 10, 32)
+	if err != nil {
+		return serverProtocol{}, fmt.Errorf("invalid feature bitmask '%s'", featuresText)
+	}
+
+	return serverProtocol{Version: protocolVersion, Features: Feature(features), Known: true}, nil
+}
+
+// enabled reports whether the feature can be used: both the client and the server must support it.
+// Servers that predate the features event are assumed to support heartbeats, which they always had.
+func (p serverProtocol) enabled(feature Feature) bool {
+	if !supportedFeatures.Has(feature) {
+		return false
+	}
+	if !p.Known {
+		return feature == FeatureHeartbeat
+	}
+	return p.Features.Has(feature)
+}
+
+// describe formats the client and server versions shown by /version
+func (p serverProtocol) describe() string {
+	lines := []string{fmt.Sprintf("Client %s, protocol version %d, features: %s", version, protocolVersion, supportedFeatures)}
+	if !p.Known {
+		lines = append(lines, "The server didn't advertise its protocol version")
+		return strings.Join(lines, "\n")
+	}
+
+	lines = append(lines,
+		fmt.Sprintf("Server protocol version %d, features: %s (%d)", p.Version, p.Features, p.Features),
+		fmt.Sprintf("Enabled features: %s", supportedFeatures&p.Features))
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHandshake(t *testing.T) {
+	tests := []struct {
+		name       string
+		reply      string // Frame the server answers the handshake with, none if empty
+		wantFrames int
+	}{
+		{name: "features", reply: "system|features|3 79", wantFrames: 1},
+		{name: "silent server", wantFrames: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clientEnd, serverEnd := net.Pipe()
+			defer clientEnd.Close()
+			defer serverEnd.Close()
+
+			// The server writes nothing before the client's first line
+			firstLine := make(chan string, 1)
+			go func() {
+				line, err := bufio.NewReader(serverEnd).ReadString('\n')
+				if err != nil {
+					return
+				}
+				firstLine <- line
+				if test.reply != "" {
+					writeFrame(serverEnd, test.reply, time.Now())
+				}
+			}()
+
+			frames, err := handshake(clientEnd, FeatureHeartbeat)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(frames) != test.wantFrames {
+				t.Errorf("got %d frames, want %d", len(frames), test.wantFrames)
+			}
+			if want := fmt.Sprintf("HELLO %d %d\n", protocolVersion, FeatureHeartbeat); <-firstLine != want {
+				t.Errorf("the first line isn't %q", want)
+			}
+		})
+	}
+}
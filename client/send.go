@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Exit statuses of the send command
+const (
+	exitSendFailed   = 1 // Couldn't connect or the connection broke
+	exitSendUsage    = 2 // Invalid flags or message
+	exitSendRejected = 3 // The server refused the username, the channel or the message
+	exitSendTimeout  = 4 // The server didn't confirm everything within the timeout
+)
+
+var (
+	errRejected = errors.New("rejected by the server")
+	errTimedOut = errors.New("timed out")
+)
+
+// sender delivers messages for the send command, waiting for the server to confirm each step
+type sender struct {
+	conn        net.Conn
+	closeReason string  // Why the server closed the connection, if it said so
+	keepFrames  bool    // Keep everything read in frames, to show it later
+	frames      []frame // Everything read so far, if keepFrames is set
+}
+
+// runSend fires messages at a channel or user without starting the TUI, for scripts and notifications:
+//
+//	client send --host chat:3000 --username deploy-bot --channel ops "v1.4.2 deployed"
+//
+// Without a message argument, every line read from stdin is sent as a message. It returns the exit status.
+func runSend(args []string) int {
+	flags := flag.NewFlagSet("send", flag.ContinueOnError)
+	address := flags.String("host", host, "Address of the server")
+	proxyURL := flags.String("proxy", "", "Connect through a SOCKS5 proxy, e.g. socks5://localhost:1080")
+	username := flags.String("username", "", "Username to send the message as")
+	channel := flags.String("channel", "", "Channel to send the message to")
+	whisper := flags.String("whisper", "", "User to whisper the message to, instead of sending it to a channel")
+	timeout := flags.Duration("timeout", 10*time.Second, "How long to wait for everything to be sent and confirmed")
+	if err := flags.Parse(args); err != nil {
+		return exitSendUsage
+	}
+
+	if *username == "" || (*channel == "") == (*whisper == "") {
+		fmt.Fprintln(os.Stderr, "send needs a --username and either a --channel or a --whisper target")
+		return exitSendUsage
+	}
+
+	messages, err := sendMessages(flags.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitSendUsage
+	}
+
+	dialer, err := newDialer(*proxyURL)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitSendUsage
+	}
+
+	// The handshake only reads the features event, which isn't needed to send
+	conn, _, err := connectToServer(dialer, *address, *timeout, supportedFeatures)
+	if err != nil {
+		return exitSendFailed
+	}
+	defer conn.Close()
+
+	// Everything, including the confirmations, has to happen within the timeout
+	conn.SetDeadline(time.Now().Add(*timeout))
+
+	s := &sender{conn: conn}
+	if err := s.send(*username, *channel, *whisper, messages); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to send:", err)
+		switch {
+		case errors.Is(err, errRejected):
+			return exitSendRejected
+		case errors.Is(err, errTimedOut):
+			return exitSendTimeout
+		default:
+			return exitSendFailed
+		}
+	}
+	return 0
+}
+
+// sendMessages returns the message given as arguments, or the lines of stdin if there are none
+func sendMessages(args []string) ([]string, error) {
+	var messages []string
+	if len(args) > 0 {
+		messages = []string{strings.Join(args, " ")}
+	} else {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				messages = append(messages, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read the message from stdin: %w", err)
+		}
+	}
+
+	if len(messages) == 0 {
+		return nil, errors.New("there is no message to send")
+	}
+	for _, message := range messages {
+		if strings.Contains(message, "|") {
+			return nil, errors.New("the '|' character is not allowed")
+		}
+	}
+	return messages, nil
+}
+
+// send registers the username, joins the channel if sending to one, and sends every message,
+// waiting for the server to relay each of them
+func (s *sender) send(username, channel, whisper string, messages []string) error {
+	if err := s.writeLine(username); err != nil {
+		return err
+	}
+	err := s.await(func(text string) (bool, error) {
+		switch {
+		case strings.HasPrefix(text, "Your username has been set to"):
+			return true, nil
+		case strings.HasPrefix(text, "Failed to set username"):
+			return false, fmt.Errorf("%w: %s", errRejected, text)
+		}
+		return false, nil // The welcome message
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	if whisper != "" {
+		for _, message := range messages {
+			if err := s.writeLine("/whisper " + whisper + " " + message); err != nil {
+				return err
+			}
+			if err := s.await(confirmedBy("Whisper sent to"), nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := s.writeLine("/join " + channel); err != nil {
+		return err
+	}
+	if err := s.await(confirmedBy("You have joined channel"), nil); err != nil {
+		return err
+	}
+
+	for _, message := range messages {
+		if err := s.writeLine(message); err != nil {
+			return err
+		}
+
+		// The server echoes the messages it relays to their sender
+		echoed := func(event, argument string) bool {
+			parts := strings.SplitN(argument, " ", 3)
+			return event == "echo" && len(parts) == 3 && parts[1] == channel
+		}
+		if err := s.await(nil, echoed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// confirmedBy matches the server's reply starting with the confirmation, taking any other reply as a refusal
+func confirmedBy(confirmation string) func(text string) (bool, error) {
+	return func(text string) (bool, error) {
+		if strings.HasPrefix(text, confirmation) {
+			return true, nil
+		}
+		// Sent when the server has a default channel, before the reply to the join
+		if strings.HasPrefix(text, "You have been auto-joined") {
+			return false, nil
+		}
+		return false, fmt.Errorf("%w: %s", errRejected, text)
+	}
+}
+
+// await reads what the server sends until a reply matches or an event is seen.
+// Replies are the server's messages that aren't about a channel, matched with reply, and events are matched with event.
+func (s *sender) await(reply func(text string) (bool, error), event func(event, argument string) bool) error {
+	for {
+		body, sentAt, err := readFrame(s.conn)
+		if err != nil {
+			var netErr net.Error
+			switch {
+			case s.closeReason != "":
+				return fmt.Errorf("%w: %s", errRejected, s.closeReason)
+			case errors.As(err, &netErr) && netErr.Timeout():
+				return errTimedOut
+			}
+			return err
+		}
+
+		if s.keepFrames {
+			s.frames = append(s.frames, frame{Body: body, SentAt: sentAt})
+		}
+
+		msg, ok := parseMessage(body, sentAt)
+		if !ok {
+			continue
+		}
+
+		switch {
+		case msg.SenderName == "system":
+			if msg.Channel == "close" {
+				s.closeReason = msg.Content
+			}
+			if event != nil && event(msg.Channel, msg.Content) {
+				return nil
+			}
+		case msg.SenderName == "Server" && msg.Channel == "":
+			if reply == nil {
+				// Nothing but an event confirms this step, so any reply is a refusal, e.g. being muted
+				return fmt.Errorf("%w: %s", errRejected, msg.Content)
+			}
+			if done, err := reply(msg.Content); done || err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *sender) writeLine(line string) error {
+	_, err := s.conn.Write([]byte(line + "\n"))
+	return err
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+var (
+	motdStyle      = lipgloss.NewStyle().Border(lipgloss.RoundedBorder()).BorderForeground(lipgloss.Color("6")).Padding(0, 1)
+	motdTitleStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("6")).Bold(true)
+)
+
+// Columns and lines taken by the border and padding of the MOTD panel
+const (
+	motdFrameWidth  = 4
+	motdFrameHeight = 2
+)
+
+// splash shows the message of the day the server sent when we connected, instead of the chat, until a key is pressed
+type splash struct {
+	title    string
+	text     string
+	width    int
+	viewport viewport.Model // Scrolls MOTDs taller than the terminal
+}
+
+// motdTitle names the server the message of the day comes from, with its protocol version if it told us
+func (m *model) motdTitle() string {
+	if !m.server.Known {
+		return host
+	}
+	return fmt.Sprintf("%s · protocol v%d", host, m.server.Version)
+}
+
+// renderMOTD renders the message of the day as a panel of the given width, word wrapping its text
+func renderMOTD(title, text string, width int) string {
+	// The style's width includes the padding but not the border
+	return motdStyle.Width(max(width-2, 1)).Render(motdTitleStyle.Render(title) + "\n\n" + text)
+}
+
+func newSplash(title, text string, width, height int) *splash {
+	s := &splash{title: title, text: text}
+	s.resize(width, height)
+	return s
+}
+
+// resize fits the panel to the terminal, wrapping the text again for the new width
+func (s *splash) resize(width, height int) {
+	s.width = width
+	innerWidth := max(width-motdFrameWidth, 1)
+	content := lipgloss.NewStyle().Width(innerWidth).Render(s.text)
+
+	// Leave room for the title, the blank line after it and the hint below the panel
+	maxHeight := max(height-motdFrameHeight-3, 1)
+	s.viewport = viewport.New(innerWidth, min(lipgloss.Height(content), maxHeight))
+	s.viewport.SetContent(content)
+}
+
+func (s *splash) View() string {
+	hint := "Press any key to continue"
+	if !s.viewport.AtTop() || !s.viewport.AtBottom() {
+		hint = "↑/↓ to scroll, any other key to continue"
+	}
+
+	return renderMOTD(s.title, s.viewport.View(), s.width) + "\n" + timeStyle.Render(hint)
+}
+
+// updateSplash scrolls the splash with the arrow and page keys and dismisses it with any other key
+func (m model) updateSplash(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyCtrlC:
+		return m, tea.Quit
+	case tea.KeyUp:
+		m.splash.viewport.ScrollUp(1)
+	case tea.KeyDown:
+		m.splash.viewport.ScrollDown(1)
+	case tea.KeyPgUp:
+		m.splash.viewport.HalfPageUp()
+	case tea.KeyPgDown:
+		m.splash.viewport.HalfPageDown()
+	default:
+		m.splash = nil
+	}
+	return m, nil
+}
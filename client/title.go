@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/mattn/go-isatty"
+)
+
+const titlePrefix = "gochat"
+
+// buildTitle builds the terminal title from the active channel and the number of unread messages, e.g. "gochat — #general (3 unread)"
+func buildTitle(channel string, unread int) string {
+	title := titlePrefix
+	if channel != "" {
+		title += " — #" + channel
+	}
+	if unread > 0 {
+		title += fmt.Sprintf(" (%d unread)", unread)
+	}
+	return title
+}
+
+// supportsTitle reports whether the terminal can be given a title with escape sequences.
+// Output that isn't a terminal, dumb terminals and the Linux console would show them as garbage instead.
+func supportsTitle(out *os.File, term string) bool {
+	if !isatty.IsTerminal(out.Fd()) && !isatty.IsCygwinTerminal(out.Fd()) {
+		return false
+	}
+	return term != "dumb" && term != "linux"
+}
+
+// updateTitle returns a command that sets the terminal title, or nil if titles are disabled
+func (m *model) updateTitle() tea.Cmd {
+	if !m.termTitle {
+		return nil
+	}
+	return tea.SetWindowTitle(buildTitle(m.activeChannel, m.unread))
+}
+
+// countUnread counts a message received while the terminal isn't focused, returning a command to update the title.
+// Whispers are counted even while it's focused, until the user sends something.
+func (m *model) countUnread(whisper bool) tea.Cmd {
+	if m.focused && !whisper {
+		return nil
+	}
+
+	m.unread++
+	return m.updateTitle()
+}
+
+// markRead clears the unread messages count, returning a command to update the title if it changed
+func (m *model) markRead() tea.Cmd {
+	if m.unread == 0 {
+		return nil
+	}
+
+	m.unread = 0
+	return m.updateTitle()
+}
+
+// ringBell returns a command that rings the terminal bell for a whisper received while the terminal isn't focused,
+// which most terminals turn into an urgency hint or a flashing tab. Nil if the bell is disabled or the user is looking.
+func (m *model) ringBell() tea.Cmd {
+	if !m.bell || m.focused {
+		return nil
+	}
+	return func() tea.Msg {
+		// The renderer owns stdout, and the bell doesn't move the cursor wherever it's written
+		os.Stderr.WriteString("\a")
+		return nil
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// transcriptView shows the lines of the transcript that fit on screen. Unlike the bubbles viewport,
+// which splits and measures its whole content every time it changes, lines are appended as they are
+// rendered, so a new message costs the same however long the transcript is.
+type transcriptView struct {
+	Width   int
+	Height  int
+	YOffset int // Index of the first visible line
+	KeyMap  viewport.KeyMap
+	lines   []string
+}
+
+func newTranscriptView(width, height int) transcriptView {
+	return transcriptView{Width: width, Height: height, KeyMap: scrollKeyMap()}
+}
+
+// SetLines replaces every line, keeping the scroll position when possible
+func (t *transcriptView) SetLines(lines []string) {
+	t.lines = lines
+	t.SetYOffset(t.YOffset)
+}
+
+// AppendLines adds lines after the last one
+func (t *transcriptView) AppendLines(lines ...string) {
+	t.lines = append(t.lines, lines...)
+}
+
+func (t *transcriptView) maxYOffset() int {
+	return max(0, len(t.lines)-t.Height)
+}
+
+func (t *transcriptView) SetYOffset(offset int) {
+	t.YOffset = min(max(offset, 0), t.maxYOffset())
+}
+
+func (t *transcriptView) ScrollUp(n int) {
+	t.SetYOffset(t.YOffset - n)
+}
+
+func (t *transcriptView) ScrollDown(n int) {
+	t.SetYOffset(t.YOffset + n)
+}
+
+func (t *transcriptView) GotoBottom() {
+	t.YOffset = t.maxYOffset()
+}
+
+// Update scrolls the transcript with the keys of its key map
+func (t transcriptView) Update(msg tea.Msg) (transcriptView, tea.Cmd) {
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return t, nil
+	}
+
+	switch {
+	case key.Matches(keyMsg, t.KeyMap.Up):
+		t.ScrollUp(1)
+	case key.Matches(keyMsg, t.KeyMap.Down):
+		t.ScrollDown(1)
+	case key.Matches(keyMsg, t.KeyMap.PageUp):
+		t.ScrollUp(t.Height)
+	case key.Matches(keyMsg, t.KeyMap.PageDown):
+		t.ScrollDown(t.Height)
+	case key.Matches(keyMsg, t.KeyMap.HalfPageUp):
+		t.ScrollUp(t.Height / 2)
+	case key.Matches(keyMsg, t.KeyMap.HalfPageDown):
+		t.ScrollDown(t.Height / 2)
+	}
+	return t, nil
+}
+
+// View renders the visible lines, padded to the height of the view
+func (t transcriptView) View() string {
+	if t.Height <= 0 {
+		return ""
+	}
+
+	visible := make([]string, t.Height)
+	end := min(t.YOffset+t.Height, len(t.lines))
+	copy(visible, t.lines[min(t.YOffset, end):end])
+	return strings.Join(visible, "\n")
+}
+
+// renderEntry renders the message at index with its time, wrapped to the width of the transcript,
+// preceded by a divider if it's the first message of its day
+func (m *model) renderEntry(index int) []string {
+	return renderTranscriptEntry(m.messages, index, m.viewport.Width, m.location)
+}
+
+// renderTranscriptEntry renders the entry at index like renderEntry, for any list of entries
+func renderTranscriptEntry(entries []transcriptEntry, index, width int, location *time.Location) []string {
+	entry := entries[index]
+	at := entry.At.In(location)
+
+	var text string
+	if index == 0 || !sameDay(at, entries[index-1].At.In(location)) {
+		text = dividerStyle.Render(formatDivider(at)) + "\n"
+	}
+	text += timeStyle.Render(at.Format("15:04")) + " " + entry.Text + deliveryMarker(entry.Delivery, entry.Reason)
+
+	return strings.Split(lipgloss.NewStyle().Width(width).Render(text), "\n")
+}
+
+// syncTranscript updates the view with the messages added or changed since it was last synced.
+// Messages appended after the last one are rendered and appended, anything else rebuilds the view
+// from the lines cached for each message.
+func (m *model) syncTranscript() {
+	if !m.transcriptDirty {
+		for i := m.renderedCount; i < len(m.messages); i++ {
+			m.messages[i].lines = m.renderEntry(i)
+			m.viewport.AppendLines(m.messages[i].lines...)
+		}
+		m.renderedCount = len(m.messages)
+		return
+	}
+
+	var lines []string
+	for i := range m.messages {
+		if m.messages[i].lines == nil {
+			m.messages[i].lines = m.renderEntry(i)
+		}
+		lines = append(lines, m.messages[i].lines...)
+	}
+	m.viewport.SetLines(lines)
+	m.renderedCount = len(m.messages)
+	m.transcriptDirty = false
+}
+
+// invalidateEntry drops the cached lines of the message at index, if there's one, so it's rendered again on the next sync
+func (m *model) invalidateEntry(index int) {
+	if index < len(m.messages) {
+		m.messages[index].lines = nil
+	}
+	m.transcriptDirty = true
+}
+
+// invalidateTranscript renders every message again on the next sync, e.g. after the width changed
+func (m *model) invalidateTranscript() {
+	for i := range m.messages {
+		m.messages[i].lines = nil
+	}
+	m.transcriptDirty = true
+}
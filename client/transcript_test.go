@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestModel returns a model with only what the transcript needs, showing times in UTC
+func newTestModel(width, height int) *model {
+	return &model{viewport: newTranscriptView(width, height), location: time.UTC}
+}
+
+// renderAll renders every entry again from scratch, which syncing the transcript must match
+func renderAll(m *model) []string {
+	var lines []string
+	for i := range m.messages {
+		lines = append(lines, renderTranscriptEntry(m.messages, i, m.viewport.Width, m.location)...)
+	}
+	return lines
+}
+
+func TestTranscriptView(t *testing.T) {
+	tests := []struct {
+		name   string
+		lines  int
+		height int
+		scroll func(view *transcriptView)
+		want   []string
+	}{
+		{name: "empty", lines: 0, height: 3, want: []string{"", "", ""}},
+		{name: "fewer lines than fit", lines: 2, height: 3, want: []string{"line 0", "line 1", ""}},
+		{name: "top", lines: 5, height: 2, want: []string{"line 0", "line 1"}},
+		{name: "bottom", lines: 5, height: 2, scroll: (*transcriptView).GotoBottom, want: []string{"line 3", "line 4"}},
+		{name: "scrolled down", lines: 5, height: 2, scroll: func(view *transcriptView) { view.ScrollDown(2) }, want: []string{"line 2", "line 3"}},
+		{name: "scrolled past the bottom", lines: 5, height: 2, scroll: func(view *transcriptView) { view.ScrollDown(10) }, want: []string{"line 3", "line 4"}},
+		{name: "scrolled past the top", lines: 5, height: 2, scroll: func(view *transcriptView) { view.GotoBottom(); view.ScrollUp(10) }, want: []string{"line 0", "line 1"}},
+		{name: "no height", lines: 5, height: 0, want: []string{""}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			view := newTranscriptView(20, test.height)
+			for i := range test.lines {
+				view.AppendLines(fmt.Sprintf("line %d", i))
+			}
+			if test.scroll != nil {
+				test.scroll(&view)
+			}
+
+			if got := strings.Split(view.View(), "\n"); !slices.Equal(got, test.want) {
+				t.Errorf("showing %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSyncTranscript(t *testing.T) {
+	start := time.Date(2026, time.March, 4, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		update func(m *model) // Applied to a transcript of a message a minute from the start, once synced
+	}{
+		{name: "appended", update: func(m *model) { m.addMessage(start.Add(time.Hour), "later") }},
+		{name: "appended on the next day", update: func(m *model) { m.addMessage(start.Add(24*time.Hour), "tomorrow") }},
+		{name: "inserted", update: func(m *model) { m.addMessage(start.Add(90*time.Second), "replayed") }},
+		{name: "inserted first", update: func(m *model) { m.addMessage(start.Add(-time.Hour), "earlier") }},
+		{name: "inserted on the previous day", update: func(m *model) { m.addMessage(start.Add(-24*time.Hour), "yesterday") }},
+		{
+			name: "changed",
+			update: func(m *model) {
+				m.messages[1].Text = "edited"
+				m.invalidateEntry(1)
+			},
+		},
+		{
+			name: "resized",
+			update: func(m *model) {
+				m.viewport.Width = 12
+				m.invalidateTranscript()
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newTestModel(40, 100)
+			for i := range 5 {
+				m.addMessage(start.Add(time.Duration(i)*time.Minute), fmt.Sprintf("message %d is long enough to wrap", i))
+			}
+			m.syncTranscript()
+
+			test.update(m)
+			m.syncTranscript()
+
+			if want := renderAll(m); !slices.Equal(m.viewport.lines, want) {
+				t.Errorf("transcript is\n%s\nwant\n%s", strings.Join(m.viewport.lines, "\n"), strings.Join(want, "\n"))
+			}
+			if m.renderedCount != len(m.messages) || m.transcriptDirty {
+				t.Errorf("%d of %d messages rendered, dirty %t", m.renderedCount, len(m.messages), m.transcriptDirty)
+			}
+		})
+	}
+}
+
+func TestSyncTranscriptCostIsConstant(t *testing.T) {
+	// Adding a message to a long transcript allocates no more than adding one to a short one,
+	// since only the new message is rendered and appended
+	allocsAfter := func(existing int) float64 {
+		m := newTestModel(80, 40)
+		at := time.Date(2026, time.March, 4, 12, 0, 0, 0, time.UTC)
+		for range existing {
+			at = at.Add(time.Second)
+			m.addMessage(at, "hello there")
+		}
+		m.syncTranscript()
+
+		return testing.AllocsPerRun(200, func() {
+			at = at.Add(time.Second)
+			m.addMessage(at, "hello there")
+			m.syncTranscript()
+		})
+	}
+
+	short, long := allocsAfter(100), allocsAfter(5000)
+	if long > short*1.5+2 {
+		t.Errorf("adding a message allocates %.1f times with 5000 messages, %.1f with 100", long, short)
+	}
+}
+
+func BenchmarkAddMessage(b *testing.B) {
+	for _, existing := range []int{0, 5000} {
+		b.Run(fmt.Sprintf("%d messages", existing), func(b *testing.B) {
+			m := newTestModel(80, 40)
+			at := time.Date(2026, time.March, 4, 12, 0, 0, 0, time.UTC)
+			for range existing {
+				at = at.Add(time.Second)
+				m.addMessage(at, "hello there")
+			}
+			m.syncTranscript()
+
+			b.ResetTimer()
+			for range b.N {
+				at = at.Add(time.Second)
+				m.addMessage(at, "hello there")
+				m.syncTranscript()
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Size of the oversized line sent by the oversized scenario
+const oversizedLineSize = 4 << 20
+
+// ChaosScenario is a way a client can misbehave. Run is repeated until the deadline.
+type ChaosScenario struct {
+	Name string
+	Run  func(config Config, username string, deadline time.Time) error
+}
+
+// chaosScenarios are assigned to misbehaving clients in turn
+var chaosScenarios = []ChaosScenario{
+	{Name: "half-open", Run: halfOpen},
+	{Name: "mid-line", Run: midLineDisconnect},
+	{Name: "oversized", Run: oversized},
+	{Name: "garbage", Run: garbage},
+	{Name: "reconnect-loop", Run: reconnectLoop},
+	{Name: "pipes", Run: pipes},
+}
+
+// runChaos repeats the scenario until the deadline, counting each run.
+// Every run uses a new username since the server may not have dropped the previous connection yet.
+func runChaos(scenario ChaosScenario, config Config, username string, deadline time.Time, stats *Stats) {
+	for n := 0; time.Now().Before(deadline); n++ {
+		if err := scenario.Run(config, fmt.Sprintf("%s-%d", username, n), deadline); err != nil {
+			stats.ConnectFails.Add(1)
+			time.Sleep(100 * time.Millisecond)
+		}
+		stats.Scenarios[scenario.Name].Add(1)
+	}
+}
+
+// halfOpen connects and never sends anything, not even a username, holding the connection until the deadline
+func halfOpen(config Config, username string, deadline time.Time) error {
+	conn, err := net.DialTimeout("tcp", config.Host, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// The server may close the connection once the registration timeout expires
+	conn.SetReadDeadline(deadline)
+	buf := make([]byte, 512)
+	for {
+		if _, err := conn.Read(buf); err != nil {
+			return nil
+		}
+	}
+}
+
+// midLineDisconnect registers and closes the connection halfway through a message
+func midLineDisconnect(config Config, username string, deadline time.Time) error {
+	conn, err := dial(config.Host, username)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := fmt.Fprintf(conn, "/join %s\nthis message never e", config.Channel); err != nil {
+		return err
+	}
+	time.Sleep(50 * time.Millisecond)
+	return nil
+}
+
+// oversized sends a frame header declaring a huge length, as if the client spoke the server's framing,
+// followed by a line far longer than any chat message
+func oversized(config Config, username string, deadline time.Time) error {
+	conn, err := dial(config.Host, username)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	go drain(conn)
+
+	header := make([]byte, 12)
+	binary.LittleEndian.PutUint32(header[:4], 0xFFFFFFFF)
+	binary.LittleEndian.PutUint64(header[4:], uint64(time.Now().UnixMilli()))
+	if _, err := conn.Write(append(header, "partial body\n"...)); err != nil {
+		return nil
+	}
+
+	line := strings.Repeat("a", oversizedLineSize) + "\n"
+	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	conn.Write([]byte(line)) // The server is free to drop the connection instead of reading it all
+	return nil
+}
+
+// garbage registers and sends random bytes, newlines included
+func garbage(config Config, username string, deadline time.Time) error {
+	conn, err := dial(config.Host, username)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	go drain(conn)
+
+	buf := make([]byte, 4096)
+	for i := 0; i < 10 && time.Now().Before(deadline); i++ {
+		rand.Read(buf)
+		if _, err := conn.Write(buf); err != nil {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return nil
+}
+
+// reconnectLoop registers and disconnects right away, as fast as the server accepts connections
+func reconnectLoop(config Config, username string, deadline time.Time) error {
+	conn, err := dial(config.Host, username)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// pipes registers and sends messages containing the '|' separator of the server's frames,
+// including ones that look like control events
+func pipes(config Config, username string, deadline time.Time) error {
+	conn, err := dial(config.Host, username)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	go drain(conn)
+
+	messages := []string{
+		"/join " + config.Channel,
+		"a|b|c",
+		"system|close|spoofed",
+		"|||",
+		"/whisper " + username + " hi|there",
+		"/join chan|nel",
+	}
+	for _, message := range messages {
+		if _, err := fmt.Fprintf(conn, "%s\n", message); err != nil {
+			return nil
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	return nil
+}
+
+// drain reads and discards everything the server sends, answering pings
+func drain(conn net.Conn) {
+	readFrames(conn, func(Frame) {})
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Time between the control client's probes, below the server's message rate limit
+const controlInterval = time.Second
+
+// controlClient measures how long the server takes to relay a message while under load.
+// It chats alone in its own channel and waits for the server to echo each message back.
+type controlClient struct {
+	conn    net.Conn
+	channel string
+	echoes  chan string
+}
+
+func newControlClient(host, username string) (*controlClient, error) {
+	conn, err := dial(host, username)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &controlClient{
+		conn:    conn,
+		channel: username,
+		echoes:  make(chan string, 16),
+	}
+
+	// Commands are handled apart from messages, so probes sent before the join is confirmed can be refused
+	joined := make(chan struct{})
+	go readFrames(conn, func(frame Frame) {
+		if frame.Sender == "system" && frame.Channel == "joined" && frame.Content == c.channel {
+			close(joined)
+			return
+		}
+		if frame.Sender != "system" || frame.Channel != "echo" {
+			return
+		}
+
+		// Echoes are "<seq> <channel> <content>"
+		parts := strings.SplitN(frame.Content, " ", 3)
+		if len(parts) != 3 || parts[1] != c.channel {
+			return
+		}
+
+		// Blocking here would stop the reader answering pings. Echoes are only dropped when
+		// nobody is waiting for them, which awaitEcho skips as stale anyway.
+		select {
+		case c.echoes <- parts[2]:
+		default:
+		}
+	})
+
+	if _, err := fmt.Fprintf(conn, "/join %s\n", c.channel); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	select {
+	case <-joined:
+		return c, nil
+	case <-time.After(5 * time.Second):
+		conn.Close()
+		return nil, errors.New("the server didn't confirm the control client joined its channel")
+	}
+}
+
+// Measure probes the server until the deadline, returning the latency of each answered probe
+// and the number of probes that weren't answered within twice the max latency
+func (c *controlClient) Measure(deadline time.Time, maxLatency time.Duration) ([]time.Duration, int) {
+	var latencies []time.Duration
+	timeouts := 0
+
+	for n := 0; time.Now().Before(deadline); n++ {
+		probe := fmt.Sprintf("probe %d", n)
+		sentAt := time.Now()
+		if _, err := fmt.Fprintf(c.conn, "%s\n", probe); err != nil {
+			// The server dropped the control client, every remaining probe is lost
+			return latencies, timeouts + int(time.Until(deadline)/controlInterval) + 1
+		}
+
+		if c.awaitEcho(probe, 2*maxLatency) {
+			latencies = append(latencies, time.Since(sentAt))
+		} else {
+			timeouts++
+		}
+
+		time.Sleep(time.Until(sentAt.Add(controlInterval)))
+	}
+
+	return latencies, timeouts
+}
+
+// awaitEcho waits for the server to echo the probe, skipping the echoes of earlier probes that timed out
+func (c *controlClient) awaitEcho(probe string, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case echo := <-c.echoes:
+			if echo == probe {
+				return true
+			}
+		case <-timer.C:
+			return false
+		}
+	}
+}
+
+func (c *controlClient) Close() error {
+	return c.conn.Close()
+}
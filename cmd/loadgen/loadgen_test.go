@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The chaos scenarios run against a real server built from ../../server, checking it stays responsive
+
+const (
+	scenarioDuration = 3 * time.Second
+	chaosClients     = 3 // Clients running each scenario at once
+)
+
+var serverBinary string // Path of the server built by TestMain
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "loadgen-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	serverBinary = filepath.Join(dir, "server")
+	build := exec.Command("go", "build", "-o", serverBinary, "../../server")
+	build.Stdout, build.Stderr = os.Stderr, os.Stderr
+	if err := build.Run(); err != nil {
+		fmt.Fprintln(os.Stderr, "building the server:", err)
+		os.RemoveAll(dir)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// startServer runs the server on a free port until the test ends, returning its address
+func startServer(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	listener.Close()
+
+	server := exec.Command(serverBinary, "-host", "127.0.0.1", "-port", port, "-log-level", "error", "-log-format", "text")
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		server.Process.Kill()
+		server.Wait()
+	})
+
+	addr := net.JoinHostPort("127.0.0.1", port)
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(20 * time.Millisecond) {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			return addr
+		}
+	}
+	t.Fatal("the server didn't start listening")
+	return ""
+}
+
+func TestChaosScenarios(t *testing.T) {
+	if testing.Short() {
+		t.Skip("running every chaos scenario against a server")
+	}
+
+	// The scenarios table is the one the load generator assigns to misbehaving clients
+	for _, scenario := range chaosScenarios {
+		t.Run(scenario.Name, func(t *testing.T) {
+			t.Parallel()
+			config := Config{Host: startServer(t), Rate: 1, Channel: "chaos", MaxLatency: time.Second}
+			deadline := time.Now().Add(scenarioDuration)
+			stats := newStats()
+
+			control, err := newControlClient(config.Host, "control")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer control.Close()
+
+			var wg sync.WaitGroup
+			for i := range chaosClients {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					runChaos(scenario, config, fmt.Sprintf("chaos%d", i), deadline, stats)
+				}()
+			}
+
+			latencies, timeouts := control.Measure(deadline, config.MaxLatency)
+			wg.Wait()
+
+			if len(latencies) == 0 || timeouts > 0 {
+				t.Errorf("the server was unresponsive: %d probes answered, %d timeouts", len(latencies), timeouts)
+			}
+			for _, latency := range latencies {
+				if latency > config.MaxLatency {
+					t.Errorf("a probe took %s, want at most %s", latency, config.MaxLatency)
+				}
+			}
+			if runs := stats.Scenarios[scenario.Name].Load(); runs == 0 {
+				t.Error("the scenario never ran")
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	if testing.Short() {
+		t.Skip("generating load against a server")
+	}
+
+	tests := []struct {
+		name  string
+		chaos float64
+	}{
+		{name: "steady", chaos: 0},
+		{name: "mixed", chaos: 0.5},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			config := Config{
+				Host:       startServer(t),
+				Clients:    12,
+				Duration:   scenarioDuration,
+				Rate:       1,
+				Channel:    "load",
+				Chaos:      test.chaos,
+				MaxLatency: time.Second,
+			}
+			if !run(config) {
+				t.Error("the server was unresponsive")
+			}
+		})
+	}
+}
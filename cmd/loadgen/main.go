@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Config holds the load generator's settings
+type Config struct {
+	Host       string
+	Clients    int
+	Duration   time.Duration
+	Rate       float64 // Messages per second sent by each well-behaved client
+	Channel    string
+	Chaos      float64       // Fraction of clients that misbehave
+	MaxLatency time.Duration // Control latency above which the server is considered unresponsive
+}
+
+// Stats counts what happened during the run, shared by all simulated clients
+type Stats struct {
+	Connected    atomic.Int64
+	ConnectFails atomic.Int64
+	Sent         atomic.Int64
+	Received     atomic.Int64
+	Scenarios    map[string]*atomic.Int64 // Chaos scenario runs by name
+}
+
+// newStats returns stats with a counter for each chaos scenario
+func newStats() *Stats {
+	stats := &Stats{Scenarios: make(map[string]*atomic.Int64)}
+	for _, scenario := range chaosScenarios {
+		stats.Scenarios[scenario.Name] = &atomic.Int64{}
+	}
+	return stats
+}
+
+func main() {
+	host := flag.String("host", "localhost:3000", "Address of the server to load")
+	clients := flag.Int("clients", 50, "Number of simulated clients")
+	duration := flag.Duration("duration", 30*time.Second, "How long to generate load for")
+	rate := flag.Float64("rate", 0.5, "Messages per second sent by each well-behaved client")
+	channel := flag.String("channel", "loadgen", "Channel the simulated clients chat in")
+	chaos := flag.Float64("chaos", 0, "Fraction of clients (0 to 1) that misbehave: half-open connections, mid-line disconnects, oversized input, binary garbage, reconnect loops and pipe-laden messages")
+	maxLatency := flag.Duration("max-latency", 2*time.Second, "Control client latency above which the server is considered unresponsive")
+	flag.Parse()
+
+	if *chaos < 0 || *chaos > 1 {
+		log.Fatal("-chaos must be between 0 and 1")
+	}
+	if *rate <= 0 {
+		log.Fatal("-rate must be positive")
+	}
+
+	config := Config{
+		Host:       *host,
+		Clients:    *clients,
+		Duration:   *duration,
+		Rate:       *rate,
+		Channel:    *channel,
+		Chaos:      *chaos,
+		MaxLatency: *maxLatency,
+	}
+
+	if !run(config) {
+		os.Exit(1)
+	}
+}
+
+// run generates load until the configured duration is over, returning whether the server stayed responsive
+func run(config Config) bool {
+	stats := newStats()
+	deadline := time.Now().Add(config.Duration)
+	prefix := fmt.Sprintf("load%d-", os.Getpid())
+
+	control, err := newControlClient(config.Host, prefix+"control")
+	if err != nil {
+		log.Fatal("Error connecting the control client: ", err)
+	}
+	defer control.Close()
+
+	chaotic := int(float64(config.Clients) * config.Chaos)
+	fmt.Printf("Generating load on %s for %s: %d clients, %d misbehaving\n", config.Host, config.Duration, config.Clients, chaotic)
+
+	var wg sync.WaitGroup
+	for i := 0; i < config.Clients; i++ {
+		wg.Add(1)
+		username := fmt.Sprintf("%s%d", prefix, i)
+		if i < chaotic {
+			scenario := chaosScenarios[i%len(chaosScenarios)]
+			go func() {
+				defer wg.Done()
+				runChaos(scenario, config, username, deadline, stats)
+			}()
+			continue
+		}
+
+		go func() {
+			defer wg.Done()
+			runSteady(config, username, deadline, stats)
+		}()
+	}
+
+	latencies, timeouts := control.Measure(deadline, config.MaxLatency)
+	wg.Wait()
+
+	return report(config, stats, latencies, timeouts)
+}
+
+// runSteady simulates a well-behaved client chatting in the load channel until the deadline
+func runSteady(config Config, username string, deadline time.Time, stats *Stats) {
+	conn, err := dial(config.Host, username)
+	if err != nil {
+		stats.ConnectFails.Add(1)
+		return
+	}
+	defer conn.Close()
+	stats.Connected.Add(1)
+
+	go readFrames(conn, func(frame Frame) {
+		stats.Received.Add(1)
+	})
+
+	if _, err := fmt.Fprintf(conn, "/join %s\n", config.Channel); err != nil {
+		return
+	}
+
+	interval := time.Duration(float64(time.Second) / config.Rate)
+	// Spread the clients out so they don't all send at once
+	time.Sleep(time.Duration(rand.Int64N(int64(interval))))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for n := 0; time.Now().Before(deadline); n++ {
+		if _, err := fmt.Fprintf(conn, "message %d from %s\n", n, username); err != nil {
+			return
+		}
+		stats.Sent.Add(1)
+		<-ticker.C
+	}
+}
+
+// hello is the handshake clients start with: protocol version 3 with heartbeats, which readFrames answers
+const hello = "HELLO 3 1"
+
+// dial connects to the server and registers with the given username
+func dial(host, username string) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", host, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := fmt.Fprintf(conn, "%s\n%s\n", hello, username); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Frame is a message sent by the server
+type Frame struct {
+	Sender  string
+	Channel string
+	Content string
+	SentAt  time.Time
+}
+
+// readFrames calls handle for each frame sent by the server until the connection is closed,
+// answering pings so the connection isn't culled
+func readFrames(conn net.Conn, handle func(Frame)) error {
+	reader := bufio.NewReader(conn)
+	header := make([]byte, 12)
+
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			return err
+		}
+
+		body := make([]byte, binary.LittleEndian.Uint32(header[:4]))
+		if _, err := io.ReadFull(reader, body); err != nil {
+			return err
+		}
+
+		parts := strings.SplitN(string(body), "|", 3)
+		if len(parts) != 3 {
+			continue
+		}
+
+		frame := Frame{
+			Sender:  parts[0],
+			Channel: parts[1],
+			Content: parts[2],
+			SentAt:  time.UnixMilli(int64(binary.LittleEndian.Uint64(header[4:]))),
+		}
+		if frame.Sender == "system" && frame.Channel == "ping" {
+			if _, err := conn.Write([]byte("PONG\n")); err != nil {
+				return err
+			}
+		}
+		handle(frame)
+	}
+}
+
+// report prints the results of the run, returning whether the server stayed responsive
+func report(config Config, stats *Stats, latencies []time.Duration, timeouts int) bool {
+	fmt.Printf("\nClients connected: %d (%d failed to connect)\n", stats.Connected.Load(), stats.ConnectFails.Load())
+	fmt.Printf("Messages sent: %d, frames received: %d\n", stats.Sent.Load(), stats.Received.Load())
+
+	if config.Chaos > 0 {
+		fmt.Println("Chaos scenarios run:")
+		for _, scenario := range chaosScenarios {
+			fmt.Printf("  %-16s %d\n", scenario.Name, stats.Scenarios[scenario.Name].Load())
+		}
+	}
+
+	if len(latencies) == 0 {
+		fmt.Printf("Control client got no replies (%d timeouts), the server is unresponsive\n", timeouts)
+		return false
+	}
+
+	slices.Sort(latencies)
+	var total time.Duration
+	for _, latency := range latencies {
+		total += latency
+	}
+	p99 := latencies[min(len(latencies)-1, len(latencies)*99/100)]
+	worst := latencies[len(latencies)-1]
+
+	fmt.Printf("Control latency: avg %s, p99 %s, max %s over %d samples, %d timeouts\n",
+		(total / time.Duration(len(latencies))).Round(time.Microsecond), p99.Round(time.Microsecond), worst.Round(time.Microsecond), len(latencies), timeouts)
+
+	if timeouts > 0 || worst > config.MaxLatency {
+		fmt.Printf("The server was unresponsive for more than %s\n", config.MaxLatency)
+		return false
+	}
+
+	fmt.Println("The server stayed responsive")
+	return true
+}
@@ -0,0 +1,27 @@
+// Package pluginapi holds the types command plugins of the chat server are built against.
+//
+// The server lives in package main, which plugins can't import, so a plugin receives the client
+// that ran its command and the server as the interfaces below instead of the server's own types.
+package pluginapi
+
+// Client is the client that ran a plugin's command
+type Client interface {
+	GetUsername() string
+	IsAdmin() bool
+	SendServerMessage(text string) error
+}
+
+// Server is the chat server a plugin's command runs on. Commands run on the server's run loop,
+// so the methods must only be called from Exec, not from goroutines it starts.
+type Server interface {
+	// Usernames returns the usernames of the registered clients, sorted
+	Usernames() []string
+	// Broadcast sends a message from the server to the members of the channel
+	Broadcast(channel, message string) error
+}
+
+// CommandPlugin is implemented by the value exported as "Plugin" from a Go plugin built with -buildmode=plugin
+type CommandPlugin interface {
+	Name() string
+	Exec(args []string, client Client, server Server)
+}
@@ -0,0 +1,31 @@
+// Example command plugin for the chat server.
+//
+// Build it with:
+//
+//	go build -buildmode=plugin -o plugins/echo.so ./plugins/echo
+//
+// and load it as an admin with /loadplugin plugins/echo.so
+package main
+
+import (
+	"strings"
+
+	"github.com/CDavidSV/Go-TCP-Chat/pluginapi"
+)
+
+type echoPlugin struct{}
+
+func (echoPlugin) Name() string {
+	return "echoplugin"
+}
+
+func (echoPlugin) Exec(args []string, client pluginapi.Client, server pluginapi.Server) {
+	client.SendServerMessage(client.GetUsername() + " said: " + strings.Join(args, " "))
+}
+
+// Plugin is the symbol looked up by the server
+var Plugin echoPlugin
+
+var _ pluginapi.CommandPlugin = Plugin
+
+func main() {}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+var ErrInvalidBanIP = errors.New("invalid IP address")
+
+// Ban keeps the clients connecting from an IP address out of the server
+type Ban struct {
+	Expires  time.Time     // Zero if the ban is permanent
+	Duration time.Duration // Duration given in the ban file, used to tell whether a reload changed it
+	FromFile bool          // Loaded from the ban file, as opposed to added with /banip
+}
+
+// banList holds the banned IP addresses, loaded from a ban file and added by admins.
+// Only accessed from the server's run loop.
+type banList struct {
+	path string // Ban file, none if empty
+	bans map[string]Ban
+}
+
+// BanFileLine is an entry of the ban file: an IP address optionally followed by how long the ban lasts, e.g. "192.168.1.1 24h"
+type BanFileLine struct {
+	IP       string
+	Duration time.Duration // 0 for a permanent ban
+}
+
+// BanReload is the outcome of reloading the ban file
+type BanReload struct {
+	Added   int
+	Removed int
+	Skipped []string // Malformed lines, with their line number
+}
+
+// newBanList creates a ban list, loading the bans in the file at path if it isn't empty
+func newBanList(path string) (*banList, BanReload, error) {
+	b := &banList{path: path, bans: make(map[string]Ban)}
+	if path == "" {
+		return b, BanReload{}, nil
+	}
+
+	reload, err := b.Reload()
+	return b, reload, err
+}
+
+// parseBanFile reads the entries of a ban file, skipping blank lines and # comments.
+// Malformed lines are skipped and returned so they can be reported.
+func parseBanFile(path string) ([]BanFileLine, []string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	var entries []BanFileLine
+	var skipped []string
+	scanner := bufio.NewScanner(file)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		entry, err := parseBanLine(line)
+		if err != nil {
+			skipped = append(skipped, fmt.Sprintf("line %d: %s", number, err.Error()))
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, skipped, scanner.Err()
+}
+
+func parseBanLine(line string) (BanFileLine, error) {
+	fields := strings.Fields(line)
+	if len(fields) > 2 {
+		return BanFileLine{}, errors.New("expected an IP address and an optional duration")
+	}
+
+	ip := net.ParseIP(fields[0])
+	if ip == nil {
+		return BanFileLine{}, fmt.Errorf("%w '%s'", ErrInvalidBanIP, fields[0])
+	}
+
+	entry := BanFileLine{IP: ip.String()}
+	if len(fields) == 2 {
+		duration, err := time.ParseDuration(fields[1])
+		if err != nil || duration <= 0 {
+			return BanFileLine{}, fmt.Errorf("invalid duration '%s'", fields[1])
+		}
+		entry.Duration = duration
+	}
+	return entry, nil
+}
+
+// Reload replaces the bans loaded from the ban file with its current entries.
+// Bans added with /banip are kept whether the file lists them or not, and file bans whose duration didn't change keep their expiry.
+func (b *banList) Reload() (BanReload, error) {
+	entries, skipped, err := parseBanFile(b.path)
+	if err != nil {
+		return BanReload{}, err
+	}
+
+	reload := BanReload{Skipped: skipped}
+	listed := make(map[string]struct{}, len(entries))
+	now := time.Now()
+	for _, entry := range entries {
+		listed[entry.IP] = struct{}{}
+
+		existing, exists := b.bans[entry.IP]
+		if exists && (!existing.FromFile || existing.Duration == entry.Duration) {
+			continue
+		}
+		if !exists {
+			reload.Added++
+		}
+
+		ban := Ban{Duration: entry.Duration, FromFile: true}
+		if entry.Duration > 0 {
+			ban.Expires = now.Add(entry.Duration)
+		}
+		b.bans[entry.IP] = ban
+	}
+
+	for ip, ban := range b.bans {
+		if _, ok := listed[ip]; ban.FromFile && !ok {
+			delete(b.bans, ip)
+			reload.Removed++
+		}
+	}
+	return reload, nil
+}
+
+// HasFile reports whether bans are loaded from a file
+func (b *banList) HasFile() bool {
+	return b.path != ""
+}
+
+// Add bans the IP address, for the given duration or permanently if it's 0
+func (b *banList) Add(address string, duration time.Duration) error {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return ErrInvalidBanIP
+	}
+
+	ban := Ban{}
+	if duration > 0 {
+		ban.Expires = time.Now().Add(duration)
+	}
+	b.bans[ip.String()] = ban
+	return nil
+}
+
+// Remove lifts the ban on the IP address, returning false if it wasn't banned.
+// Bans from the ban file come back on the next reload if the file still lists them.
+func (b *banList) Remove(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+
+	if _, ok := b.bans[ip.String()]; !ok {
+		return false
+	}
+	delete(b.bans, ip.String())
+	return true
+}
+
+// IsBanned reports whether the IP address is banned, forgetting the ban if it has expired
+func (b *banList) IsBanned(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+
+	ban, ok := b.bans[ip.String()]
+	if !ok {
+		return false
+	}
+
+	if !ban.Expires.IsZero() && !time.Now().Before(ban.Expires) {
+		delete(b.bans, ip.String())
+		return false
+	}
+	return true
+}
+
+// Host returns the IP address of the client without its port
+func (c *Client) Host() string {
+	host, _, err := net.SplitHostPort(c.IP)
+	if err != nil {
+		return c.IP
+	}
+	return host
+}
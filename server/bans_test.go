@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// writeBanFile writes the ban file's content to a temporary file, returning its path
+func writeBanFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "bans")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseBanLine(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    BanFileLine
+		wantErr bool
+	}{
+		{line: "192.0.2.1", want: BanFileLine{IP: "192.0.2.1"}},
+		{line: "192.0.2.1 24h", want: BanFileLine{IP: "192.0.2.1", Duration: 24 * time.Hour}},
+		{line: "2001:DB8::1 1m", want: BanFileLine{IP: "2001:db8::1", Duration: time.Minute}},
+		{line: "192.0.2.1\t90s", want: BanFileLine{IP: "192.0.2.1", Duration: 90 * time.Second}},
+		{line: "192.0.2", wantErr: true},
+		{line: "example.com", wantErr: true},
+		{line: "192.0.2.1 forever", wantErr: true},
+		{line: "192.0.2.1 -1h", wantErr: true},
+		{line: "192.0.2.1 0s", wantErr: true},
+		{line: "192.0.2.1 1h extra", wantErr: true},
+	}
+
+	for _, test := range tests {
+		got, err := parseBanLine(test.line)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parseBanLine(%q): got error %v, want error: %t", test.line, err, test.wantErr)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("parseBanLine(%q) = %+v, want %+v", test.line, got, test.want)
+		}
+	}
+}
+
+func TestParseBanFile(t *testing.T) {
+	path := writeBanFile(t, "# Banned addresses\n\n192.0.2.1\n  192.0.2.2 1h  \nnot-an-address\n192.0.2.3 soon\n")
+
+	entries, skipped, err := parseBanFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantEntries := []BanFileLine{{IP: "192.0.2.1"}, {IP: "192.0.2.2", Duration: time.Hour}}
+	if !slices.Equal(entries, wantEntries) {
+		t.Errorf("entries %+v, want %+v", entries, wantEntries)
+	}
+	wantSkipped := []string{"line 5: invalid IP address 'not-an-address'", "line 6: invalid duration 'soon'"}
+	if !slices.Equal(skipped, wantSkipped) {
+		t.Errorf("skipped %q, want %q", skipped, wantSkipped)
+	}
+
+	if _, _, err := parseBanFile(filepath.Join(t.TempDir(), "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got error %v for a missing file", err)
+	}
+}
+
+func TestBanListReload(t *testing.T) {
+	tests := []struct {
+		name        string
+		before      string   // Ban file when the list is created
+		added       []string // Banned with /banip before reloading
+		after       string   // Ban file when reloading
+		want        BanReload
+		banned      []string
+		notBanned   []string
+		keepsExpiry bool // Whether 192.0.2.1's expiry is the one set when the list was created
+	}{
+		{
+			name:   "added",
+			before: "192.0.2.1\n",
+			after:  "192.0.2.1\n192.0.2.2\n",
+			want:   BanReload{Added: 1},
+			banned: []string{"192.0.2.1", "192.0.2.2"},
+		},
+		{
+			name:      "removed",
+			before:    "192.0.2.1\n192.0.2.2\n",
+			after:     "192.0.2.2\n",
+			want:      BanReload{Removed: 1},
+			banned:    []string{"192.0.2.2"},
+			notBanned: []string{"192.0.2.1"},
+		},
+		{
+			name:   "admin bans are kept",
+			before: "",
+			added:  []string{"192.0.2.9"},
+			after:  "192.0.2.1\n",
+			want:   BanReload{Added: 1},
+			banned: []string{"192.0.2.1", "192.0.2.9"},
+		},
+		{
+			name:        "unchanged durations keep their expiry",
+			before:      "192.0.2.1 1h\n",
+			after:       "192.0.2.1 1h\n",
+			banned:      []string{"192.0.2.1"},
+			keepsExpiry: true,
+		},
+		{
+			name:   "changed durations restart",
+			before: "192.0.2.1 1h\n",
+			after:  "192.0.2.1 2h\n",
+			banned: []string{"192.0.2.1"},
+		},
+		{
+			name:   "malformed lines are reported",
+			before: "",
+			after:  "192.0.2.1\nbad\n",
+			want:   BanReload{Added: 1, Skipped: []string{"line 2: invalid IP address 'bad'"}},
+			banned: []string{"192.0.2.1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := writeBanFile(t, test.before)
+			bans, _, err := newBanList(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, ip := range test.added {
+				bans.Add(ip, 0)
+			}
+			expiry := bans.bans["192.0.2.1"].Expires
+
+			time.Sleep(time.Millisecond) // So a restarted ban expires later
+			if err := os.WriteFile(path, []byte(test.after), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			reload, err := bans.Reload()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if reload.Added != test.want.Added || reload.Removed != test.want.Removed || !slices.Equal(reload.Skipped, test.want.Skipped) {
+				t.Errorf("reload %+v, want %+v", reload, test.want)
+			}
+			for _, ip := range test.banned {
+				if !bans.IsBanned(ip) {
+					t.Errorf("%s isn't banned", ip)
+				}
+			}
+			for _, ip := range test.notBanned {
+				if bans.IsBanned(ip) {
+					t.Errorf("%s is banned", ip)
+				}
+			}
+			if kept := bans.bans["192.0.2.1"].Expires.Equal(expiry); !expiry.IsZero() && kept != test.keepsExpiry {
+				t.Errorf("expiry kept: %t, want %t", kept, test.keepsExpiry)
+			}
+		})
+	}
+}
+
+func TestBanListExpiry(t *testing.T) {
+	tests := []struct {
+		name     string
+		address  string
+		duration time.Duration
+		checked  string
+		want     bool
+	}{
+		{name: "permanent", address: "192.0.2.1", checked: "192.0.2.1", want: true},
+		{name: "running", address: "192.0.2.1", duration: time.Hour, checked: "192.0.2.1", want: true},
+		{name: "expired", address: "192.0.2.1", duration: time.Nanosecond, checked: "192.0.2.1", want: false},
+		{name: "other address", address: "192.0.2.1", checked: "192.0.2.2", want: false},
+		{name: "same IPv6 address written differently", address: "2001:db8::1", checked: "2001:DB8:0::1", want: true},
+		{name: "not an address", address: "192.0.2.1", checked: "localhost", want: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bans, _, _ := newBanList("")
+			if err := bans.Add(test.address, test.duration); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(time.Millisecond)
+			if got := bans.IsBanned(test.checked); got != test.want {
+				t.Errorf("IsBanned(%q) = %t, want %t", test.checked, got, test.want)
+			}
+		})
+	}
+}
+
+func TestBannedClientsAreRefused(t *testing.T) {
+	server := newTestServer(t, nil)
+	server.bans.Add("127.0.0.1", 0)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Serve(listener)
+	defer server.Shutdown()
+
+	conn := dial(t, listener.Addr().String(), "")
+	defer conn.Close()
+
+	body, _, err := readFrame(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := formatEvent("close", "You are banned from this server."); body != want {
+		t.Errorf("got %q, want %q", body, want)
+	}
+}
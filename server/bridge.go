@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// Bridges are bots relaying channels to another chat system. Once an admin allows it with /bridge, a bridge sends the
+// messages of the remote users with "BRIDGEMSG <name>|<content>", which are shown as sent by "<name> (via bridge)".
+
+const (
+	bridgeSuffix         = " (via bridge)" // Appended to bridged names for clients that don't know the bridged event
+	maxBridgedNameLength = 32              // Longest name of a remote user, in characters
+)
+
+// parseBridgeMessage splits the argument of a BRIDGEMSG line into the name of the remote user and the content
+func parseBridgeMessage(argument string) (name, content string, err error) {
+	name, content, ok := strings.Cut(argument, "|")
+	name, content = strings.TrimSpace(name), strings.TrimSpace(content)
+	if !ok || name == "" || content == "" {
+		return "", "", errors.New("Usage: BRIDGEMSG <name>|<message>")
+	}
+	if utf8.RuneCountInString(name) > maxBridgedNameLength {
+		return "", "", fmt.Errorf("Bridged names can't exceed %d characters.", maxBridgedNameLength)
+	}
+	return name, content, nil
+}
+
+// relayBridgeMessage handles a BRIDGEMSG line sent by the client, routing it like a chat message.
+// It's rate limited like any other line, so a bridge shares its limit between all the users it relays.
+// Must only be called from the client's Read goroutine.
+func (c *Client) relayBridgeMessage(argument string) {
+	name, content, err := parseBridgeMessage(argument)
+	switch {
+	case !c.bridge.Load():
+		c.SendServerMessage("Only bridges can relay messages. Ask an admin to allow it with /bridge.")
+		c.rejectMessage(c.targetChannelName(content), "not a bridge")
+		return
+	case err != nil:
+		c.SendServerMessage(err.Error())
+		c.rejectMessage(c.targetChannelName(content), "invalid bridge message")
+		return
+	}
+
+	channel, content, ok := c.routeChatMessage(content)
+	if !ok || !c.checkMessageLength(channel.Name, content) {
+		return
+	}
+	if err := c.server.broadcastBridgeMessage(c, channel, name, content); err != nil {
+		c.rejectMessage(channel.Name, "server busy")
+		return
+	}
+	c.messageCount.Add(1)
+}
+
+// broadcastBridgeMessage queues a message relayed by a bridge for the members of the channel, like broadcastChatMessage
+func (s *Server) broadcastBridgeMessage(client Session, channel *Channel, name, msg string) error {
+	return s.queueChatMessage(Message{
+		Sender:     client,
+		SenderName: name + bridgeSuffix,
+		Channel:    channel,
+		Content:    msg,
+		Chat:       true,
+		Bridged:    name,
+	})
+}
+
+// checkBridgedName makes sure a bridged name can't pass for a user of this server
+func (s *Server) checkBridgedName(name string) error {
+	if s.isReservedUsername(name) {
+		return fmt.Errorf("'%s' is reserved", name)
+	}
+	if s.nicks.IsRegistered(name) {
+		return fmt.Errorf("'%s' is a registered username", name)
+	}
+
+	normalized := normalizeUsername(name)
+	for _, client := range s.clients {
+		if client.IsRegistered() && normalizeUsername(client.GetUsername()) == normalized {
+			return fmt.Errorf("'%s' is too similar to the user '%s'", name, client.GetUsername())
+		}
+	}
+	return nil
+}
+
+// formatBridged formats the bridged event sent instead of a chat message to clients that know it: "<channel> <name>|<content>"
+func formatBridged(msg Message) string {
+	return formatEvent("bridged", fmt.Sprintf("%s %s|%s", msg.Channel.Name, msg.Bridged, msg.Content))
+}
+
+// bridge allows a client to relay messages as a bridge for the rest of its session, or lists the bridges
+func bridge(name string, args []string, client Session, server *Server) {
+	if len(args) == 0 && name == "bridge" {
+		var bridges []string
+		for _, connectedClient := range server.clients {
+			if connectedClient.IsBridge() {
+				bridges = append(bridges, connectedClient.GetUsername())
+			}
+		}
+
+		if len(bridges) == 0 {
+			client.SendServerMessage("There are no bridges. Use /bridge <username> to allow a client to relay messages.")
+			return
+		}
+		slices.Sort(bridges)
+		client.SendServerMessage("Bridges: " + strings.Join(bridges, ", "))
+		return
+	}
+
+	if len(args) != 1 {
+		client.SendServerMessage(fmt.Sprintf("Usage: /%s <username>", name))
+		return
+	}
+
+	target, exists := server.clients[args[0]]
+	if !exists || !target.IsRegistered() {
+		client.SendServerMessage(fmt.Sprintf("User '%s' not found.", args[0]))
+		return
+	}
+
+	if name == "unbridge" {
+		if !target.SetBridge(false) {
+			client.SendServerMessage(fmt.Sprintf("'%s' is not a bridge.", args[0]))
+			return
+		}
+
+		server.log.command.Info("Bridge removed", "admin", client.GetUsername(), "username", args[0])
+		client.SendServerMessage(fmt.Sprintf("'%s' can no longer relay messages.", args[0]))
+		target.SendServerMessage("You can no longer relay messages as a bridge.")
+		return
+	}
+
+	if target.SetBridge(true) {
+		client.SendServerMessage(fmt.Sprintf("'%s' is already a bridge.", args[0]))
+		return
+	}
+
+	server.log.command.Info("Bridge added", "admin", client.GetUsername(), "username", args[0])
+	client.SendServerMessage(fmt.Sprintf("'%s' can now relay messages as a bridge until they disconnect.", args[0]))
+	target.SendServerMessage("You can now relay messages of other chat systems with BRIDGEMSG <name>|<message>. They are shown as sent by '<name> (via bridge)'.")
+}
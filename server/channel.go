@@ -1,43 +1,485 @@
 package main
 
-import "errors"
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"iter"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	maxPins        = 10  // Maximum number of pinned messages per channel
+	maxTopicLength = 200 // Maximum length of a channel topic in bytes
+	maxHintLength  = 80  // Maximum length of a password hint in characters, longer ones are truncated
+	maxInviteCodes = 20  // Maximum number of usable invite codes per channel
+
+	// Number of member colors clients can tell apart, even on terminals limited to the basic ANSI colors
+	channelPaletteSize = 12
+
+	defaultSpamLockDuration = 10 * time.Minute
+
+	defaultInviteCodeUses = 1
+	maxInviteCodeUses     = 100
+	defaultInviteCodeTTL  = 24 * time.Hour
+	maxInviteCodeTTL      = 7 * 24 * time.Hour
+)
 
 var (
-	ErrIncorrectPassword = errors.New("incorrect password")
+	ErrIncorrectPassword  = errors.New("incorrect password")
+	ErrPasswordRequired   = errors.New("password required")
+	ErrChannelLocked      = errors.New("channel is locked")
+	ErrTooManyPins        = errors.New("too many pinned messages")
+	ErrPinNotFound        = errors.New("pinned message not found")
+	ErrTooManyInviteCodes = errors.New("too many invite codes")
+	ErrInviteCodeNotFound = errors.New("invite code not found")
+	ErrInviteCodeExpired  = errors.New("invite code expired")
 )
 
+// Channel owns its membership and settings behind methods.
+// It isn't safe for concurrent use: all of its methods are called from the server's run loop,
+// including command handlers, which run on it too.
 type Channel struct {
-	Name     string
-	members  map[string]*Client
-	password string
+	Name      string
+	members   map[string]Session
+	password  string
+	hint      string // Shown to clients that try to join without the right password
+	operators map[Session]struct{}
+	owner     string    // Username of the member who created the channel, the only one besides admins who can change its topic
+	locked    bool      // Only invited clients can join while locked
+	hidden    bool      // Left out of /channels for non-members, but still joinable by name
+	spamLock  bool      // Only operators can send messages while in no-spam mode
+	spamUntil time.Time // When no-spam mode expires
+	color     string    // Color of the channel name in /channels, 0-255 or #rrggbb, empty for none
+	topic     ChannelTopic
+	invited   map[string]struct{} // Usernames allowed to join past the lock
+	pins      []Pin
+	codes     map[string]*InviteCode // Invite codes by code, kept after expiring so they can be told apart from wrong passwords
+	colors    map[string]int         // Palette index of each member by username
+	stats     map[Session]*MembershipStats
+	history   messageRing     // Last messages relayed to the channel, for /history
+	activity  ChannelActivity // Messages relayed in the last minutes, for /top and the metrics endpoint
+	format    FormattingMode  // Transformations applied to the messages sent to the channel
+	createdAt time.Time
+	createdBy string    // Username of the client that created the channel, kept when it's renamed or ownership changes
+	emptyAt   time.Time // When the last member left, zero while the channel has members
+}
+
+// MemberInfo is a snapshot of a channel member
+type MemberInfo struct {
+	Client   Session
+	Username string
+	Operator bool
+	Color    int // Palette index assigned with AssignColor
+}
+
+// ChannelTopic is the topic of a channel along with who set it and when
+type ChannelTopic struct {
+	Text  string    `json:"text"`
+	SetBy string    `json:"set_by"`
+	SetAt time.Time `json:"set_at"`
+}
+
+// Pin is a message pinned to a channel by one of its operators
+type Pin struct {
+	Text     string    `json:"text"`
+	PinnedBy string    `json:"pinned_by"`
+	PinnedAt time.Time `json:"pinned_at"`
+}
+
+// InviteCode lets users join a password protected channel without knowing its password
+type InviteCode struct {
+	Code      string
+	UsesLeft  int
+	ExpiresAt time.Time
+	CreatedBy string
+}
+
+func (ic *InviteCode) Expired() bool {
+	return ic.UsesLeft <= 0 || time.Now().After(ic.ExpiresAt)
 }
 
 type Message struct {
 	Channel    *Channel
+	Sender     Session // Nil for messages sent by the server
 	SenderName string
 	Content    string
+	Event      string // Control event sent instead of a chat message, with Content as its argument
+	Chat       bool   // Typed by the sender, as opposed to notices sent on its behalf, so channel modes apply
+	Bridged    string // Remote user a bridge relayed the message for, empty otherwise
 }
 
 func NewChannel(name, password string) *Channel {
 	return &Channel{
-		Name:     name,
-		members:  make(map[string]*Client),
-		password: password,
+		Name:      name,
+		members:   make(map[string]Session),
+		password:  password,
+		operators: make(map[Session]struct{}),
+		invited:   make(map[string]struct{}),
+		codes:     make(map[string]*InviteCode),
+		colors:    make(map[string]int),
+		stats:     make(map[Session]*MembershipStats),
+		format:    FormattingFull,
+		createdAt: time.Now(),
 	}
 }
 
-func (ch *Channel) AddMember(client *Client, password string) error {
-	// If the channel has a password, check it
-	if ch.password != "" && ch.password != password {
-		return ErrIncorrectPassword
+// AddMember adds the client to the channel. Passwords, locks and invite codes are checked by the caller.
+func (ch *Channel) AddMember(client Session) {
+	ch.members[client.GetUsername()] = client
+	if _, exists := ch.stats[client]; !exists {
+		ch.stats[client] = &MembershipStats{JoinedAt: time.Now()}
 	}
+	ch.emptyAt = time.Time{}
+}
 
-	ch.members[client.GetUsername()] = client
-	return nil
+// RemoveMember removes the client from the channel along with its operator status, color and stats,
+// recording when the channel became empty if it was the last member
+func (ch *Channel) RemoveMember(client Session) {
+	if ch.members[client.GetUsername()] == client {
+		delete(ch.members, client.GetUsername())
+		delete(ch.colors, client.GetUsername())
+	}
+	delete(ch.operators, client)
+	delete(ch.stats, client)
+
+	if len(ch.members) == 0 {
+		ch.emptyAt = time.Now()
+	}
+}
+
+// RenameMember updates the username a member is known by, keeping its color
+func (ch *Channel) RenameMember(oldUsername, newUsername string) {
+	client, ok := ch.members[oldUsername]
+	if !ok {
+		return
+	}
+
+	delete(ch.members, oldUsername)
+	ch.members[newUsername] = client
+
+	if index, ok := ch.colors[oldUsername]; ok {
+		delete(ch.colors, oldUsername)
+		ch.colors[newUsername] = index
+	}
+
+	if ch.owner == oldUsername {
+		ch.owner = newUsername
+	}
+}
+
+// ReplaceMember hands the membership of a client, with its operator status and stats, to another client with the same username
+func (ch *Channel) ReplaceMember(old, replacement Session) {
+	username := old.GetUsername()
+	if ch.members[username] != old {
+		return
+	}
+	ch.members[username] = replacement
+
+	if _, isOperator := ch.operators[old]; isOperator {
+		delete(ch.operators, old)
+		ch.operators[replacement] = struct{}{}
+	}
+	if stats, exists := ch.stats[old]; exists {
+		delete(ch.stats, old)
+		ch.stats[replacement] = stats
+	}
+}
+
+// Member returns the member with the given username
+func (ch *Channel) Member(username string) (Session, bool) {
+	client, ok := ch.members[username]
+	return client, ok
+}
+
+// Sessions iterates over the members in no particular order. Unlike Members it doesn't copy or sort them,
+// so it's what the broadcast path uses; members must not be added while iterating.
+func (ch *Channel) Sessions() iter.Seq[Session] {
+	return maps.Values(ch.members)
+}
+
+// Members returns a snapshot of the members sorted by username for display, so the channel can be changed while iterating
+func (ch *Channel) Members() []MemberInfo {
+	members := make([]MemberInfo, 0, len(ch.members))
+	for username, client := range ch.members {
+		members = append(members, MemberInfo{
+			Client:   client,
+			Username: username,
+			Operator: ch.IsOperator(client),
+			Color:    ch.colors[username],
+		})
+	}
+
+	slices.SortFunc(members, func(a, b MemberInfo) int {
+		return strings.Compare(a.Username, b.Username)
+	})
+	return members
+}
+
+func (ch *Channel) MemberCount() int {
+	return len(ch.members)
+}
+
+func (ch *Channel) IsEmpty() bool {
+	return len(ch.members) == 0
+}
+
+// EmptySince returns when the last member left, zero while the channel has members
+func (ch *Channel) EmptySince() time.Time {
+	return ch.emptyAt
+}
+
+func (ch *Channel) CreatedAt() time.Time {
+	return ch.createdAt
+}
+
+// LastActivity returns when the channel was last used: when its last member left or its last message was sent,
+// whichever came later, or when it was created if neither happened
+func (ch *Channel) LastActivity() time.Time {
+	last := ch.createdAt
+	if ch.emptyAt.After(last) {
+		last = ch.emptyAt
+	}
+	if entry, ok := ch.history.newest(); ok && entry.Time.After(last) {
+		last = entry.Time
+	}
+	return last
+}
+
+// CreatedBy returns the username of the client that created the channel, "unknown" if it wasn't registered
+func (ch *Channel) CreatedBy() string {
+	if ch.createdBy == "" {
+		return "unknown"
+	}
+	return ch.createdBy
+}
+
+// SetCreatedBy records the username of the client that created the channel
+func (ch *Channel) SetCreatedBy(username string) {
+	ch.createdBy = username
+}
+
+// AssignColor gives the member the palette color used by the fewest members, preferring lower indexes on ties.
+// Members keep their color until they leave.
+func (ch *Channel) AssignColor(username string) int {
+	if index, ok := ch.colors[username]; ok {
+		return index
+	}
+
+	var used [channelPaletteSize]int
+	for _, index := range ch.colors {
+		used[index]++
+	}
+
+	best := 0
+	for index := range used {
+		if used[index] < used[best] {
+			best = index
+		}
+	}
+
+	ch.colors[username] = best
+	return best
+}
+
+// MemberColor returns the palette color assigned to the member
+func (ch *Channel) MemberColor(username string) int {
+	return ch.colors[username]
+}
+
+func (ch *Channel) IsOperator(client Session) bool {
+	_, ok := ch.operators[client]
+	return ok
+}
+
+func (ch *Channel) AddOperator(client Session) {
+	ch.operators[client] = struct{}{}
+}
+
+func (ch *Channel) RemoveOperator(client Session) {
+	delete(ch.operators, client)
+}
+
+func (ch *Channel) HasOperators() bool {
+	return len(ch.operators) > 0
+}
+
+// Operators returns the usernames of the operators, sorted
+func (ch *Channel) Operators() []string {
+	operators := make([]string, 0, len(ch.operators))
+	for operator := range ch.operators {
+		operators = append(operators, operator.GetUsername())
+	}
+	slices.Sort(operators)
+	return operators
+}
+
+func (ch *Channel) IsLocked() bool {
+	return ch.locked
+}
+
+func (ch *Channel) SetLocked(locked bool) {
+	ch.locked = locked
+}
+
+func (ch *Channel) IsHidden() bool {
+	return ch.hidden
 }
 
-func (ch *Channel) RemoveMember(client *Client) {
-	delete(ch.members, client.GetUsername())
+func (ch *Channel) SetHidden(hidden bool) {
+	ch.hidden = hidden
+}
+
+// Color returns the color of the channel name, empty for none
+func (ch *Channel) Color() string {
+	return ch.color
+}
+
+// SetColor changes the color of the channel name, which must have been validated with validateChannelColor
+func (ch *Channel) SetColor(color string) {
+	ch.color = color
+}
+
+func (ch *Channel) Owner() string {
+	return ch.owner
+}
+
+// SetOwner makes the user the owner of the channel
+func (ch *Channel) SetOwner(username string) {
+	ch.owner = username
+}
+
+// IsOwner reports whether the client is the member that owns the channel
+func (ch *Channel) IsOwner(client Session) bool {
+	return ch.owner != "" && ch.members[ch.owner] == client
+}
+
+func (ch *Channel) Topic() ChannelTopic {
+	return ch.topic
+}
+
+// SetTopic changes the topic of the channel, recording who changed it and when
+func (ch *Channel) SetTopic(text, setBy string) {
+	ch.topic = ChannelTopic{
+		Text:  text,
+		SetBy: setBy,
+		SetAt: time.Now(),
+	}
+}
+
+// PasswordHint returns the hint shown to clients that fail to give the password, empty if there is none
+func (ch *Channel) PasswordHint() string {
+	return ch.hint
+}
+
+// SetPasswordHint changes the password hint, truncating it to maxHintLength characters.
+// It returns the hint as it was set.
+func (ch *Channel) SetPasswordHint(hint string) string {
+	if runes := []rune(hint); len(runes) > maxHintLength {
+		hint = string(runes[:maxHintLength])
+	}
+	ch.hint = hint
+	return hint
+}
+
+func (ch *Channel) FormattingMode() FormattingMode {
+	return ch.format
+}
+
+// SetFormattingMode changes which transformations are applied to the messages sent to the channel
+func (ch *Channel) SetFormattingMode(mode FormattingMode) {
+	ch.format = mode
+}
+
+// SetSpamLock enables no-spam mode for the given duration
+func (ch *Channel) SetSpamLock(duration time.Duration) {
+	ch.spamLock = true
+	ch.spamUntil = time.Now().Add(duration)
+}
+
+func (ch *Channel) ClearSpamLock() {
+	ch.spamLock = false
+	ch.spamUntil = time.Time{}
+}
+
+func (ch *Channel) IsSpamLocked() bool {
+	return ch.spamLock
+}
+
+// SpamLockExpired reports whether no-spam mode is enabled but should have expired already
+func (ch *Channel) SpamLockExpired() bool {
+	return ch.spamLock && !time.Now().Before(ch.spamUntil)
+}
+
+// Invite allows the user to join the channel even while it's locked
+func (ch *Channel) Invite(username string) {
+	ch.invited[username] = struct{}{}
+}
+
+func (ch *Channel) IsInvited(username string) bool {
+	_, ok := ch.invited[username]
+	return ok
+}
+
+// UseInvite removes the user's invite, since invites can only be used once
+func (ch *Channel) UseInvite(username string) {
+	delete(ch.invited, username)
+}
+
+func (ch *Channel) AddPin(text, pinnedBy string) (Pin, error) {
+	if len(ch.pins) >= maxPins {
+		return Pin{}, ErrTooManyPins
+	}
+
+	pin := Pin{
+		Text:     text,
+		PinnedBy: pinnedBy,
+		PinnedAt: time.Now(),
+	}
+	ch.pins = append(ch.pins, pin)
+	return pin, nil
+}
+
+// RemovePin removes the pin at the given position, starting from 1 as shown by /pins
+func (ch *Channel) RemovePin(position int) (Pin, error) {
+	if position < 1 || position > len(ch.pins) {
+		return Pin{}, ErrPinNotFound
+	}
+
+	pin := ch.pins[position-1]
+	ch.pins = slices.Delete(ch.pins, position-1, position)
+	return pin, nil
+}
+
+// restoreTopic sets the topic saved earlier, keeping who set it and when
+func (ch *Channel) restoreTopic(topic ChannelTopic) {
+	ch.topic = topic
+}
+
+// restorePins replaces the pins of the channel with pins saved earlier, keeping at most maxPins
+func (ch *Channel) restorePins(pins []Pin) {
+	ch.pins = slices.Clone(pins[:min(len(pins), maxPins)])
+}
+
+func (ch *Channel) HasPins() bool {
+	return len(ch.pins) > 0
+}
+
+func (ch *Channel) Pins() []Pin {
+	return slices.Clone(ch.pins)
+}
+
+// AddHistory records a message relayed to the channel, dropping the oldest one once the history is full
+func (ch *Channel) AddHistory(entry HistoryEntry) {
+	ch.history.add(entry)
+}
+
+// History returns up to n of the channel's most recent messages, oldest first
+func (ch *Channel) History(n int) []HistoryEntry {
+	return ch.history.last(n)
 }
 
 func (ch *Channel) RequiresPassword() bool {
@@ -45,5 +487,93 @@ func (ch *Channel) RequiresPassword() bool {
 }
 
 func (ch *Channel) ValidatePassword(password string) bool {
-	return ch.password == password
+	return passwordsEqual(password, ch.password)
+}
+
+// CreateInviteCode generates a random invite code that can be used the given number of times until it expires
+func (ch *Channel) CreateInviteCode(uses int, ttl time.Duration, createdBy string) (*InviteCode, error) {
+	if len(ch.InviteCodes()) >= maxInviteCodes {
+		return nil, ErrTooManyInviteCodes
+	}
+
+	// 5 random bytes encode to 8 characters without padding
+	var code string
+	for code == "" || ch.codes[code] != nil {
+		random := make([]byte, 5)
+		if _, err := rand.Read(random); err != nil {
+			return nil, err
+		}
+		code = base32.StdEncoding.EncodeToString(random)
+	}
+
+	inviteCode := &InviteCode{
+		Code:      code,
+		UsesLeft:  uses,
+		ExpiresAt: time.Now().Add(ttl),
+		CreatedBy: createdBy,
+	}
+	ch.codes[code] = inviteCode
+	return inviteCode, nil
+}
+
+// UseInviteCode takes one use from the invite code
+func (ch *Channel) UseInviteCode(code string) error {
+	if err := ch.CheckInviteCode(code); err != nil {
+		return err
+	}
+
+	ch.codes[strings.ToUpper(code)].UsesLeft--
+	return nil
+}
+
+// CheckInviteCode returns the error UseInviteCode would, without using the code
+func (ch *Channel) CheckInviteCode(code string) error {
+	inviteCode, exists := ch.codes[strings.ToUpper(code)]
+	if !exists {
+		return ErrInviteCodeNotFound
+	}
+
+	if inviteCode.Expired() {
+		return ErrInviteCodeExpired
+	}
+	return nil
+}
+
+// PruneInviteCodes deletes the invite codes that expired before the cutoff, which are treated as wrong passwords from then on.
+// Returns how many were deleted.
+func (ch *Channel) PruneInviteCodes(cutoff time.Time) int {
+	pruned := 0
+	for code, inviteCode := range ch.codes {
+		if inviteCode.ExpiresAt.Before(cutoff) {
+			delete(ch.codes, code)
+			pruned++
+		}
+	}
+	return pruned
+}
+
+// RevokeInviteCode deletes the invite code, so it's treated as a wrong password from then on
+func (ch *Channel) RevokeInviteCode(code string) error {
+	code = strings.ToUpper(code)
+	if _, exists := ch.codes[code]; !exists {
+		return ErrInviteCodeNotFound
+	}
+
+	delete(ch.codes, code)
+	return nil
+}
+
+// InviteCodes returns the invite codes that can still be used, the ones expiring first come first
+func (ch *Channel) InviteCodes() []InviteCode {
+	var codes []InviteCode
+	for _, inviteCode := range ch.codes {
+		if !inviteCode.Expired() {
+			codes = append(codes, *inviteCode)
+		}
+	}
+
+	slices.SortFunc(codes, func(a, b InviteCode) int {
+		return a.ExpiresAt.Compare(b.ExpiresAt)
+	})
+	return codes
 }
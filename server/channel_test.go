@@ -0,0 +1,46 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestChannelSessions(t *testing.T) {
+	tests := []struct {
+		name    string
+		members []string
+	}{
+		{name: "empty"},
+		{name: "one member", members: []string{"alice"}},
+		{name: "several members", members: []string{"carol", "alice", "bob"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			channel := NewChannel("general", "")
+			for _, username := range test.members {
+				channel.AddMember(newFakeSession(username))
+			}
+
+			var got []string
+			for member := range channel.Sessions() {
+				got = append(got, member.GetUsername())
+			}
+			slices.Sort(got)
+			want := slices.Sorted(slices.Values(test.members))
+			if !slices.Equal(got, want) {
+				t.Errorf("got the members %q, want %q", got, want)
+			}
+
+			// The broadcast path goes over the members for every message, so it mustn't copy them
+			allocs := testing.AllocsPerRun(100, func() {
+				for member := range channel.Sessions() {
+					_ = member
+				}
+			})
+			if allocs > 0 {
+				t.Errorf("iterating allocated %.0f times", allocs)
+			}
+		})
+	}
+}
@@ -6,12 +6,17 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"math"
 	"math/rand/v2"
 	"net"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
+	"unicode/utf8"
+
+	"github.com/muesli/termenv"
 )
 
 var rateLimitMessages []string = []string{
@@ -25,45 +30,148 @@ var rateLimitMessages []string = []string{
 	"Let's keep the chat enjoyable for everyone.",
 }
 
+var (
+	ErrLineTooLong      = errors.New("line too long")
+	ErrSendQueueFull    = errors.New("send queue full, closing the connection")
+	ErrConnectionClosed = errors.New("connection closed after the send queue filled up")
+)
+
+// Number of times a client can exceed the join flood limit before being muted
+const joinFloodOffensesBeforeMute = 3
+
+const (
+	streamStartMarker = ".|.|STREAM_START"
+	streamEndMarker   = ".|.|STREAM_END"
+)
+
+// OutgoingMessage is a formatted message waiting to be written to the client, along with the time it was sent
+type OutgoingMessage struct {
+	Body   string
+	SentAt time.Time
+	Close  bool // Close the connection once the message has been written
+}
+
+// SessionState is the part of a session that command handlers keep between commands.
+// It's only accessed from the server's run loop, which is why it needs no locking.
+type SessionState struct {
+	// Separate buckets for channel creation, which is more expensive than sending a message,
+	// and whispers, which bypass channel moderation.
+	channelCreateBucket tokenBucket
+	whisperBucket       tokenBucket
+	receivedWhispers    []time.Time          // Whispers received within the last minute
+	commandLastUsed     map[string]time.Time // When commands with a cooldown were last used
+	lastNameChange      time.Time            // Last change with /name, not counting the first username
+	commandReceivedAt   time.Time            // When the command being handled was received
+	ignoredChannels     map[string]struct{}  // Joined channels whose messages aren't delivered to the client
+	loggedInAs          string               // Registered username the client proved it owns
+	pendingLogin        string               // Registered username the client asked for, given to it once it logs in
+	whisperGroups       map[string][]string  // Recipient sets defined with /group for the session, by name
+	echoOff             bool                 // Set with /set echo off, so reflections of the client's messages aren't delivered to it
+	originated          originatedLog        // Messages sent recently while echo is off
+
+	// Join flood protection
+	joinTimes         []time.Time // Joins within the sliding window
+	joinCooldownUntil time.Time
+	joinFloodOffenses int
+}
+
 type Client struct {
+	id            string // Unique for the lifetime of the server, assigned when the client is registered with the run loop
 	IP            string // Client's IP address (used as initial key)
 	Username      atomic.Value
+	displayIcon   atomic.Value // Shown before the username in messages, empty if not set
 	registered    atomic.Bool
+	admin         atomic.Bool
+	bridge        atomic.Bool // Allowed by an admin to relay messages with BRIDGEMSG
 	conn          net.Conn
-	channel       atomic.Value
+	channels      []*Channel // Joined channels, in the order they were joined
+	activeChannel *Channel   // Channel plain messages are sent to
+	channelsMu    sync.RWMutex
 	server        *Server
-	send          chan string
-	bucket        int
-	maxBucketSize int
-	bucketRate    float64 // tokens per second to refill
-	lastRequest   time.Time
+	send          chan OutgoingMessage
+	rateLimiter   RateLimiter // Limits the messages and commands the client sends, only accessed from its Read goroutine
+	nameAttempts  int         // Lines sent before registering, only accessed from its Read goroutine
 	reader        *bufio.Reader
 	writer        *bufio.Writer
+
+	SessionState // Only accessed from the server's run loop
+
+	mutedUntil atomic.Int64 // Unix nanoseconds until which the client can't send messages
+
+	protocol   atomic.Int32  // Protocol version negotiated with the first line, see negotiate
+	features   atomic.Uint32 // Features negotiated with the first line
+	negotiated chan struct{} // Closed once the protocol is settled, see settleProtocol
+
+	notices       noticeCoalescer   // Merges repeated warnings, used from the Read goroutine and the run loop
+	readAcks      readAckQueue      // Read receipts waiting for the run loop
+	rateLimitHits rateLimitHitQueue // Rate limited chat messages waiting for the run loop to count them
+
+	connectedAt      time.Time
+	messageCount     atomic.Int64 // Messages sent to channels
+	firstMessageSent atomic.Bool  // Set once the first chat message of the client has been announced
+	lastInput        atomic.Int64 // Unix nanoseconds of the last message or command
+	lastWrite        atomic.Int64 // Unix nanoseconds of the last frame written to the client, for keepalives
+	colorProfile     atomic.Int32 // termenv.Profile of the client's terminal, sent with TERMCOLOR
+	bytesRead        atomic.Int64
+	bytesWritten     atomic.Int64           // Including message headers
+	messagesReceived atomic.Int64           // Messages and events written to the client
+	endReason        atomic.Pointer[string] // Why the session ended, see endSession
+
+	// Send queue depth, to spot clients that read too slowly before their queue fills up
+	queueHighWater  atomic.Int64 // Deepest the send queue has been
+	overflowed      atomic.Bool  // Set once the send queue filled up and the connection was closed
+	slowSince       atomic.Int64 // Unix nanoseconds since the queue has been over the slow client threshold, 0 if it isn't
+	lastSlowWarning time.Time    // Only accessed from the server's run loop
+
+	// Resuming the session after the connection drops, see resume.go
+	resumeToken string      // Token the client can resume its session with, only accessed from the server's run loop
+	suspended   atomic.Bool // Set while the connection is gone and the session waits to be resumed
+	suspendedAt time.Time   // Only accessed from the server's run loop
+	resumed     bool        // The session was resumed from another connection before this one was closed, run loop only
+	heldMu      sync.Mutex
+	held        []OutgoingMessage // Messages sent while suspended
+	heldDropped int               // Messages dropped while suspended because too many were held
+
+	// Away status, set with /away or automatically once the client has been idle for a while
+	awayMu      sync.Mutex
+	awayMessage string // Empty if the client isn't away
+	autoAway    bool
+	awaySince   time.Time
 }
 
-func NewClient(conn net.Conn, server *Server, name string, maxBucketSize int, bucketRate float64) *Client {
+func NewClient(conn net.Conn, server *Server, name string, rateLimiter RateLimiter) *Client {
 	// Extract IP address from connection
 	ip := conn.RemoteAddr().String()
 
-	reader := bufio.NewReader(conn)
-	writer := bufio.NewWriter(conn)
+	// Readers and writers are reused since each one holds a buffer, which adds up with many connections
+	reader := server.readers.Get().(*bufio.Reader)
+	reader.Reset(conn)
+	writer := server.writers.Get().(*bufio.Writer)
+	writer.Reset(conn)
 
 	client := &Client{
-		IP:            ip,
-		Username:      atomic.Value{},
-		registered:    atomic.Bool{},
-		channel:       atomic.Value{},
-		conn:          conn,
-		server:        server,
-		maxBucketSize: maxBucketSize,
-		send:          make(chan string, 1024),
-		bucketRate:    bucketRate,
-		reader:        reader,
-		writer:        writer,
+		IP:          ip,
+		Username:    atomic.Value{},
+		registered:  atomic.Bool{},
+		conn:        conn,
+		server:      server,
+		rateLimiter: rateLimiter,
+		send:        make(chan OutgoingMessage, server.config.SendQueueSize),
+		negotiated:  make(chan struct{}),
+		reader:      reader,
+		writer:      writer,
+		connectedAt: time.Now(),
+
+		SessionState: SessionState{ignoredChannels: make(map[string]struct{})},
 	}
 
 	client.Username.Store(name)
+	client.displayIcon.Store("")
 	client.registered.Store(false) // Not registered until username is set
+	client.protocol.Store(protocolUnknown)
+	client.lastInput.Store(client.connectedAt.UnixNano())
+	client.lastWrite.Store(client.connectedAt.UnixNano())
+	client.colorProfile.Store(int32(termenv.Ascii)) // No colors until the client says its terminal supports them
 
 	return client
 }
@@ -72,133 +180,309 @@ func (c *Client) Read() {
 	defer func() {
 		c.server.unregister <- c
 		c.conn.Close()
+
+		c.reader.Reset(nil)
+		c.server.readers.Put(c.reader)
 	}()
 
+	lineTooLong := false // Set once the client is being disconnected for sending a line that is too long
+	limited := false     // Set while the lines of the client are rate limited, until one is allowed again
 	for {
-		c.conn.SetReadDeadline(time.Now().Add(5 * time.Minute))
-		msg, err := c.reader.ReadString('\n')
+		// Clients answer the server's pings, so only dead connections stay silent for this long.
+		// Legacy clients aren't pinged, so they can stay silent until they are idle for too long.
+		deadline := time.Time{}
+		if c.expectsHeartbeat() {
+			deadline = time.Now().Add(2 * c.server.config.PingInterval)
+		}
+		c.conn.SetReadDeadline(deadline)
+		msg, err := c.readLine()
+		c.countRead(len(msg))
+		if errors.Is(err, ErrLineTooLong) {
+			if !lineTooLong {
+				lineTooLong = true
+				c.settleProtocol(protocolLegacy, 0) // A line that long is no handshake, it comes from a legacy client if it was the first
+				c.server.log.client.Warn("Client sent a line that is too long", "username", c.GetUsername(), "ip", c.IP)
+				c.Disconnect(fmt.Sprintf("Lines can't be longer than %d bytes.", c.server.config.MaxLineLength))
+			}
+			// The writer closes the connection once the reason is sent, which ends the Read loop.
+			// Returning now would close it before the reason is written.
+			continue
+		}
 		if err != nil {
 			if errors.Is(err, io.EOF) {
 				// Client closed the connection
+				c.endSession("closed by client")
 				return
 			}
 
 			var opErr *net.OpError
 			if errors.As(err, &opErr) {
 				// Connection was closed or reset by peer
+				c.endSession("connection closed")
 				return
 			}
 
 			// Check for timeout
 			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				c.server.logger.Info("Client read timeout", "username", c.GetUsername())
+				c.server.log.client.Info("Client read timeout", "username", c.GetUsername())
+				c.endSession("read timeout")
 				return
 			}
 
-			c.server.logger.Error("Error reading from client", "error", err)
+			c.server.log.client.Error("Error reading from client", "error", err)
+			c.endSession("read error: " + err.Error())
 			return
 		}
 
-		// We get the elapsed time since the last request
-		now := time.Now()
-		elapsed := now.Sub(c.lastRequest).Seconds()
+		// Nothing sent after a line that is too long is handled, it would start in the middle of that line
+		if lineTooLong {
+			continue
+		}
+
+		// The first line tells which protocol the client speaks, HELLO is only used for that
+		if !c.IsRegistered() && c.negotiate(strings.TrimSpace(msg)) {
+			continue
+		}
 
-		// We used this to determine how many tokens we should add to the bucket
-		tokens := elapsed * c.bucketRate
-		c.bucket = int(math.Min(float64(c.bucket)+tokens, float64(c.maxBucketSize)))
-		c.lastRequest = time.Now()
+		// Pongs only keep the connection alive, they don't count as activity
+		if strings.TrimSpace(msg) == "PONG" {
+			continue
+		}
+
+		// Clients tell which colors their terminal supports right after connecting
+		if after, ok := strings.CutPrefix(strings.TrimSpace(msg), "TERMCOLOR "); ok {
+			if profile, exists := colorProfiles[after]; exists {
+				c.colorProfile.Store(int32(profile))
+			}
+			continue
+		}
+
+		// Read receipts are sent automatically by the client, so they don't count towards the rate limit.
+		// They are queued instead, which never blocks, so flooding them can't stall the run loop.
+		if after, ok := strings.CutPrefix(strings.TrimSpace(msg), "READ_ACK "); ok {
+			if messageID, err := strconv.ParseUint(after, 10, 64); err == nil {
+				c.queueReadAck(messageID)
+			}
+			continue
+		}
+
+		now := time.Now()
+		if !c.IsRegistered() {
+			// Registering has its own limit on attempts instead of the rate limit.
+			// Clients that used them up are being disconnected, their lines are ignored until the connection is closed.
+			if !c.countRegistrationAttempt() {
+				continue
+			}
+		} else if ok, retryAfter := c.rateLimiter.Allow(now); !ok {
+			// Clients keep an estimate of the limit, which the exact wait resynchronizes.
+			// Once is enough until a line is allowed again, the client knows it's out of tokens until then.
+			if !limited {
+				limited = true
+				c.SendMessage(formatEvent("rate-limited", strconv.FormatInt(retryAfter.Milliseconds(), 10)))
+			}
+			if !strings.HasPrefix(strings.TrimSpace(msg), "/") {
+				channelName := c.targetChannelName(msg)
+				c.rejectMessage(channelName, "rate limited")
+				c.countRateLimitHit(RateLimitHit{Channel: channelName, At: now})
+			}
 
-		if c.bucket <= 0 {
 			randIndex := rand.IntN(len(rateLimitMessages))
-			c.SendMessage(formatMessage("Server", fmt.Sprintf("You are being rate limited. %s", rateLimitMessages[randIndex])))
+			c.SendNotice("rate-limit", formatMessage("Server", fmt.Sprintf("You are being rate limited. %s Try again in %s.", rateLimitMessages[randIndex], formatRetryAfter(retryAfter))))
 			continue
 		}
+		limited = false
 
-		// Decrease the number of tokens in the bucket
-		c.bucket--
+		// Bridges relay messages of remote users, with a '|' between their name and the content
+		if after, ok := strings.CutPrefix(strings.TrimSpace(msg), "BRIDGEMSG "); ok && c.IsRegistered() {
+			c.lastInput.Store(now.UnixNano())
+			c.relayBridgeMessage(after)
+			continue
+		}
 
 		// Check if the message contains a pipe character
 		// If it does, it's a malformed message
 		if strings.Contains(msg, "|") {
-			c.SendMessage(formatMessage("Server", "Malformed message. Please avoid using the '|' character."))
+			if !c.IsRegistered() {
+				c.registrationFailed(errors.New("usernames can't contain the '|' character"))
+				continue
+			}
+			c.SendNotice("malformed", formatMessage("Server", "Malformed message. Please avoid using the '|' character."))
 			continue
 		}
 
 		// This is always done after the user connects to the server
 		// If the message contains spaces, only the first part is used as the username
 		if !c.IsRegistered() {
+			// Clients whose connection dropped take their session back instead of picking a username
+			if token, ok := strings.CutPrefix(strings.TrimSpace(msg), "RESUME "); ok {
+				response := make(chan error, 1)
+				c.server.resumeRequests <- ResumeRequest{Client: c, Token: strings.TrimSpace(token), Response: response}
+				if err := <-response; err != nil {
+					c.registrationFailed(err)
+					continue
+				}
+				c.lastInput.Store(now.UnixNano())
+				continue
+			}
+
+			// Clients that asked for a registered username log in before they are registered
+			if after, ok := strings.CutPrefix(strings.TrimSpace(msg), "/login "); ok {
+				c.server.command <- Command{
+					Client:     c,
+					Args:       strings.Fields(after),
+					Name:       "login",
+					ReceivedAt: now,
+				}
+				continue
+			}
+
 			username := strings.TrimSpace(msg)
 			if strings.Contains(username, " ") {
 				username = strings.SplitN(username, " ", 2)[0]
 			}
 
 			// Request username change through server channel
-			// Use IP as old key for first-time registration
 			response := make(chan error, 1)
 			c.server.setUsername <- UsernameChange{
 				Client:      c,
-				OldKey:      c.IP, // Use IP address as old key
+				OldKey:      c.clientsKey(),
 				NewUsername: username,
 				Response:    response,
 			}
 
-			// Wait for response
+			// Wait for response, the server completes the registration if it succeeds
 			if err := <-response; err != nil {
-				c.SendMessage(formatMessage("Server", fmt.Sprintf("Failed to set username: %s", err.Error())))
+				c.registrationFailed(err)
 				continue
 			}
-
-			c.SetRegistered(true)
-			c.SendMessage(formatMessage("Server", fmt.Sprintf("Your username has been set to '%s'. Use /join <channel_name> to join a channel.", username)))
+			c.lastInput.Store(now.UnixNano())
 			continue
 		}
 
+		c.lastInput.Store(now.UnixNano())
+		if awayFor, wasAway := c.clearAutoAway(); wasAway {
+			c.SendServerMessage("You are no longer away.")
+
+			// Only bother the channel if the client was gone for a while
+			if channel := c.GetChannel(); channel != nil && awayFor > autoAwayAnnounceAfter {
+				c.server.broadcastNotice(c, channel, fmt.Sprintf("%s is back.", c.GetUsername()))
+			}
+		}
+
 		// Check if the message is a command (starts with '/')
 		msg = strings.TrimSpace(msg)
 		if after, ok := strings.CutPrefix(msg, "/"); ok {
 			args := strings.Fields(after)
 			if len(args) == 0 {
-				c.SendMessage(formatMessage("Server", "No command provided."))
+				c.SendServerMessage("No command provided.")
 				continue // Continue listening for messages
 			}
 
 			c.server.command <- Command{
-				Client: c,
-				Args:   args[1:],
-				Name:   args[0],
+				Client:     c,
+				Args:       args[1:],
+				Name:       args[0],
+				ReceivedAt: now,
 			}
 			continue
 		}
 
-		// Regular message
-		channel := c.GetChannel()
-		if channel == nil {
-			c.SendMessage(formatMessage("Server", "You are not in a channel. Use /join <channel> to join one."))
+		channel, content, ok := c.routeChatMessage(msg)
+		if !ok || !c.checkMessageLength(channel.Name, content) {
+			continue
+		}
+		if err := c.server.broadcastChatMessage(c, channel, content); err != nil {
+			c.rejectMessage(channel.Name, "server busy")
 			continue
 		}
+		c.messageCount.Add(1)
+	}
+}
 
-		c.server.broadcastMessage(c, channel, msg)
+// routeChatMessage finds the channel a chat message goes to and its content, telling the client why it can't be sent if it can't.
+// Messages prefixed with a channel, like "#ops done", go to that channel instead of the active one.
+func (c *Client) routeChatMessage(msg string) (*Channel, string, bool) {
+	if mutedFor := c.MutedFor(); mutedFor > 0 {
+		c.SendNotice("muted", formatMessage("Server", fmt.Sprintf("You are muted for %s more.", formatRetryAfter(mutedFor))))
+		c.rejectMessage(c.targetChannelName(msg), "muted")
+		return nil, "", false
 	}
+
+	if channelName, content, ok := parseChannelPrefix(msg); ok {
+		channel := c.GetJoinedChannel(channelName)
+		if channel == nil {
+			c.SendServerMessage(fmt.Sprintf("You are not in channel '%s'. Use /join %s to join it.", channelName, channelName))
+			c.rejectMessage(channelName, "not a member")
+			return nil, "", false
+		}
+		return channel, content, true
+	}
+
+	// Regular message, sent to the active channel
+	channel := c.GetChannel()
+	if channel == nil {
+		c.SendServerMessage("You are not in a channel. Use /join <channel> to join one or /switch <channel> to choose one you have joined.")
+		c.rejectMessage("", "not in a channel")
+		return nil, "", false
+	}
+	return channel, msg, true
 }
 
 func (c *Client) Write() {
 	defer func() {
 		c.writer.Flush()
 		c.conn.Close()
+
+		c.writer.Reset(nil)
+		c.server.writers.Put(c.writer)
 	}()
 
+	// How frames are written depends on the protocol
+	c.awaitProtocol()
+	legacy := c.protocol.Load() == protocolLegacy
+
+	inStream := false
 	for msg := range c.send {
+		// Space out the chunks of a stream so they don't starve other writes
+		switch msg.Body {
+		case streamStartMarker:
+			inStream = true
+		case streamEndMarker:
+			inStream = false
+		default:
+			if inStream {
+				time.Sleep(streamChunkDelay)
+			}
+		}
+
+		body := msg.Body
+		if legacy && body != "" {
+			var known bool
+			if body, known = legacyBody(body); !known {
+				if msg.Close {
+					return
+				}
+				continue
+			}
+		}
+
 		c.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
 
-		header := make([]byte, 4)
-		binary.LittleEndian.PutUint32(header, uint32(len(msg)))
+		// The header carries the body length followed by the time the message was sent (unix milliseconds).
+		// Legacy clients only know the length.
+		header := make([]byte, 12)
+		binary.LittleEndian.PutUint32(header[0:4], uint32(len(body)))
+		binary.LittleEndian.PutUint64(header[4:12], uint64(msg.SentAt.UnixMilli()))
+		if legacy {
+			header = header[:4]
+		}
 
 		if _, err := c.writer.Write(header); err != nil {
 			c.handleWriteError(err, "header write")
 			return
 		}
 
-		if _, err := c.writer.WriteString(msg); err != nil {
+		if _, err := c.writer.WriteString(body); err != nil {
 			c.handleWriteError(err, "body write")
 			return
 		}
@@ -207,33 +491,272 @@ func (c *Client) Write() {
 			c.handleWriteError(err, "flush")
 			return
 		}
+		c.countWritten(len(header) + len(body))
+		c.lastWrite.Store(time.Now().UnixNano())
+		if body != "" {
+			c.messagesReceived.Add(1) // Keepalives aren't messages
+		}
+
+		if len(c.send) < c.server.config.SlowQueueThreshold {
+			c.slowSince.Store(0)
+		}
+
+		if msg.Close {
+			return
+		}
 	}
 }
 
+// readLine reads the next line without letting it grow past the max line length,
+// so a client can't make the server buffer a never-ending line
+func (c *Client) readLine() (string, error) {
+	var line []byte
+	for {
+		chunk, err := c.reader.ReadSlice('\n')
+		if len(line)+len(chunk) > c.server.config.MaxLineLength {
+			return "", ErrLineTooLong
+		}
+
+		line = append(line, chunk...)
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return string(line), err
+		}
+	}
+}
+
+// countRead adds the bytes read from the client to its traffic and the server's
+func (c *Client) countRead(n int) {
+	c.bytesRead.Add(int64(n))
+	c.server.stats.BytesRead.Add(int64(n))
+}
+
+// countWritten adds the bytes written to the client to its traffic and the server's
+func (c *Client) countWritten(n int) {
+	c.bytesWritten.Add(int64(n))
+	c.server.stats.BytesWritten.Add(int64(n))
+}
+
 func (c *Client) handleWriteError(err error, context string) {
+	c.endSession(fmt.Sprintf("write error (%s)", context))
+
 	var opErr *net.OpError
 	if errors.As(err, &opErr) {
 		return
 	}
 
 	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-		c.server.logger.Info(fmt.Sprintf("Client write timeout (%s)", context), "username", c.GetUsername())
+		c.server.log.client.Info(fmt.Sprintf("Client write timeout (%s)", context), "username", c.GetUsername())
 		return
 	}
 
-	c.server.logger.Error(fmt.Sprintf("Error writing to client (%s)", context), "error", err)
+	c.server.log.client.Error(fmt.Sprintf("Error writing to client (%s)", context), "error", err)
+}
+
+func (c *Client) SendMessage(msg string) error {
+	return c.SendMessageAt(msg, time.Now())
+}
+
+// SendServerMessage sends a message from the server itself, like the reply to a command, which clients show as such
+func (c *Client) SendServerMessage(text string) error {
+	return c.SendMessage(formatMessage("Server", text))
 }
 
-func (c *Client) SendMessage(msg string) {
+// SendMessageAt queues a message stamped with the given time instead of the current one (e.g. replayed messages).
+// It never blocks: if the send queue is full, the message is dropped and the connection closed, since the client
+// is too slow to read its messages or is being flooded. The error tells callers the message won't arrive,
+// ErrSendQueueFull the first time and ErrConnectionClosed for the messages sent after it.
+// Messages sent while the client is suspended are held instead.
+func (c *Client) SendMessageAt(msg string, sentAt time.Time) error {
+	if c.suspended.Load() {
+		c.hold(OutgoingMessage{Body: msg, SentAt: sentAt})
+		return nil
+	}
+	if c.overflowed.Load() {
+		c.server.stats.countDroppedSend(msg)
+		return ErrConnectionClosed
+	}
+
 	select {
-	case c.send <- msg:
+	case c.send <- OutgoingMessage{Body: msg, SentAt: sentAt}:
+		c.trackQueueDepth(len(c.send))
+		return nil
 	default:
-		// If the send buffer is full, drop the message to avoid blocking
-		c.server.logger.Warn("Send buffer full, dropping message", "username", c.GetUsername())
+		c.server.stats.countDroppedSend(msg)
+		if !c.overflowed.CompareAndSwap(false, true) {
+			return ErrConnectionClosed
+		}
 
-		// Close the client connection. This can occur if the client is too slow to read messages or is spamming too many messages causing buffer overflow.
+		c.server.log.client.Warn("Send buffer full, dropping message", "username", c.GetUsername())
+		c.endSession("send queue full")
 		c.conn.Close()
+		return ErrSendQueueFull
+	}
+}
+
+// rejectMessage tells the client a chat message it sent to the channel wasn't relayed, so it can mark it as failed.
+// Relayed messages are confirmed with the echo event instead.
+func (c *Client) rejectMessage(channelName, reason string) {
+	c.SendMessage(formatEvent("reject", channelName+" "+reason))
+}
+
+// checkMessageLength refuses chat messages longer than the limit advertised to clients, counted as typed
+// before emoji shortcodes are expanded. Reports whether the message can be sent.
+func (c *Client) checkMessageLength(channelName, content string) bool {
+	limit := c.server.config.MaxMessageLength
+	length := utf8.RuneCountInString(content)
+	if length <= limit {
+		return true
+	}
+
+	c.SendServerMessage(fmt.Sprintf("Messages can't be longer than %d characters, yours is %d over.", limit, length-limit))
+	c.rejectMessage(channelName, "too long")
+	return false
+}
+
+// targetChannelName is the name of the channel a chat message goes to: the one it's prefixed with, or the active one
+func (c *Client) targetChannelName(msg string) string {
+	if name, _, ok := parseChannelPrefix(msg); ok {
+		return name
+	}
+	if channel := c.GetChannel(); channel != nil {
+		return channel.Name
 	}
+	return ""
+}
+
+// parseChannelPrefix splits a message like "#ops deploy is done" into the channel it's sent to and its content
+func parseChannelPrefix(msg string) (channelName, content string, ok bool) {
+	after, found := strings.CutPrefix(strings.TrimSpace(msg), "#")
+	if !found {
+		return "", "", false
+	}
+
+	channelName, content, found = strings.Cut(after, " ")
+	content = strings.TrimSpace(content)
+	if !found || channelName == "" || content == "" {
+		return "", "", false
+	}
+	return channelName, content, true
+}
+
+// Disconnect sends a close event with the reason to the client and closes the connection once it has been written
+func (c *Client) Disconnect(reason string) {
+	c.endSession(reason)
+	select {
+	case c.send <- OutgoingMessage{Body: formatEvent("close", reason), SentAt: time.Now(), Close: true}:
+	default:
+		c.conn.Close()
+	}
+}
+
+// allowChannelCreation takes a token from the channel creation bucket, returning false if it's empty
+func (c *Client) allowChannelCreation() bool {
+	return c.channelCreateBucket.take(c.server.config.ChannelCreateBucket, c.server.config.ChannelCreateRate)
+}
+
+// allowWhisper takes a token from the whisper bucket for each recipient, returning false if there aren't enough
+func (c *Client) allowWhisper(recipients int) bool {
+	return c.whisperBucket.takeN(recipients, c.server.config.WhisperBucket, c.server.config.WhisperRate)
+}
+
+// useCommand records a use of the command unless it's still cooling down from the last one,
+// in which case the remaining cooldown is returned
+func (s *SessionState) useCommand(name string, cooldown time.Duration) time.Duration {
+	if s.commandLastUsed == nil {
+		s.commandLastUsed = make(map[string]time.Time)
+	}
+
+	now := time.Now()
+	if remaining := s.commandLastUsed[name].Add(cooldown).Sub(now); remaining > 0 {
+		return remaining
+	}
+
+	s.commandLastUsed[name] = now
+	return 0
+}
+
+// allowReceivingWhisper records a whisper sent to the client, returning false if it already received
+// too many of them within the last minute, no matter who sent them
+func (c *Client) allowReceivingWhisper() bool {
+	limit := c.server.config.WhisperRecipientLimit
+	if limit <= 0 {
+		return true
+	}
+
+	now := time.Now()
+	windowStart := now.Add(-time.Minute)
+	c.receivedWhispers = slices.DeleteFunc(c.receivedWhispers, func(receivedAt time.Time) bool {
+		return receivedAt.Before(windowStart)
+	})
+
+	if len(c.receivedWhispers) >= limit {
+		return false
+	}
+
+	c.receivedWhispers = append(c.receivedWhispers, now)
+	return true
+}
+
+// allowJoin records a join, returning false while the client is cooling down from joining channels too quickly.
+// Clients that keep exceeding the limit are also muted.
+func (c *Client) allowJoin() bool {
+	config := c.server.config
+	if config.JoinFloodLimit <= 0 {
+		return true
+	}
+
+	now := time.Now()
+	if now.Before(c.joinCooldownUntil) {
+		return false
+	}
+
+	// Forget about joins that are no longer within the sliding window
+	windowStart := now.Add(-config.JoinFloodWindow)
+	c.joinTimes = slices.DeleteFunc(c.joinTimes, func(joinedAt time.Time) bool {
+		return joinedAt.Before(windowStart)
+	})
+
+	if len(c.joinTimes) >= config.JoinFloodLimit {
+		c.joinTimes = nil
+		c.joinCooldownUntil = now.Add(config.JoinFloodCooldown)
+		c.joinFloodOffenses++
+
+		if c.joinFloodOffenses >= joinFloodOffensesBeforeMute {
+			c.joinFloodOffenses = 0
+			c.Mute(config.JoinFloodMute)
+			c.server.log.client.Warn("Client muted for join flooding", "username", c.GetUsername(), "ip", c.IP, "duration", config.JoinFloodMute)
+			c.SendServerMessage(fmt.Sprintf("You have been muted for %s for repeatedly joining channels too quickly.", config.JoinFloodMute))
+		}
+		return false
+	}
+
+	c.joinTimes = append(c.joinTimes, now)
+	return true
+}
+
+// JoinCooldownRemaining returns how long the client has to wait before joining another channel
+func (c *Client) JoinCooldownRemaining() time.Duration {
+	return time.Until(c.joinCooldownUntil)
+}
+
+// SendStream queues a multi-line response as a single stream bracketed by start and end markers.
+// Each chunk must already be formatted with formatMessage. The chunks are written in order by the writer goroutine, which waits streamChunkDelay between each one.
+// Stops at the first chunk that can't be queued, since the connection is closed then, returning why.
+func (c *Client) SendStream(chunks []string) error {
+	if len(chunks) > maxStreamChunks {
+		omitted := len(chunks) - maxStreamChunks + 1
+		chunks = append(chunks[:maxStreamChunks-1:maxStreamChunks-1], formatMessage("Server", fmt.Sprintf("... %d more lines omitted", omitted)))
+	}
+
+	if err := c.SendMessage(streamStartMarker); err != nil {
+		return err
+	}
+	for _, chunk := range chunks {
+		if err := c.SendMessage(chunk); err != nil {
+			return err
+		}
+	}
+	return c.SendMessage(streamEndMarker)
 }
 
 func (c *Client) SetUsername(newName string) {
@@ -244,16 +767,134 @@ func (c *Client) GetUsername() string {
 	return c.Username.Load().(string)
 }
 
-func (c *Client) GetChannel() *Channel {
-	channel := c.channel.Load()
-	if channel == nil {
-		return nil
+// GetDisplayIcon returns the icon shown before the client's username, empty if it has none
+func (c *Client) GetDisplayIcon() string {
+	return c.displayIcon.Load().(string)
+}
+
+func (c *Client) SetDisplayIcon(icon string) {
+	c.displayIcon.Store(icon)
+}
+
+// DisplayName is the name shown as the sender of the client's messages, including its icon
+func (c *Client) DisplayName() string {
+	if icon := c.GetDisplayIcon(); icon != "" {
+		return icon + " " + c.GetUsername()
+	}
+	return c.GetUsername()
+}
+
+// ID returns the client's unique ID, empty until the run loop has registered the client
+func (c *Client) ID() string {
+	return c.id
+}
+
+// clientsKey returns the key of the client in the server's clients map: its username once registered, its ID until then.
+// Clients behind the same address each get their own ID, and the space keeps it from matching a username.
+func (c *Client) clientsKey() string {
+	if c.IsRegistered() {
+		return c.GetUsername()
 	}
-	return channel.(*Channel)
+	return "unregistered " + c.ID()
 }
 
+// GetChannel returns the active channel, which plain messages are sent to
+func (c *Client) GetChannel() *Channel {
+	c.channelsMu.RLock()
+	defer c.channelsMu.RUnlock()
+	return c.activeChannel
+}
+
+// SetChannel changes the active channel, which must be one of the joined channels
 func (c *Client) SetChannel(ch *Channel) {
-	c.channel.Store(ch)
+	c.channelsMu.Lock()
+	event := c.setActiveChannel(ch)
+	c.channelsMu.Unlock()
+
+	c.sendEvents(event)
+}
+
+// setActiveChannel changes the active channel, returning the event telling the client about it, empty if it didn't
+// change. The caller must hold channelsMu, and send the event with sendEvents once it released it.
+func (c *Client) setActiveChannel(ch *Channel) string {
+	if c.activeChannel == ch {
+		return ""
+	}
+	c.activeChannel = ch
+
+	channelName := ""
+	if ch != nil {
+		channelName = ch.Name
+	}
+	return formatEvent("active-channel", channelName)
+}
+
+// sendEvents sends the events in order, skipping the empty ones
+func (c *Client) sendEvents(events ...string) {
+	for _, event := range events {
+		if event != "" {
+			c.SendMessage(event)
+		}
+	}
+}
+
+// GetChannels returns a copy of the joined channels
+func (c *Client) GetChannels() []*Channel {
+	c.channelsMu.RLock()
+	defer c.channelsMu.RUnlock()
+	return slices.Clone(c.channels)
+}
+
+// GetJoinedChannel returns the joined channel with the given name, or nil if the client is not a member
+func (c *Client) GetJoinedChannel(name string) *Channel {
+	c.channelsMu.RLock()
+	defer c.channelsMu.RUnlock()
+
+	for _, ch := range c.channels {
+		if ch.Name == name {
+			return ch
+		}
+	}
+	return nil
+}
+
+// AddChannel adds the channel to the joined channels and makes it the active one
+func (c *Client) AddChannel(ch *Channel) {
+	c.channelsMu.Lock()
+	joined := ""
+	if !slices.Contains(c.channels, ch) {
+		c.channels = append(c.channels, ch)
+		joined = formatEvent("joined", ch.Name)
+	}
+	activeChannel := c.setActiveChannel(ch)
+	c.channelsMu.Unlock()
+
+	c.sendEvents(joined, activeChannel)
+}
+
+// RemoveChannel removes the channel from the joined channels.
+// If it was the active channel, the most recently joined remaining channel becomes the active one.
+func (c *Client) RemoveChannel(ch *Channel) {
+	c.channelsMu.Lock()
+	if !slices.Contains(c.channels, ch) {
+		c.channelsMu.Unlock()
+		return
+	}
+	c.channels = slices.DeleteFunc(c.channels, func(joined *Channel) bool {
+		return joined == ch
+	})
+
+	activeChannel := ""
+	if c.activeChannel == ch {
+		var fallback *Channel
+		if len(c.channels) > 0 {
+			fallback = c.channels[len(c.channels)-1]
+		}
+		activeChannel = c.setActiveChannel(fallback)
+	}
+	c.channelsMu.Unlock()
+
+	c.sendEvents(formatEvent("left", ch.Name), activeChannel)
 }
 
 func (c *Client) IsRegistered() bool {
@@ -263,3 +904,108 @@ func (c *Client) IsRegistered() bool {
 func (c *Client) SetRegistered(registered bool) {
 	c.registered.Store(registered)
 }
+
+// Mute prevents the client from sending messages to channels for the given duration
+func (c *Client) Mute(duration time.Duration) {
+	c.mutedUntil.Store(time.Now().Add(duration).UnixNano())
+}
+
+// MutedFor returns how long the client remains muted, zero or less if it's not muted
+func (c *Client) MutedFor() time.Duration {
+	return time.Until(time.Unix(0, c.mutedUntil.Load()))
+}
+
+// clearExpiredMute forgets the client's mute if it ended before now, reporting whether there was one
+func (c *Client) clearExpiredMute(now time.Time) bool {
+	mutedUntil := c.mutedUntil.Load()
+	if mutedUntil == 0 || mutedUntil > now.UnixNano() {
+		return false
+	}
+	return c.mutedUntil.CompareAndSwap(mutedUntil, 0)
+}
+
+// SetAway marks the client as away with the given message. Automatic away statuses are cleared by the next message.
+func (c *Client) SetAway(message string, auto bool) {
+	c.awayMu.Lock()
+	defer c.awayMu.Unlock()
+	c.awayMessage = message
+	c.autoAway = auto
+	c.awaySince = time.Now()
+}
+
+func (c *Client) ClearAway() {
+	c.awayMu.Lock()
+	defer c.awayMu.Unlock()
+	c.awayMessage = ""
+	c.autoAway = false
+}
+
+// Away returns the away message, empty if the client isn't away, and whether it was set automatically
+func (c *Client) Away() (string, bool) {
+	c.awayMu.Lock()
+	defer c.awayMu.Unlock()
+	return c.awayMessage, c.autoAway
+}
+
+// clearAutoAway clears an automatic away status, returning how long the client was away
+// and false if it wasn't automatically away
+func (c *Client) clearAutoAway() (time.Duration, bool) {
+	c.awayMu.Lock()
+	defer c.awayMu.Unlock()
+
+	if c.awayMessage == "" || !c.autoAway {
+		return 0, false
+	}
+
+	c.awayMessage = ""
+	c.autoAway = false
+	return time.Since(c.awaySince), true
+}
+
+// IdleFor returns how long it has been since the client last sent a message or command
+func (c *Client) IdleFor() time.Duration {
+	return time.Since(time.Unix(0, c.lastInput.Load()))
+}
+
+// ColorProfile returns the colors the client's terminal supports
+func (c *Client) ColorProfile() termenv.Profile {
+	return termenv.Profile(c.colorProfile.Load())
+}
+
+func (c *Client) IsAdmin() bool {
+	return c.admin.Load()
+}
+
+func (c *Client) SetAdmin(admin bool) {
+	c.admin.Store(admin)
+}
+
+// State returns what the run loop keeps about the client between commands
+func (c *Client) State() *SessionState {
+	return &c.SessionState
+}
+
+// RemoteAddr returns the client's IP address
+func (c *Client) RemoteAddr() string {
+	return c.IP
+}
+
+// Protocol returns the protocol version negotiated with the client, see negotiate
+func (c *Client) Protocol() int32 {
+	return c.protocol.Load()
+}
+
+// IsBridge reports whether an admin allowed the client to relay messages with BRIDGEMSG
+func (c *Client) IsBridge() bool {
+	return c.bridge.Load()
+}
+
+// SetBridge allows or disallows the client to relay messages, returning whether it was allowed before
+func (c *Client) SetBridge(bridge bool) bool {
+	return c.bridge.Swap(bridge)
+}
+
+// markFirstMessage reports whether this is the first chat message of the client, only true once per client
+func (c *Client) markFirstMessage() bool {
+	return c.firstMessageSent.CompareAndSwap(false, true)
+}
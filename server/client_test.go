@@ -0,0 +1,480 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newPipeClient creates a client connected through an in-memory pipe, returning the other end of it
+func newPipeClient(t testing.TB, server *Server, username string) (*Client, net.Conn) {
+	t.Helper()
+	serverEnd, clientEnd := net.Pipe()
+	t.Cleanup(func() {
+		serverEnd.Close()
+		clientEnd.Close()
+	})
+	return NewClient(serverEnd, server, username, rateLimiters[server.config.RateLimiter]()), clientEnd
+}
+
+// queued empties the client's send queue, returning the bodies of the messages that were in it
+func queued(client *Client) []string {
+	var bodies []string
+	for {
+		select {
+		case msg := <-client.send:
+			bodies = append(bodies, msg.Body)
+		default:
+			return bodies
+		}
+	}
+}
+
+// readFrame reads a frame written by a client's writer, returning its body and when it was written
+func readFrame(conn net.Conn) (string, time.Time, error) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", time.Time{}, err
+	}
+	body := make([]byte, binary.LittleEndian.Uint32(header[:4]))
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return "", time.Time{}, err
+	}
+	return string(body), time.Now(), nil
+}
+
+// lines returns n formatted server messages numbered from 0
+func lines(n int) []string {
+	chunks := make([]string, n)
+	for i := range chunks {
+		chunks[i] = formatMessage("Server", fmt.Sprintf("line %d", i))
+	}
+	return chunks
+}
+
+func TestSendStream(t *testing.T) {
+	tests := []struct {
+		name      string
+		chunks    []string
+		queueSize int
+		want      []string
+		wantErr   error
+	}{
+		{
+			name:   "empty",
+			chunks: nil,
+			want:   []string{streamStartMarker, streamEndMarker},
+		},
+		{
+			name:   "chunks in order",
+			chunks: lines(3),
+			want:   slices.Concat([]string{streamStartMarker}, lines(3), []string{streamEndMarker}),
+		},
+		{
+			name:   "at the limit",
+			chunks: lines(maxStreamChunks),
+			want:   slices.Concat([]string{streamStartMarker}, lines(maxStreamChunks), []string{streamEndMarker}),
+		},
+		{
+			name:   "over the limit",
+			chunks: lines(maxStreamChunks + 5),
+			want: slices.Concat(
+				[]string{streamStartMarker},
+				lines(maxStreamChunks-1),
+				[]string{formatMessage("Server", "... 6 more lines omitted"), streamEndMarker},
+			),
+		},
+		{
+			name:      "queue full",
+			chunks:    lines(3),
+			queueSize: 2,
+			want:      []string{streamStartMarker, formatMessage("Server", "line 0")},
+			wantErr:   ErrSendQueueFull,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, func(config *Config) {
+				config.SendQueueSize = maxStreamChunks + 2 // Room for the longest stream
+				if test.queueSize > 0 {
+					config.SendQueueSize = test.queueSize
+				}
+			})
+			client, _ := newPipeClient(t, server, "alice")
+
+			if err := client.SendStream(test.chunks); !errors.Is(err, test.wantErr) {
+				t.Errorf("got error %v, want %v", err, test.wantErr)
+			}
+			if got := queued(client); !slices.Equal(got, test.want) {
+				t.Errorf("queued %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSendStreamDoesNotAliasChunks(t *testing.T) {
+	chunks := lines(maxStreamChunks + 1)
+	original := slices.Clone(chunks)
+
+	server := newTestServer(t, func(config *Config) { config.SendQueueSize = maxStreamChunks + 2 })
+	client, _ := newPipeClient(t, server, "alice")
+	client.SendStream(chunks)
+
+	if !slices.Equal(chunks, original) {
+		t.Error("SendStream changed the caller's chunks")
+	}
+}
+
+func TestWriteSpacesOutStreams(t *testing.T) {
+	server := newTestServer(t, nil)
+	client, conn := newPipeClient(t, server, "alice")
+	client.settleProtocol(protocolVersion, supportedFeatures)
+	go client.Write()
+
+	client.SendMessage(formatMessage("Server", "before"))
+	client.SendStream(lines(3))
+	client.SendMessage(formatMessage("Server", "after"))
+	close(client.send)
+
+	var bodies []string
+	var chunkTimes []time.Time
+	for range 7 {
+		body, at, err := readFrame(conn)
+		if err != nil {
+			t.Fatalf("reading frame %d: %v", len(bodies), err)
+		}
+		bodies = append(bodies, body)
+		if strings.Contains(body, "line ") {
+			chunkTimes = append(chunkTimes, at)
+		}
+	}
+
+	want := slices.Concat([]string{formatMessage("Server", "before"), streamStartMarker}, lines(3), []string{streamEndMarker, formatMessage("Server", "after")})
+	if !slices.Equal(bodies, want) {
+		t.Errorf("written %q, want %q", bodies, want)
+	}
+	for i := 1; i < len(chunkTimes); i++ {
+		// The writer sleeps before each chunk, so some slack is left for the reader being scheduled late
+		if gap := chunkTimes[i].Sub(chunkTimes[i-1]); gap < streamChunkDelay/2 {
+			t.Errorf("chunk %d was written %s after the previous one, want about %s", i, gap, streamChunkDelay)
+		}
+	}
+}
+
+func TestChannelCreationLimit(t *testing.T) {
+	tests := []struct {
+		name   string
+		bucket int
+		joins  []string
+		want   string // Reply to the last join
+	}{
+		{name: "within the burst", bucket: 3, joins: []string{"a", "b", "c"}, want: "You have joined channel 'c'"},
+		{name: "past the burst", bucket: 3, joins: []string{"a", "b", "c", "d"}, want: "You are creating channels too quickly"},
+		{name: "joining existing channels is free", bucket: 1, joins: []string{"a", "existing", "existing"}, want: "You have joined channel 'existing'"},
+		{name: "refused creations don't count", bucket: 1, joins: []string{"a", "b", "existing"}, want: "You have joined channel 'existing'"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, func(config *Config) {
+				config.ChannelCreateBucket = test.bucket
+				config.ChannelCreateRate = 1e-9
+				config.JoinFloodLimit = 0
+			})
+			join(t, server, connect(server, "bob"), "existing")
+
+			client, _ := newPipeClient(t, server, "alice")
+			client.SetRegistered(true)
+			server.clients[client.clientsKey()] = client
+
+			var replies []string
+			for _, channelName := range test.joins {
+				server.runCommand(Command{Name: "join", Args: []string{channelName}, Client: client, ReceivedAt: time.Now()})
+				replies = queued(client)
+			}
+			if !slices.ContainsFunc(replies, func(reply string) bool { return strings.Contains(reply, test.want) }) {
+				t.Errorf("got %q, want %q", replies, test.want)
+			}
+		})
+	}
+}
+
+func TestChannelEvents(t *testing.T) {
+	general, random := NewChannel("general", ""), NewChannel("random", "")
+	tests := []struct {
+		name   string
+		change func(client *Client)
+		want   []string
+	}{
+		{name: "join", change: func(client *Client) { client.AddChannel(random) }, want: []string{formatEvent("joined", "random"), formatEvent("active-channel", "random")}},
+		{name: "join again", change: func(client *Client) { client.AddChannel(general) }, want: nil},
+		{name: "switch", change: func(client *Client) { client.SetChannel(nil) }, want: []string{formatEvent("active-channel", "")}},
+		{name: "leave the active channel", change: func(client *Client) { client.RemoveChannel(general) }, want: []string{formatEvent("left", "general"), formatEvent("active-channel", "")}},
+		{name: "leave a channel not joined", change: func(client *Client) { client.RemoveChannel(random) }, want: nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, _ := newPipeClient(t, newTestServer(t, nil), "alice")
+			client.AddChannel(general)
+			queued(client)
+
+			test.change(client)
+			if got := queued(client); !slices.Equal(got, test.want) {
+				t.Errorf("sent %q, want %q", got, test.want)
+			}
+			if !client.channelsMu.TryLock() {
+				t.Fatal("channelsMu is still held")
+			}
+			client.channelsMu.Unlock()
+		})
+	}
+}
+
+func TestRefusedJoinsDontCount(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(server *Server, channel *Channel)
+		args  []string // Arguments of the joins that are refused
+	}{
+		{name: "password required", setup: func(server *Server, channel *Channel) { channel.password = "secret" }, args: []string{"general"}},
+		{name: "wrong password", setup: func(server *Server, channel *Channel) { channel.password = "secret" }, args: []string{"general", "wrong"}},
+		{name: "locked", setup: func(server *Server, channel *Channel) { channel.SetLocked(true) }, args: []string{"general"}},
+		{name: "channel limit", setup: func(server *Server, channel *Channel) { server.config.MaxChannels = 1 }, args: []string{"random"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, func(config *Config) {
+				config.JoinFloodLimit = 2
+				config.ChannelCreateBucket = 1
+				config.ChannelCreateRate = 1e-9
+			})
+			channel := join(t, server, connect(server, "bob"), "general")
+			test.setup(server, channel)
+
+			client, _ := newPipeClient(t, server, "alice")
+			client.SetRegistered(true)
+			server.clients[client.clientsKey()] = client
+
+			for range 5 {
+				server.runCommand(Command{Name: "join", Args: test.args, Client: client, ReceivedAt: time.Now()})
+			}
+			if replies := queued(client); slices.ContainsFunc(replies, func(reply string) bool { return strings.Contains(reply, "too quickly") }) {
+				t.Fatalf("refused joins were counted: %q", replies)
+			}
+
+			// The joins and the channel creation that succeed are still limited
+			server.config.MaxChannels = 0
+			channel.password = ""
+			channel.SetLocked(false)
+			for _, channelName := range []string{"general", "random"} {
+				server.runCommand(Command{Name: "join", Args: []string{channelName}, Client: client, ReceivedAt: time.Now()})
+			}
+			server.runCommand(Command{Name: "join", Args: []string{"other"}, Client: client, ReceivedAt: time.Now()})
+			if replies := queued(client); !slices.ContainsFunc(replies, func(reply string) bool { return strings.Contains(reply, "You are joining channels too quickly") }) {
+				t.Errorf("got %q, want the third join refused", replies)
+			}
+		})
+	}
+}
+
+func TestQueueReadAck(t *testing.T) {
+	tests := []struct {
+		name      string
+		acks      int
+		fullQueue bool // Other clients already fill the server's readAck channel
+		wantIDs   int
+		wantQueue int // Times the client waits in the readAck channel
+	}{
+		{name: "one", acks: 1, wantIDs: 1, wantQueue: 1},
+		{name: "batched", acks: 10, wantIDs: 10, wantQueue: 1},
+		{name: "flood", acks: 10 * maxQueuedReadAcks, wantIDs: maxQueuedReadAcks, wantQueue: 1},
+		{name: "run loop behind", acks: 3, fullQueue: true, wantIDs: 3, wantQueue: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, nil)
+			client, _ := newPipeClient(t, server, "alice")
+			if test.fullQueue {
+				other, _ := newPipeClient(t, server, "bob")
+				for range cap(server.readAck) {
+					server.readAck <- other
+				}
+			}
+
+			// Nothing reads the readAck channel, so this would hang if queueing blocked
+			for i := range test.acks {
+				client.queueReadAck(uint64(i))
+			}
+
+			var waiting int
+			for len(server.readAck) > 0 {
+				if <-server.readAck == client {
+					waiting++
+				}
+			}
+			if waiting != test.wantQueue {
+				t.Errorf("the client waited %d times in the readAck channel, want %d", waiting, test.wantQueue)
+			}
+			if ids := client.takeReadAcks(); len(ids) != test.wantIDs {
+				t.Errorf("took %d read receipts, want %d", len(ids), test.wantIDs)
+			}
+
+			// Receipts sent after the run loop took the others wake it again
+			client.queueReadAck(1)
+			if len(server.readAck) != 1 {
+				t.Error("a read receipt sent after the others were taken isn't waiting for the run loop")
+			}
+		})
+	}
+}
+
+// readLines has the client's Read goroutine handle the lines as if the client sent them at once, with the run loop
+// only taking what the goroutine hands over. Returns the commands that weren't rate limited.
+func readLines(t testing.TB, server *Server, client *Client, conn net.Conn, lines []string) []Command {
+	t.Helper()
+	client.SetRegistered(true)
+	go client.Read()
+
+	done := make(chan []Command)
+	go func() {
+		var commands []Command
+		for {
+			select {
+			case command := <-server.command:
+				commands = append(commands, command)
+			case <-server.unregister:
+				done <- commands
+				return
+			}
+		}
+	}()
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(conn, line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn.Close()
+
+	select {
+	case commands := <-done:
+		return commands
+	case <-time.After(5 * time.Second):
+		t.Fatal("the Read goroutine didn't stop")
+		return nil
+	}
+}
+
+func TestRateLimitedEvents(t *testing.T) {
+	tests := []struct {
+		name       string
+		lines      int
+		wantEvents int
+	}{
+		{name: "within the bucket", lines: maxBucketSize, wantEvents: 0},
+		{name: "one over", lines: maxBucketSize + 1, wantEvents: 1},
+		{name: "flood", lines: 10 * maxBucketSize, wantEvents: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, func(config *Config) { config.SendQueueSize = 1024 })
+			client, conn := newPipeClient(t, server, "alice")
+
+			commands := readLines(t, server, client, conn, slices.Repeat([]string{"/ping"}, test.lines))
+			if want := min(test.lines, maxBucketSize); len(commands) != want {
+				t.Errorf("%d commands were handled, want %d", len(commands), want)
+			}
+
+			var events int
+			for _, msg := range queued(client) {
+				if strings.HasPrefix(msg, formatEvent("rate-limited", "")) {
+					events++
+				}
+			}
+			if events != test.wantEvents {
+				t.Errorf("sent %d rate-limited events, want %d", events, test.wantEvents)
+			}
+		})
+	}
+}
+
+func TestRateLimitHitsAreCountedInBatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		lines    int
+		wantHits int
+	}{
+		{name: "within the bucket", lines: maxBucketSize, wantHits: 0},
+		{name: "some", lines: maxBucketSize + 5, wantHits: 5},
+		{name: "flood", lines: maxBucketSize + 5*maxRateLimitHitsKept, wantHits: maxRateLimitHitsKept},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, func(config *Config) { config.SendQueueSize = 4096 })
+			client, conn := newPipeClient(t, server, "alice")
+
+			// Nothing reads the rateLimited channel, so this would hang if counting hits blocked
+			readLines(t, server, client, conn, slices.Repeat([]string{"hello"}, test.lines))
+
+			waiting := len(server.rateLimited)
+			if want := min(test.wantHits, 1); waiting != want {
+				t.Errorf("the client waited %d times in the rateLimited channel, want %d", waiting, want)
+			}
+			if hits := client.takeRateLimitHits(); len(hits) != test.wantHits {
+				t.Errorf("took %d rate limit hits, want %d", len(hits), test.wantHits)
+			}
+		})
+	}
+}
+
+func TestBackFromAwayNotice(t *testing.T) {
+	tests := []struct {
+		name     string
+		auto     bool
+		awayFor  time.Duration
+		wantSent bool
+	}{
+		{name: "auto-away for a while", auto: true, awayFor: autoAwayAnnounceAfter + time.Minute, wantSent: true},
+		{name: "auto-away briefly", auto: true, awayFor: time.Minute},
+		{name: "away by choice", awayFor: autoAwayAnnounceAfter + time.Minute},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, nil)
+			bob := connect(server, "bob")
+			join(t, server, bob, "general")
+			client, conn := newPipeClient(t, server, "alice")
+			join(t, server, client, "general")
+			client.SetAway("idle", test.auto)
+			client.awaySince = time.Now().Add(-test.awayFor)
+			queued(client)
+			bob.sent = nil
+
+			readLines(t, server, client, conn, []string{"/ping"})
+			for len(server.broadcast) > 0 {
+				server.relayMessage(<-server.broadcast)
+			}
+
+			notice := formatChannelMessage("Server", "general", "alice is back.")
+			if got := bob.received(notice); got != test.wantSent {
+				t.Errorf("bob received %q, want the notice: %t", bob.sent, test.wantSent)
+			}
+			if slices.ContainsFunc(queued(client), func(msg string) bool { return strings.Contains(msg, "is back.") }) {
+				t.Error("alice was sent the notice about herself")
+			}
+		})
+	}
+}
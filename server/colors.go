@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"regexp"
+	"strconv"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
+)
+
+var hexColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// colorProfiles maps the profile names sent by clients with TERMCOLOR to the colors their terminal supports
+var colorProfiles = map[string]termenv.Profile{
+	"ascii":     termenv.Ascii,
+	"ansi":      termenv.ANSI,
+	"ansi256":   termenv.ANSI256,
+	"truecolor": termenv.TrueColor,
+}
+
+// validateChannelColor checks that the color is either a terminal color from 0 to 255 or a hex color like #ff8800
+func validateChannelColor(color string) error {
+	if hexColorPattern.MatchString(color) {
+		return nil
+	}
+
+	number, err := strconv.Atoi(color)
+	if err != nil || number < 0 || number > 255 {
+		return errors.New("the color must be a number from 0 to 255 or a hex color like #ff8800")
+	}
+	return nil
+}
+
+// colorize renders the text in the given color using only what the client's terminal can display.
+// The text is returned as is if the client doesn't support colors.
+func colorize(text, color string, profile termenv.Profile) string {
+	if color == "" || profile == termenv.Ascii {
+		return text
+	}
+
+	renderer := lipgloss.NewRenderer(io.Discard)
+	renderer.SetColorProfile(profile)
+	return renderer.NewStyle().Foreground(lipgloss.Color(color)).Render(text)
+}
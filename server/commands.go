@@ -1,21 +1,36 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
+
+	"github.com/rivo/uniseg"
 )
 
-type CommandFunc func(name string, args []string, client *Client, server *Server)
+type CommandFunc func(name string, args []string, client Session, server *Server)
 
 type Command struct {
-	Name   string
-	Args   []string
-	Client *Client
+	Name       string
+	Args       []string
+	Client     Session
+	ReceivedAt time.Time
 }
 
-func joinChannel(name string, args []string, client *Client, server *Server) {
+const (
+	maxEchoDelay             = 5000 * time.Millisecond // Longest delay accepted by /echo-delay
+	maxMassWhisperRecipients = 20                      // Most users /masswhisper can reach at once
+)
+
+func joinChannel(name string, args []string, client Session, server *Server) {
 	if len(args) < 1 {
-		client.SendMessage(formatMessage("Server", "Usage: /join <channel_name> [password]"))
+		client.SendServerMessage("Usage: /join <channel_name> [password|invite_code]")
 		return
 	}
 
@@ -26,97 +41,253 @@ func joinChannel(name string, args []string, client *Client, server *Server) {
 	}
 
 	if len(password) > maxPasswordLength {
-		client.SendMessage(formatMessage("Server", fmt.Sprintf("Password is too long. Maximum length is %d characters.", maxPasswordLength)))
+		client.SendServerMessage(fmt.Sprintf("Password is too long. Maximum length is %d characters.", maxPasswordLength))
 		return
 	}
 
 	channelName := args[0]
-	channel, exists := server.channels[channelName]
-	if !exists {
-		channel = NewChannel(channelName, password)
+	if !server.admitJoin(client, channelName, password) {
+		return
+	}
 
-		server.channels[channelName] = channel
+	channel, err := server.enterChannel(client, channelName, password)
+	if err != nil {
+		sendJoinError(client, server.channels[channelName], err)
+		return
 	}
 
-	joinedChannel := client.GetChannel()
+	client.SendServerMessage(fmt.Sprintf("You have joined channel '%s'%s", channel.Name, formatColorTag(channel.Color())))
+}
 
-	if joinedChannel != nil {
-		joinedChannel.RemoveMember(client)
+// admitJoin reports whether the client may join the channel with the password, creating it if it doesn't exist,
+// and tells it why not otherwise. Used by /join and the auto-join to the default channel.
+func (s *Server) admitJoin(client Session, channelName, password string) bool {
+	existing, exists := s.channels[channelName]
+	joined := client.GetJoinedChannel(channelName) != nil
 
-		server.broadcastMessage(client, joinedChannel, fmt.Sprintf("%s has left the channel.", client.GetUsername()))
-		if len(joinedChannel.members) == 0 {
-			delete(server.channels, joinedChannel.Name)
+	// Joins that would be refused anyway aren't counted against the client's join and creation limits
+	switch {
+	case joined:
+	case exists:
+		if err := checkAccess(client, existing, password); err != nil {
+			sendJoinError(client, existing, err)
+			return false
+		}
+	default:
+		if maxChannels := s.config.MaxChannels; maxChannels > 0 && len(s.channels) >= maxChannels {
+			client.SendServerMessage("Server channel limit reached. Please join an existing channel.")
+			return false
 		}
 	}
 
-	if channel.RequiresPassword() && password == "" {
-		client.SendMessage(formatMessage("Server", fmt.Sprintf("Channel '%s' requires a password.", channelName)))
-		return
+	// Refuse joins from flooding clients before any join notification is generated
+	if !joined && !client.allowJoin() {
+		client.SendServerMessage(fmt.Sprintf("You are joining channels too quickly. Please wait %s.", formatRetryAfter(client.JoinCooldownRemaining())))
+		return false
 	}
 
-	if err := channel.AddMember(client, password); err != nil {
-		client.SendMessage(formatMessage("Server", fmt.Sprintf("Incorrect password for channel '%s'", channelName)))
-		return
+	if !exists && !client.allowChannelCreation() {
+		client.SendServerMessage("You are creating channels too quickly. Please wait.")
+		return false
 	}
+	return true
+}
 
-	client.SetChannel(channel)
-	client.SendMessage(formatMessage("Server", fmt.Sprintf("You have joined channel '%s'", channel.Name)))
-	server.broadcastMessage(client, channel, fmt.Sprintf("%s has joined the channel.", client.GetUsername()))
+// sendJoinError tells the client why it couldn't join the channel
+func sendJoinError(client Session, channel *Channel, err error) {
+	switch {
+	case errors.Is(err, ErrPasswordRequired):
+		client.SendServerMessage(fmt.Sprintf("Channel '%s' requires a password.%s", channel.Name, formatHint(channel)))
+	case errors.Is(err, ErrIncorrectPassword):
+		client.SendServerMessage(fmt.Sprintf("Incorrect password for channel '%s'.%s", channel.Name, formatHint(channel)))
+	case errors.Is(err, ErrChannelLocked):
+		client.SendServerMessage(fmt.Sprintf("Cannot join '%s': channel is locked.", channel.Name))
+	case errors.Is(err, ErrInviteCodeExpired):
+		client.SendServerMessage(fmt.Sprintf("Invite code for channel '%s' has expired or has no uses left.", channel.Name))
+	}
 }
 
-func leaveChannel(name string, args []string, client *Client, server *Server) {
+// resolveJoinedChannel returns the joined channel named in the arguments, or the active channel if none is given.
+// It tells the client why if there is no such channel.
+func resolveJoinedChannel(args []string, client Session) *Channel {
+	if len(args) > 0 {
+		joinedChannel := client.GetJoinedChannel(args[0])
+		if joinedChannel == nil {
+			client.SendServerMessage(fmt.Sprintf("You are not in channel '%s'.", args[0]))
+		}
+		return joinedChannel
+	}
+
 	joinedChannel := client.GetChannel()
+	if joinedChannel == nil {
+		client.SendServerMessage("You are not in any channel.")
+	}
+	return joinedChannel
+}
 
+func leaveChannel(name string, args []string, client Session, server *Server) {
+	joinedChannel := resolveJoinedChannel(args, client)
 	if joinedChannel == nil {
-		client.SendMessage(formatMessage("Server", "You are not in any channel."))
 		return
 	}
-	joinedChannel.RemoveMember(client)
-	server.broadcastMessage(client, joinedChannel, fmt.Sprintf("%s has left the channel.", client.GetUsername()))
+	server.leaveChannel(client, joinedChannel)
 
-	if len(joinedChannel.members) == 0 {
-		delete(server.channels, joinedChannel.Name)
+	client.SendServerMessage(fmt.Sprintf("You have left channel '%s'", joinedChannel.Name))
+	if activeChannel := client.GetChannel(); activeChannel != nil {
+		client.SendServerMessage(fmt.Sprintf("Your active channel is now '%s'", activeChannel.Name))
 	}
-
-	client.SetChannel(nil)
-	client.SendMessage(formatMessage("Server", fmt.Sprintf("You have left channel '%s'", joinedChannel.Name)))
 }
 
-func connectedClients(name string, args []string, client *Client, server *Server) {
-	client.SendMessage(formatMessage("Server", fmt.Sprintf("Connected clients (%d)", len(server.clients))))
+func switchChannel(name string, args []string, client Session, server *Server) {
+	if len(args) < 1 {
+		client.SendServerMessage("Usage: /switch <channel_name>")
+		return
+	}
+
+	joinedChannel := resolveJoinedChannel(args, client)
+	if joinedChannel == nil {
+		return
+	}
+
+	client.SetChannel(joinedChannel)
+	client.SendServerMessage(fmt.Sprintf("Your messages will now be sent to '%s'", joinedChannel.Name))
 }
 
-func channelMembers(name string, args []string, client *Client, server *Server) {
-	joinedChannel := client.GetChannel()
+func connectedClients(name string, args []string, client Session, server *Server) {
+	client.SendServerMessage(fmt.Sprintf("Connected clients (%d)", len(server.clients)))
+}
 
+func channelMembers(name string, args []string, client Session, server *Server) {
+	joinedChannel := resolveJoinedChannel(args, client)
 	if joinedChannel == nil {
-		client.SendMessage(formatMessage("", "You are not in any channel."))
 		return
 	}
 
 	var members []string
-	for _, member := range joinedChannel.members {
-		members = append(members, member.GetUsername())
+	for _, member := range joinedChannel.Members() {
+		members = append(members, member.Username)
 	}
-	client.SendMessage(formatMessage("", fmt.Sprintf("Members in channel '%s': \n%s", joinedChannel.Name, strings.Join(members, ", "))))
+	client.SendServerMessage(fmt.Sprintf("Members in channel '%s': \n%s", joinedChannel.Name, strings.Join(members, ", ")))
+}
+
+// ChannelListEntry describes a channel in the channel-list event clients with a channel browser ask for with /channels --event
+type ChannelListEntry struct {
+	Name     string `json:"name"`
+	Members  int    `json:"members"`
+	Topic    string `json:"topic,omitempty"`
+	Locked   bool   `json:"locked,omitempty"`
+	Password bool   `json:"password,omitempty"`
+	Hidden   bool   `json:"hidden,omitempty"`
+	Joined   bool   `json:"joined,omitempty"`
 }
 
-func listChannels(name string, args []string, client *Client, server *Server) {
-	if len(server.channels) == 0 {
-		client.SendMessage(formatMessage("", "No channels available."))
+func listChannels(name string, args []string, client Session, server *Server) {
+	onlyEmpty := false
+	asEvent := false
+	var createdBefore time.Time
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--empty":
+			onlyEmpty = true
+		case "--event":
+			asEvent = true
+		case "--created-before":
+			if i+1 >= len(args) {
+				client.SendServerMessage("Usage: /channels [--empty] [--created-before <duration|YYYY-MM-DD>]")
+				return
+			}
+
+			i++
+			cutoff, err := parseCutoff(args[i])
+			if err != nil {
+				client.SendServerMessage(fmt.Sprintf("Invalid date '%s'. Use a duration like 24h or a date like 2006-01-02.", args[i]))
+				return
+			}
+			createdBefore = cutoff
+		default:
+			client.SendServerMessage("Usage: /channels [--empty] [--created-before <duration|YYYY-MM-DD>]")
+			return
+		}
+	}
+
+	channelNames := make([]string, 0, len(server.channelIndex))
+	entries := make([]ChannelListEntry, 0, len(server.channelIndex))
+	for _, channel := range server.channelIndex {
+		if onlyEmpty && !channel.IsEmpty() {
+			continue
+		}
+
+		if !createdBefore.IsZero() && !channel.CreatedAt().Before(createdBefore) {
+			continue
+		}
+
+		// Hidden channels are only listed to their members
+		isMember := client.GetJoinedChannel(channel.Name) != nil
+		if channel.IsHidden() && !isMember {
+			continue
+		}
+
+		entries = append(entries, ChannelListEntry{
+			Name:     channel.Name,
+			Members:  channel.MemberCount(),
+			Topic:    channel.Topic().Text,
+			Locked:   channel.IsLocked(),
+			Password: channel.RequiresPassword(),
+			Hidden:   channel.IsHidden(),
+			Joined:   isMember,
+		})
+
+		entry := colorize(channel.Name, channel.Color(), client.ColorProfile()) + fmt.Sprintf(" (%d)", channel.MemberCount())
+		if channel.IsLocked() {
+			entry += " [locked]"
+		}
+		if channel.IsHidden() {
+			entry += " [hidden]"
+		}
+
+		// Admins see how old channels are, to spot the stale ones
+		if client.IsAdmin() {
+			entry += fmt.Sprintf(" created %s ago by %s", formatDuration(time.Since(channel.CreatedAt())), channel.CreatedBy())
+		}
+		channelNames = append(channelNames, entry)
+	}
+
+	// Channel browsers get the list as an event, an empty list included
+	if asEvent {
+		data, err := json.Marshal(entries)
+		if err != nil {
+			client.SendServerMessage("Failed to list the channels.")
+			return
+		}
+		client.SendMessage(formatEvent("channel-list", string(data)))
 		return
 	}
 
-	var channelNames []string
-	for channelName, channel := range server.channels {
-		channelNames = append(channelNames, channelName+fmt.Sprintf(" (%d)", len(channel.members)))
+	if len(channelNames) == 0 {
+		client.SendServerMessage("No channels available.")
+		return
+	}
+	client.SendServerMessage(fmt.Sprintf("Available channels: \n%s", strings.Join(channelNames, "\n")))
+}
+
+// parseCutoff parses either a duration, meaning that long ago, or a date
+func parseCutoff(value string) (time.Time, error) {
+	if duration, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-duration), nil
 	}
-	client.SendMessage(formatMessage("", fmt.Sprintf("Available channels: \n%s", strings.Join(channelNames, "\n"))))
+
+	return time.ParseInLocation(time.DateOnly, value, time.Local)
 }
 
-func changeName(name string, args []string, client *Client, server *Server) {
+func changeName(name string, args []string, client Session, server *Server) {
 	if len(args) < 1 {
-		client.SendMessage(formatMessage("Server", "Usage: /name <new_username>"))
+		client.SendServerMessage(fmt.Sprintf("Usage: /%s <new_username>", name))
+		return
+	}
+
+	// Changing names too often makes it hard to tell who is who
+	if remaining := server.config.NameCooldown - time.Since(client.State().lastNameChange); remaining > 0 && !client.IsAdmin() {
+		client.SendServerMessage(fmt.Sprintf("You must wait %d more seconds before changing your name again.", int(math.Ceil(remaining.Seconds()))))
 		return
 	}
 
@@ -124,68 +295,1801 @@ func changeName(name string, args []string, client *Client, server *Server) {
 	oldUsername := client.GetUsername()
 
 	// Use the shared changeUsername function
-	if err := server.changeUsername(client, oldUsername, newName); err != nil {
-		client.SendMessage(formatMessage("Server", fmt.Sprintf("Failed to change username: %s", err.Error())))
+	err := server.requireLogin(client, newName)
+	if err == nil {
+		err = server.changeUsername(client, oldUsername, newName)
+	}
+	if err != nil {
+		client.SendServerMessage(fmt.Sprintf("Failed to change username: %s", err.Error()))
+		return
+	}
+
+	client.State().lastNameChange = time.Now()
+	client.SendServerMessage(fmt.Sprintf("Your username has been changed to '%s'", newName))
+}
+
+func away(name string, args []string, client Session, server *Server) {
+	if len(args) == 0 {
+		if awayMessage, _ := client.Away(); awayMessage == "" {
+			client.SendServerMessage("Usage: /away <message> (or /away without a message to come back)")
+			return
+		}
+
+		client.ClearAway()
+		client.SendServerMessage("You are no longer away.")
+		return
+	}
+
+	// Set manually, so it's kept until the client runs /away again
+	client.SetAway(strings.Join(args, " "), false)
+	client.SendServerMessage("You are now away. Use /away again to come back.")
+}
+
+func registerNick(name string, args []string, client Session, server *Server) {
+	if len(args) != 1 {
+		client.SendServerMessage("Usage: /register <password>")
+		return
+	}
+
+	username, password := client.GetUsername(), args[0]
+	if server.nicks.IsRegistered(username) {
+		client.SendServerMessage(fmt.Sprintf("'%s' is already registered.", username))
+		return
+	}
+	if len(password) < minNickPasswordLength {
+		client.SendServerMessage(fmt.Sprintf("The password must be at least %d characters long.", minNickPasswordLength))
+		return
+	}
+
+	server.runOffLoop(func() func() {
+		hash, err := hashNickPassword(password)
+		return func() {
+			if err != nil {
+				server.log.command.Error("Failed to hash nickname password", "username", username, "error", err)
+				if server.hasSession(client) {
+					client.SendServerMessage("Failed to register your username.")
+				}
+				return
+			}
+			server.addNick(client, username, hash)
+		}
+	})
+}
+
+// addNick registers the nickname the client asked to register once its password is hashed, saving it off the run loop
+func (s *Server) addNick(client Session, username string, hash []byte) {
+	// Someone else may have registered it while the password was hashed
+	if err := s.nicks.Add(username, hash); err != nil {
+		if s.hasSession(client) {
+			client.SendServerMessage(fmt.Sprintf("'%s' is already registered.", username))
+		}
+		return
+	}
+
+	registered := func() {
+		if !s.hasSession(client) {
+			return
+		}
+		client.State().loggedInAs = username
+		s.log.command.Info("Nickname registered", "username", username, "ip", client.RemoteAddr())
+		client.SendServerMessage(fmt.Sprintf("'%s' is now registered. Use /ghost %s <password> to reclaim it from a stale session.", username, username))
+	}
+	if !s.nicks.Persistent() {
+		registered()
+		return
+	}
+
+	snapshot := s.nicks.snapshot()
+	s.runOffLoop(func() func() {
+		err := s.nicks.save(snapshot)
+		return func() {
+			if err == nil {
+				registered()
+				return
+			}
+
+			s.nicks.Remove(username, hash)
+			s.log.storage.Error("Failed to register nickname", "username", username, "error", err)
+			if s.hasSession(client) {
+				client.SendServerMessage("Failed to register your username.")
+			}
+		}
+	})
+}
+
+// login proves the client owns a registered username, giving it the username it asked for if it doesn't have it yet.
+// Like with /ghost, a session still holding the username is disconnected.
+func login(name string, args []string, client Session, server *Server) {
+	if !server.nicks.Persistent() {
+		client.SendServerMessage("Logins are disabled on this server.")
+		return
+	}
+
+	if len(args) != 1 {
+		client.SendServerMessage("Usage: /login <password>")
+		return
+	}
+
+	nickname := client.State().pendingLogin
+	if nickname == "" {
+		nickname = client.GetUsername()
+	}
+
+	hash, registered := server.nicks.Hash(nickname)
+	if nickname == "" || !registered {
+		client.SendServerMessage("Your username is not registered. Use /register <password> to register it.")
+		return
+	}
+
+	if server.isLoggedIn(client) && nickname == client.GetUsername() {
+		client.SendServerMessage(fmt.Sprintf("You are already logged in as '%s'.", nickname))
+		return
+	}
+
+	if !server.allowAuthAttempt(client, nickname, time.Now()) {
+		client.SendServerMessage("Too many login attempts. Please try again later.")
+		return
+	}
+
+	password := args[0]
+	server.runOffLoop(func() func() {
+		correct := checkNickPassword(hash, password)
+		return func() {
+			if server.hasSession(client) {
+				server.finishLogin(client, nickname, correct)
+			}
+		}
+	})
+}
+
+// finishLogin logs the client in once its password was checked
+func (s *Server) finishLogin(client Session, nickname string, correct bool) {
+	if !correct {
+		s.log.command.Warn("Failed login attempt", "username", nickname, "ip", client.RemoteAddr())
+		client.SendServerMessage("Incorrect password.")
+		return
+	}
+
+	client.State().loggedInAs = nickname
+	if nickname != client.GetUsername() {
+		if holder, exists := s.clients[nickname]; exists && holder != client {
+			s.disconnectClient(holder, fmt.Sprintf("'%s' logged in from another session.", nickname))
+		}
+
+		if err := s.changeUsername(client, client.clientsKey(), nickname); err != nil {
+			client.SendServerMessage(fmt.Sprintf("Failed to change username: %s", err.Error()))
+			return
+		}
+	}
+
+	s.log.command.Info("User logged in", "username", nickname, "ip", client.RemoteAddr())
+	client.SendServerMessage(fmt.Sprintf("You are logged in as '%s'.", nickname))
+	if !client.IsRegistered() {
+		s.completeRegistration(client)
+	}
+	s.welcomeBack(client)
+}
+
+// ghost disconnects the session holding a registered nickname and renames the caller to it.
+// The stale session's channels are not transferred, the caller keeps its own.
+func ghost(name string, args []string, client Session, server *Server) {
+	if len(args) != 2 {
+		client.SendServerMessage("Usage: /ghost <username> <password>")
 		return
 	}
 
-	client.SendMessage(formatMessage("Server", fmt.Sprintf("Your username has been changed to '%s'", newName)))
+	nickname, password := args[0], args[1]
+	if nickname == client.GetUsername() {
+		client.SendServerMessage(fmt.Sprintf("You are already '%s'.", nickname))
+		return
+	}
+
+	if !server.allowAuthAttempt(client, nickname, time.Now()) {
+		client.SendServerMessage("Too many ghost attempts. Please try again later.")
+		return
+	}
+
+	hash, registered := server.nicks.Hash(nickname)
+	if !registered {
+		server.finishGhost(client, nickname, false)
+		return
+	}
+
+	server.runOffLoop(func() func() {
+		correct := checkNickPassword(hash, password)
+		return func() {
+			if server.hasSession(client) {
+				server.finishGhost(client, nickname, correct)
+			}
+		}
+	})
+}
+
+// finishGhost hands the nickname over to the client once its password was checked
+func (s *Server) finishGhost(client Session, nickname string, correct bool) {
+	if !correct {
+		s.log.command.Warn("Failed ghost attempt", "username", client.GetUsername(), "ip", client.RemoteAddr(), "target", nickname)
+		client.SendServerMessage("Invalid username or password.")
+		return
+	}
+
+	// Ownership wins, even over a session that is still active
+	if holder, exists := s.clients[nickname]; exists && holder != client {
+		s.disconnectClient(holder, fmt.Sprintf("'%s' was reclaimed by its owner.", nickname))
+	}
+
+	if err := s.changeUsername(client, client.clientsKey(), nickname); err != nil {
+		client.SendServerMessage(fmt.Sprintf("Failed to change username: %s", err.Error()))
+		return
+	}
+
+	client.State().loggedInAs = nickname
+	s.log.command.Info("Nickname reclaimed", "username", nickname, "ip", client.RemoteAddr())
+	client.SendServerMessage(fmt.Sprintf("You have reclaimed '%s'.", nickname))
+	s.welcomeBack(client)
+}
+
+// deliverWhisper sends the whisper, followed by a request to acknowledge it once it's read.
+// Returns an error if the whisper couldn't be queued, in which case the recipient is being disconnected.
+func (s *Server) deliverWhisper(sender, recipient Session, message string) error {
+	if err := recipient.SendMessage(formatMessage(fmt.Sprintf("DM from %s", sender.GetUsername()), message)); err != nil {
+		return err
+	}
+
+	// Legacy clients never acknowledge whispers, so their senders aren't told when they are read
+	if recipient.Protocol() == protocolLegacy {
+		return nil
+	}
+	messageID := s.trackReadAck(sender.GetUsername(), recipient.GetUsername(), false)
+	recipient.SendMessage(formatEvent("read-receipt", strconv.FormatUint(messageID, 10)))
+	return nil
 }
 
-func whisper(name string, args []string, client *Client, server *Server) {
+// massWhisper whispers the same message to several users at once. Whisper rate limits don't apply to admins.
+func massWhisper(name string, args []string, client Session, server *Server) {
 	if len(args) < 2 {
-		client.SendMessage(formatMessage("Server", "Usage: /whisper <username> <message>"))
+		client.SendServerMessage("Usage: /masswhisper <user1,user2,...> <message>")
+		return
+	}
+
+	var usernames []string
+	for _, username := range strings.Split(args[0], ",") {
+		if username != "" && !slices.Contains(usernames, username) {
+			usernames = append(usernames, username)
+		}
+	}
+
+	if len(usernames) == 0 {
+		client.SendServerMessage("Usage: /masswhisper <user1,user2,...> <message>")
+		return
+	}
+
+	if len(usernames) > maxMassWhisperRecipients {
+		client.SendServerMessage(fmt.Sprintf("You can whisper at most %d users at once.", maxMassWhisperRecipients))
 		return
 	}
 
-	targetUsername := args[0]
 	message := strings.Join(args[1:], " ")
+	var failed []string
+	for _, username := range usernames {
+		targetClient, exists := server.clients[username]
+		if !exists || !targetClient.IsRegistered() {
+			failed = append(failed, username)
+			continue
+		}
 
-	targetClient, exists := server.clients[targetUsername]
-	if !exists {
-		client.SendMessage(formatMessage("Server", fmt.Sprintf("User '%s' not found or not registered.", targetUsername)))
+		if err := server.deliverWhisper(client, targetClient, message); err != nil {
+			failed = append(failed, username)
+			continue
+		}
+		server.stats.WhispersSent++
+	}
+
+	summary := fmt.Sprintf("Delivered to %d/%d users.", len(usernames)-len(failed), len(usernames))
+	if len(failed) > 0 {
+		summary += fmt.Sprintf(" Failed: %s.", strings.Join(failed, ", "))
+	}
+	client.SendServerMessage(summary)
+}
+
+func ignoreChannel(name string, args []string, client Session, server *Server) {
+	if len(args) < 1 {
+		client.SendServerMessage(fmt.Sprintf("Usage: /%s <channel_name>", name))
+		return
+	}
+
+	joinedChannel := resolveJoinedChannel(args, client)
+	if joinedChannel == nil {
 		return
 	}
 
-	if client.GetUsername() == targetUsername {
-		client.SendMessage(formatMessage("Server", "You cannot whisper to yourself."))
+	_, ignored := client.State().ignoredChannels[joinedChannel.Name]
+	if name == "unignorechannel" {
+		if !ignored {
+			client.SendServerMessage(fmt.Sprintf("You are not ignoring channel '%s'.", joinedChannel.Name))
+			return
+		}
+
+		delete(client.State().ignoredChannels, joinedChannel.Name)
+		client.SendServerMessage(fmt.Sprintf("You will receive messages from channel '%s' again.", joinedChannel.Name))
 		return
 	}
 
-	if !targetClient.IsRegistered() {
-		client.SendMessage(formatMessage("Server", fmt.Sprintf("User '%s' is not available.", targetUsername)))
+	if ignored {
+		client.SendServerMessage(fmt.Sprintf("You are already ignoring channel '%s'.", joinedChannel.Name))
 		return
 	}
 
-	// Send the whisper message
-	targetClient.SendMessage(formatMessage(fmt.Sprintf("DM from %s", client.GetUsername()), message))
-	client.SendMessage(formatMessage("Server", fmt.Sprintf("Whisper sent to '%s'", targetUsername)))
+	client.State().ignoredChannels[joinedChannel.Name] = struct{}{}
+	client.SendServerMessage(fmt.Sprintf("You will no longer receive messages from channel '%s'. You are still a member.", joinedChannel.Name))
 }
 
-func help(name string, args []string, client *Client, server *Server) {
-	helpText := `Available commands:
-/join <channel_name> [password] - Join or create a channel
-/leave - Leave the current channel
-/clients - Get the number of connected clients
-/members - List members in your current channel
-/channels - List all available channels
-/name <new_username> - Change your username
-/whisper <username> <message> - Send a private message to a user
-/help - Show this help message
+func ignoredChannels(name string, args []string, client Session, server *Server) {
+	if len(client.State().ignoredChannels) == 0 {
+		client.SendServerMessage("You are not ignoring any channels.")
+		return
+	}
 
-Note: Arguments in <> are required, arguments in [] are optional.
-`
+	channelNames := make([]string, 0, len(client.State().ignoredChannels))
+	for channelName := range client.State().ignoredChannels {
+		channelNames = append(channelNames, channelName)
+	}
+	slices.Sort(channelNames)
+	client.SendServerMessage(fmt.Sprintf("Ignored channels: %s", strings.Join(channelNames, ", ")))
+}
+
+// whisper sends a private message to one or more users, given as a comma-separated list of usernames and @groups
+func whisper(name string, args []string, client Session, server *Server) {
+	if len(args) < 2 {
+		client.SendServerMessage("Usage: /whisper <username[,username|@group...]> <message>")
+		return
+	}
 
-	client.SendMessage(formatMessage("", helpText))
+	usernames, err := parseRecipients(args[0], client)
+	if err != nil {
+		client.SendServerMessage(err.Error())
+		return
+	}
+	message := strings.Join(args[1:], " ")
+
+	if len(usernames) == 0 {
+		client.SendServerMessage("You cannot whisper to yourself.")
+		return
+	}
+
+	// Each recipient takes a token, so more recipients than the bucket holds could never be whispered
+	limit := min(maxWhisperRecipients, server.config.WhisperBucket)
+	if len(usernames) > limit {
+		client.SendServerMessage(fmt.Sprintf("You can whisper at most %d users at once.", limit))
+		return
+	}
+
+	// Users that can't be whispered are reported without using up the sender's whispers
+	var recipients []Session
+	var failed []string
+	for _, username := range usernames {
+		targetClient, exists := server.clients[username]
+		switch {
+		case !exists:
+			failed = append(failed, fmt.Sprintf("User '%s' not found or not registered.", username))
+		case !targetClient.IsRegistered():
+			failed = append(failed, fmt.Sprintf("User '%s' is not available.", username))
+		default:
+			recipients = append(recipients, targetClient)
+		}
+	}
+
+	// Whispers have their own stricter limits since they bypass channel moderation
+	if len(recipients) > 0 && !client.allowWhisper(len(recipients)) {
+		server.stats.WhispersRateLimited++
+		client.SendNotice("whisper-rate-limit", formatMessage("Server", "You are sending whispers too quickly. Please wait before whispering again."))
+		return
+	}
+
+	var delivered []Session
+	var sent []string
+	for _, targetClient := range recipients {
+		targetUsername := targetClient.GetUsername()
+		if !targetClient.allowReceivingWhisper() {
+			server.stats.WhispersCapped++
+			failed = append(failed, fmt.Sprintf("'%s' is receiving too many whispers right now. Try again later.", targetUsername))
+			continue
+		}
+
+		// Shadow muted clients are told their whisper was sent, and read once the recipient reads another one,
+		// but it's never delivered
+		if server.isShadowMuted(client) {
+			if targetClient.Protocol() != protocolLegacy {
+				server.trackReadAck(client.GetUsername(), targetUsername, true)
+			}
+		} else if err := server.deliverWhisper(client, targetClient, message); err != nil {
+			failed = append(failed, fmt.Sprintf("'%s' is not available.", targetUsername))
+			continue
+		}
+		server.stats.WhispersSent++
+		delivered = append(delivered, targetClient)
+		sent = append(sent, fmt.Sprintf("'%s'", targetUsername))
+	}
+
+	if len(sent) > 0 {
+		client.SendServerMessage(fmt.Sprintf("Whisper sent to %s", strings.Join(sent, ", ")))
+	}
+	if len(failed) > 0 {
+		client.SendServerMessage(strings.Join(failed, "\n"))
+	}
+
+	for _, targetClient := range delivered {
+		if awayMessage, _ := targetClient.Away(); awayMessage != "" {
+			client.SendServerMessage(fmt.Sprintf("'%s' is away: %s", targetClient.GetUsername(), awayMessage))
+		}
+	}
 }
 
-func (s *Server) loadCommands() {
-	s.commands["join"] = joinChannel
-	s.commands["leave"] = leaveChannel
-	s.commands["clients"] = connectedClients
-	s.commands["members"] = channelMembers
-	s.commands["channels"] = listChannels
-	s.commands["name"] = changeName
-	s.commands["whisper"] = whisper
-	s.commands["help"] = help
+// validateIcon checks that the icon is a single printable grapheme cluster, such as an emoji
+func validateIcon(icon string) error {
+	if uniseg.GraphemeClusterCount(icon) != 1 {
+		return errors.New("the icon must be a single character or emoji")
+	}
+
+	for _, r := range icon {
+		if r <= unicode.MaxASCII {
+			return errors.New("the icon cannot be an ASCII character")
+		}
+
+		// Emoji sequences are joined by zero width joiners and variation selectors, which aren't graphic on their own
+		if !unicode.IsGraphic(r) && r != '\u200d' && !unicode.Is(unicode.Variation_Selector, r) {
+			return errors.New("the icon must be printable")
+		}
+	}
+
+	return nil
+}
+
+func setIcon(name string, args []string, client Session, server *Server) {
+	if len(args) != 1 {
+		client.SendServerMessage("Usage: /seticon <emoji>")
+		return
+	}
+
+	if err := validateIcon(args[0]); err != nil {
+		client.SendServerMessage(fmt.Sprintf("Invalid icon: %s", err.Error()))
+		return
+	}
+
+	client.SetDisplayIcon(args[0])
+	client.SendServerMessage(fmt.Sprintf("Your messages will now be shown as '%s'", client.DisplayName()))
+}
+
+func clearIcon(name string, args []string, client Session, server *Server) {
+	if client.GetDisplayIcon() == "" {
+		client.SendServerMessage("You don't have an icon set.")
+		return
+	}
+
+	client.SetDisplayIcon("")
+	client.SendServerMessage("Your icon has been removed.")
+}
+
+func stats(name string, args []string, client Session, server *Server) {
+	lines := []string{
+		"Server stats:",
+		fmt.Sprintf("Connected clients: %d", len(server.clients)),
+		fmt.Sprintf("Channels: %s", formatChannelCount(server)),
+		fmt.Sprintf("Whispers sent: %d", server.stats.WhispersSent),
+		fmt.Sprintf("Whispers rate limited: %d", server.stats.WhispersRateLimited),
+		fmt.Sprintf("Whispers rejected by recipient limit: %d", server.stats.WhispersCapped),
+		fmt.Sprintf("Bytes read: %s", formatBytes(server.stats.BytesRead.Load())),
+		fmt.Sprintf("Bytes written: %s", formatBytes(server.stats.BytesWritten.Load())),
+		fmt.Sprintf("Dropped broadcasts: %d", server.stats.DroppedBroadcasts.Load()),
+		fmt.Sprintf("Dropped sends: %d replies, %d events, %d messages", server.stats.DroppedReplies.Load(), server.stats.DroppedEvents.Load(), server.stats.DroppedMessages.Load()),
+		fmt.Sprintf("Slow clients: %d", len(server.slowClients())),
+	}
+
+	// Admins also get what the runtime is up to
+	if client.IsAdmin() {
+		lines = append(lines, "")
+		lines = append(lines, server.formatRuntimeStats()...)
+	}
+	client.SendServerMessage(strings.Join(lines, "\n"))
+}
+
+func echo(name string, args []string, client Session, server *Server) {
+	if len(args) < 1 {
+		client.SendServerMessage("Usage: /echo <text>")
+		return
+	}
+
+	client.SendServerMessage(formatEcho(strings.Join(args, " "), client.State().commandReceivedAt))
+}
+
+func echoDelay(name string, args []string, client Session, server *Server) {
+	if len(args) < 2 {
+		client.SendServerMessage("Usage: /echo-delay <ms> <text>")
+		return
+	}
+
+	ms, err := strconv.Atoi(args[0])
+	if err != nil || ms < 0 {
+		client.SendServerMessage("The delay must be a number of milliseconds.")
+		return
+	}
+
+	// Compared before converting, a large enough number of milliseconds overflows a Duration
+	delay := maxEchoDelay
+	if int64(ms) <= maxEchoDelay.Milliseconds() {
+		delay = time.Duration(ms) * time.Millisecond
+	} else {
+		client.SendServerMessage(fmt.Sprintf("The delay is capped at %d ms.", maxEchoDelay.Milliseconds()))
+	}
+
+	// The echo goes back through the run loop in case the client disconnects in the meantime
+	text, receivedAt := strings.Join(args[1:], " "), client.State().commandReceivedAt
+	go func() {
+		select {
+		case <-time.After(delay):
+		case <-server.shutdown:
+			return
+		}
+
+		select {
+		case server.deliver <- Delivery{To: client, Body: formatMessage("Server", formatEcho(text, receivedAt))}:
+		case <-server.shutdown:
+		}
+	}()
+}
+
+// formatEcho formats echoed text along with the time elapsed since its command was received
+func formatEcho(text string, receivedAt time.Time) string {
+	return fmt.Sprintf("%s (%s)", text, time.Since(receivedAt).Round(time.Microsecond))
+}
+
+func channelCount(name string, args []string, client Session, server *Server) {
+	client.SendServerMessage(fmt.Sprintf("Channels: %s", formatChannelCount(server)))
+}
+
+// formatChannelCount formats the number of channels as a fraction of the limit, e.g. 3/10
+func formatChannelCount(server *Server) string {
+	if server.config.MaxChannels <= 0 {
+		return fmt.Sprintf("%d/unlimited", len(server.channels))
+	}
+	return fmt.Sprintf("%d/%d", len(server.channels), server.config.MaxChannels)
+}
+
+// formatBytes formats a number of bytes using binary units, e.g. 1.5 KiB
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for quotient := n / unit; quotient >= unit; quotient /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func admin(name string, args []string, client Session, server *Server) {
+	if len(args) < 1 {
+		client.SendServerMessage("Usage: /admin <password>")
+		return
+	}
+
+	if client.IsAdmin() {
+		client.SendServerMessage("You are already an admin.")
+		return
+	}
+
+	if server.config.AdminPassword == "" || !passwordsEqual(args[0], server.config.AdminPassword) {
+		server.log.command.Warn("Failed admin login attempt", "username", client.GetUsername(), "ip", client.RemoteAddr())
+		client.SendServerMessage("Incorrect admin password.")
+		return
+	}
+
+	client.SetAdmin(true)
+	server.log.command.Info("Client gained admin privileges", "username", client.GetUsername(), "ip", client.RemoteAddr())
+	client.SendServerMessage("You are now an admin.")
+}
+
+func setDefaultChannel(name string, args []string, client Session, server *Server) {
+	if len(args) < 1 {
+		client.SendServerMessage("Usage: /setdefaultchannel <channel_name>")
+		return
+	}
+
+	// The channel can be given as it's shown, with a leading '#'
+	channelName := strings.TrimPrefix(args[0], "#")
+	if channelName == "" {
+		client.SendServerMessage("Usage: /setdefaultchannel <channel_name>")
+		return
+	}
+
+	server.defaultChannel = channelName
+	client.SendServerMessage(fmt.Sprintf("New users will now be auto-joined to #%s.", server.defaultChannel))
+}
+
+func say(name string, args []string, client Session, server *Server) {
+	if len(args) < 1 {
+		client.SendServerMessage("Usage: /say [#channel] <message>")
+		return
+	}
+
+	// An argument starting with '#' targets that channel, even if the admin is not a member
+	channel := client.GetChannel()
+	if channelName, ok := strings.CutPrefix(args[0], "#"); ok {
+		if len(args) < 2 {
+			client.SendServerMessage("Usage: /say [#channel] <message>")
+			return
+		}
+
+		var exists bool
+		channel, exists = server.channels[channelName]
+		if !exists {
+			client.SendServerMessage(fmt.Sprintf("Channel '%s' not found.", channelName))
+			return
+		}
+		args = args[1:]
+	}
+
+	if channel == nil {
+		client.SendServerMessage("You are not in a channel. Use /say #<channel> <message> to choose one.")
+		return
+	}
+
+	server.broadcastMessage(client, channel, strings.Join(args, " "))
+}
+
+// UserExport is the information about a client included in /exportusers
+type UserExport struct {
+	ID             string    `json:"id"`
+	Username       string    `json:"username"`
+	Registered     bool      `json:"registered"`
+	Channel        string    `json:"channel"`
+	Channels       []string  `json:"channels"`
+	ConnectedSince time.Time `json:"connected_since"`
+	MessageCount   int64     `json:"message_count"`
+}
+
+func announce(name string, args []string, client Session, server *Server) {
+	perChannel := len(args) > 0 && args[0] == "--channels"
+	if perChannel {
+		args = args[1:]
+	}
+
+	if len(args) < 1 {
+		client.SendServerMessage("Usage: /announce [--channels] <message>")
+		return
+	}
+
+	message := "[Announcement] " + strings.Join(args, " ")
+	if !perChannel {
+		server.broadcastMessage(nil, nil, message)
+		return
+	}
+
+	// Sent to each channel like any other channel message, so members of several channels see it in each of them
+	for _, channel := range server.channelIndex {
+		server.broadcastMessage(nil, channel, message)
+	}
+	client.SendServerMessage(fmt.Sprintf("Announcement sent to %d channel(s).", len(server.channelIndex)))
+}
+
+func banIP(name string, args []string, client Session, server *Server) {
+	if len(args) < 1 || len(args) > 2 {
+		client.SendServerMessage("Usage: /banip <ip> [duration]")
+		return
+	}
+
+	var duration time.Duration
+	if len(args) == 2 {
+		parsed, err := time.ParseDuration(args[1])
+		if err != nil || parsed <= 0 {
+			client.SendServerMessage(fmt.Sprintf("Invalid duration '%s'. Use a duration like 30m or 24h.", args[1]))
+			return
+		}
+		duration = parsed
+	}
+
+	if err := server.bans.Add(args[0], duration); err != nil {
+		client.SendServerMessage(fmt.Sprintf("Invalid IP address '%s'.", args[0]))
+		return
+	}
+
+	server.emitEvent(ServerEvent{Event: "ban", IP: args[0], By: client.GetUsername()})
+
+	// Clients already connected from the address are disconnected too
+	disconnected := 0
+	for _, connectedClient := range server.clients {
+		if server.bans.IsBanned(connectedClient.Host()) {
+			connectedClient.Disconnect("You have been banned from this server.")
+			server.emitEvent(ServerEvent{Event: "kick", User: connectedClient.GetUsername(), IP: connectedClient.Host(), By: client.GetUsername()})
+			disconnected++
+		}
+	}
+
+	server.log.command.Info("IP banned", "admin", client.GetUsername(), "ip", args[0], "duration", duration)
+	until := "permanently"
+	if duration > 0 {
+		until = "for " + duration.String()
+	}
+	client.SendServerMessage(fmt.Sprintf("Banned %s %s, disconnecting %d client(s).", args[0], until, disconnected))
+}
+
+func unbanIP(name string, args []string, client Session, server *Server) {
+	if len(args) != 1 {
+		client.SendServerMessage("Usage: /unbanip <ip>")
+		return
+	}
+
+	if !server.bans.Remove(args[0]) {
+		client.SendServerMessage(fmt.Sprintf("'%s' is not banned.", args[0]))
+		return
+	}
+
+	server.log.command.Info("IP unbanned", "admin", client.GetUsername(), "ip", args[0])
+	client.SendServerMessage(fmt.Sprintf("Unbanned %s.", args[0]))
+}
+
+func reloadBans(name string, args []string, client Session, server *Server) {
+	if !server.bans.HasFile() {
+		client.SendServerMessage("There is no ban file to reload. Start the server with -ban-file.")
+		return
+	}
+
+	reload, err := server.bans.Reload()
+	if err != nil {
+		server.log.storage.Error("Failed to reload ban file", "file", server.config.BanFile, "error", err)
+		client.SendServerMessage("Failed to reload the ban file.")
+		return
+	}
+
+	server.log.command.Info("Ban file reloaded", "admin", client.GetUsername(), "added", reload.Added, "removed", reload.Removed, "skipped", len(reload.Skipped))
+	lines := []string{fmt.Sprintf("Ban file reloaded: %d added, %d removed.", reload.Added, reload.Removed)}
+	if len(reload.Skipped) > 0 {
+		lines = append(lines, fmt.Sprintf("Skipped %d malformed line(s):", len(reload.Skipped)))
+		lines = append(lines, reload.Skipped...)
+	}
+	client.SendServerMessage(strings.Join(lines, "\n"))
+}
+
+func shadowMute(name string, args []string, client Session, server *Server) {
+	if len(args) != 1 {
+		client.SendServerMessage(fmt.Sprintf("Usage: /%s <username>", name))
+		return
+	}
+
+	username := args[0]
+	_, muted := server.shadowMuted[username]
+
+	if name == "unshadowmute" {
+		if !muted {
+			client.SendServerMessage(fmt.Sprintf("'%s' is not shadow muted.", username))
+			return
+		}
+
+		delete(server.shadowMuted, username)
+		server.saveModeration()
+		server.log.command.Info("Shadow mute removed", "admin", client.GetUsername(), "username", username)
+		client.SendServerMessage(fmt.Sprintf("'%s' is no longer shadow muted.", username))
+		return
+	}
+
+	if muted {
+		client.SendServerMessage(fmt.Sprintf("'%s' is already shadow muted.", username))
+		return
+	}
+
+	// Users that aren't connected can be shadow muted too, it applies once they use the name
+	server.shadowMuted[username] = struct{}{}
+	server.saveModeration()
+	server.log.command.Info("Shadow muted", "admin", client.GetUsername(), "username", username)
+	client.SendServerMessage(fmt.Sprintf("'%s' is now shadow muted. Only they will see their messages.", username))
+}
+
+func exportUsers(name string, args []string, client Session, server *Server) {
+	includeTemp := slices.Contains(args, "--include-temp")
+
+	users := make([]UserExport, 0, len(server.clients))
+	for _, connectedClient := range server.clients {
+		if !connectedClient.IsRegistered() && !includeTemp {
+			continue
+		}
+
+		// Unregistered clients don't have a username yet, so they are identified by their address
+		username := connectedClient.GetUsername()
+		if !connectedClient.IsRegistered() {
+			username = connectedClient.RemoteAddr()
+		}
+		session := connectedClient.session(time.Now())
+		user := UserExport{
+			ID:             connectedClient.ID(),
+			Username:       username,
+			Registered:     connectedClient.IsRegistered(),
+			Channels:       make([]string, 0),
+			ConnectedSince: session.ConnectedAt.UTC(),
+			MessageCount:   session.MessagesSent,
+		}
+
+		if activeChannel := connectedClient.GetChannel(); activeChannel != nil {
+			user.Channel = activeChannel.Name
+		}
+
+		for _, channel := range connectedClient.GetChannels() {
+			user.Channels = append(user.Channels, channel.Name)
+		}
+
+		users = append(users, user)
+	}
+
+	slices.SortFunc(users, func(a, b UserExport) int {
+		return strings.Compare(a.Username, b.Username)
+	})
+
+	data, err := json.Marshal(users)
+	if err != nil {
+		server.log.storage.Error("Failed to export users", "error", err)
+		client.SendServerMessage("Failed to export users.")
+		return
+	}
+
+	client.SendServerMessage(string(data))
+}
+
+func setChannelColor(name string, args []string, client Session, server *Server) {
+	if len(args) != 1 {
+		client.SendServerMessage("Usage: /setchannelcolor <0-255|#rrggbb|none>")
+		return
+	}
+
+	joinedChannel := resolveJoinedChannel(nil, client)
+	if joinedChannel == nil {
+		return
+	}
+
+	if args[0] == "none" {
+		joinedChannel.SetColor("")
+		client.SendServerMessage(fmt.Sprintf("Removed the color of '%s'.%s", joinedChannel.Name, formatColorTag("none")))
+		return
+	}
+
+	if err := validateChannelColor(args[0]); err != nil {
+		client.SendServerMessage(fmt.Sprintf("Invalid color: %s.", err.Error()))
+		return
+	}
+
+	joinedChannel.SetColor(args[0])
+	client.SendServerMessage(fmt.Sprintf("The color of '%s' is now %s.%s", colorize(joinedChannel.Name, joinedChannel.Color(), client.ColorProfile()), joinedChannel.Color(), formatColorTag(joinedChannel.Color())))
+}
+
+// formatColorTag formats the color of a channel as " [color:<color>]", for clients to color the channel's name.
+// It's empty if the channel has no color.
+func formatColorTag(color string) string {
+	if color == "" {
+		return ""
+	}
+	return " [color:" + color + "]"
+}
+
+func noSpam(name string, args []string, client Session, server *Server) {
+	const usage = "Usage: /nospam [on|off] [--duration <duration>]"
+
+	joinedChannel := resolveJoinedChannel(nil, client)
+	if joinedChannel == nil {
+		return
+	}
+
+	enable := true
+	duration := defaultSpamLockDuration
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "on":
+			enable = true
+		case "off":
+			enable = false
+		case "--duration":
+			if i+1 >= len(args) {
+				client.SendServerMessage(usage)
+				return
+			}
+
+			i++
+			parsed, err := time.ParseDuration(args[i])
+			if err != nil || parsed <= 0 {
+				client.SendServerMessage(fmt.Sprintf("Invalid duration '%s'. Use a duration like 10m.", args[i]))
+				return
+			}
+			duration = parsed
+		default:
+			client.SendServerMessage(usage)
+			return
+		}
+	}
+
+	if !enable {
+		if !joinedChannel.IsSpamLocked() {
+			client.SendServerMessage(fmt.Sprintf("Channel '%s' is not in no-spam mode.", joinedChannel.Name))
+			return
+		}
+
+		joinedChannel.ClearSpamLock()
+		server.broadcastMessage(nil, joinedChannel, fmt.Sprintf("%s turned off no-spam mode. Everyone can send messages again.", client.GetUsername()))
+		return
+	}
+
+	joinedChannel.SetSpamLock(duration)
+	server.broadcastMessage(nil, joinedChannel, fmt.Sprintf("%s turned on no-spam mode for %s. Only operators can send messages.", client.GetUsername(), duration))
+}
+
+func createInviteCode(name string, args []string, client Session, server *Server) {
+	channel := resolveJoinedChannel(nil, client)
+	if channel == nil {
+		return
+	}
+
+	if !channel.RequiresPassword() {
+		client.SendServerMessage(fmt.Sprintf("Channel '%s' doesn't have a password, anyone can join it.", channel.Name))
+		return
+	}
+
+	const usage = "Usage: /invitecode [uses] [ttl]"
+	uses := defaultInviteCodeUses
+	if len(args) > 0 {
+		parsedUses, err := strconv.Atoi(args[0])
+		if err != nil || parsedUses < 1 || parsedUses > maxInviteCodeUses {
+			client.SendServerMessage(fmt.Sprintf("%s (uses must be between 1 and %d)", usage, maxInviteCodeUses))
+			return
+		}
+		uses = parsedUses
+	}
+
+	ttl := defaultInviteCodeTTL
+	if len(args) > 1 {
+		parsedTTL, err := time.ParseDuration(args[1])
+		if err != nil || parsedTTL <= 0 || parsedTTL > maxInviteCodeTTL {
+			client.SendServerMessage(fmt.Sprintf("%s (ttl must be a duration like 30m, up to %s)", usage, maxInviteCodeTTL))
+			return
+		}
+		ttl = parsedTTL
+	}
+
+	inviteCode, err := channel.CreateInviteCode(uses, ttl, client.GetUsername())
+	if err != nil {
+		if errors.Is(err, ErrTooManyInviteCodes) {
+			client.SendServerMessage(fmt.Sprintf("Channel '%s' already has %d invite codes. Revoke one with /revokecode <code>.", channel.Name, maxInviteCodes))
+			return
+		}
+
+		server.log.command.Error("Failed to create invite code", "channel", channel.Name, "error", err)
+		client.SendServerMessage("Failed to create an invite code.")
+		return
+	}
+
+	client.SendServerMessage(fmt.Sprintf("Invite code for '%s': %s (%d use(s), expires in %s). Join with /join %s %s",
+		channel.Name, inviteCode.Code, inviteCode.UsesLeft, ttl, channel.Name, inviteCode.Code))
+}
+
+func listInviteCodes(name string, args []string, client Session, server *Server) {
+	channel := resolveJoinedChannel(nil, client)
+	if channel == nil {
+		return
+	}
+
+	codes := channel.InviteCodes()
+	if len(codes) == 0 {
+		client.SendServerMessage(fmt.Sprintf("Channel '%s' has no invite codes.", channel.Name))
+		return
+	}
+
+	lines := make([]string, 0, len(codes))
+	for _, inviteCode := range codes {
+		lines = append(lines, fmt.Sprintf("%s - %d use(s) left, expires in %s, created by %s",
+			inviteCode.Code, inviteCode.UsesLeft, formatRetryAfter(time.Until(inviteCode.ExpiresAt)), inviteCode.CreatedBy))
+	}
+	client.SendMessage(formatChannelMessage("Server", channel.Name, fmt.Sprintf("Invite codes for '%s':\n%s", channel.Name, strings.Join(lines, "\n"))))
+}
+
+func revokeInviteCode(name string, args []string, client Session, server *Server) {
+	if len(args) != 1 {
+		client.SendServerMessage("Usage: /revokecode <code>")
+		return
+	}
+
+	channel := resolveJoinedChannel(nil, client)
+	if channel == nil {
+		return
+	}
+
+	if err := channel.RevokeInviteCode(args[0]); err != nil {
+		client.SendServerMessage(fmt.Sprintf("Invite code '%s' not found in '%s'.", args[0], channel.Name))
+		return
+	}
+
+	client.SendServerMessage(fmt.Sprintf("Invite code '%s' has been revoked.", strings.ToUpper(args[0])))
+}
+
+func purgeEmpty(name string, args []string, client Session, server *Server) {
+	var olderThan time.Duration
+	if len(args) > 0 {
+		if len(args) != 2 || args[0] != "--older-than" {
+			client.SendServerMessage("Usage: /purge-empty [--older-than <duration>]")
+			return
+		}
+
+		duration, err := time.ParseDuration(args[1])
+		if err != nil || duration < 0 {
+			client.SendServerMessage(fmt.Sprintf("Invalid duration '%s'. Use a duration like 30m or 24h.", args[1]))
+			return
+		}
+		olderThan = duration
+	}
+
+	var purged []string
+	for _, channel := range server.channels {
+		if !channel.IsEmpty() || time.Since(channel.EmptySince()) < olderThan {
+			continue
+		}
+
+		server.deleteChannel(channel)
+		purged = append(purged, channel.Name)
+	}
+
+	if len(purged) == 0 {
+		client.SendServerMessage("No empty channels to purge.")
+		return
+	}
+
+	slices.Sort(purged)
+	server.log.command.Info("Purged empty channels", "admin", client.GetUsername(), "channels", purged)
+	client.SendServerMessage(fmt.Sprintf("Purged %d empty channel(s): %s", len(purged), strings.Join(purged, ", ")))
+}
+
+func loadPlugin(name string, args []string, client Session, server *Server) {
+	if len(args) < 1 {
+		client.SendServerMessage("Usage: /loadplugin <path>")
+		return
+	}
+
+	cmdPlugin, err := server.loadPlugin(args[0])
+	if err != nil {
+		client.SendServerMessage(fmt.Sprintf("Failed to load plugin: %s", err.Error()))
+		return
+	}
+
+	server.log.command.Info("Plugin loaded", "command", cmdPlugin.Name(), "path", args[0], "username", client.GetUsername())
+	client.SendServerMessage(fmt.Sprintf("Plugin loaded. Use /%s to run it.", cmdPlugin.Name()))
+}
+
+func unloadPlugin(name string, args []string, client Session, server *Server) {
+	if len(args) < 1 {
+		client.SendServerMessage("Usage: /unloadplugin <name>")
+		return
+	}
+
+	if !server.unloadPlugin(args[0]) {
+		client.SendServerMessage(fmt.Sprintf("No plugin named '%s' is loaded.", args[0]))
+		return
+	}
+
+	server.log.command.Info("Plugin unloaded", "command", args[0], "username", client.GetUsername())
+	client.SendServerMessage(fmt.Sprintf("Plugin '%s' unloaded. Its code stays in memory until the server restarts.", args[0]))
+}
+
+func lockChannel(name string, args []string, client Session, server *Server) {
+	joinedChannel := resolveJoinedChannel(args, client)
+	if joinedChannel == nil {
+		return
+	}
+
+	if joinedChannel.IsLocked() {
+		client.SendServerMessage(fmt.Sprintf("Channel '%s' is already locked.", joinedChannel.Name))
+		return
+	}
+
+	joinedChannel.SetLocked(true)
+	server.broadcastMessage(nil, joinedChannel, fmt.Sprintf("%s locked the channel. Only invited users can join.", client.GetUsername()))
+}
+
+func unlockChannel(name string, args []string, client Session, server *Server) {
+	joinedChannel := resolveJoinedChannel(args, client)
+	if joinedChannel == nil {
+		return
+	}
+
+	if !joinedChannel.IsLocked() {
+		client.SendServerMessage(fmt.Sprintf("Channel '%s' is not locked.", joinedChannel.Name))
+		return
+	}
+
+	joinedChannel.SetLocked(false)
+	server.broadcastMessage(nil, joinedChannel, fmt.Sprintf("%s unlocked the channel. Anyone can join again.", client.GetUsername()))
+}
+
+func hideChannel(name string, args []string, client Session, server *Server) {
+	joinedChannel := resolveJoinedChannel(args, client)
+	if joinedChannel == nil {
+		return
+	}
+
+	if joinedChannel.IsHidden() {
+		client.SendServerMessage(fmt.Sprintf("Channel '%s' is already hidden.", joinedChannel.Name))
+		return
+	}
+
+	joinedChannel.SetHidden(true)
+	server.saveChannelState(joinedChannel)
+	server.broadcastMessage(nil, joinedChannel, fmt.Sprintf("%s hid the channel. It's no longer listed in /channels, but can still be joined by name.", client.GetUsername()))
+}
+
+func unhideChannel(name string, args []string, client Session, server *Server) {
+	joinedChannel := resolveJoinedChannel(args, client)
+	if joinedChannel == nil {
+		return
+	}
+
+	if !joinedChannel.IsHidden() {
+		client.SendServerMessage(fmt.Sprintf("Channel '%s' is not hidden.", joinedChannel.Name))
+		return
+	}
+
+	joinedChannel.SetHidden(false)
+	server.saveChannelState(joinedChannel)
+	server.broadcastMessage(nil, joinedChannel, fmt.Sprintf("%s unhid the channel. It's listed in /channels again.", client.GetUsername()))
+}
+
+func invite(name string, args []string, client Session, server *Server) {
+	if len(args) < 1 {
+		client.SendServerMessage("Usage: /invite <username> [channel_name]")
+		return
+	}
+
+	joinedChannel := resolveJoinedChannel(args[1:], client)
+	if joinedChannel == nil {
+		return
+	}
+
+	targetUsername := args[0]
+	targetClient, exists := server.clients[targetUsername]
+	if !exists || !targetClient.IsRegistered() {
+		client.SendServerMessage(fmt.Sprintf("User '%s' not found or not registered.", targetUsername))
+		return
+	}
+
+	if _, isMember := joinedChannel.Member(targetUsername); isMember {
+		client.SendServerMessage(fmt.Sprintf("'%s' is already in '%s'.", targetUsername, joinedChannel.Name))
+		return
+	}
+
+	joinedChannel.Invite(targetUsername)
+	targetClient.SendServerMessage(fmt.Sprintf("%s invited you to join '%s'. Use /join %s to accept.", client.GetUsername(), joinedChannel.Name, joinedChannel.Name))
+	client.SendServerMessage(fmt.Sprintf("Invited '%s' to '%s'", targetUsername, joinedChannel.Name))
+}
+
+func op(name string, args []string, client Session, server *Server) {
+	if len(args) < 1 {
+		client.SendServerMessage(fmt.Sprintf("Usage: /%s <username> [channel_name]", name))
+		return
+	}
+
+	joinedChannel := resolveJoinedChannel(args[1:], client)
+	if joinedChannel == nil {
+		return
+	}
+
+	targetClient, isMember := joinedChannel.Member(args[0])
+	if !isMember {
+		client.SendServerMessage(fmt.Sprintf("'%s' is not in '%s'.", args[0], joinedChannel.Name))
+		return
+	}
+
+	// The same handler is used for /op and /deop
+	if name == "deop" {
+		joinedChannel.RemoveOperator(targetClient)
+		server.broadcastMessage(nil, joinedChannel, fmt.Sprintf("%s is no longer an operator.", args[0]))
+		return
+	}
+
+	joinedChannel.AddOperator(targetClient)
+	server.broadcastMessage(nil, joinedChannel, fmt.Sprintf("%s is now an operator.", args[0]))
+}
+
+// formatPins lists the channel's pinned messages numbered from 1
+func formatPins(channel *Channel) string {
+	lines := []string{fmt.Sprintf("Pinned messages in '%s':", channel.Name)}
+	for i, pin := range channel.Pins() {
+		lines = append(lines, fmt.Sprintf("%d. %s (pinned by %s on %s)", i+1, pin.Text, pin.PinnedBy, pin.PinnedAt.UTC().Format("Jan 2 15:04 UTC")))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func pin(name string, args []string, client Session, server *Server) {
+	if len(args) < 1 {
+		client.SendServerMessage("Usage: /pin <message>")
+		return
+	}
+
+	joinedChannel := resolveJoinedChannel(nil, client)
+	if joinedChannel == nil {
+		return
+	}
+
+	pinned, err := joinedChannel.AddPin(strings.Join(args, " "), client.GetUsername())
+	if err != nil {
+		client.SendServerMessage(fmt.Sprintf("Cannot pin more than %d messages. Use /unpin <n> to remove one first.", maxPins))
+		return
+	}
+	server.saveChannelState(joinedChannel)
+
+	server.broadcastMessage(nil, joinedChannel, fmt.Sprintf("%s pinned a message: %s", client.GetUsername(), pinned.Text))
+}
+
+func unpin(name string, args []string, client Session, server *Server) {
+	if len(args) < 1 {
+		client.SendServerMessage("Usage: /unpin <n>")
+		return
+	}
+
+	joinedChannel := resolveJoinedChannel(nil, client)
+	if joinedChannel == nil {
+		return
+	}
+
+	position, err := strconv.Atoi(args[0])
+	if err != nil {
+		client.SendServerMessage("Usage: /unpin <n>")
+		return
+	}
+
+	unpinned, err := joinedChannel.RemovePin(position)
+	if err != nil {
+		client.SendServerMessage(fmt.Sprintf("There is no pinned message number %d. Use /pins to list them.", position))
+		return
+	}
+	server.saveChannelState(joinedChannel)
+
+	server.broadcastMessage(nil, joinedChannel, fmt.Sprintf("%s unpinned a message: %s", client.GetUsername(), unpinned.Text))
+}
+
+// formatTopic formats the topic of the channel as shown by /topic and to new members
+func formatTopic(topic ChannelTopic) string {
+	if topic.Text == "" {
+		return "No topic set"
+	}
+	return fmt.Sprintf("Topic: %s (set by %s %s ago)", topic.Text, topic.SetBy, formatDuration(time.Since(topic.SetAt)))
+}
+
+// formatDuration formats a duration using its largest unit only, e.g. 3m or 2h
+func formatDuration(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	}
+}
+
+func topic(name string, args []string, client Session, server *Server) {
+	joinedChannel := resolveJoinedChannel(nil, client)
+	if joinedChannel == nil {
+		return
+	}
+
+	if len(args) == 0 {
+		client.SendMessage(formatChannelMessage("Server", joinedChannel.Name, formatTopic(joinedChannel.Topic())))
+		return
+	}
+
+	// Anyone can see the topic, but only the owner can change it
+	if roleIn(client, joinedChannel) < RoleOwner {
+		client.SendServerMessage("Only the channel owner can change the topic.")
+		return
+	}
+
+	text := strings.Join(args, " ")
+	if len(text) > maxTopicLength {
+		client.SendServerMessage(fmt.Sprintf("The topic cannot exceed %d characters.", maxTopicLength))
+		return
+	}
+
+	joinedChannel.SetTopic(text, client.GetUsername())
+	server.saveChannelState(joinedChannel)
+	server.broadcastMessage(nil, joinedChannel, fmt.Sprintf("%s changed the topic to: %s", client.GetUsername(), text))
+}
+
+// formatHint formats the password hint of the channel to be appended to a join error, empty if it has none
+func formatHint(channel *Channel) string {
+	if channel == nil || channel.PasswordHint() == "" {
+		return ""
+	}
+	return fmt.Sprintf(" Hint: %s.", channel.PasswordHint())
+}
+
+func setHint(name string, args []string, client Session, server *Server) {
+	if len(args) < 1 {
+		client.SendServerMessage("Usage: /sethint <text>")
+		return
+	}
+
+	joinedChannel := resolveJoinedChannel(nil, client)
+	if joinedChannel == nil {
+		return
+	}
+
+	if !joinedChannel.RequiresPassword() {
+		client.SendServerMessage(fmt.Sprintf("'%s' doesn't have a password to give a hint for.", joinedChannel.Name))
+		return
+	}
+
+	text := strings.Join(args, " ")
+	hint := joinedChannel.SetPasswordHint(text)
+	server.saveChannelState(joinedChannel)
+	if hint != text {
+		client.SendServerMessage(fmt.Sprintf("The hint was cut to %d characters.", maxHintLength))
+	}
+	client.SendServerMessage(fmt.Sprintf("Password hint of '%s' set to: %s", joinedChannel.Name, hint))
+}
+
+func removeHint(name string, args []string, client Session, server *Server) {
+	joinedChannel := resolveJoinedChannel(nil, client)
+	if joinedChannel == nil {
+		return
+	}
+
+	if joinedChannel.PasswordHint() == "" {
+		client.SendServerMessage(fmt.Sprintf("'%s' has no password hint.", joinedChannel.Name))
+		return
+	}
+
+	joinedChannel.SetPasswordHint("")
+	server.saveChannelState(joinedChannel)
+	client.SendServerMessage(fmt.Sprintf("Removed the password hint of '%s'.", joinedChannel.Name))
+}
+
+func clearTopic(name string, args []string, client Session, server *Server) {
+	joinedChannel := resolveJoinedChannel(nil, client)
+	if joinedChannel == nil {
+		return
+	}
+
+	if joinedChannel.Topic().Text == "" {
+		client.SendServerMessage(fmt.Sprintf("'%s' has no topic to clear.", joinedChannel.Name))
+		return
+	}
+
+	joinedChannel.SetTopic("", client.GetUsername())
+	server.saveChannelState(joinedChannel)
+	server.broadcastMessage(nil, joinedChannel, fmt.Sprintf("%s cleared the channel topic.", client.GetUsername()))
+}
+
+func listPins(name string, args []string, client Session, server *Server) {
+	joinedChannel := resolveJoinedChannel(args, client)
+	if joinedChannel == nil {
+		return
+	}
+
+	if !joinedChannel.HasPins() {
+		client.SendServerMessage(fmt.Sprintf("There are no pinned messages in '%s'.", joinedChannel.Name))
+		return
+	}
+
+	client.SendServerMessage(formatPins(joinedChannel))
+}
+
+func history(name string, args []string, client Session, server *Server) {
+	count := defaultHistoryLines
+	if len(args) > 0 {
+		parsed, err := strconv.Atoi(args[0])
+		if err != nil || parsed < 1 {
+			client.SendServerMessage("Usage: /history [count]")
+			return
+		}
+		count = min(parsed, historySize)
+	}
+
+	joinedChannel := resolveJoinedChannel(nil, client)
+	if joinedChannel == nil {
+		return
+	}
+
+	// Messages hidden from the client don't count towards the number asked for
+	entries := visibleTo(joinedChannel.History(historySize), client.GetUsername())
+	entries = entries[max(len(entries)-count, 0):]
+	if len(entries) == 0 {
+		client.SendServerMessage(fmt.Sprintf("There are no messages in the history of '%s'.", joinedChannel.Name))
+		return
+	}
+
+	// Replayed with their original time so clients show when they were sent
+	client.SendServerMessage(fmt.Sprintf("Last %d message(s) in '%s':", len(entries), joinedChannel.Name))
+	for _, entry := range entries {
+		// The rest would be dropped too
+		if err := client.SendMessageAt(formatChannelMessage(entry.Sender, joinedChannel.Name, entry.Content), entry.Time); err != nil {
+			return
+		}
+	}
+}
+
+// exportHistory sends the channel's messages as JSON lines: the whole message log if messages are persisted,
+// otherwise the history kept in memory. The log is read off the run loop, and the lines are streamed in chunks.
+func exportHistory(name string, args []string, client Session, server *Server) {
+	joinedChannel := resolveJoinedChannel(args, client)
+	if joinedChannel == nil {
+		return
+	}
+
+	channelName, username := joinedChannel.Name, client.GetUsername()
+	entries := joinedChannel.History(historySize)
+	messageLog := server.messageLog
+	server.runOffLoop(func() func() {
+		var err error
+		if messageLog != nil {
+			// Appended lines may be read partially, which are skipped
+			entries, err = messageLog.ReadAll(channelName)
+		}
+		var chunks []string
+		if err == nil {
+			chunks, err = exportChunks(visibleTo(entries, username))
+		}
+
+		return func() {
+			if !server.hasSession(client) {
+				return
+			}
+			if err != nil {
+				server.log.storage.Error("Failed to export messages", "channel", channelName, "error", err)
+				client.SendServerMessage("Failed to export the channel's messages.")
+				return
+			}
+			if len(chunks) == 0 {
+				client.SendServerMessage(fmt.Sprintf("There are no messages to export in '%s'.", channelName))
+				return
+			}
+			client.SendStream(chunks)
+		}
+	})
+}
+
+// exportChunks formats the entries as JSON lines, grouped into as many server messages as fit in a stream
+func exportChunks(entries []HistoryEntry) ([]string, error) {
+	perChunk := max(exportLinesPerChunk, (len(entries)+maxStreamChunks-1)/maxStreamChunks)
+
+	var chunks []string
+	for batch := range slices.Chunk(entries, perChunk) {
+		var export strings.Builder
+		for _, entry := range batch {
+			entry.VisibleTo = "" // Whoever sees the message can't tell it was hidden from the others
+			line, err := json.Marshal(entry)
+			if err != nil {
+				return nil, err
+			}
+			export.Write(line)
+			export.WriteByte('\n')
+		}
+		chunks = append(chunks, formatMessage("Server", strings.TrimSuffix(export.String(), "\n")))
+	}
+	return chunks, nil
+}
+
+func channelInfo(name string, args []string, client Session, server *Server) {
+	channel := client.GetChannel()
+	if len(args) > 0 {
+		if channel = visibleChannel(strings.TrimPrefix(args[0], "#"), "see its details", client, server); channel == nil {
+			return
+		}
+	}
+
+	if channel == nil {
+		client.SendServerMessage(fmt.Sprintf("Usage: /%s [channel_name]", name))
+		return
+	}
+	client.SendServerMessage(formatChannelInfo(channel, client))
+}
+
+// visibleChannel looks up a channel the client asked about by name, telling them why they can't see it if that's the case.
+// Hidden channels aren't found by anyone outside them and password protected channels are private to their members.
+func visibleChannel(channelName string, action string, client Session, server *Server) *Channel {
+	channel, exists := server.channels[channelName]
+	member := client.GetJoinedChannel(channelName) != nil
+	if !exists || (channel.IsHidden() && !member) {
+		client.SendServerMessage(fmt.Sprintf("Channel '%s' not found.", channelName))
+		return nil
+	}
+	if channel.RequiresPassword() && !member {
+		client.SendServerMessage(fmt.Sprintf("Only members of '%s' can %s.", channelName, action))
+		return nil
+	}
+	return channel
+}
+
+func channelAge(name string, args []string, client Session, server *Server) {
+	channel := client.GetChannel()
+	if len(args) > 0 {
+		if channel = visibleChannel(strings.TrimPrefix(args[0], "#"), "see when it was created", client, server); channel == nil {
+			return
+		}
+	}
+
+	if channel == nil {
+		client.SendServerMessage("Usage: /channelage [channel_name]")
+		return
+	}
+
+	createdAt := channel.CreatedAt()
+	client.SendServerMessage(fmt.Sprintf("Channel #%s was created %s ago (%s).", channel.Name, formatDuration(time.Since(createdAt)), createdAt.UTC().Format(time.RFC3339)))
+}
+
+// formatChannelInfo describes the channel for /whois, /channel and /channelinfo
+func formatChannelInfo(channel *Channel, client Session) string {
+	createdAt, topic := channel.CreatedAt(), channel.Topic()
+	info := []string{
+		fmt.Sprintf("Channel #%s", channel.Name),
+		formatTopic(topic),
+		fmt.Sprintf("Created: %s ago (%s)", formatDuration(time.Since(createdAt)), createdAt.UTC().Format(time.RFC3339)),
+		fmt.Sprintf("Created by: %s", channel.CreatedBy()),
+		fmt.Sprintf("Members: %d", channel.MemberCount()),
+		fmt.Sprintf("Owner: %s", channel.Owner()),
+		fmt.Sprintf("Operators: %s", strings.Join(channel.Operators(), ", ")),
+		fmt.Sprintf("Password protected: %t", channel.RequiresPassword()),
+		fmt.Sprintf("Locked: %t", channel.IsLocked()),
+		fmt.Sprintf("No-spam mode: %t", channel.IsSpamLocked()),
+		fmt.Sprintf("Formatting: %s", channel.FormattingMode()),
+	}
+	if topic.Text != "" {
+		info = slices.Insert(info, 2, fmt.Sprintf("Topic set by: %s at %s", topic.SetBy, topic.SetAt.UTC().Format(time.RFC3339)))
+	}
+
+	// Only members are told whether the channel is hidden
+	if client.GetJoinedChannel(channel.Name) != nil {
+		info = append(info, fmt.Sprintf("Hidden: %t", channel.IsHidden()))
+	}
+	if channel.Color() != "" {
+		info = append(info, fmt.Sprintf("Color: %s", channel.Color()))
+	}
+	return strings.Join(info, "\n")
+}
+
+func whois(name string, args []string, client Session, server *Server) {
+	if len(args) < 1 {
+		client.SendServerMessage("Usage: /whois <username|#channel>")
+		return
+	}
+
+	// Channels are looked up with a leading '#'
+	if channelName, ok := strings.CutPrefix(args[0], "#"); ok {
+		if channel := visibleChannel(channelName, "see its details", client, server); channel != nil {
+			client.SendServerMessage(formatChannelInfo(channel, client))
+		}
+		return
+	}
+
+	targetClient, exists := server.clients[args[0]]
+	if !exists || !targetClient.IsRegistered() {
+		client.SendServerMessage(fmt.Sprintf("User '%s' not found or not registered.", args[0]))
+		return
+	}
+
+	var channelNames []string
+	for _, channel := range targetClient.GetChannels() {
+		entry := "#" + channel.Name
+		if channel.IsOperator(targetClient) {
+			entry += " (op)"
+		}
+		channelNames = append(channelNames, entry)
+	}
+
+	// Users who logged in as their registered username are marked as verified
+	user := targetClient.GetUsername()
+	if server.isLoggedIn(targetClient) {
+		user = "[✓] " + user
+	}
+
+	info := []string{
+		fmt.Sprintf("User %s", user),
+		fmt.Sprintf("Channels: %s", strings.Join(channelNames, ", ")),
+		fmt.Sprintf("Admin: %t", targetClient.IsAdmin()),
+	}
+	if awayMessage, _ := targetClient.Away(); awayMessage != "" {
+		info = append(info, fmt.Sprintf("Away: %s", awayMessage))
+	}
+	if client.IsAdmin() {
+		info = append(info, fmt.Sprintf("ID: %s", targetClient.ID()))
+		info = append(info, fmt.Sprintf("Shadow muted: %t", server.isShadowMuted(targetClient)))
+		traffic := targetClient.session(time.Now())
+		info = append(info, fmt.Sprintf("Traffic: %s read, %s written", formatBytes(traffic.BytesRead), formatBytes(traffic.BytesWritten)))
+	}
+	client.SendServerMessage(strings.Join(info, "\n"))
+}
+
+func quit(name string, args []string, client Session, server *Server) {
+	server.disconnectClient(client, "Goodbye!")
+}
+
+func help(name string, args []string, client Session, server *Server) {
+	helpText := `Available commands:
+/join <channel_name> [password|invite_code] - Join or create a channel and make it your active channel
+/leave [channel_name] - Leave a channel (defaults to your active channel)
+/switch <channel_name> - Send your messages to another channel you have joined
+#<channel_name> <message> - Send a single message to another channel you have joined
+/clients - Get the number of connected clients
+/members [channel_name] - List members in a channel (defaults to your active channel)
+/channels [--empty] [--created-before <duration|YYYY-MM-DD>] - List all available channels, optionally only empty or older ones
+/top - List the five most active channels of the last 15 minutes
+/name <new_username> - Change your username (also /nick)
+/whisper <username> <message> - Send a private message to a user
+/whisper <user1,user2,@group...> <message> - Send the same private message to several users
+/group [name] [user1,user2,...] - Define a group to whisper with /whisper @name, or show your groups
+/ignorechannel <channel_name> - Stop receiving messages from a channel without leaving it
+/unignorechannel <channel_name> - Receive messages from an ignored channel again
+/ignoredchannels - List the channels you are ignoring
+/whois <username|#channel> - Show information about a user or channel
+/channel [channel_name] - Show information about a channel, your active one by default, including when and by whom it was created
+/channelinfo [channel_name] - Same as /channel
+/channelage [channel_name] - Show how long ago a channel was created, your active one by default
+/topic [text] - Show the topic of your active channel, or change it if you are its owner
+/pins [channel_name] - List the pinned messages of a channel
+/history [count] - Show the last messages sent to your active channel (20 by default, up to 100)
+/export [channel_name] - Get the messages of a channel as JSON lines, the full log if the server persists messages
+/channelcount - Show how many channels there are out of the server's limit
+/stats - Show server statistics, along with runtime stats for admins
+/version - Show the server's protocol version and features
+/motd - Show the server's message of the day
+/goroutines [lines] - Show the server's goroutine stacks, 20 lines by default (debug servers or localhost only)
+/echo <text> - Send the text back to you along with how long the server took to handle it
+/echo-delay <ms> <text> - Like /echo, but after a delay of up to 5000 ms
+/seticon <emoji> - Show an icon before your name in messages
+/clearicon - Remove your icon
+/away [message] - Mark yourself as away with a message, or come back without one
+/set [echo <on|off>] - Show your settings, or turn off receiving copies of your own messages relayed by others (for bridge bots)
+/register <password> - Protect your username with a password
+/ghost <username> <password> - Disconnect the session using your registered username and take it back
+/login <password> - Log in as your registered username, e.g. after reconnecting (only with -auth-file)
+/rejoin - Join the channels you were in during your last session, once logged in
+/help - Show this help message
+/quit - Leave the server
+/admin <password> - Gain admin privileges
+
+Channel operator commands (default to your active channel):
+/lock [channel_name] - Only allow invited users to join
+/unlock [channel_name] - Allow anyone to join again
+/hidechannel [channel_name] - Leave the channel out of /channels for non-members, it can still be joined by name
+/unhidechannel [channel_name] - List the channel in /channels again
+/invite <username> [channel_name] - Invite a user, even past the lock
+/op <username> [channel_name] - Make a member an operator
+/deop <username> [channel_name] - Remove a member's operator status
+/inspect <username> [channel_name] - Show when a member joined, their messages in the channel, mute and recent rate limits
+/setchannelcolor <0-255|#rrggbb|none> - Set the color of your active channel's name in /channels
+/nospam [on|off] [--duration <duration>] - Only let operators send messages to your active channel for a while (default 10m)
+/invitecode [uses] [ttl] - Create a code to join your password protected active channel without its password (default 1 use, 24h)
+/invitecodes - List the invite codes of your active channel
+/revokecode <code> - Revoke an invite code of your active channel
+/pin <message> - Pin a message to your active channel
+/unpin <n> - Remove a pinned message from your active channel
+/topic-clear - Clear the topic of your active channel
+/sethint <text> - Set a hint shown to users who try to join your active channel without the right password
+/removehint - Remove the password hint of your active channel
+/formatting <full|basic|none> - Choose which formatting is applied to the messages of your active channel
+
+Admin commands:
+/setdefaultchannel <channel_name> - Set the channel new users are auto-joined to
+/say [#channel] <message> - Send a message as yourself to your active channel or any other channel
+/purge-empty [--older-than <duration>] - Delete empty channels, optionally only those empty for longer than the duration
+/shuffle [--dry-run|--confirm] - Move everyone from their active channel to a random other channel
+/announce [--channels] <message> - Send an announcement to everyone, or to every channel separately with --channels
+/masswhisper <user1,user2,...> <message> - Whisper the same message to up to 20 users
+/banip <ip> [duration] - Ban an IP address, permanently or for the duration, and disconnect its clients
+/unbanip <ip> - Lift the ban on an IP address
+/reloadbans - Reload the ban file without restarting the server
+/shadowmute <username> - Silently hide a user's messages from everyone but themselves
+/unshadowmute <username> - Stop hiding a user's messages
+/exportusers [--include-temp] - Export the connected users as JSON, including unregistered ones with --include-temp
+/slowclients - List the clients falling behind on reading their messages
+/sessions [--sort duration|traffic] - List the active sessions with their duration, messages and bytes
+/bridge [username] - Allow a client to relay messages of another chat system, or list the bridges
+/unbridge <username> - Stop a client from relaying messages
+/loadplugin <path> - Load a command plugin
+/unloadplugin <name> - Remove a plugin's command
+
+Note: Arguments in <> are required, arguments in [] are optional.
+`
+
+	client.SendServerMessage(helpText)
+}
+
+func (s *Server) loadCommands() {
+	s.commands["join"] = CommandSpec{Handler: joinChannel}
+	s.commands["leave"] = CommandSpec{Handler: leaveChannel}
+	s.commands["switch"] = CommandSpec{Handler: switchChannel}
+	s.commands["clients"] = CommandSpec{Handler: connectedClients, Cooldown: 5 * time.Second}
+	s.commands["members"] = CommandSpec{Handler: channelMembers, Cooldown: 2 * time.Second}
+	s.commands["channels"] = CommandSpec{Handler: listChannels, Cooldown: 5 * time.Second}
+	s.commands["top"] = CommandSpec{Handler: topChannels, Cooldown: 5 * time.Second}
+	s.commands["name"] = CommandSpec{Handler: changeName}
+	s.commands["nick"] = CommandSpec{Handler: changeName}
+	s.commands["whisper"] = CommandSpec{Handler: whisper}
+	s.commands["group"] = CommandSpec{Handler: group}
+	s.commands["ignorechannel"] = CommandSpec{Handler: ignoreChannel}
+	s.commands["unignorechannel"] = CommandSpec{Handler: ignoreChannel}
+	s.commands["ignoredchannels"] = CommandSpec{Handler: ignoredChannels}
+	s.commands["away"] = CommandSpec{Handler: away}
+	s.commands["set"] = CommandSpec{Handler: set}
+	s.commands["register"] = CommandSpec{Handler: registerNick}
+	s.commands["ghost"] = CommandSpec{Handler: ghost}
+	s.commands["login"] = CommandSpec{Handler: login}
+	s.commands["rejoin"] = CommandSpec{Handler: rejoin}
+	s.commands["help"] = CommandSpec{Handler: help}
+	s.commands["quit"] = CommandSpec{Handler: quit}
+	s.commands["whois"] = CommandSpec{Handler: whois}
+	s.commands["channel"] = CommandSpec{Handler: channelInfo}
+	s.commands["channelinfo"] = CommandSpec{Handler: channelInfo}
+	s.commands["channelage"] = CommandSpec{Handler: channelAge}
+	s.commands["pins"] = CommandSpec{Handler: listPins}
+	s.commands["history"] = CommandSpec{Handler: history, Cooldown: 2 * time.Second}
+	s.commands["export"] = CommandSpec{Handler: exportHistory, Cooldown: 30 * time.Second}
+	s.commands["topic"] = CommandSpec{Handler: topic}
+	s.commands["stats"] = CommandSpec{Handler: stats}
+	s.commands["version"] = CommandSpec{Handler: version}
+	s.commands["motd"] = CommandSpec{Handler: motd}
+	s.commands["goroutines"] = CommandSpec{Handler: goroutines}
+	s.commands["echo"] = CommandSpec{Handler: echo}
+	s.commands["echo-delay"] = CommandSpec{Handler: echoDelay}
+	s.commands["channelcount"] = CommandSpec{Handler: channelCount}
+	s.commands["seticon"] = CommandSpec{Handler: setIcon}
+	s.commands["clearicon"] = CommandSpec{Handler: clearIcon}
+	s.commands["admin"] = CommandSpec{Handler: admin}
+
+	// Channel operator commands
+	s.commands["lock"] = CommandSpec{Handler: lockChannel, Role: RoleOperator, Channel: channelArg(0)}
+	s.commands["unlock"] = CommandSpec{Handler: unlockChannel, Role: RoleOperator, Channel: channelArg(0)}
+	s.commands["hidechannel"] = CommandSpec{Handler: hideChannel, Role: RoleOperator, Channel: channelArg(0)}
+	s.commands["unhidechannel"] = CommandSpec{Handler: unhideChannel, Role: RoleOperator, Channel: channelArg(0)}
+	s.commands["invite"] = CommandSpec{Handler: invite, Role: RoleOperator, Channel: channelArg(1)}
+	s.commands["op"] = CommandSpec{Handler: op, Role: RoleOperator, Channel: channelArg(1)}
+	s.commands["deop"] = CommandSpec{Handler: op, Role: RoleOperator, Channel: channelArg(1)}
+	s.commands["inspect"] = CommandSpec{Handler: inspect, Role: RoleOperator, Channel: channelArg(1)}
+	s.commands["setchannelcolor"] = CommandSpec{Handler: setChannelColor, Role: RoleOperator, Channel: activeChannel}
+	s.commands["nospam"] = CommandSpec{Handler: noSpam, Role: RoleOperator, Channel: activeChannel}
+	s.commands["invitecode"] = CommandSpec{Handler: createInviteCode, Role: RoleOperator, Channel: activeChannel}
+	s.commands["invitecodes"] = CommandSpec{Handler: listInviteCodes, Role: RoleOperator, Channel: activeChannel}
+	s.commands["revokecode"] = CommandSpec{Handler: revokeInviteCode, Role: RoleOperator, Channel: activeChannel}
+	s.commands["pin"] = CommandSpec{Handler: pin, Role: RoleOperator, Channel: activeChannel}
+	s.commands["unpin"] = CommandSpec{Handler: unpin, Role: RoleOperator, Channel: activeChannel}
+	s.commands["topic-clear"] = CommandSpec{Handler: clearTopic, Role: RoleOperator, Channel: activeChannel}
+	s.commands["sethint"] = CommandSpec{Handler: setHint, Role: RoleOperator, Channel: activeChannel}
+	s.commands["formatting"] = CommandSpec{Handler: formatting, Role: RoleOperator, Channel: activeChannel}
+	s.commands["removehint"] = CommandSpec{Handler: removeHint, Role: RoleOperator, Channel: activeChannel}
+
+	// Admin commands
+	s.commands["setdefaultchannel"] = CommandSpec{Handler: setDefaultChannel, Role: RoleAdmin}
+	s.commands["say"] = CommandSpec{Handler: say, Role: RoleAdmin}
+	s.commands["announce"] = CommandSpec{Handler: announce, Role: RoleAdmin}
+	s.commands["masswhisper"] = CommandSpec{Handler: massWhisper, Role: RoleAdmin}
+	s.commands["banip"] = CommandSpec{Handler: banIP, Role: RoleAdmin}
+	s.commands["unbanip"] = CommandSpec{Handler: unbanIP, Role: RoleAdmin}
+	s.commands["reloadbans"] = CommandSpec{Handler: reloadBans, Role: RoleAdmin}
+	s.commands["shadowmute"] = CommandSpec{Handler: shadowMute, Role: RoleAdmin}
+	s.commands["unshadowmute"] = CommandSpec{Handler: shadowMute, Role: RoleAdmin}
+	s.commands["exportusers"] = CommandSpec{Handler: exportUsers, Role: RoleAdmin}
+	s.commands["slowclients"] = CommandSpec{Handler: listSlowClients, Role: RoleAdmin}
+	s.commands["sessions"] = CommandSpec{Handler: sessions, Role: RoleAdmin}
+	s.commands["bridge"] = CommandSpec{Handler: bridge, Role: RoleAdmin}
+	s.commands["unbridge"] = CommandSpec{Handler: bridge, Role: RoleAdmin}
+	s.commands["purge-empty"] = CommandSpec{Handler: purgeEmpty, Role: RoleAdmin}
+	s.commands["shuffle"] = CommandSpec{Handler: shuffle, Role: RoleAdmin}
+	s.commands["loadplugin"] = CommandSpec{Handler: loadPlugin, Role: RoleAdmin}
+	s.commands["unloadplugin"] = CommandSpec{Handler: unloadPlugin, Role: RoleAdmin}
+
+	for name, cooldown := range s.config.CommandCooldowns {
+		spec, exists := s.commands[name]
+		if !exists {
+			s.log.command.Warn("Ignoring cooldown of unknown command", "command", name)
+			continue
+		}
+
+		spec.Cooldown = cooldown
+		s.commands[name] = spec
+	}
 }
@@ -0,0 +1,1431 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// commandTest runs a command as alice, a registered fake session, on a fresh server
+type commandTest struct {
+	name      string
+	command   string
+	args      []string
+	configure func(config *Config)
+	setup     func(t *testing.T, server *Server, alice *fakeSession)
+	want      string // Text of a frame sent to alice or of a message queued for broadcast by the command
+	check     func(t *testing.T, server *Server, alice *fakeSession)
+}
+
+func runCommandTests(t *testing.T, tests []commandTest) {
+	t.Helper()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, test.configure)
+			alice := connect(server, "alice")
+			if test.setup != nil {
+				test.setup(t, server, alice)
+			}
+
+			// Only what the command itself sends is looked at
+			alice.sent = nil
+			drainBroadcasts(server)
+
+			server.runCommand(Command{Name: test.command, Args: test.args, Client: alice, ReceivedAt: time.Now()})
+			finishWork(t, server)
+
+			broadcasts := drainBroadcasts(server)
+			if test.want != "" && !alice.received(test.want) && !slices.ContainsFunc(broadcasts, func(msg string) bool {
+				return strings.Contains(msg, test.want)
+			}) {
+				t.Errorf("/%s %s: want %q, got sent %q and broadcasts %q", test.command, strings.Join(test.args, " "), test.want, alice.sent, broadcasts)
+			}
+			if test.check != nil {
+				test.check(t, server, alice)
+			}
+		})
+	}
+}
+
+// joined joins alice to the channels, creating the ones that don't exist, the last one ending up active
+func joined(channelNames ...string) func(t *testing.T, server *Server, alice *fakeSession) {
+	return func(t *testing.T, server *Server, alice *fakeSession) {
+		for _, channelName := range channelNames {
+			join(t, server, alice, channelName)
+		}
+	}
+}
+
+// memberOf joins alice to a channel bob created, so she isn't one of its operators
+func memberOf(channelName string) func(t *testing.T, server *Server, alice *fakeSession) {
+	return func(t *testing.T, server *Server, alice *fakeSession) {
+		join(t, server, connect(server, "bob"), channelName)
+		join(t, server, alice, channelName)
+	}
+}
+
+// asAdmin runs the setup, if any, with alice as an admin
+func asAdmin(setup func(t *testing.T, server *Server, alice *fakeSession)) func(t *testing.T, server *Server, alice *fakeSession) {
+	return func(t *testing.T, server *Server, alice *fakeSession) {
+		alice.SetAdmin(true)
+		if setup != nil {
+			setup(t, server, alice)
+		}
+	}
+}
+
+func TestUnknownCommandAndCooldown(t *testing.T) {
+	runCommandTests(t, []commandTest{
+		{name: "unknown", command: "nope", want: "Unknown command"},
+		{
+			name:    "cooldown",
+			command: "clients",
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				server.runCommand(Command{Name: "clients", Client: alice})
+			},
+			want: "Please wait 5s before using /clients again.",
+		},
+		{
+			name:    "cooldown almost over",
+			command: "clients",
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				alice.State().commandLastUsed = map[string]time.Time{"clients": time.Now().Add(-server.commands["clients"].Cooldown + 300*time.Millisecond)}
+			},
+			want: "Please wait 1s before using /clients again.",
+		},
+		{
+			name:    "admins skip cooldowns",
+			command: "clients",
+			setup: asAdmin(func(t *testing.T, server *Server, alice *fakeSession) {
+				server.runCommand(Command{Name: "clients", Client: alice})
+			}),
+			want: "Connected clients (1)",
+		},
+	})
+}
+
+func TestChannelCommands(t *testing.T) {
+	runCommandTests(t, []commandTest{
+		{name: "join usage", command: "join", want: "Usage: /join"},
+		{name: "join password too long", command: "join", args: []string{"general", strings.Repeat("p", 33)}, want: "Password is too long"},
+		{
+			name:    "join flood",
+			command: "join",
+			args:    []string{"general"},
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				alice.denied["join"] = true
+			},
+			want: "You are joining channels too quickly. Please wait 30s.",
+		},
+		{
+			name:      "join channel limit",
+			command:   "join",
+			args:      []string{"random"},
+			configure: func(config *Config) { config.MaxChannels = 1 },
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				join(t, server, connect(server, "bob"), "general")
+			},
+			want: "Server channel limit reached",
+		},
+		{
+			name:    "join creation rate",
+			command: "join",
+			args:    []string{"general"},
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				alice.denied["create"] = true
+			},
+			want: "You are creating channels too quickly",
+		},
+		{
+			name:    "join password required",
+			command: "join",
+			args:    []string{"secret"},
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				bob := connect(server, "bob")
+				if _, err := server.enterChannel(bob, "secret", "hunter2"); err != nil {
+					t.Fatal(err)
+				}
+				server.channels["secret"].SetPasswordHint("a classic")
+			},
+			want: "Channel 'secret' requires a password. Hint: a classic.",
+		},
+		{
+			name:    "join incorrect password",
+			command: "join",
+			args:    []string{"secret", "wrong"},
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				if _, err := server.enterChannel(connect(server, "bob"), "secret", "hunter2"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: "Incorrect password for channel 'secret'.",
+		},
+		{
+			name:    "join locked",
+			command: "join",
+			args:    []string{"general"},
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				join(t, server, connect(server, "bob"), "general").SetLocked(true)
+			},
+			want: "Cannot join 'general': channel is locked.",
+		},
+		{
+			name:    "join used up invite code",
+			command: "join",
+			args:    []string{"secret", "CODE"},
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				if _, err := server.enterChannel(connect(server, "bob"), "secret", "hunter2"); err != nil {
+					t.Fatal(err)
+				}
+				inviteCode, err := server.channels["secret"].CreateInviteCode(1, time.Hour, "bob")
+				if err != nil {
+					t.Fatal(err)
+				}
+				server.channels["secret"].UseInviteCode(inviteCode.Code)
+				server.channels["secret"].codes["CODE"] = inviteCode
+			},
+			want: "has expired or has no uses left",
+		},
+		{
+			name:    "join",
+			command: "join",
+			args:    []string{"general"},
+			want:    "You have joined channel 'general'",
+			check: func(t *testing.T, server *Server, alice *fakeSession) {
+				if alice.GetChannel() == nil || alice.GetChannel().Name != "general" {
+					t.Errorf("active channel is %v, want general", alice.GetChannel())
+				}
+				if !server.channels["general"].IsOwner(alice) {
+					t.Error("the creator of the channel isn't its owner")
+				}
+			},
+		},
+		{
+			name:    "join with password",
+			command: "join",
+			args:    []string{"secret", "hunter2"},
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				if _, err := server.enterChannel(connect(server, "bob"), "secret", "hunter2"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: "You have joined channel 'secret'",
+		},
+
+		{name: "leave without channel", command: "leave", want: "You are not in any channel."},
+		{name: "leave unknown channel", command: "leave", args: []string{"random"}, setup: joined("general"), want: "You are not in channel 'random'."},
+		{
+			name:    "leave",
+			command: "leave",
+			setup:   joined("general", "random"),
+			want:    "Your active channel is now 'general'",
+			check: func(t *testing.T, server *Server, alice *fakeSession) {
+				if !alice.received("You have left channel 'random'") {
+					t.Errorf("alice wasn't told she left: %q", alice.sent)
+				}
+				if _, exists := server.channels["random"]; exists {
+					t.Error("the empty channel wasn't deleted")
+				}
+			},
+		},
+
+		{name: "switch usage", command: "switch", want: "Usage: /switch"},
+		{name: "switch not joined", command: "switch", args: []string{"random"}, setup: joined("general"), want: "You are not in channel 'random'."},
+		{name: "switch", command: "switch", args: []string{"general"}, setup: joined("general", "random"), want: "Your messages will now be sent to 'general'"},
+
+		{name: "members without channel", command: "members", want: "You are not in any channel."},
+		{name: "members", command: "members", setup: memberOf("general"), want: "Members in channel 'general': \nalice, bob"},
+
+		{name: "channels bad flag", command: "channels", args: []string{"--nope"}, want: "Usage: /channels"},
+		{name: "channels missing date", command: "channels", args: []string{"--created-before"}, want: "Usage: /channels"},
+		{name: "channels bad date", command: "channels", args: []string{"--created-before", "soon"}, want: "Invalid date 'soon'"},
+		{name: "channels none", command: "channels", want: "No channels available."},
+		{name: "channels", command: "channels", setup: joined("general"), want: "Available channels: \ngeneral (1)"},
+		{
+			name:    "channels hides hidden channels",
+			command: "channels",
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				join(t, server, connect(server, "bob"), "secret").SetHidden(true)
+			},
+			want: "No channels available.",
+		},
+		{name: "channels as event", command: "channels", args: []string{"--event"}, setup: joined("general"), want: `system|channel-list|[{"name":"general","members":1,"joined":true}]`},
+		{name: "channels empty event", command: "channels", args: []string{"--event"}, want: "system|channel-list|[]"},
+
+		{name: "top without messages", command: "top", setup: joined("general"), want: "No messages were sent to any channel"},
+		{
+			name:    "top",
+			command: "top",
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				join(t, server, alice, "general").RecordActivity("alice", time.Now())
+			},
+			want: "1. general: 1 message(s) from 1 speaker(s), 1 member(s)",
+		},
+
+		{name: "channelcount", command: "channelcount", setup: joined("general"), want: "Channels: 1/unlimited"},
+		{name: "channelcount with limit", command: "channelcount", configure: func(config *Config) { config.MaxChannels = 10 }, want: "Channels: 0/10"},
+	})
+}
+
+func TestUserCommands(t *testing.T) {
+	runCommandTests(t, []commandTest{
+		{name: "clients", command: "clients", want: "Connected clients (1)"},
+
+		{name: "name usage", command: "nick", want: "Usage: /nick <new_username>"},
+		{
+			name:    "name cooldown",
+			command: "name",
+			args:    []string{"carol"},
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				alice.lastNameChange = time.Now()
+			},
+			want: "You must wait 60 more seconds",
+		},
+		{name: "name reserved", command: "name", args: []string{"Server"}, want: "Failed to change username: 'Server' is reserved"},
+		{
+			name:    "name taken",
+			command: "name",
+			args:    []string{"bob"},
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				connect(server, "bob")
+			},
+			want: "'bob' is already taken",
+		},
+		{
+			name:    "name",
+			command: "name",
+			args:    []string{"carol"},
+			setup:   joined("general"),
+			want:    "Your username has been changed to 'carol'",
+			check: func(t *testing.T, server *Server, alice *fakeSession) {
+				if server.clients["carol"] != Session(alice) {
+					t.Error("the client isn't known by its new name")
+				}
+				if _, isMember := server.channels["general"].Member("carol"); !isMember {
+					t.Error("the channel member wasn't renamed")
+				}
+			},
+		},
+
+		{name: "away usage", command: "away", want: "Usage: /away"},
+		{name: "away", command: "away", args: []string{"out", "for", "lunch"}, want: "You are now away"},
+		{
+			name:    "away back",
+			command: "away",
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				alice.SetAway("lunch", false)
+			},
+			want: "You are no longer away.",
+		},
+
+		{name: "seticon usage", command: "seticon", want: "Usage: /seticon"},
+		{name: "seticon ascii", command: "seticon", args: []string{"a"}, want: "Invalid icon: the icon cannot be an ASCII character"},
+		{name: "seticon several", command: "seticon", args: []string{"🔥🔥"}, want: "Invalid icon: the icon must be a single character or emoji"},
+		{name: "seticon", command: "seticon", args: []string{"🔥"}, want: "Your messages will now be shown as '🔥 alice'"},
+		{name: "clearicon without icon", command: "clearicon", want: "You don't have an icon set."},
+		{
+			name:    "clearicon",
+			command: "clearicon",
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				alice.SetDisplayIcon("🔥")
+			},
+			want: "Your icon has been removed.",
+		},
+
+		{name: "set", command: "set", want: "Settings:\necho: on"},
+		{name: "set usage", command: "set", args: []string{"echo", "maybe"}, want: "Usage: /set echo <on|off>"},
+		{
+			name:    "set echo off",
+			command: "set",
+			args:    []string{"echo", "off"},
+			want:    "Echo is off.",
+			check: func(t *testing.T, server *Server, alice *fakeSession) {
+				if !alice.echoOff {
+					t.Error("echo is still on")
+				}
+			},
+		},
+		{
+			name:    "set echo on",
+			command: "set",
+			args:    []string{"echo", "on"},
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				alice.echoOff = true
+			},
+			want: "Echo is on.",
+		},
+
+		{name: "ignorechannel usage", command: "ignorechannel", want: "Usage: /ignorechannel"},
+		{name: "ignorechannel not joined", command: "ignorechannel", args: []string{"general"}, want: "You are not in channel 'general'."},
+		{
+			name:    "ignorechannel",
+			command: "ignorechannel",
+			args:    []string{"general"},
+			setup:   joined("general"),
+			want:    "You will no longer receive messages from channel 'general'",
+			check: func(t *testing.T, server *Server, alice *fakeSession) {
+				if _, ignored := alice.ignoredChannels["general"]; !ignored {
+					t.Error("the channel isn't ignored")
+				}
+			},
+		},
+		{
+			name:    "ignorechannel twice",
+			command: "ignorechannel",
+			args:    []string{"general"},
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				join(t, server, alice, "general")
+				alice.ignoredChannels["general"] = struct{}{}
+			},
+			want: "You are already ignoring channel 'general'.",
+		},
+		{name: "unignorechannel not ignored", command: "unignorechannel", args: []string{"general"}, setup: joined("general"), want: "You are not ignoring channel 'general'."},
+		{
+			name:    "unignorechannel",
+			command: "unignorechannel",
+			args:    []string{"general"},
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				join(t, server, alice, "general")
+				alice.ignoredChannels["general"] = struct{}{}
+			},
+			want: "You will receive messages from channel 'general' again.",
+		},
+		{name: "ignoredchannels none", command: "ignoredchannels", want: "You are not ignoring any channels."},
+		{
+			name:    "ignoredchannels",
+			command: "ignoredchannels",
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				alice.ignoredChannels["random"] = struct{}{}
+				alice.ignoredChannels["general"] = struct{}{}
+			},
+			want: "Ignored channels: general, random",
+		},
+
+		{name: "whois usage", command: "whois", want: "Usage: /whois"},
+		{name: "whois unknown user", command: "whois", args: []string{"bob"}, want: "User 'bob' not found or not registered."},
+		{name: "whois unknown channel", command: "whois", args: []string{"#general"}, want: "Channel 'general' not found."},
+		{name: "whois channel", command: "whois", args: []string{"#general"}, setup: joined("general"), want: "Channel #general"},
+		{
+			name:    "whois hidden channel",
+			command: "whois",
+			args:    []string{"#secret"},
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				join(t, server, connect(server, "bob"), "secret").SetHidden(true)
+			},
+			want: "Channel 'secret' not found.",
+		},
+		{
+			name:    "whois password channel",
+			command: "whois",
+			args:    []string{"#secret"},
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				if _, err := server.enterChannel(connect(server, "bob"), "secret", "hunter2"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: "Only members of 'secret' can see its details.",
+		},
+		{name: "whois", command: "whois", args: []string{"bob"}, setup: memberOf("general"), want: "User bob\nChannels: #general (op)\nAdmin: false"},
+		{name: "whois as admin", command: "whois", args: []string{"bob"}, setup: asAdmin(memberOf("general")), want: "Shadow muted: false"},
+
+		{name: "help", command: "help", want: "Available commands:"},
+		{name: "version", command: "version", want: "Protocol version"},
+		{name: "stats", command: "stats", want: "Server stats:\nConnected clients: 1"},
+		{name: "stats as admin", command: "stats", setup: asAdmin(nil), want: "Goroutines"},
+
+		{name: "motd none", command: "motd", want: "This server has no message of the day."},
+		{
+			name:    "motd",
+			command: "motd",
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				server.motd = "Be nice"
+			},
+			want: "system|motd|Be nice",
+		},
+
+		{name: "goroutines remotely", command: "goroutines", want: "/goroutines is only available"},
+		{name: "goroutines usage", command: "goroutines", args: []string{"0"}, configure: func(config *Config) { config.Debug = true }, want: "Usage: /goroutines"},
+		{
+			name:    "goroutines from localhost",
+			command: "goroutines",
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				alice.ip = "127.0.0.1:50000"
+			},
+			want: "goroutine ",
+		},
+
+		{name: "echo usage", command: "echo", want: "Usage: /echo"},
+		{name: "echo", command: "echo", args: []string{"hello", "there"}, want: "hello there ("},
+		{name: "echo-delay usage", command: "echo-delay", args: []string{"10"}, want: "Usage: /echo-delay"},
+		{name: "echo-delay negative", command: "echo-delay", args: []string{"-1", "hi"}, want: "The delay must be a number of milliseconds."},
+		{name: "echo-delay not a number", command: "echo-delay", args: []string{"soon", "hi"}, want: "The delay must be a number of milliseconds."},
+		{name: "echo-delay capped", command: "echo-delay", args: []string{"10000000", "hi"}, want: "The delay is capped at"},
+		{name: "echo-delay overflowing", command: "echo-delay", args: []string{"9223372036854775807", "hi"}, want: "The delay is capped at"},
+		{
+			name:    "echo-delay",
+			command: "echo-delay",
+			args:    []string{"1", "hi"},
+			check: func(t *testing.T, server *Server, alice *fakeSession) {
+				select {
+				case delivery := <-server.deliver:
+					if delivery.To != Session(alice) || !strings.Contains(delivery.Body, "hi (") {
+						t.Errorf("got delivery %+v", delivery)
+					}
+				case <-time.After(time.Second):
+					t.Error("the echo wasn't delivered")
+				}
+			},
+		},
+
+		{name: "admin usage", command: "admin", want: "Usage: /admin"},
+		{name: "admin disabled", command: "admin", args: []string{"secret"}, want: "Incorrect admin password."},
+		{name: "admin wrong password", command: "admin", args: []string{"wrong"}, configure: func(config *Config) { config.AdminPassword = "secret" }, want: "Incorrect admin password."},
+		{name: "admin password prefix", command: "admin", args: []string{"secre"}, configure: func(config *Config) { config.AdminPassword = "secret" }, want: "Incorrect admin password."},
+		{name: "admin already", command: "admin", args: []string{"secret"}, setup: asAdmin(nil), want: "You are already an admin."},
+		{
+			name:      "admin",
+			command:   "admin",
+			args:      []string{"secret"},
+			configure: func(config *Config) { config.AdminPassword = "secret" },
+			want:      "You are now an admin.",
+			check: func(t *testing.T, server *Server, alice *fakeSession) {
+				if !alice.IsAdmin() {
+					t.Error("alice isn't an admin")
+				}
+			},
+		},
+
+		{
+			name:    "quit",
+			command: "quit",
+			setup:   joined("general"),
+			check: func(t *testing.T, server *Server, alice *fakeSession) {
+				if alice.disconnected != "Goodbye!" {
+					t.Errorf("disconnected with %q", alice.disconnected)
+				}
+				if _, exists := server.clients["alice"]; exists {
+					t.Error("the client wasn't removed")
+				}
+			},
+		},
+	})
+}
+
+func TestWhisperCommands(t *testing.T) {
+	withBob := func(t *testing.T, server *Server, alice *fakeSession) {
+		connect(server, "bob")
+	}
+
+	runCommandTests(t, []commandTest{
+		{name: "whisper usage", command: "whisper", args: []string{"bob"}, want: "Usage: /whisper"},
+		{name: "whisper to self", command: "whisper", args: []string{"alice", "hi"}, want: "You cannot whisper to yourself."},
+		{name: "whisper unknown group", command: "whisper", args: []string{"@friends", "hi"}, want: "@friends"},
+		{name: "whisper unknown user", command: "whisper", args: []string{"bob", "hi"}, want: "User 'bob' not found or not registered."},
+		{
+			name:    "whisper rate limited",
+			command: "whisper",
+			args:    []string{"bob", "hi"},
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				withBob(t, server, alice)
+				alice.denied["whisper"] = true
+			},
+			want: "You are sending whispers too quickly.",
+		},
+		{
+			name:    "whisper recipient capped",
+			command: "whisper",
+			args:    []string{"bob", "hi"},
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				connect(server, "bob").denied["receive"] = true
+			},
+			want: "'bob' is receiving too many whispers right now.",
+		},
+		{
+			name:    "whisper",
+			command: "whisper",
+			args:    []string{"bob", "hi", "there"},
+			setup:   withBob,
+			want:    "Whisper sent to 'bob'",
+			check: func(t *testing.T, server *Server, alice *fakeSession) {
+				bob := server.clients["bob"].(*fakeSession)
+				if !bob.received("hi there") || !bob.received("system|read-receipt|") {
+					t.Errorf("bob got %q", bob.sent)
+				}
+			},
+		},
+		{
+			name:    "whisper away user",
+			command: "whisper",
+			args:    []string{"bob", "hi"},
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				connect(server, "bob").SetAway("lunch", false)
+			},
+			want: "'bob' is away: lunch",
+		},
+		{
+			name:    "whisper while shadow muted",
+			command: "whisper",
+			args:    []string{"bob", "hi"},
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				withBob(t, server, alice)
+				server.shadowMuted["alice"] = struct{}{}
+			},
+			want: "Whisper sent to 'bob'",
+			check: func(t *testing.T, server *Server, alice *fakeSession) {
+				if bob := server.clients["bob"].(*fakeSession); bob.received("DM from") {
+					t.Error("the whisper of a shadow muted user was delivered")
+				}
+			},
+		},
+
+		{name: "group none", command: "group", want: "You have no groups."},
+		{name: "group unknown", command: "group", args: []string{"@friends"}, want: "Group '@friends' not found."},
+		{name: "group empty name", command: "group", args: []string{"@", "bob"}, want: "Group names can't be empty or contain commas."},
+		{name: "group of groups", command: "group", args: []string{"friends", "@others"}, want: "Groups can only contain usernames."},
+		{name: "group of self", command: "group", args: []string{"friends", "alice"}, want: "A group needs at least one other user."},
+		{name: "group usage", command: "group", args: []string{"a", "b", "c"}, want: "Usage: /group"},
+		{
+			name:    "group",
+			command: "group",
+			args:    []string{"friends", "bob,carol,bob"},
+			want:    "Group '@friends' is now bob, carol.",
+			check: func(t *testing.T, server *Server, alice *fakeSession) {
+				if !slices.Equal(alice.whisperGroups["friends"], []string{"bob", "carol"}) {
+					t.Errorf("group is %q", alice.whisperGroups["friends"])
+				}
+			},
+		},
+		{
+			name:    "group show",
+			command: "group",
+			args:    []string{"friends"},
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				alice.whisperGroups = map[string][]string{"friends": {"bob"}}
+			},
+			want: "@friends: bob",
+		},
+
+		{name: "masswhisper non-admin", command: "masswhisper", args: []string{"bob", "hi"}, want: "Permission denied: you need to be an admin to do that."},
+		{name: "masswhisper usage", command: "masswhisper", args: []string{",", "hi"}, setup: asAdmin(nil), want: "Usage: /masswhisper"},
+		{name: "masswhisper too many", command: "masswhisper", args: []string{"u1,u2,u3,u4,u5,u6,u7,u8,u9,u10,u11,u12,u13,u14,u15,u16,u17,u18,u19,u20,u21", "hi"}, setup: asAdmin(nil), want: "You can whisper at most 20 users at once."},
+		{name: "masswhisper", command: "masswhisper", args: []string{"bob,carol", "hi"}, setup: asAdmin(withBob), want: "Delivered to 1/2 users. Failed: carol."},
+	})
+}
+
+func TestNicknameCommands(t *testing.T) {
+	persistent := func(t *testing.T, server *Server, alice *fakeSession) {
+		server.nicks.file.path = filepath.Join(t.TempDir(), "nicks.json")
+	}
+	registered := func(nickname string) func(t *testing.T, server *Server, alice *fakeSession) {
+		return func(t *testing.T, server *Server, alice *fakeSession) {
+			persistent(t, server, alice)
+			registerNickname(t, server, nickname)
+		}
+	}
+
+	runCommandTests(t, []commandTest{
+		{name: "register usage", command: "register", want: "Usage: /register"},
+		{name: "register short password", command: "register", args: []string{"abc"}, want: "The password must be at least"},
+		{name: "register taken", command: "register", args: []string{"correct horse"}, setup: registered("alice"), want: "'alice' is already registered."},
+		{
+			name:    "register",
+			command: "register",
+			args:    []string{"correct horse"},
+			want:    "'alice' is now registered.",
+			check: func(t *testing.T, server *Server, alice *fakeSession) {
+				if !server.isLoggedIn(alice) {
+					t.Error("alice isn't logged in after registering")
+				}
+			},
+		},
+
+		{name: "ghost usage", command: "ghost", args: []string{"bob"}, want: "Usage: /ghost"},
+		{name: "ghost self", command: "ghost", args: []string{"alice", "pw"}, want: "You are already 'alice'."},
+		{
+			name:    "ghost attempts",
+			command: "ghost",
+			args:    []string{"bob", "pw"},
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				for range maxGhostAttempts {
+					server.allowAuthAttempt(alice, "bob", time.Now())
+				}
+			},
+			want: "Too many ghost attempts.",
+		},
+		{name: "ghost wrong password", command: "ghost", args: []string{"bob", "wrong password"}, setup: registered("bob"), want: "Invalid username or password."},
+		{
+			name:    "ghost",
+			command: "ghost",
+			args:    []string{"bob", "correct horse"},
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				registered("bob")(t, server, alice)
+				connect(server, "bob")
+			},
+			want: "You have reclaimed 'bob'.",
+			check: func(t *testing.T, server *Server, alice *fakeSession) {
+				if alice.GetUsername() != "bob" || server.clients["bob"] != Session(alice) {
+					t.Errorf("alice is called %q", alice.GetUsername())
+				}
+			},
+		},
+
+		{name: "login disabled", command: "login", args: []string{"pw"}, want: "Logins are disabled on this server."},
+		{name: "login usage", command: "login", setup: persistent, want: "Usage: /login"},
+		{name: "login unregistered", command: "login", args: []string{"pw"}, setup: persistent, want: "Your username is not registered."},
+		{name: "login wrong password", command: "login", args: []string{"wrong password"}, setup: registered("alice"), want: "Incorrect password."},
+		{
+			name:    "login attempts",
+			command: "login",
+			args:    []string{"correct horse"},
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				registered("alice")(t, server, alice)
+				for range maxGhostAttempts {
+					server.allowAuthAttempt(alice, "alice", time.Now())
+				}
+			},
+			want: "Too many login attempts.",
+		},
+		{name: "login", command: "login", args: []string{"correct horse"}, setup: registered("alice"), want: "You are logged in as 'alice'."},
+		{
+			name:    "login pending username",
+			command: "login",
+			args:    []string{"correct horse"},
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				registered("bob")(t, server, alice)
+				if err := server.requireLogin(alice, "bob"); err == nil {
+					t.Fatal("bob wasn't required to log in")
+				}
+			},
+			want: "You are logged in as 'bob'.",
+			check: func(t *testing.T, server *Server, alice *fakeSession) {
+				if alice.GetUsername() != "bob" {
+					t.Errorf("alice is called %q", alice.GetUsername())
+				}
+			},
+		},
+		{
+			name:    "login twice",
+			command: "login",
+			args:    []string{"correct horse"},
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				registered("alice")(t, server, alice)
+				alice.loggedInAs = "alice"
+			},
+			want: "You are already logged in as 'alice'.",
+		},
+
+		{name: "rejoin logged out", command: "rejoin", want: "Log in with /login"},
+		{
+			name:    "rejoin nothing",
+			command: "rejoin",
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				registered("alice")(t, server, alice)
+				alice.loggedInAs = "alice"
+			},
+			want: "There are no channels to rejoin",
+		},
+		{
+			name:    "rejoin",
+			command: "rejoin",
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				registered("alice")(t, server, alice)
+				alice.loggedInAs = "alice"
+				server.lastSessions["alice"] = lastSession{SeenAt: time.Now(), Channels: []string{"general", "random"}}
+			},
+			want: "You have joined channel 'random'",
+			check: func(t *testing.T, server *Server, alice *fakeSession) {
+				if len(alice.GetChannels()) != 2 {
+					t.Errorf("alice is in %d channels", len(alice.GetChannels()))
+				}
+			},
+		},
+	})
+}
+
+func TestChannelInfoCommands(t *testing.T) {
+	withHistory := func(t *testing.T, server *Server, alice *fakeSession) {
+		join(t, server, alice, "general").AddHistory(HistoryEntry{Sender: "bob", Content: "hello", Time: time.Now()})
+	}
+	passwordChannel := func(t *testing.T, server *Server, alice *fakeSession) {
+		if _, err := server.enterChannel(connect(server, "bob"), "secret", "hunter2"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	runCommandTests(t, []commandTest{
+		{name: "channel unknown", command: "channel", args: []string{"#general"}, want: "Channel 'general' not found."},
+		{name: "channel usage", command: "channelinfo", want: "Usage: /channelinfo"},
+		{
+			name:    "channel hidden",
+			command: "channel",
+			args:    []string{"#secret"},
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				join(t, server, connect(server, "bob"), "secret").SetHidden(true)
+			},
+			want: "Channel 'secret' not found.",
+		},
+		{name: "channelinfo password", command: "channelinfo", args: []string{"secret"}, setup: passwordChannel, want: "Only members of 'secret' can see its details."},
+		{name: "channel", command: "channel", setup: joined("general"), want: "Channel #general\nNo topic set\nCreated: "},
+		{
+			name:    "channelinfo topic",
+			command: "channelinfo",
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				join(t, server, alice, "general").restoreTopic(ChannelTopic{Text: "cats", SetBy: "bob", SetAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)})
+			},
+			want: "Topic: cats (set by bob",
+		},
+		{
+			name:    "channelinfo topic metadata",
+			command: "channelinfo",
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				join(t, server, alice, "general").restoreTopic(ChannelTopic{Text: "cats", SetBy: "bob", SetAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)})
+			},
+			want: "\nTopic set by: bob at 2026-01-02T03:04:05Z\nCreated: ",
+		},
+
+		{name: "channelage unknown", command: "channelage", args: []string{"general"}, want: "Channel 'general' not found."},
+		{
+			name:    "channelage hidden",
+			command: "channelage",
+			args:    []string{"secret"},
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				join(t, server, connect(server, "bob"), "secret").SetHidden(true)
+			},
+			want: "Channel 'secret' not found.",
+		},
+		{name: "channelage password", command: "channelage", args: []string{"secret"}, setup: passwordChannel, want: "Only members of 'secret'"},
+		{name: "channelage usage", command: "channelage", want: "Usage: /channelage"},
+		{name: "channelage", command: "channelage", setup: joined("general"), want: "Channel #general was created 0s ago"},
+
+		{name: "topic without channel", command: "topic", want: "You are not in any channel."},
+		{name: "topic", command: "topic", setup: joined("general"), want: "No topic set"},
+		{name: "topic not owner", command: "topic", args: []string{"cats"}, setup: memberOf("general"), want: "Only the channel owner can change the topic."},
+		{name: "topic too long", command: "topic", args: []string{strings.Repeat("x", maxTopicLength+1)}, setup: joined("general"), want: "The topic cannot exceed"},
+		{
+			name:    "topic set",
+			command: "topic",
+			args:    []string{"all", "about", "cats"},
+			setup:   joined("general"),
+			want:    "alice changed the topic to: all about cats",
+			check: func(t *testing.T, server *Server, alice *fakeSession) {
+				if topic := server.channels["general"].Topic(); topic.Text != "all about cats" || topic.SetBy != "alice" {
+					t.Errorf("topic is %+v", topic)
+				}
+			},
+		},
+
+		{name: "pins none", command: "pins", setup: joined("general"), want: "There are no pinned messages in 'general'."},
+		{
+			name:    "pins",
+			command: "pins",
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				join(t, server, alice, "general").AddPin("read the rules", "alice")
+			},
+			want: "1. read the rules (pinned by alice",
+		},
+
+		{name: "history usage", command: "history", args: []string{"0"}, want: "Usage: /history"},
+		{name: "history without channel", command: "history", want: "You are not in any channel."},
+		{name: "history empty", command: "history", setup: joined("general"), want: "There are no messages in the history of 'general'."},
+		{name: "history", command: "history", args: []string{"5"}, setup: withHistory, want: "bob|general|hello"},
+
+		{name: "export without channel", command: "export", want: "You are not in any channel."},
+		{name: "export empty", command: "export", setup: joined("general"), want: "There are no messages to export in 'general'."},
+		{name: "export", command: "export", setup: withHistory, want: `"content":"hello"`},
+	})
+}
+
+func TestOperatorCommands(t *testing.T) {
+	withPassword := func(t *testing.T, server *Server, alice *fakeSession) {
+		if _, err := server.enterChannel(alice, "secret", "hunter2"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	withBob := func(t *testing.T, server *Server, alice *fakeSession) {
+		join(t, server, alice, "general")
+		join(t, server, connect(server, "bob"), "general")
+	}
+
+	runCommandTests(t, []commandTest{
+		{name: "lock not operator", command: "lock", setup: memberOf("general"), want: "Permission denied: you need to be an operator of 'general' to do that."},
+		{name: "lock without channel", command: "lock", want: "You are not in any channel."},
+		{
+			name:    "lock",
+			command: "lock",
+			setup:   joined("general"),
+			want:    "alice locked the channel.",
+			check: func(t *testing.T, server *Server, alice *fakeSession) {
+				if !server.channels["general"].IsLocked() {
+					t.Error("the channel isn't locked")
+				}
+			},
+		},
+		{
+			name:    "lock locked",
+			command: "lock",
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				join(t, server, alice, "general").SetLocked(true)
+			},
+			want: "Channel 'general' is already locked.",
+		},
+		{name: "unlock unlocked", command: "unlock", setup: joined("general"), want: "Channel 'general' is not locked."},
+		{
+			name:    "unlock",
+			command: "unlock",
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				join(t, server, alice, "general").SetLocked(true)
+			},
+			want: "alice unlocked the channel.",
+		},
+		{name: "admins operate any channel", command: "lock", setup: asAdmin(memberOf("general")), want: "alice locked the channel."},
+
+		{name: "hidechannel", command: "hidechannel", args: []string{"general"}, setup: joined("general", "random"), want: "alice hid the channel."},
+		{
+			name:    "hidechannel hidden",
+			command: "hidechannel",
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				join(t, server, alice, "general").SetHidden(true)
+			},
+			want: "Channel 'general' is already hidden.",
+		},
+		{name: "unhidechannel not hidden", command: "unhidechannel", setup: joined("general"), want: "Channel 'general' is not hidden."},
+		{
+			name:    "unhidechannel",
+			command: "unhidechannel",
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				join(t, server, alice, "general").SetHidden(true)
+			},
+			want: "alice unhid the channel.",
+		},
+
+		{name: "invite usage", command: "invite", want: "Usage: /invite"},
+		{name: "invite unknown user", command: "invite", args: []string{"bob"}, setup: joined("general"), want: "User 'bob' not found or not registered."},
+		{name: "invite member", command: "invite", args: []string{"bob"}, setup: withBob, want: "'bob' is already in 'general'."},
+		{
+			name:    "invite",
+			command: "invite",
+			args:    []string{"bob", "general"},
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				join(t, server, alice, "general")
+				connect(server, "bob")
+			},
+			want: "Invited 'bob' to 'general'",
+			check: func(t *testing.T, server *Server, alice *fakeSession) {
+				if !server.channels["general"].IsInvited("bob") || !server.clients["bob"].(*fakeSession).received("alice invited you") {
+					t.Error("bob wasn't invited")
+				}
+			},
+		},
+
+		{name: "op usage", command: "op", want: "Usage: /op"},
+		{name: "op not member", command: "op", args: []string{"bob"}, setup: joined("general"), want: "'bob' is not in 'general'."},
+		{
+			name:    "op",
+			command: "op",
+			args:    []string{"bob"},
+			setup:   withBob,
+			want:    "bob is now an operator.",
+			check: func(t *testing.T, server *Server, alice *fakeSession) {
+				if !server.channels["general"].IsOperator(server.clients["bob"]) {
+					t.Error("bob isn't an operator")
+				}
+			},
+		},
+		{
+			name:    "deop",
+			command: "deop",
+			args:    []string{"bob"},
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				withBob(t, server, alice)
+				server.channels["general"].AddOperator(server.clients["bob"])
+			},
+			want: "bob is no longer an operator.",
+		},
+
+		{name: "inspect usage", command: "inspect", want: "Usage: /inspect"},
+		{name: "inspect not member", command: "inspect", args: []string{"bob"}, setup: joined("general"), want: "'bob' is not a member of 'general'"},
+		{name: "inspect", command: "inspect", args: []string{"bob"}, setup: withBob, want: "bob in 'general'\nRole: user"},
+
+		{name: "setchannelcolor usage", command: "setchannelcolor", setup: joined("general"), want: "Usage: /setchannelcolor"},
+		{name: "setchannelcolor invalid", command: "setchannelcolor", args: []string{"300"}, setup: joined("general"), want: "Invalid color"},
+		{name: "setchannelcolor", command: "setchannelcolor", args: []string{"#ff8800"}, setup: joined("general"), want: "[color:#ff8800]"},
+		{name: "setchannelcolor none", command: "setchannelcolor", args: []string{"none"}, setup: joined("general"), want: "Removed the color of 'general'."},
+
+		{name: "nospam usage", command: "nospam", args: []string{"maybe"}, setup: joined("general"), want: "Usage: /nospam"},
+		{name: "nospam bad duration", command: "nospam", args: []string{"--duration", "-1m"}, setup: joined("general"), want: "Invalid duration '-1m'"},
+		{name: "nospam off when off", command: "nospam", args: []string{"off"}, setup: joined("general"), want: "Channel 'general' is not in no-spam mode."},
+		{
+			name:    "nospam",
+			command: "nospam",
+			args:    []string{"--duration", "1m"},
+			setup:   joined("general"),
+			want:    "alice turned on no-spam mode for 1m0s.",
+			check: func(t *testing.T, server *Server, alice *fakeSession) {
+				if !server.channels["general"].IsSpamLocked() {
+					t.Error("the channel isn't in no-spam mode")
+				}
+			},
+		},
+		{
+			name:    "nospam off",
+			command: "nospam",
+			args:    []string{"off"},
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				join(t, server, alice, "general").SetSpamLock(time.Minute)
+			},
+			want: "alice turned off no-spam mode.",
+		},
+
+		{name: "invitecode without password", command: "invitecode", setup: joined("general"), want: "Channel 'general' doesn't have a password"},
+		{name: "invitecode bad uses", command: "invitecode", args: []string{"0"}, setup: withPassword, want: "uses must be between 1 and"},
+		{name: "invitecode bad ttl", command: "invitecode", args: []string{"1", "forever"}, setup: withPassword, want: "ttl must be a duration"},
+		{name: "invitecode", command: "invitecode", args: []string{"2", "1h"}, setup: withPassword, want: "(2 use(s), expires in 1h0m0s). Join with /join secret "},
+		{name: "invitecodes none", command: "invitecodes", setup: withPassword, want: "Channel 'secret' has no invite codes."},
+		{
+			name:    "invitecodes",
+			command: "invitecodes",
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				withPassword(t, server, alice)
+				server.channels["secret"].CreateInviteCode(3, time.Hour, "alice")
+			},
+			want: "3 use(s) left",
+		},
+		{name: "revokecode usage", command: "revokecode", setup: withPassword, want: "Usage: /revokecode"},
+		{name: "revokecode unknown", command: "revokecode", args: []string{"nope"}, setup: withPassword, want: "Invite code 'nope' not found in 'secret'."},
+		{
+			name:    "revokecode",
+			command: "revokecode",
+			args:    []string{"code"},
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				withPassword(t, server, alice)
+				inviteCode, _ := server.channels["secret"].CreateInviteCode(1, time.Hour, "alice")
+				server.channels["secret"].codes = map[string]*InviteCode{"CODE": inviteCode}
+			},
+			want: "Invite code 'CODE' has been revoked.",
+		},
+
+		{name: "pin usage", command: "pin", setup: joined("general"), want: "Usage: /pin"},
+		{name: "pin", command: "pin", args: []string{"read", "the", "rules"}, setup: joined("general"), want: "alice pinned a message: read the rules"},
+		{
+			name:    "pin full",
+			command: "pin",
+			args:    []string{"one", "more"},
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				channel := join(t, server, alice, "general")
+				for range maxPins {
+					channel.AddPin("pinned", "alice")
+				}
+			},
+			want: "Cannot pin more than",
+		},
+		{name: "unpin usage", command: "unpin", setup: joined("general"), want: "Usage: /unpin"},
+		{name: "unpin not a number", command: "unpin", args: []string{"first"}, setup: joined("general"), want: "Usage: /unpin"},
+		{name: "unpin missing", command: "unpin", args: []string{"1"}, setup: joined("general"), want: "There is no pinned message number 1."},
+		{
+			name:    "unpin",
+			command: "unpin",
+			args:    []string{"1"},
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				join(t, server, alice, "general").AddPin("read the rules", "alice")
+			},
+			want: "alice unpinned a message: read the rules",
+		},
+
+		{name: "topic-clear none", command: "topic-clear", setup: joined("general"), want: "'general' has no topic to clear."},
+		{
+			name:    "topic-clear",
+			command: "topic-clear",
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				join(t, server, alice, "general").SetTopic("cats", "alice")
+			},
+			want: "alice cleared the channel topic.",
+		},
+
+		{name: "sethint usage", command: "sethint", setup: withPassword, want: "Usage: /sethint"},
+		{name: "sethint without password", command: "sethint", args: []string{"hint"}, setup: joined("general"), want: "'general' doesn't have a password to give a hint for."},
+		{name: "sethint", command: "sethint", args: []string{"a", "classic"}, setup: withPassword, want: "Password hint of 'secret' set to: a classic"},
+		{name: "sethint too long", command: "sethint", args: []string{strings.Repeat("x", maxHintLength+1)}, setup: withPassword, want: "The hint was cut to"},
+		{name: "removehint none", command: "removehint", setup: withPassword, want: "'secret' has no password hint."},
+		{
+			name:    "removehint",
+			command: "removehint",
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				withPassword(t, server, alice)
+				server.channels["secret"].SetPasswordHint("a classic")
+			},
+			want: "Removed the password hint of 'secret'.",
+		},
+
+		{name: "formatting", command: "formatting", setup: joined("general"), want: "Formatting of 'general' is"},
+		{name: "formatting modes", command: "formatting", setup: joined("general"), want: "full and basic both expand emoji shortcodes"},
+		{name: "formatting invalid", command: "formatting", args: []string{"fancy"}, setup: joined("general"), want: "Usage: /formatting"},
+		{name: "formatting unchanged", command: "formatting", args: []string{string(FormattingFull)}, setup: joined("general"), want: "is already full"},
+		{name: "formatting set", command: "formatting", args: []string{"NONE"}, setup: joined("general"), want: "alice set the channel's formatting to none."},
+	})
+}
+
+func TestAdminCommands(t *testing.T) {
+	withBob := func(t *testing.T, server *Server, alice *fakeSession) {
+		connect(server, "bob")
+	}
+
+	runCommandTests(t, []commandTest{
+		{name: "non-admin", command: "setdefaultchannel", args: []string{"general"}, want: "Permission denied: you need to be an admin to do that."},
+		{name: "setdefaultchannel usage", command: "setdefaultchannel", setup: asAdmin(nil), want: "Usage: /setdefaultchannel"},
+		{name: "setdefaultchannel without name", command: "setdefaultchannel", args: []string{"#"}, setup: asAdmin(nil), want: "Usage: /setdefaultchannel"},
+		{
+			name:    "setdefaultchannel with #",
+			command: "setdefaultchannel",
+			args:    []string{"#lobby"},
+			setup:   asAdmin(nil),
+			want:    "New users will now be auto-joined to #lobby.",
+			check: func(t *testing.T, server *Server, alice *fakeSession) {
+				if server.defaultChannel != "lobby" {
+					t.Errorf("default channel is %q", server.defaultChannel)
+				}
+			},
+		},
+		{
+			name:    "setdefaultchannel",
+			command: "setdefaultchannel",
+			args:    []string{"lobby"},
+			setup:   asAdmin(nil),
+			want:    "New users will now be auto-joined to #lobby.",
+			check: func(t *testing.T, server *Server, alice *fakeSession) {
+				if server.defaultChannel != "lobby" {
+					t.Errorf("default channel is %q", server.defaultChannel)
+				}
+			},
+		},
+
+		{name: "say usage", command: "say", setup: asAdmin(nil), want: "Usage: /say"},
+		{name: "say channel usage", command: "say", args: []string{"#general"}, setup: asAdmin(nil), want: "Usage: /say"},
+		{name: "say unknown channel", command: "say", args: []string{"#general", "hi"}, setup: asAdmin(nil), want: "Channel 'general' not found."},
+		{name: "say without channel", command: "say", args: []string{"hi"}, setup: asAdmin(nil), want: "You are not in a channel."},
+		{name: "say", command: "say", args: []string{"hi", "all"}, setup: asAdmin(joined("general")), want: "hi all"},
+		{name: "say to channel", command: "say", args: []string{"#random", "hi"}, setup: asAdmin(memberOf("random")), want: "hi"},
+
+		{name: "announce usage", command: "announce", args: []string{"--channels"}, setup: asAdmin(nil), want: "Usage: /announce"},
+		{name: "announce", command: "announce", args: []string{"restart", "soon"}, setup: asAdmin(nil), want: "[Announcement] restart soon"},
+		{name: "announce to channels", command: "announce", args: []string{"--channels", "hi"}, setup: asAdmin(joined("general", "random")), want: "Announcement sent to 2 channel(s)."},
+
+		{name: "banip usage", command: "banip", setup: asAdmin(nil), want: "Usage: /banip"},
+		{name: "banip bad duration", command: "banip", args: []string{"198.51.100.1", "soon"}, setup: asAdmin(nil), want: "Invalid duration 'soon'"},
+		{name: "banip bad address", command: "banip", args: []string{"nowhere"}, setup: asAdmin(nil), want: "Invalid IP address 'nowhere'."},
+		{
+			name:    "banip",
+			command: "banip",
+			args:    []string{"198.51.100.1", "1h"},
+			setup: asAdmin(func(t *testing.T, server *Server, alice *fakeSession) {
+				connect(server, "bob").ip = "198.51.100.1:40000"
+			}),
+			want: "Banned 198.51.100.1 for 1h0m0s, disconnecting 1 client(s).",
+			check: func(t *testing.T, server *Server, alice *fakeSession) {
+				if bob := server.clients["bob"].(*fakeSession); bob.disconnected == "" {
+					t.Error("bob wasn't disconnected")
+				}
+			},
+		},
+		{name: "unbanip usage", command: "unbanip", setup: asAdmin(nil), want: "Usage: /unbanip"},
+		{name: "unbanip not banned", command: "unbanip", args: []string{"198.51.100.1"}, setup: asAdmin(nil), want: "'198.51.100.1' is not banned."},
+		{
+			name:    "unbanip",
+			command: "unbanip",
+			args:    []string{"198.51.100.1"},
+			setup: asAdmin(func(t *testing.T, server *Server, alice *fakeSession) {
+				server.bans.Add("198.51.100.1", 0)
+			}),
+			want: "Unbanned 198.51.100.1.",
+		},
+		{name: "reloadbans without file", command: "reloadbans", setup: asAdmin(nil), want: "There is no ban file to reload."},
+		{
+			name:    "reloadbans",
+			command: "reloadbans",
+			setup: asAdmin(func(t *testing.T, server *Server, alice *fakeSession) {
+				path := filepath.Join(t.TempDir(), "bans")
+				if err := os.WriteFile(path, []byte("198.51.100.1\nnot an address\n"), 0o600); err != nil {
+					t.Fatal(err)
+				}
+				server.bans.path = path
+			}),
+			want: "Ban file reloaded: 1 added, 0 removed.\nSkipped 1 malformed line(s):",
+		},
+
+		{name: "shadowmute usage", command: "shadowmute", setup: asAdmin(nil), want: "Usage: /shadowmute"},
+		{name: "shadowmute", command: "shadowmute", args: []string{"bob"}, setup: asAdmin(nil), want: "'bob' is now shadow muted."},
+		{
+			name:    "shadowmute twice",
+			command: "shadowmute",
+			args:    []string{"bob"},
+			setup: asAdmin(func(t *testing.T, server *Server, alice *fakeSession) {
+				server.shadowMuted["bob"] = struct{}{}
+			}),
+			want: "'bob' is already shadow muted.",
+		},
+		{name: "unshadowmute not muted", command: "unshadowmute", args: []string{"bob"}, setup: asAdmin(nil), want: "'bob' is not shadow muted."},
+		{
+			name:    "unshadowmute",
+			command: "unshadowmute",
+			args:    []string{"bob"},
+			setup: asAdmin(func(t *testing.T, server *Server, alice *fakeSession) {
+				server.shadowMuted["bob"] = struct{}{}
+			}),
+			want: "'bob' is no longer shadow muted.",
+		},
+
+		{name: "exportusers", command: "exportusers", setup: asAdmin(joined("general")), want: `"username":"alice","registered":true,"channel":"general","channels":["general"]`},
+		{
+			name:    "exportusers temporary",
+			command: "exportusers",
+			args:    []string{"--include-temp"},
+			setup: asAdmin(func(t *testing.T, server *Server, alice *fakeSession) {
+				guest := newFakeSession("")
+				guest.registered = false
+				server.clients[guest.clientsKey()] = guest
+			}),
+			want: `"username":"203.0.113.7:50000","registered":false`,
+		},
+
+		{name: "slowclients none", command: "slowclients", setup: asAdmin(nil), want: "There are no slow clients."},
+		{name: "sessions usage", command: "sessions", args: []string{"--sort", "name"}, setup: asAdmin(nil), want: "Usage: /sessions"},
+		{name: "sessions", command: "sessions", args: []string{"--sort", "traffic"}, setup: asAdmin(withBob), want: "Active sessions (2), sorted by traffic:"},
+
+		{name: "bridge none", command: "bridge", setup: asAdmin(nil), want: "There are no bridges."},
+		{name: "bridge usage", command: "unbridge", setup: asAdmin(nil), want: "Usage: /unbridge"},
+		{name: "bridge unknown", command: "bridge", args: []string{"bob"}, setup: asAdmin(nil), want: "User 'bob' not found."},
+		{
+			name:    "bridge",
+			command: "bridge",
+			args:    []string{"bob"},
+			setup:   asAdmin(withBob),
+			want:    "'bob' can now relay messages as a bridge",
+			check: func(t *testing.T, server *Server, alice *fakeSession) {
+				if !server.clients["bob"].IsBridge() {
+					t.Error("bob isn't a bridge")
+				}
+			},
+		},
+		{
+			name:    "bridge twice",
+			command: "bridge",
+			args:    []string{"bob"},
+			setup: asAdmin(func(t *testing.T, server *Server, alice *fakeSession) {
+				connect(server, "bob").bridge = true
+			}),
+			want: "'bob' is already a bridge.",
+		},
+		{
+			name:    "bridge list",
+			command: "bridge",
+			setup: asAdmin(func(t *testing.T, server *Server, alice *fakeSession) {
+				connect(server, "bob").bridge = true
+			}),
+			want: "Bridges: bob",
+		},
+		{name: "unbridge not a bridge", command: "unbridge", args: []string{"bob"}, setup: asAdmin(withBob), want: "'bob' is not a bridge."},
+		{
+			name:    "unbridge",
+			command: "unbridge",
+			args:    []string{"bob"},
+			setup: asAdmin(func(t *testing.T, server *Server, alice *fakeSession) {
+				connect(server, "bob").bridge = true
+			}),
+			want: "'bob' can no longer relay messages.",
+		},
+
+		{name: "purge-empty usage", command: "purge-empty", args: []string{"--older"}, setup: asAdmin(nil), want: "Usage: /purge-empty"},
+		{name: "purge-empty bad duration", command: "purge-empty", args: []string{"--older-than", "old"}, setup: asAdmin(nil), want: "Invalid duration 'old'"},
+		{name: "purge-empty none", command: "purge-empty", setup: asAdmin(joined("general")), want: "No empty channels to purge."},
+		{
+			name:      "purge-empty",
+			command:   "purge-empty",
+			configure: func(config *Config) { config.PersistentChannels = true },
+			setup: asAdmin(func(t *testing.T, server *Server, alice *fakeSession) {
+				server.leaveChannel(alice, join(t, server, alice, "random"))
+				join(t, server, alice, "general")
+			}),
+			want: "Purged 1 empty channel(s): random",
+		},
+
+		{name: "shuffle usage", command: "shuffle", args: []string{"--now"}, setup: asAdmin(nil), want: "Usage: /shuffle"},
+		{name: "shuffle nobody", command: "shuffle", setup: asAdmin(nil), want: "There is nobody to shuffle."},
+		{
+			name:    "shuffle prompt",
+			command: "shuffle",
+			setup: asAdmin(func(t *testing.T, server *Server, alice *fakeSession) {
+				join(t, server, connect(server, "bob"), "random")
+				join(t, server, alice, "general")
+			}),
+			want: "This will move 2 members to other channels.",
+		},
+		{
+			name:    "shuffle",
+			command: "shuffle",
+			args:    []string{"--confirm"},
+			setup: asAdmin(func(t *testing.T, server *Server, alice *fakeSession) {
+				join(t, server, connect(server, "bob"), "random")
+				join(t, server, alice, "general")
+			}),
+			want: "Shuffled 2 members.",
+			check: func(t *testing.T, server *Server, alice *fakeSession) {
+				if alice.GetChannel() == nil || alice.GetChannel().Name != "random" {
+					t.Errorf("alice is in %v", alice.GetChannel())
+				}
+			},
+		},
+
+		{name: "loadplugin usage", command: "loadplugin", setup: asAdmin(nil), want: "Usage: /loadplugin"},
+		{name: "loadplugin missing", command: "loadplugin", args: []string{"/nonexistent.so"}, setup: asAdmin(nil), want: "Failed to load plugin:"},
+		{name: "unloadplugin usage", command: "unloadplugin", setup: asAdmin(nil), want: "Usage: /unloadplugin"},
+		{name: "unloadplugin unknown", command: "unloadplugin", args: []string{"dice"}, setup: asAdmin(nil), want: "No plugin named 'dice' is loaded."},
+		{
+			name:    "unloadplugin",
+			command: "unloadplugin",
+			args:    []string{"dice"},
+			setup: asAdmin(func(t *testing.T, server *Server, alice *fakeSession) {
+				server.plugins["dice"] = nil
+				server.commands["dice"] = CommandSpec{}
+			}),
+			want: "Plugin 'dice' unloaded.",
+			check: func(t *testing.T, server *Server, alice *fakeSession) {
+				if _, exists := server.commands["dice"]; exists {
+					t.Error("the plugin's command is still registered")
+				}
+			},
+		},
+	})
+}
+
+func BenchmarkListChannels(b *testing.B) {
+	const channels = 5000
+
+	server := newTestServer(b, nil)
+	for i := range channels {
+		channel := NewChannel(fmt.Sprintf("channel-%04d", channels-i), "")
+		channel.SetHidden(i%10 == 0)
+		server.addChannel(channel)
+	}
+
+	benchmarks := []struct {
+		name string
+		args []string
+	}{
+		{name: "text"},
+		{name: "event", args: []string{"--event"}},
+		{name: "empty", args: []string{"--empty"}},
+	}
+
+	for _, benchmark := range benchmarks {
+		b.Run(benchmark.name, func(b *testing.B) {
+			alice := newFakeSession("alice")
+			b.ReportAllocs()
+			for b.Loop() {
+				listChannels("channels", benchmark.args, alice, server)
+				alice.sent = alice.sent[:0]
+			}
+		})
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := []struct {
+		duration time.Duration
+		want     string
+	}{
+		{duration: 0, want: "0s"},
+		{duration: 59 * time.Second, want: "59s"},
+		{duration: time.Minute, want: "1m"},
+		{duration: 59*time.Minute + 59*time.Second, want: "59m"},
+		{duration: time.Hour, want: "1h"},
+		{duration: 23*time.Hour + 59*time.Minute, want: "23h"},
+		{duration: 24 * time.Hour, want: "1d"},
+		{duration: 400 * 24 * time.Hour, want: "400d"},
+	}
+
+	for _, test := range tests {
+		if got := formatDuration(test.duration); got != test.want {
+			t.Errorf("formatDuration(%s) = %q, want %q", test.duration, got, test.want)
+		}
+	}
+}
+
+func TestChannelAge(t *testing.T) {
+	createdAt := time.Now().Add(-3*24*time.Hour - time.Hour).UTC().Truncate(time.Second)
+	created := fmt.Sprintf("was created 3d ago (%s).", createdAt.Format(time.RFC3339))
+
+	tests := []struct {
+		name     string
+		args     []string
+		member   bool // Whether alice is a member of the channel
+		password string
+		want     string
+	}{
+		{name: "current channel", member: true, want: "Channel #general " + created},
+		{name: "public channel by name", args: []string{"#general"}, want: "Channel #general " + created},
+		{name: "private channel by name", args: []string{"general"}, password: "hunter2", want: "Only members of 'general' can see when it was created."},
+		{name: "private channel of a member", args: []string{"general"}, member: true, password: "hunter2", want: "Channel #general " + created},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, nil)
+			alice := connect(server, "alice")
+			channel, err := server.enterChannel(connect(server, "bob"), "general", test.password)
+			if err != nil {
+				t.Fatal(err)
+			}
+			channel.createdAt = createdAt
+			if test.member {
+				if _, err := server.enterChannel(alice, "general", test.password); err != nil {
+					t.Fatal(err)
+				}
+			}
+			alice.sent = nil
+
+			server.runCommand(Command{Name: "channelage", Args: test.args, Client: alice})
+			if want := formatMessage("Server", test.want); len(alice.sent) != 1 || alice.sent[0] != want {
+				t.Errorf("got %q, want %q", alice.sent, want)
+			}
+		})
+	}
+}
+
+func TestCommandRepliesComeFromTheServer(t *testing.T) {
+	// Replies without the server as their sender are shown by clients as if someone had said them,
+	// or unstyled like the plain lines of "." other than the stream markers
+	serverSenders := []string{"Server", "system"}
+
+	for _, args := range [][]string{nil, {"bob"}, {"general"}, {"bob", "hi"}} {
+		for _, name := range slices.Sorted(maps.Keys(newTestServer(t, nil).commands)) {
+			t.Run(strings.Join(append([]string{name}, args...), " "), func(t *testing.T) {
+				server := newTestServer(t, nil)
+				alice, bob := connect(server, "alice"), connect(server, "bob")
+				alice.SetAdmin(true)
+				general := join(t, server, alice, "general")
+				join(t, server, bob, "general")
+				// Lists like the invite codes are only sent when there's something in them
+				if _, err := general.CreateInviteCode(1, time.Hour, "alice"); err != nil {
+					t.Fatal(err)
+				}
+				general.SetTopic("Welcome", "alice")
+				alice.sent = nil
+
+				server.runCommand(Command{Name: name, Args: args, Client: alice, ReceivedAt: time.Now()})
+				finishWork(t, server)
+				for _, reply := range alice.sent {
+					if reply == streamStartMarker || reply == streamEndMarker {
+						continue
+					}
+					if sender, _, _ := strings.Cut(reply, "|"); !slices.Contains(serverSenders, sender) {
+						t.Errorf("reply %q is sent by %q", reply, sender)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Config holds the settings the server is started with
+type Config struct {
+	Host          string
+	Port          string
+	AdminPassword string // Password used with /admin to gain admin privileges, admin commands are disabled if empty
+
+	PersistentChannels  bool          // Keep channels around once their last member leaves
+	SweepInterval       time.Duration // How often stale channels, expired invite codes and mutes are removed, 0 disables it
+	StaleChannelAge     time.Duration // How long a persistent channel stays empty and unused before it's removed, 0 keeps them forever
+	InviteCodeRetention time.Duration // How long expired invite codes are kept, so using one is told apart from a wrong password
+	MaxChannels         int           // Maximum number of channels on the server, 0 for no limit
+	NameCooldown        time.Duration // Minimum time between username changes, admins are exempt
+	FloodProtection     bool          // Drop chat messages when the broadcast channel is full instead of waiting for room
+	BroadcastWait       time.Duration // How long chat messages wait for room without flood protection before they are dropped
+	IdleAway            time.Duration // Clients idle for this long are marked as away, 0 disables it
+
+	IdleTimeout         time.Duration // Registered clients idle for this long are disconnected, 0 disables it
+	RegistrationTimeout time.Duration // Clients that haven't set a username for this long are disconnected, 0 disables it
+	PingInterval        time.Duration // How often clients are pinged, connections silent for twice as long are closed
+	ResumeGrace         time.Duration // How long the session of a registered client whose connection dropped can be resumed, 0 disables it
+	KeepaliveInterval   time.Duration // Clients using the keepalive feature that were sent nothing for this long get an empty frame, 0 disables it
+	TCPKeepalive        time.Duration // Idle time and interval of TCP keepalive probes, 0 for Go's defaults and negative to disable them
+
+	DisableFirstMessageAnnounce bool // Don't announce the first message clients send to a channel
+
+	ChannelCreateBucket int     // Maximum number of channels a client can create in a burst
+	ChannelCreateRate   float64 // Channel creations per second refilled for each client
+
+	WhisperBucket         int     // Maximum number of whispers a client can send in a burst
+	WhisperRate           float64 // Whispers per second refilled for each client
+	WhisperRecipientLimit int     // Maximum number of whispers a client can receive per minute, 0 for no limit
+
+	JoinFloodLimit    int           // Maximum number of joins within JoinFloodWindow, 0 disables the protection
+	JoinFloodWindow   time.Duration // Sliding window in which joins are counted
+	JoinFloodCooldown time.Duration // How long joins are refused after exceeding the limit
+	JoinFloodMute     time.Duration // How long clients that keep flooding joins are muted
+
+	ReadBufferSize  int // Size of each client's buffered reader in bytes
+	WriteBufferSize int // Size of each client's buffered writer in bytes
+	SendQueueSize   int // Number of messages queued for a client before it's disconnected as too slow
+	MaxLineLength   int // Longest line a client can send in bytes, clients sending longer ones are disconnected
+
+	MaxMessageLength int // Longest chat message in characters, advertised to clients when they connect
+
+	RateLimiter string // Name of the limiter applied to the messages and commands of each client, one of rateLimiters
+
+	SlowQueueThreshold   int           // Number of queued messages above which a client is considered to be falling behind
+	SlowClientAfter      time.Duration // How long a client's queue stays above the threshold before it's reported as slow
+	DowngradeSlowClients bool          // Only send slow clients the messages of their active channel
+
+	PersistMessages bool  // Append channel messages to log files in the messages directory
+	MessageLogSize  int64 // Size in bytes past which a channel's message log is rotated
+
+	BanFile string // File of banned IP addresses, one per line with an optional duration, none if empty
+
+	MOTDFile string // File with the message of the day sent to clients when they connect, none if empty
+
+	AuthFile string // JSON file registered nicknames are saved to, clients must log in to use them if set
+
+	ModerationFile string // JSON file shadow mutes are saved to, kept in memory only if empty
+	StateFile      string // JSON file channel topics, pins, password hints and hidden flags are saved to, kept in memory only if empty
+
+	EventPipe       string // Path of the named pipe server events are written to, none if empty
+	EventPipeBuffer int    // Number of events queued for the event pipe before new ones are dropped
+
+	MetricsAddr       string // Address the metrics endpoint listens on, disabled if empty
+	MetricsMinMembers int    // Members a channel needs to get its own metrics, so the number of series stays bounded
+
+	LogLevel      slog.Level // Least severe level logged
+	LogFormat     string     // How logs are written, one of logFormats
+	LogSubsystems []string   // Subsystems logged below warn level, all of them if empty
+
+	Debug bool // Enables debugging commands like /goroutines for every client, not just local ones
+
+	CommandCooldowns map[string]time.Duration // Overrides the default cooldown of commands by name, 0 removes it
+
+	ReservedNames []string // Usernames nobody can take, on top of the names the server sends messages as
+}
+
+// Validate checks the whole configuration before the server starts, returning every problem found rather than the first one:
+// flag values out of range, a listen address that doesn't resolve, files that can't be read or parsed,
+// and storage paths that can't be written to
+func (c Config) Validate() []error {
+	var problems []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			problems = append(problems, fmt.Errorf(format, args...))
+		}
+	}
+
+	if _, err := net.ResolveTCPAddr("tcp", net.JoinHostPort(c.Host, c.Port)); err != nil {
+		problems = append(problems, fmt.Errorf("-host and -port don't make an address to listen on: %w", err))
+	}
+
+	check(c.PingInterval > 0, "-ping-interval must be positive")
+	check(c.KeepaliveInterval == 0 || c.KeepaliveInterval >= time.Second, "-keepalive-interval must be 0 or at least 1s")
+	check(c.BroadcastWait > 0, "-broadcast-wait must be positive")
+	check(c.SweepInterval >= 0 && c.StaleChannelAge >= 0 && c.InviteCodeRetention >= 0,
+		"-sweep-interval, -stale-channel-days and -invite-code-retention can't be negative")
+	check(c.MaxChannels >= 0, "-max-channels can't be negative")
+	check(c.NameCooldown >= 0 && c.IdleAway >= 0 && c.IdleTimeout >= 0 && c.RegistrationTimeout >= 0 && c.ResumeGrace >= 0,
+		"-name-cooldown, -idle-away, -idle-timeout, -registration-timeout and -resume-grace can't be negative")
+	check(c.ChannelCreateBucket >= 1 && c.ChannelCreateRate > 0, "-channel-create-bucket and -channel-create-rate must be positive")
+	check(c.WhisperBucket >= 1 && c.WhisperRate > 0, "-whisper-bucket and -whisper-rate must be positive")
+	check(c.WhisperRecipientLimit >= 0, "-whisper-recipient-limit can't be negative")
+	check(c.JoinFloodLimit >= 0 && c.JoinFloodCooldown >= 0 && c.JoinFloodMute >= 0,
+		"-join-flood-limit, -join-flood-cooldown and -join-flood-mute can't be negative")
+	check(c.JoinFloodLimit == 0 || c.JoinFloodWindow > 0, "-join-flood-window must be positive")
+	check(c.ReadBufferSize >= 16 && c.WriteBufferSize >= 16, "-read-buffer and -write-buffer must be at least 16")
+	check(c.SendQueueSize >= 1 && c.MaxLineLength >= 1 && c.MaxMessageLength >= 1,
+		"-send-queue, -max-line-length and -max-message-length must be positive")
+	check(c.SlowQueueThreshold >= 1 && c.SlowQueueThreshold <= c.SendQueueSize, "-slow-queue-threshold must be between 1 and -send-queue")
+	check(c.SlowClientAfter > 0, "-slow-client-after must be positive")
+	check(c.MessageLogSize >= 1<<20, "-message-log-size must be at least 1")
+	check(c.EventPipe == "" || c.EventPipeBuffer >= 1, "-event-pipe-buffer must be positive")
+	check(c.MetricsMinMembers >= 0, "-metrics-min-members can't be negative")
+	if c.MetricsAddr != "" {
+		if _, err := net.ResolveTCPAddr("tcp", c.MetricsAddr); err != nil {
+			problems = append(problems, fmt.Errorf("-metrics-addr: %w", err))
+		}
+	}
+
+	_, ok := rateLimiters[c.RateLimiter]
+	check(ok, "-rate-limiter must be token-bucket or sliding-window")
+	check(slices.Contains(logFormats, c.LogFormat), "-log-format must be auto, pretty or text")
+	for _, subsystem := range c.LogSubsystems {
+		check(slices.Contains(logSubsystems, subsystem), "-log-subsystems: unknown subsystem '%s', expected one of %s", subsystem, strings.Join(logSubsystems, ", "))
+	}
+
+	// The files are loaded the way the server loads them, so their contents are checked too
+	if _, err := loadMOTD(c.MOTDFile); err != nil {
+		problems = append(problems, fmt.Errorf("-motd: %w", err))
+	}
+	if c.BanFile != "" {
+		if _, _, err := parseBanFile(c.BanFile); err != nil {
+			problems = append(problems, fmt.Errorf("-ban-file: %w", err))
+		}
+	}
+	if c.AuthFile != "" {
+		// Registrations are saved next to the file before replacing it
+		if _, err := newNickStore(c.AuthFile); err != nil {
+			problems = append(problems, fmt.Errorf("-auth-file: %w", err))
+		} else if err := checkWritableDir(filepath.Dir(c.AuthFile)); err != nil {
+			problems = append(problems, fmt.Errorf("-auth-file: %w", err))
+		}
+	}
+	if c.ModerationFile != "" {
+		if err := readJSONFile(c.ModerationFile, &moderationState{}); err != nil {
+			problems = append(problems, fmt.Errorf("-moderation-file: %w", err))
+		} else if err := checkWritableDir(filepath.Dir(c.ModerationFile)); err != nil {
+			problems = append(problems, fmt.Errorf("-moderation-file: %w", err))
+		}
+	}
+	if c.StateFile != "" {
+		if err := readJSONFile(c.StateFile, &serverState{}); err != nil {
+			problems = append(problems, fmt.Errorf("-state-file: %w", err))
+		} else if err := checkWritableDir(filepath.Dir(c.StateFile)); err != nil {
+			problems = append(problems, fmt.Errorf("-state-file: %w", err))
+		}
+	}
+	if c.PersistMessages {
+		if err := checkWritableDir(messageLogDir); err != nil {
+			problems = append(problems, fmt.Errorf("-persist-messages: %w", err))
+		}
+	}
+	if c.EventPipe != "" {
+		if err := checkWritableDir(filepath.Dir(c.EventPipe)); err != nil {
+			problems = append(problems, fmt.Errorf("-event-pipe: %w", err))
+		}
+	}
+	return problems
+}
+
+// checkWritableDir makes sure files can be created in the directory, by creating one and removing it.
+// Directories that don't exist yet are fine if they can be created in their parent.
+func checkWritableDir(dir string) error {
+	for {
+		info, err := os.Stat(dir)
+		if errors.Is(err, os.ErrNotExist) && filepath.Dir(dir) != dir {
+			dir = filepath.Dir(dir)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+		break
+	}
+
+	file, err := os.CreateTemp(dir, ".write-check-*")
+	if err != nil {
+		return fmt.Errorf("%s is not writable: %w", dir, err)
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// parseReservedNames parses a comma separated list of usernames like "admin,moderator"
+func parseReservedNames(value string) []string {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// parseLogSubsystems parses a comma separated list of subsystems like "client,storage"
+func parseLogSubsystems(value string) []string {
+	var subsystems []string
+	for _, subsystem := range strings.Split(value, ",") {
+		if subsystem = strings.ToLower(strings.TrimSpace(subsystem)); subsystem != "" {
+			subsystems = append(subsystems, subsystem)
+		}
+	}
+	return subsystems
+}
+
+// parseCommandCooldowns parses a comma separated list of command cooldowns like "channels=10s,clients=0"
+func parseCommandCooldowns(value string) (map[string]time.Duration, error) {
+	cooldowns := make(map[string]time.Duration)
+	if strings.TrimSpace(value) == "" {
+		return cooldowns, nil
+	}
+
+	for _, entry := range strings.Split(value, ",") {
+		name, durationText, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid command cooldown '%s', expected <command>=<duration>", entry)
+		}
+
+		duration, err := time.ParseDuration(durationText)
+		if err != nil || duration < 0 {
+			return nil, fmt.Errorf("invalid duration for command '%s': '%s'", name, durationText)
+		}
+		cooldowns[strings.TrimPrefix(name, "/")] = duration
+	}
+	return cooldowns, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// confusableRunes maps characters that look alike in most fonts to a single representative.
+// Names are lowercased before the lookup, so only lowercase forms are needed.
+// Only unambiguous look-alikes are mapped: 'i' and 'l' are told apart in most fonts and mapping
+// one to the other would refuse many real names, e.g. "ali" for looking like "all".
+var confusableRunes = map[rune]rune{
+	// Digits and symbols
+	'0': 'o',
+	'1': 'l',
+	'3': 'e',
+	'4': 'a',
+	'@': 'a',
+	'5': 's',
+	'$': 's',
+	'7': 't',
+	'8': 'b',
+
+	// Cyrillic
+	'а': 'a',
+	'в': 'b',
+	'е': 'e',
+	'ё': 'e',
+	'і': 'i',
+	'ї': 'i',
+	'ј': 'j',
+	'к': 'k',
+	'м': 'm',
+	'н': 'h',
+	'о': 'o',
+	'р': 'p',
+	'с': 'c',
+	'т': 't',
+	'у': 'y',
+	'х': 'x',
+	'ѕ': 's',
+	'ԁ': 'd',
+	'ԛ': 'q',
+	'ԝ': 'w',
+
+	// Greek
+	'α': 'a',
+	'β': 'b',
+	'ε': 'e',
+	'ι': 'i',
+	'κ': 'k',
+	'ν': 'v',
+	'ο': 'o',
+	'ρ': 'p',
+	'τ': 't',
+	'υ': 'u',
+	'χ': 'x',
+}
+
+// confusableSequences are groups of characters that look like a single one.
+// "cl" and "d" are left out, they only look alike in some fonts and many names start with "cl".
+var confusableSequences = strings.NewReplacer(
+	"rn", "m",
+	"vv", "w",
+)
+
+// normalizeUsername reduces a username to a form where names that look alike are equal.
+// It's only used for comparisons, never shown to users.
+func normalizeUsername(username string) string {
+	// Compatibility normalization turns fullwidth letters, ligatures and similar forms into plain ones
+	username = norm.NFKC.String(username)
+
+	var builder strings.Builder
+	builder.Grow(len(username))
+	for _, r := range username {
+		// Drop invisible characters such as zero width spaces and joiners
+		if unicode.Is(unicode.Cf, r) || unicode.IsSpace(r) {
+			continue
+		}
+
+		r = unicode.ToLower(r)
+		if replacement, ok := confusableRunes[r]; ok {
+			r = replacement
+		}
+		builder.WriteRune(r)
+	}
+
+	return confusableSequences.Replace(builder.String())
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeUsername(t *testing.T) {
+	tests := []struct {
+		name      string
+		a, b      string
+		lookAlike bool
+	}{
+		{name: "same", a: "alice", b: "alice", lookAlike: true},
+		{name: "case", a: "Alice", b: "aLICE", lookAlike: true},
+		{name: "digits", a: "b0b", b: "bob", lookAlike: true},
+		{name: "one for l", a: "a1ice", b: "alice", lookAlike: true},
+		{name: "cyrillic", a: "аlice", b: "alice", lookAlike: true},
+		{name: "cyrillic i", a: "аlіce", b: "alice", lookAlike: true},
+		{name: "greek", a: "αlice", b: "alice", lookAlike: true},
+		{name: "fullwidth", a: "ａｌｉｃｅ", b: "alice", lookAlike: true},
+		{name: "zero width space", a: "ali​ce", b: "alice", lookAlike: true},
+		{name: "rn for m", a: "rnallory", b: "mallory", lookAlike: true},
+		{name: "vv for w", a: "vvalter", b: "walter", lookAlike: true},
+
+		{name: "different names", a: "alice", b: "bob", lookAlike: false},
+		{name: "i and l", a: "ali", b: "all", lookAlike: false},
+		{name: "capital i and l", a: "Ian", b: "lan", lookAlike: false},
+		{name: "cl and d", a: "clay", b: "day", lookAlike: false},
+		{name: "cyrillic i and l", a: "аlі", b: "all", lookAlike: false},
+		{name: "exclamation mark", a: "bob!", b: "bobl", lookAlike: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a, b := normalizeUsername(test.a), normalizeUsername(test.b)
+			if (a == b) != test.lookAlike {
+				t.Errorf("%q normalizes to %q and %q to %q, want them to look alike: %t", test.a, a, test.b, b, test.lookAlike)
+			}
+		})
+	}
+}
+
+func TestChangeUsernameLookalikes(t *testing.T) {
+	tests := []struct {
+		name       string
+		connected  []string // Usernames of other registered clients
+		registered []string // Usernames registered in the auth file
+		loggedInAs string
+		username   string
+		wantErr    string
+	}{
+		{name: "free", connected: []string{"bob"}, username: "alice"},
+		{name: "taken", connected: []string{"alice"}, username: "alice", wantErr: "'alice' is already taken"},
+		{name: "connected lookalike", connected: []string{"alice"}, username: "Аlice", wantErr: "too similar to the existing user 'alice'"},
+		{name: "registered lookalike", registered: []string{"alice"}, username: "a1ice", wantErr: "too similar to the registered user 'alice'"},
+		{name: "own registered username", registered: []string{"alice"}, loggedInAs: "alice", username: "Alice"},
+		{name: "unambiguous", registered: []string{"all"}, connected: []string{"clay"}, username: "ali"},
+		{name: "reserved", username: "5erver", wantErr: "'5erver' is reserved"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, nil)
+			server.nicks.file.path = filepath.Join(t.TempDir(), "nicks.json")
+			for _, username := range test.connected {
+				connect(server, username)
+			}
+			for _, username := range test.registered {
+				registerNickname(t, server, username)
+			}
+
+			client := connect(server, "newcomer")
+			client.loggedInAs = test.loggedInAs
+			err := server.changeUsername(client, client.clientsKey(), test.username)
+
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("got error %v", err)
+				}
+				if server.clients[test.username] != Session(client) {
+					t.Error("the client isn't known by its new username")
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("got error %v, want %q", err, test.wantErr)
+			}
+			if client.GetUsername() != "newcomer" {
+				t.Errorf("the username was changed to %q", client.GetUsername())
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"time"
+)
+
+// ServerEvent is written as a JSON line to the event pipe for external scripts to react to
+type ServerEvent struct {
+	Event   string    `json:"event"`
+	User    string    `json:"user,omitempty"`
+	IP      string    `json:"ip,omitempty"`
+	Channel string    `json:"channel,omitempty"`
+	Content string    `json:"content,omitempty"`
+	By      string    `json:"by,omitempty"` // Admin who kicked or banned the user
+	Time    time.Time `json:"ts"`
+}
+
+// eventPipe writes server events to a named pipe. Events are queued and written by a separate goroutine,
+// and dropped when nobody is reading or the queue is full, so the server is never stalled.
+type eventPipe struct {
+	path   string
+	events chan ServerEvent
+	logger *slog.Logger
+	done   chan struct{} // Closed once run has written the remaining events and returned
+}
+
+func newEventPipe(path string, buffer int, logger *slog.Logger) (*eventPipe, error) {
+	if err := createFifo(path); err != nil {
+		return nil, err
+	}
+
+	return &eventPipe{
+		path:   path,
+		events: make(chan ServerEvent, buffer),
+		logger: logger,
+		done:   make(chan struct{}),
+	}, nil
+}
+
+// emit queues the event without blocking
+func (p *eventPipe) emit(event ServerEvent) {
+	event.Time = time.Now()
+
+	select {
+	case p.events <- event:
+	default:
+		p.logger.Warn("Event pipe queue full, dropping event", "event", event.Event)
+	}
+}
+
+// run writes the queued events to the pipe, opening it again whenever a reader shows up
+func (p *eventPipe) run() {
+	defer close(p.done)
+
+	var writer *fifoWriter
+	defer func() {
+		if writer != nil {
+			writer.close()
+		}
+	}()
+
+	for event := range p.events {
+		if writer == nil {
+			var err error
+			if writer, err = openFifo(p.path); err != nil {
+				continue // Nobody is reading the pipe
+			}
+		}
+
+		line, err := json.Marshal(event)
+		if err != nil {
+			p.logger.Error("Failed to encode event", "event", event.Event, "error", err)
+			continue
+		}
+
+		if err := writer.write(append(line, '\n')); err != nil {
+			if isPipeFull(err) {
+				continue // The reader is too slow, drop the event
+			}
+
+			// The reader went away, or stopped reading in the middle of a line
+			writer.close()
+			writer = nil
+		}
+	}
+}
+
+// close stops accepting events and waits for the queued ones to be written.
+// Nothing must emit events afterwards.
+func (p *eventPipe) close() {
+	close(p.events)
+	<-p.done
+}
+
+// emitEvent sends the event to the event pipe if there is one
+func (s *Server) emitEvent(event ServerEvent) {
+	if s.events != nil {
+		s.events.emit(event)
+	}
+}
@@ -0,0 +1,27 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+var errEventPipeUnsupported = errors.New("event pipes are only supported on Unix systems")
+
+type fifoWriter struct{}
+
+func createFifo(path string) error {
+	return errEventPipeUnsupported
+}
+
+func openFifo(path string) (*fifoWriter, error) {
+	return nil, errEventPipeUnsupported
+}
+
+func (w *fifoWriter) write(data []byte) error {
+	return errEventPipeUnsupported
+}
+
+func (w *fifoWriter) close() {}
+
+func isPipeFull(err error) bool {
+	return false
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"log/slog"
+	"slices"
+	"testing"
+	"time"
+)
+
+// queuedEvents empties the event queue, returning the events with their time cleared
+func queuedEvents(pipe *eventPipe) []ServerEvent {
+	var events []ServerEvent
+	for {
+		select {
+		case event := <-pipe.events:
+			event.Time = time.Time{}
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestBanEvents(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		connected map[string]string // IPs of the connected clients by username
+		want      []ServerEvent
+	}{
+		{
+			name: "nobody connected",
+			args: []string{"198.51.100.1"},
+			want: []ServerEvent{{Event: "ban", IP: "198.51.100.1", By: "alice"}},
+		},
+		{
+			name:      "connected client",
+			args:      []string{"198.51.100.1", "1h"},
+			connected: map[string]string{"bob": "198.51.100.1:40000", "carol": "198.51.100.2:40000"},
+			want: []ServerEvent{
+				{Event: "ban", IP: "198.51.100.1", By: "alice"},
+				{Event: "kick", User: "bob", IP: "198.51.100.1", By: "alice"},
+			},
+		},
+		{name: "invalid address", args: []string{"nowhere"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, nil)
+			server.events = &eventPipe{events: make(chan ServerEvent, 8), logger: slog.New(slog.DiscardHandler)}
+			alice := connect(server, "alice")
+			alice.SetAdmin(true)
+			for username, ip := range test.connected {
+				connect(server, username).ip = ip
+			}
+
+			server.runCommand(Command{Name: "banip", Args: test.args, Client: alice, ReceivedAt: time.Now()})
+			if got := queuedEvents(server.events); !slices.Equal(got, test.want) {
+				t.Errorf("emitted %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// fifoWriteTimeout is how long the rest of a line written in part waits for the reader to make room for it
+const fifoWriteTimeout = time.Second
+
+var errFifoStalled = errors.New("the reader stopped reading in the middle of a line")
+
+// fifoWriter writes to a named pipe opened in non-blocking mode. The file descriptor is used directly
+// since an os.File would wait for the pipe to have room instead of failing.
+type fifoWriter struct {
+	fd int
+}
+
+// createFifo creates the named pipe, reusing it if it already exists
+func createFifo(path string) error {
+	info, err := os.Stat(path)
+	if err == nil {
+		if info.Mode()&os.ModeNamedPipe == 0 {
+			return fmt.Errorf("'%s' already exists and is not a named pipe", path)
+		}
+		return nil
+	}
+
+	return syscall.Mkfifo(path, 0o600)
+}
+
+// openFifo opens the named pipe for writing, failing if nobody has it open for reading
+func openFifo(path string) (*fifoWriter, error) {
+	fd, err := syscall.Open(path, syscall.O_WRONLY|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &fifoWriter{fd: fd}, nil
+}
+
+// write writes the line to the pipe. Writes of up to PIPE_BUF bytes are all or nothing, but a longer line can be
+// written in part when the pipe fills up. The rest is then written as the reader makes room for it, so the reader
+// never gets a torn line, and errFifoStalled is returned if it doesn't in time, after which the pipe must be closed.
+func (w *fifoWriter) write(line []byte) error {
+	deadline := time.Now().Add(fifoWriteTimeout)
+	for written := 0; written < len(line); {
+		n, err := syscall.Write(w.fd, line[written:])
+		if n > 0 {
+			written += n
+		}
+
+		switch {
+		case err == nil:
+		case !errors.Is(err, syscall.EAGAIN):
+			return err
+		case written == 0:
+			return err // Nothing was written, so the line can be dropped as a whole
+		case time.Now().After(deadline):
+			return errFifoStalled
+		default:
+			time.Sleep(time.Millisecond)
+		}
+	}
+	return nil
+}
+
+func (w *fifoWriter) close() {
+	syscall.Close(w.fd)
+}
+
+func isPipeFull(err error) bool {
+	return errors.Is(err, syscall.EAGAIN)
+}
@@ -0,0 +1,122 @@
+//go:build unix
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestFifoWriter(t *testing.T) {
+	long := `{"content":"` + strings.Repeat("a", 1<<20) + `"}` // Longer than the pipe's buffer
+	tests := []struct {
+		name    string
+		lines   []string
+		read    bool // The reader keeps reading, otherwise it never does
+		wantErr error
+	}{
+		{name: "short lines", lines: []string{`{"event":"connect"}`, `{"event":"message"}`}, read: true},
+		{name: "line longer than the pipe", lines: []string{`{"event":"connect"}`, long, `{"event":"message"}`}, read: true},
+		{name: "reader stalls in the middle of a line", lines: []string{long}, wantErr: errFifoStalled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "events")
+			if err := createFifo(path); err != nil {
+				t.Fatal(err)
+			}
+			reader, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reader.Close()
+			writer, err := openFifo(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The reader only needs to block while the test reads what was written
+			if err := syscall.SetNonblock(int(reader.Fd()), false); err != nil {
+				t.Fatal(err)
+			}
+			received := make(chan []string)
+			if test.read {
+				go func() {
+					var lines []string
+					scanner := bufio.NewScanner(reader)
+					scanner.Buffer(nil, 2<<20)
+					for scanner.Scan() {
+						lines = append(lines, scanner.Text())
+					}
+					received <- lines
+				}()
+			}
+
+			for _, line := range test.lines {
+				if err = writer.write(append([]byte(line), '\n')); err != nil {
+					break
+				}
+			}
+			writer.close()
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("got error %v, want %v", err, test.wantErr)
+			}
+			if !test.read {
+				return
+			}
+
+			lines := <-received
+			if len(lines) != len(test.lines) {
+				t.Fatalf("read %d lines, want %d", len(lines), len(test.lines))
+			}
+			for i, line := range lines {
+				if line != test.lines[i] || !json.Valid([]byte(line)) {
+					t.Errorf("line %d was torn: %q", i, line[:min(len(line), 40)])
+				}
+			}
+		})
+	}
+}
+
+func TestFifoWriterDropsLinesWhenFull(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events")
+	if err := createFifo(path); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	writer, err := openFifo(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.close()
+
+	// Short lines fill the pipe without any of them being written in part
+	line := append(bytes.Repeat([]byte("a"), 99), '\n')
+	for {
+		err := writer.write(line)
+		if isPipeFull(err) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("got error %v, want the pipe to fill up", err)
+		}
+	}
+
+	data := make([]byte, 1<<20)
+	n, _ := reader.Read(data)
+	if n%len(line) != 0 {
+		t.Errorf("read %d bytes, want whole lines of %d", n, len(line))
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// FormattingMode controls which transformations are applied to the messages sent to a channel
+type FormattingMode string
+
+const (
+	FormattingFull  FormattingMode = "full"  // Every transformation, the same as basic until there are others
+	FormattingBasic FormattingMode = "basic" // Only emoji shortcodes are expanded
+	FormattingNone  FormattingMode = "none"  // Messages are relayed as typed
+)
+
+var formattingModes = []FormattingMode{FormattingFull, FormattingBasic, FormattingNone}
+
+// emojiShortcodes maps the shortcodes expanded in messages, e.g. :smile:, to their emoji
+var emojiShortcodes = map[string]string{
+	"smile":    "😄",
+	"grin":     "😁",
+	"joy":      "😂",
+	"wink":     "😉",
+	"cry":      "😢",
+	"thinking": "🤔",
+	"heart":    "❤️",
+	"thumbsup": "👍",
+	"+1":       "👍",
+	"wave":     "👋",
+	"ok_hand":  "👌",
+	"eyes":     "👀",
+	"fire":     "🔥",
+	"tada":     "🎉",
+	"rocket":   "🚀",
+	"100":      "💯",
+}
+
+var shortcodePattern = regexp.MustCompile(`:[a-z0-9_+]+:`)
+
+// formattingModesHelp explains what the modes do. Emoji shortcodes are the only formatting for now,
+// so operators are told full and basic are the same rather than left looking for a difference.
+const formattingModesHelp = "full and basic both expand emoji shortcodes such as :smile: and are the same for now, none relays messages as typed."
+
+// parseFormattingMode returns the mode with the given name, false if there's none
+func parseFormattingMode(name string) (FormattingMode, bool) {
+	mode := FormattingMode(name)
+	return mode, slices.Contains(formattingModes, mode)
+}
+
+// expandEmoji replaces the known emoji shortcodes in the content, leaving unknown ones as typed
+func expandEmoji(content string) string {
+	return shortcodePattern.ReplaceAllStringFunc(content, func(shortcode string) string {
+		if emoji, ok := emojiShortcodes[shortcode[1:len(shortcode)-1]]; ok {
+			return emoji
+		}
+		return shortcode
+	})
+}
+
+// formatContent applies the transformations the channel's formatting mode allows to a chat message.
+// Emoji expansion is the only transformation for now, so full and basic modes format messages the same way.
+func formatContent(mode FormattingMode, content string) string {
+	if mode == FormattingNone {
+		return content
+	}
+	return expandEmoji(content)
+}
+
+func formatting(name string, args []string, client Session, server *Server) {
+	joinedChannel := resolveJoinedChannel(nil, client)
+	if joinedChannel == nil {
+		return
+	}
+
+	if len(args) < 1 {
+		client.SendServerMessage(fmt.Sprintf("Formatting of '%s' is %s. Usage: /formatting <full|basic|none>\n%s", joinedChannel.Name, joinedChannel.FormattingMode(), formattingModesHelp))
+		return
+	}
+
+	mode, ok := parseFormattingMode(strings.ToLower(args[0]))
+	if !ok {
+		client.SendServerMessage("Usage: /formatting <full|basic|none>\n" + formattingModesHelp)
+		return
+	}
+
+	if mode == joinedChannel.FormattingMode() {
+		client.SendServerMessage(fmt.Sprintf("Formatting of '%s' is already %s.", joinedChannel.Name, mode))
+		return
+	}
+
+	joinedChannel.SetFormattingMode(mode)
+	server.broadcastMessage(nil, joinedChannel, fmt.Sprintf("%s set the channel's formatting to %s.", client.GetUsername(), mode))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseFormattingMode(t *testing.T) {
+	tests := []struct {
+		name   string
+		want   FormattingMode
+		wantOK bool
+	}{
+		{name: "full", want: FormattingFull, wantOK: true},
+		{name: "basic", want: FormattingBasic, wantOK: true},
+		{name: "none", want: FormattingNone, wantOK: true},
+		{name: "FULL", wantOK: false}, // Lowercased by /formatting before parsing
+		{name: "fancy", wantOK: false},
+		{name: "", wantOK: false},
+	}
+
+	for _, test := range tests {
+		mode, ok := parseFormattingMode(test.name)
+		if ok != test.wantOK || (ok && mode != test.want) {
+			t.Errorf("parseFormattingMode(%q) = %q, %t, want %q, %t", test.name, mode, ok, test.want, test.wantOK)
+		}
+	}
+}
+
+func TestFormatContent(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    FormattingMode
+		content string
+		want    string
+	}{
+		{name: "shortcode", mode: FormattingFull, content: "ship it :rocket:", want: "ship it 🚀"},
+		{name: "several shortcodes", mode: FormattingFull, content: ":+1::100:", want: "👍💯"},
+		{name: "basic mode", mode: FormattingBasic, content: ":fire:", want: "🔥"},
+		{name: "unknown shortcode", mode: FormattingFull, content: ":nope: :fire:", want: ":nope: 🔥"},
+		{name: "uppercase isn't a shortcode", mode: FormattingFull, content: ":FIRE:", want: ":FIRE:"},
+		{name: "times aren't shortcodes", mode: FormattingFull, content: "at 10:30:00", want: "at 10:30:00"},
+		{name: "no formatting", mode: FormattingNone, content: "ship it :rocket:", want: "ship it :rocket:"},
+
+		// There's no markdown pass yet, full mode formats messages just like basic
+		{name: "markdown in full mode", mode: FormattingFull, content: "**bold** _italic_", want: "**bold** _italic_"},
+		{name: "markdown in basic mode", mode: FormattingBasic, content: "**bold** _italic_", want: "**bold** _italic_"},
+		{name: "markdown without formatting", mode: FormattingNone, content: "**bold** _italic_", want: "**bold** _italic_"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := formatContent(test.mode, test.content); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRelayFormatsChatMessages(t *testing.T) {
+	tests := []struct {
+		name string
+		mode FormattingMode
+		chat bool
+		want string
+	}{
+		{name: "full", mode: FormattingFull, chat: true, want: "alice|general|hi 👋"},
+		{name: "none", mode: FormattingNone, chat: true, want: "alice|general|hi :wave:"},
+		{name: "server messages aren't formatted", mode: FormattingFull, chat: false, want: "alice|general|hi :wave:"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, func(config *Config) { config.DisableFirstMessageAnnounce = true })
+			alice, bob := connect(server, "alice"), connect(server, "bob")
+			channel := join(t, server, alice, "general")
+			join(t, server, bob, "general")
+			channel.SetFormattingMode(test.mode)
+			bob.sent = nil
+
+			server.relayMessage(Message{Sender: alice, SenderName: "alice", Channel: channel, Content: "hi :wave:", Chat: test.chat})
+			if len(bob.sent) != 1 || bob.sent[0] != test.want {
+				t.Errorf("bob got %q, want %q", bob.sent, test.want)
+			}
+		})
+	}
+}
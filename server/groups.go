@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+const (
+	maxWhisperRecipients = 10 // Most users a single /whisper can reach, after expanding groups
+	maxWhisperGroups     = 10 // Most groups a client can define with /group
+)
+
+// parseRecipients splits a comma-separated list of usernames and @groups into the usernames it stands for,
+// in order and without duplicates. The sender is silently left out.
+func parseRecipients(list string, client Session) ([]string, error) {
+	var usernames []string
+	add := func(username string) {
+		if username != "" && username != client.GetUsername() && !slices.Contains(usernames, username) {
+			usernames = append(usernames, username)
+		}
+	}
+
+	for _, recipient := range strings.Split(list, ",") {
+		groupName, isGroup := strings.CutPrefix(recipient, "@")
+		if !isGroup {
+			add(recipient)
+			continue
+		}
+
+		members, exists := client.State().whisperGroups[groupName]
+		if !exists {
+			return nil, fmt.Errorf("Group '@%s' not found. Use /group %s <user1,user2,...> to define it.", groupName, groupName)
+		}
+		for _, member := range members {
+			add(member)
+		}
+	}
+	return usernames, nil
+}
+
+// group defines a named set of users to whisper with /whisper @name for the rest of the session,
+// shows one, or lists them all
+func group(name string, args []string, client Session, server *Server) {
+	switch len(args) {
+	case 0:
+		if len(client.State().whisperGroups) == 0 {
+			client.SendServerMessage("You have no groups. Use /group <name> <user1,user2,...> to define one.")
+			return
+		}
+
+		lines := []string{"Your groups:"}
+		for _, groupName := range slices.Sorted(maps.Keys(client.State().whisperGroups)) {
+			lines = append(lines, fmt.Sprintf("@%s: %s", groupName, strings.Join(client.State().whisperGroups[groupName], ", ")))
+		}
+		client.SendServerMessage(strings.Join(lines, "\n"))
+	case 1:
+		groupName := strings.TrimPrefix(args[0], "@")
+		members, exists := client.State().whisperGroups[groupName]
+		if !exists {
+			client.SendServerMessage(fmt.Sprintf("Group '@%s' not found.", groupName))
+			return
+		}
+		client.SendServerMessage(fmt.Sprintf("@%s: %s", groupName, strings.Join(members, ", ")))
+	case 2:
+		groupName := strings.TrimPrefix(args[0], "@")
+		if groupName == "" || strings.Contains(groupName, ",") {
+			client.SendServerMessage("Group names can't be empty or contain commas.")
+			return
+		}
+
+		// Groups can't contain other groups, so they are always usernames
+		var members []string
+		for _, username := range strings.Split(args[1], ",") {
+			if strings.HasPrefix(username, "@") {
+				client.SendServerMessage("Groups can only contain usernames.")
+				return
+			}
+			if username != "" && username != client.GetUsername() && !slices.Contains(members, username) {
+				members = append(members, username)
+			}
+		}
+
+		if len(members) == 0 {
+			client.SendServerMessage("A group needs at least one other user.")
+			return
+		}
+		if len(members) > maxWhisperRecipients {
+			client.SendServerMessage(fmt.Sprintf("Groups can have at most %d users.", maxWhisperRecipients))
+			return
+		}
+
+		_, replacing := client.State().whisperGroups[groupName]
+		if !replacing && len(client.State().whisperGroups) >= maxWhisperGroups {
+			client.SendServerMessage(fmt.Sprintf("You can have at most %d groups.", maxWhisperGroups))
+			return
+		}
+
+		if client.State().whisperGroups == nil {
+			client.State().whisperGroups = make(map[string][]string)
+		}
+		client.State().whisperGroups[groupName] = members
+		client.SendServerMessage(fmt.Sprintf("Group '@%s' is now %s. Use /whisper @%s <message> to whisper to them.", groupName, strings.Join(members, ", "), groupName))
+	default:
+		client.SendServerMessage("Usage: /group [name] [user1,user2,...]")
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+const (
+	historySize         = 100 // Messages kept in memory per channel for /history
+	defaultHistoryLines = 20  // Messages shown by /history without a count
+	exportLinesPerChunk = 20  // Messages sent per chunk of /export, more if the stream would be too long otherwise
+
+	messageLogDir      = "messages"
+	messageLogTailSize = 512 << 10 // Bytes read from the end of a log to fill a channel's history on startup
+)
+
+// HistoryEntry is a chat message relayed to a channel, as kept in memory and written to the message log
+type HistoryEntry struct {
+	Sender    string    `json:"sender"`
+	Content   string    `json:"content"`
+	Time      time.Time `json:"ts"`
+	VisibleTo string    `json:"visible_to,omitempty"` // Only shown to this user if set, like the messages of shadow muted users
+}
+
+// visibleTo returns the entries the user can see, in the same order
+func visibleTo(entries []HistoryEntry, username string) []HistoryEntry {
+	return slices.DeleteFunc(slices.Clone(entries), func(entry HistoryEntry) bool {
+		return entry.VisibleTo != "" && entry.VisibleTo != username
+	})
+}
+
+// messageRing keeps the last historySize messages of a channel
+type messageRing struct {
+	entries []HistoryEntry
+	next    int // Index the next entry is written to once the ring is full
+}
+
+func (r *messageRing) add(entry HistoryEntry) {
+	if len(r.entries) < historySize {
+		r.entries = append(r.entries, entry)
+		return
+	}
+
+	r.entries[r.next] = entry
+	r.next = (r.next + 1) % historySize
+}
+
+// newest returns the most recent entry, false if there are none
+func (r *messageRing) newest() (HistoryEntry, bool) {
+	if len(r.entries) == 0 {
+		return HistoryEntry{}, false
+	}
+	return r.entries[(r.next+len(r.entries)-1)%len(r.entries)], true
+}
+
+// last returns up to n of the most recent entries, oldest first
+func (r *messageRing) last(n int) []HistoryEntry {
+	ordered := append(append([]HistoryEntry{}, r.entries[r.next:]...), r.entries[:r.next]...)
+	if n < len(ordered) {
+		ordered = ordered[len(ordered)-n:]
+	}
+	return ordered
+}
+
+// messageLog appends the messages of every channel to messages/<channel>.log as JSON lines.
+// A log that grows past maxSize is moved to <channel>.log.1, replacing the previous one.
+// Only accessed from the server's run loop.
+type messageLog struct {
+	dir     string
+	maxSize int64
+	files   map[string]*os.File
+	sizes   map[string]int64
+}
+
+func newMessageLog(dir string, maxSize int64) (*messageLog, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &messageLog{
+		dir:     dir,
+		maxSize: maxSize,
+		files:   make(map[string]*os.File),
+		sizes:   make(map[string]int64),
+	}, nil
+}
+
+// path returns the log file of the channel, escaping the name so it can't point outside the log directory
+func (l *messageLog) path(channel string) string {
+	return filepath.Join(l.dir, url.PathEscape(channel)+".log")
+}
+
+// Append writes the entry to the channel's log, rotating it first if it would grow too large
+func (l *messageLog) Append(channel string, entry HistoryEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	file, err := l.open(channel)
+	if err != nil {
+		return err
+	}
+
+	if l.sizes[channel] > 0 && l.sizes[channel]+int64(len(line)) > l.maxSize {
+		if file, err = l.rotate(channel); err != nil {
+			return err
+		}
+	}
+
+	n, err := file.Write(line)
+	l.sizes[channel] += int64(n)
+	return err
+}
+
+// open returns the channel's log file, opening it for appending the first time
+func (l *messageLog) open(channel string) (*os.File, error) {
+	if file, ok := l.files[channel]; ok {
+		return file, nil
+	}
+
+	file, err := os.OpenFile(l.path(channel), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	l.files[channel] = file
+	l.sizes[channel] = info.Size()
+	return file, nil
+}
+
+// rotate moves the channel's log aside and starts a new one
+func (l *messageLog) rotate(channel string) (*os.File, error) {
+	l.files[channel].Close()
+	delete(l.files, channel)
+
+	if err := os.Rename(l.path(channel), l.path(channel)+".1"); err != nil {
+		return nil, err
+	}
+	return l.open(channel)
+}
+
+// Tail returns up to n of the most recent entries of the channel's current log, oldest first
+func (l *messageLog) Tail(channel string, n int) ([]HistoryEntry, error) {
+	file, err := os.Open(l.path(channel))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	offset := max(0, info.Size()-messageLogTailSize)
+	data := make([]byte, info.Size()-offset)
+	if _, err := file.ReadAt(data, offset); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	// Reading from the middle of the file most likely starts halfway through a line
+	if offset > 0 {
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			data = data[i+1:]
+		}
+	}
+
+	entries, err := decodeHistory(bytes.NewReader(data))
+	if n < len(entries) {
+		entries = entries[len(entries)-n:]
+	}
+	return entries, err
+}
+
+// ReadAll returns every entry logged for the channel, including the rotated log, oldest first
+func (l *messageLog) ReadAll(channel string) ([]HistoryEntry, error) {
+	var entries []HistoryEntry
+	for _, path := range []string{l.path(channel) + ".1", l.path(channel)} {
+		file, err := os.Open(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		fileEntries, err := decodeHistory(file)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, fileEntries...)
+	}
+	return entries, nil
+}
+
+// decodeHistory reads JSON lines, skipping the ones that can't be decoded (e.g. a line cut short by a crash)
+func decodeHistory(r io.Reader) ([]HistoryEntry, error) {
+	var entries []HistoryEntry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for scanner.Scan() {
+		var entry HistoryEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// Prune closes the log files of the channels that are no longer kept, returning how many were closed.
+// The files stay on disk, so a channel created again with the same name gets its history back.
+func (l *messageLog) Prune(keep func(channel string) bool) int {
+	pruned := 0
+	for channel, file := range l.files {
+		if keep(channel) {
+			continue
+		}
+
+		file.Close()
+		delete(l.files, channel)
+		delete(l.sizes, channel)
+		pruned++
+	}
+	return pruned
+}
+
+// Close closes the open log files
+func (l *messageLog) Close() {
+	for channel, file := range l.files {
+		file.Close()
+		delete(l.files, channel)
+	}
+}
+
+// recordMessage adds a chat message relayed to a channel to its history, and to its log if messages are persisted.
+// If visibleTo isn't empty, only that user sees the message in the history.
+func (s *Server) recordMessage(msg Message, visibleTo string) {
+	entry := HistoryEntry{
+		Sender:    msg.SenderName,
+		Content:   msg.Content,
+		Time:      time.Now(),
+		VisibleTo: visibleTo,
+	}
+	msg.Channel.AddHistory(entry)
+
+	if s.messageLog == nil {
+		return
+	}
+	if err := s.messageLog.Append(msg.Channel.Name, entry); err != nil {
+		s.log.storage.Error("Failed to write message log", "channel", msg.Channel.Name, "error", err)
+	}
+}
+
+// restoreHistory fills the history of a new channel from the end of its log
+func (s *Server) restoreHistory(channel *Channel) {
+	if s.messageLog == nil {
+		return
+	}
+
+	entries, err := s.messageLog.Tail(channel.Name, historySize)
+	if err != nil {
+		s.log.storage.Error("Failed to read message log", "channel", channel.Name, "error", err)
+	}
+	for _, entry := range entries {
+		channel.AddHistory(entry)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// historyEntries returns n entries sent by alice, numbered from 0
+func historyEntries(n int) []HistoryEntry {
+	entries := make([]HistoryEntry, n)
+	for i := range entries {
+		entries[i] = HistoryEntry{Sender: "alice", Content: fmt.Sprintf("message %d", i), Time: time.Unix(int64(i), 0).UTC()}
+	}
+	return entries
+}
+
+func TestExportChunks(t *testing.T) {
+	tests := []struct {
+		name       string
+		entries    int
+		wantChunks int
+	}{
+		{name: "empty", entries: 0, wantChunks: 0},
+		{name: "one", entries: 1, wantChunks: 1},
+		{name: "one chunk", entries: exportLinesPerChunk, wantChunks: 1},
+		{name: "two chunks", entries: exportLinesPerChunk + 1, wantChunks: 2},
+		{name: "as many chunks as a stream holds", entries: exportLinesPerChunk * maxStreamChunks, wantChunks: maxStreamChunks},
+		{name: "larger chunks", entries: exportLinesPerChunk*maxStreamChunks + 1, wantChunks: 48}, // 21 entries each
+		{name: "whole log", entries: 100_000, wantChunks: maxStreamChunks},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chunks, err := exportChunks(historyEntries(test.entries))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(chunks) != test.wantChunks {
+				t.Errorf("got %d chunks, want %d", len(chunks), test.wantChunks)
+			}
+
+			// Every entry is exported once, in order
+			var i int
+			for _, chunk := range chunks {
+				content, ok := strings.CutPrefix(chunk, formatMessage("Server", ""))
+				if !ok {
+					t.Fatalf("chunk %q isn't a server message", chunk)
+				}
+				for line := range strings.SplitSeq(content, "\n") {
+					var entry HistoryEntry
+					if err := json.Unmarshal([]byte(line), &entry); err != nil {
+						t.Fatalf("line %q: %v", line, err)
+					}
+					if want := fmt.Sprintf("message %d", i); entry.Content != want {
+						t.Fatalf("line %d is %q, want %q", i, entry.Content, want)
+					}
+					i++
+				}
+			}
+			if i != test.entries {
+				t.Errorf("exported %d entries, want %d", i, test.entries)
+			}
+		})
+	}
+}
+
+func TestExportReadsTheLogOffTheRunLoop(t *testing.T) {
+	server := newTestServer(t, nil)
+	messageLog, err := newMessageLog(t.TempDir(), 10<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.messageLog = messageLog
+	for _, entry := range historyEntries(500) {
+		if err := messageLog.Append("general", entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	alice := connect(server, "alice")
+	join(t, server, alice, "general")
+	alice.sent = nil
+
+	server.runCommand(Command{Name: "export", Client: alice, ReceivedAt: time.Now()})
+	if server.pendingWork == 0 || len(alice.sent) > 0 {
+		t.Fatalf("the export was sent right away: %q", alice.sent)
+	}
+
+	finishWork(t, server)
+	if len(alice.sent) != 500/exportLinesPerChunk+2 {
+		t.Fatalf("got %d frames, want %d chunks between the stream markers", len(alice.sent), 500/exportLinesPerChunk)
+	}
+	if alice.sent[0] != streamStartMarker || alice.sent[len(alice.sent)-1] != streamEndMarker {
+		t.Errorf("the export isn't sent as a stream: %q", alice.sent)
+	}
+	if !alice.received(`"content":"message 499"`) {
+		t.Error("the last message of the log wasn't exported")
+	}
+}
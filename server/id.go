@@ -0,0 +1,39 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+)
+
+const maxIDAttempts = 10 // UUIDs generated before falling back to a longer random ID
+
+// generateUniqueID returns a random UUID v4 that isn't a key of existing.
+// Collisions are practically impossible, but if they keep happening it falls back to 32 random bytes in hex.
+func generateUniqueID[V any](existing map[string]V) string {
+	return uniqueID(existing, newUUID)
+}
+
+// uniqueID is generateUniqueID with the UUIDs tried coming from newID
+func uniqueID[V any](existing map[string]V, newID func() string) string {
+	for range maxIDAttempts {
+		id := newID()
+		if _, taken := existing[id]; !taken {
+			return id
+		}
+	}
+
+	random := make([]byte, 32)
+	rand.Read(random)
+	return hex.EncodeToString(random)
+}
+
+// newUUID returns a random (version 4) UUID
+func newUUID() string {
+	uuid := make([]byte, 16)
+	rand.Read(uuid)
+	uuid[6] = (uuid[6] & 0x0f) | 0x40 // Version 4
+	uuid[8] = (uuid[8] & 0x3f) | 0x80 // RFC 4122 variant
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16])
+}
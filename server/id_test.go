@@ -0,0 +1,86 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestGenerateUniqueIDHasNoCollisions(t *testing.T) {
+	const ids = 1_000_000
+	if testing.Short() {
+		t.Skip("generating a million IDs")
+	}
+
+	existing := make(map[string]struct{}, ids)
+	for range ids {
+		id := generateUniqueID(existing)
+		if _, taken := existing[id]; taken {
+			t.Fatalf("ID %s was generated twice after %d IDs", id, len(existing))
+		}
+		existing[id] = struct{}{}
+	}
+}
+
+func TestUniqueID(t *testing.T) {
+	const taken = "00000000-0000-4000-8000-000000000000"
+
+	tests := []struct {
+		name     string
+		existing map[string]bool
+		newID    func() string
+		want     *regexp.Regexp
+	}{
+		{
+			name:     "free UUID",
+			existing: map[string]bool{},
+			newID:    newUUID,
+			want:     uuidPattern,
+		},
+		{
+			name:     "UUID taken once",
+			existing: map[string]bool{taken: true},
+			newID: func() func() string {
+				calls := 0
+				return func() string {
+					calls++
+					if calls == 1 {
+						return taken
+					}
+					return newUUID()
+				}
+			}(),
+			want: uuidPattern,
+		},
+		{
+			name:     "every UUID taken",
+			existing: map[string]bool{taken: true},
+			newID:    func() string { return taken },
+			want:     regexp.MustCompile(`^[0-9a-f]{64}$`),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			id := uniqueID(test.existing, test.newID)
+			if !test.want.MatchString(id) {
+				t.Errorf("got %q, want an ID matching %s", id, test.want)
+			}
+			if test.existing[id] {
+				t.Errorf("got %q, which was already taken", id)
+			}
+		})
+	}
+}
+
+func TestUniqueIDTriesEveryAttempt(t *testing.T) {
+	attempts := 0
+	uniqueID(map[string]bool{"taken": true}, func() string {
+		attempts++
+		return "taken"
+	})
+	if attempts != maxIDAttempts {
+		t.Errorf("tried %d UUIDs before falling back, want %d", attempts, maxIDAttempts)
+	}
+}
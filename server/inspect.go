@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	rateLimitHitWindow   = 10 * time.Minute // How far back /inspect counts rate limit hits
+	maxRateLimitHitsKept = 100              // Most hits remembered per member, enough to tell someone is flooding
+	rateLimitedQueueSize = 1024             // Clients with rate limit hits that can wait for the run loop at once
+)
+
+// MembershipStats is what a channel tracks about one of its members for /inspect, dropped when the member leaves
+type MembershipStats struct {
+	JoinedAt      time.Time
+	Messages      int         // Chat messages relayed to the channel
+	rateLimitHits []time.Time // When messages to the channel were rate limited, oldest first, within rateLimitHitWindow
+}
+
+// RateLimitHit is a chat message a client sent to a channel that was rate limited
+type RateLimitHit struct {
+	Channel string
+	At      time.Time
+}
+
+// rateLimitHitQueue holds the rate limit hits counted by a client's Read goroutine until the run loop records them.
+// A client flooding a channel gets many of them, so they are handed over in batches and the Read goroutine never waits.
+type rateLimitHitQueue struct {
+	mu     sync.Mutex
+	hits   []RateLimitHit // Guarded by mu, at most maxRateLimitHitsKept
+	queued atomic.Bool    // Set while the client waits in the server's rateLimited channel
+}
+
+// countRateLimitHit adds the hit to those waiting for the run loop and has it record them, unless it's already going to.
+// Only the latest maxRateLimitHitsKept are kept, that's all /inspect would remember anyway.
+func (c *Client) countRateLimitHit(hit RateLimitHit) {
+	c.rateLimitHits.mu.Lock()
+	if len(c.rateLimitHits.hits) >= maxRateLimitHitsKept {
+		c.rateLimitHits.hits = c.rateLimitHits.hits[1:]
+	}
+	c.rateLimitHits.hits = append(c.rateLimitHits.hits, hit)
+	c.rateLimitHits.mu.Unlock()
+
+	if !c.rateLimitHits.queued.CompareAndSwap(false, true) {
+		return
+	}
+	select {
+	case c.server.rateLimited <- c:
+	default:
+		// The run loop is behind, the hits are recorded along with the next one
+		c.rateLimitHits.queued.Store(false)
+	}
+}
+
+// takeRateLimitHits returns the hits counted since the last call, on the run loop
+func (c *Client) takeRateLimitHits() []RateLimitHit {
+	// Cleared first so hits counted from now on wake the run loop again
+	c.rateLimitHits.queued.Store(false)
+
+	c.rateLimitHits.mu.Lock()
+	defer c.rateLimitHits.mu.Unlock()
+	hits := c.rateLimitHits.hits
+	c.rateLimitHits.hits = nil
+	return hits
+}
+
+// RecentRateLimitHits returns how many times the member was rate limited within rateLimitHitWindow before now
+func (ms *MembershipStats) RecentRateLimitHits(now time.Time) int {
+	count := 0
+	for _, hit := range ms.rateLimitHits {
+		if now.Sub(hit) <= rateLimitHitWindow {
+			count++
+		}
+	}
+	return count
+}
+
+// MemberStats returns the stats of a member, nil if the client isn't a member
+func (ch *Channel) MemberStats(client Session) *MembershipStats {
+	return ch.stats[client]
+}
+
+// CountMessage counts a chat message the member sent to the channel
+func (ch *Channel) CountMessage(client Session) {
+	if stats := ch.stats[client]; stats != nil {
+		stats.Messages++
+	}
+}
+
+// CountRateLimitHit records that a message the member sent to the channel was rate limited,
+// forgetting the hits that are too old to be shown
+func (ch *Channel) CountRateLimitHit(client Session, at time.Time) {
+	stats := ch.stats[client]
+	if stats == nil {
+		return
+	}
+
+	hits := stats.rateLimitHits
+	for len(hits) > 0 && (at.Sub(hits[0]) > rateLimitHitWindow || len(hits) >= maxRateLimitHitsKept) {
+		hits = hits[1:]
+	}
+	stats.rateLimitHits = append(hits, at)
+}
+
+// inspect shows an operator what the channel knows about one of its members
+func inspect(name string, args []string, client Session, server *Server) {
+	if len(args) < 1 {
+		client.SendServerMessage("Usage: /inspect <username> [channel]")
+		return
+	}
+
+	joinedChannel := resolveJoinedChannel(args[1:], client)
+	if joinedChannel == nil {
+		return
+	}
+
+	target, isMember := joinedChannel.Member(args[0])
+	stats := joinedChannel.MemberStats(target)
+	if !isMember || stats == nil {
+		client.SendServerMessage(fmt.Sprintf("'%s' is not a member of '%s', you can only inspect members of your channel.", args[0], joinedChannel.Name))
+		return
+	}
+
+	now := time.Now()
+	muted := "no"
+	if mutedFor := target.MutedFor(); mutedFor > 0 {
+		muted = fmt.Sprintf("for %s more", formatRetryAfter(mutedFor))
+	}
+
+	info := []string{
+		fmt.Sprintf("%s in '%s'", target.GetUsername(), joinedChannel.Name),
+		fmt.Sprintf("Role: %s", roleIn(target, joinedChannel)),
+		fmt.Sprintf("Joined: %s ago (%s)", formatDuration(now.Sub(stats.JoinedAt)), stats.JoinedAt.UTC().Format(time.DateTime)),
+		fmt.Sprintf("Messages in this channel: %d", stats.Messages),
+		fmt.Sprintf("Muted: %s", muted),
+		fmt.Sprintf("Rate limited in the last %s: %d times", formatDuration(rateLimitHitWindow), stats.RecentRateLimitHits(now)),
+	}
+	if client.IsAdmin() {
+		info = append(info, fmt.Sprintf("Shadow muted: %t", server.isShadowMuted(target)))
+	}
+	client.SendServerMessage(strings.Join(info, "\n"))
+}
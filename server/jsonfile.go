@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// jsonFile is a file stores save their state to as JSON. Writes happen off the run loop, so they are versioned:
+// the run loop numbers each state it hands over, and a state older than the last one written is skipped.
+type jsonFile struct {
+	path  string
+	mu    sync.Mutex // Held while the file is written
+	saved uint64     // Version of the last state written, guarded by mu
+}
+
+// write saves the state to the file, replacing it only once it's fully written.
+// It's safe to call from any goroutine.
+func (f *jsonFile) write(version uint64, state any) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if version <= f.saved {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		return err
+	}
+	f.saved = version
+	return nil
+}
+
+// readJSONFile decodes the file at path into state, leaving it as it is if the file doesn't exist
+func readJSONFile(path string, state any) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, state)
+}
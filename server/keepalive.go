@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net"
+	"time"
+)
+
+// NATs and firewalls drop connections that carry no traffic for a while, which a quiet channel easily doesn't.
+// Pings only reach clients using heartbeats, so clients using the keepalive feature that were sent nothing for
+// -keepalive-interval are sent an empty frame, which they drop without showing it. TCP keepalive probes are
+// enabled on accepted connections too, for clients that can't take empty frames.
+
+const keepaliveCheckInterval = 5 * time.Second // How often clients are checked for having been sent nothing for too long
+
+// sendKeepalives sends an empty frame to the clients using the keepalive feature that were sent nothing for -keepalive-interval
+func (s *Server) sendKeepalives(now time.Time) {
+	for _, client := range s.connections {
+		// Messages still queued will be written anyway
+		if client.suspended.Load() || !client.uses(FeatureKeepalive) || len(client.send) > 0 || client.WriteIdleFor(now) < s.config.KeepaliveInterval {
+			continue
+		}
+		client.SendMessage("")
+	}
+}
+
+// WriteIdleFor returns how long it's been since a frame was last written to the client
+func (c *Client) WriteIdleFor(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, c.lastWrite.Load()))
+}
+
+// setTCPKeepalive enables TCP keepalive probes on an accepted connection, sent every -tcp-keepalive once it's been
+// idle that long. With 0 Go's defaults are used, and with a negative interval they're disabled.
+func setTCPKeepalive(conn net.Conn, interval time.Duration) error {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	if interval < 0 {
+		return tcpConn.SetKeepAliveConfig(net.KeepAliveConfig{Enable: false})
+	}
+	return tcpConn.SetKeepAliveConfig(net.KeepAliveConfig{Enable: true, Idle: interval, Interval: interval})
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/mattn/go-isatty"
+)
+
+// Subsystems the server logs from, each with its own child logger tagged with a subsystem attribute
+const (
+	subsystemListener = "listener" // Accepting and refusing connections, starting and shutting down
+	subsystemRunLoop  = "runloop"  // The run loop: broadcasts, sweeps, slow clients
+	subsystemClient   = "client"   // Client connections and sessions
+	subsystemCommand  = "command"  // Commands and what admins do with them
+	subsystemStorage  = "storage"  // Files the server reads and writes: message logs, bans, profiles
+	subsystemEvents   = "events"   // The event pipe external scripts read
+)
+
+var logSubsystems = []string{subsystemListener, subsystemRunLoop, subsystemClient, subsystemCommand, subsystemStorage, subsystemEvents}
+
+// logFormats are the values of -log-format. Auto picks pretty when writing to a terminal and text otherwise.
+var logFormats = []string{"auto", "pretty", "text"}
+
+const (
+	logSampleInterval = time.Second // Window in which high-volume debug logs are sampled
+	logSampleBurst    = 10          // Entries logged per window, the rest are counted and reported with the next one logged
+)
+
+// loggers are the child loggers of each subsystem
+type loggers struct {
+	listener *slog.Logger
+	runLoop  *slog.Logger
+	client   *slog.Logger
+	command  *slog.Logger
+	storage  *slog.Logger
+	events   *slog.Logger
+}
+
+// newLoggers creates the loggers of each subsystem writing to out, filtered as configured
+func newLoggers(out *os.File, config Config) loggers {
+	// The filter decides what is logged, so the handler it wraps takes everything
+	var handler slog.Handler
+	options := &slog.HandlerOptions{Level: slog.LevelDebug}
+	if config.LogFormat == "pretty" || config.LogFormat == "auto" && isatty.IsTerminal(out.Fd()) {
+		handler = newPrettyHandler(out, options)
+	} else {
+		handler = slog.NewTextHandler(out, options)
+	}
+	logger := slog.New(newSubsystemFilter(handler, config.LogLevel, config.LogSubsystems))
+
+	return loggers{
+		listener: logger.With("subsystem", subsystemListener),
+		runLoop:  logger.With("subsystem", subsystemRunLoop),
+		client:   logger.With("subsystem", subsystemClient),
+		command:  logger.With("subsystem", subsystemCommand),
+		storage:  logger.With("subsystem", subsystemStorage),
+		events:   logger.With("subsystem", subsystemEvents),
+	}
+}
+
+// subsystemFilter only lets through the logs of the subsystems it's given, except for warnings and errors which are always logged.
+// The subsystem of a logger is taken from the subsystem attribute it was created with.
+type subsystemFilter struct {
+	handler    slog.Handler
+	level      slog.Leveler
+	subsystems []string // Subsystems logged below warn level, all of them if empty
+	subsystem  string   // Subsystem of this logger, empty until it's given one
+}
+
+func newSubsystemFilter(handler slog.Handler, level slog.Leveler, subsystems []string) *subsystemFilter {
+	return &subsystemFilter{handler: handler, level: level, subsystems: subsystems}
+}
+
+func (f *subsystemFilter) Enabled(ctx context.Context, level slog.Level) bool {
+	minimum := f.level.Level()
+	if len(f.subsystems) > 0 && !slices.Contains(f.subsystems, f.subsystem) {
+		minimum = max(minimum, slog.LevelWarn)
+	}
+	return level >= minimum && f.handler.Enabled(ctx, level)
+}
+
+func (f *subsystemFilter) Handle(ctx context.Context, record slog.Record) error {
+	return f.handler.Handle(ctx, record)
+}
+
+func (f *subsystemFilter) WithAttrs(attrs []slog.Attr) slog.Handler {
+	filter := *f
+	filter.handler = f.handler.WithAttrs(attrs)
+	for _, attr := range attrs {
+		if attr.Key == "subsystem" {
+			filter.subsystem = attr.Value.String()
+		}
+	}
+	return &filter
+}
+
+func (f *subsystemFilter) WithGroup(name string) slog.Handler {
+	filter := *f
+	filter.handler = f.handler.WithGroup(name)
+	return &filter
+}
+
+// ANSI colors of the pretty handler
+const (
+	colorReset  = "\033[0m"
+	colorDim    = "\033[2m"
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+	colorBlue   = "\033[34m"
+)
+
+const prettyMessageWidth = 36 // Messages are padded to this width so the attributes after them line up
+
+// prettyHandler writes logs for people reading them in a terminal, one line each with the level colored and the
+// subsystem and message padded so they line up: "15:04:05.000 INFO  client   Client connected      id=... ip=..."
+type prettyHandler struct {
+	mu        *sync.Mutex // Shared by the handlers derived from the same one, which write to the same output
+	out       io.Writer
+	level     slog.Leveler
+	subsystem string
+	attrs     string // Attributes the logger was created with, already formatted
+	group     string // Prefix of the keys of the attributes, from WithGroup
+}
+
+func newPrettyHandler(out io.Writer, options *slog.HandlerOptions) *prettyHandler {
+	return &prettyHandler{mu: &sync.Mutex{}, out: out, level: options.Level}
+}
+
+func (h *prettyHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *prettyHandler) Handle(_ context.Context, record slog.Record) error {
+	var line bytes.Buffer
+	line.WriteString(colorDim + record.Time.Format("15:04:05.000") + colorReset + " ")
+	line.WriteString(levelColor(record.Level) + fmt.Sprintf("%-5s", record.Level.String()) + colorReset + " ")
+	line.WriteString(colorBlue + fmt.Sprintf("%-8s", h.subsystem) + colorReset + " ")
+	line.WriteString(fmt.Sprintf("%-*s", prettyMessageWidth, record.Message))
+	line.WriteString(h.attrs)
+	record.Attrs(func(attr slog.Attr) bool {
+		writePrettyAttr(&line, h.group, attr)
+		return true
+	})
+	line.WriteString("\n")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.out.Write(line.Bytes())
+	return err
+}
+
+func (h *prettyHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handler := *h
+	var formatted bytes.Buffer
+	for _, attr := range attrs {
+		// The subsystem has its own column
+		if attr.Key == "subsystem" && h.group == "" {
+			handler.subsystem = attr.Value.String()
+			continue
+		}
+		writePrettyAttr(&formatted, h.group, attr)
+	}
+	handler.attrs += formatted.String()
+	return &handler
+}
+
+func (h *prettyHandler) WithGroup(name string) slog.Handler {
+	handler := *h
+	handler.group += name + "."
+	return &handler
+}
+
+// levelColor returns the color of a level: errors are red, warnings yellow, info green and debug dim
+func levelColor(level slog.Level) string {
+	switch {
+	case level >= slog.LevelError:
+		return colorRed
+	case level >= slog.LevelWarn:
+		return colorYellow
+	case level >= slog.LevelInfo:
+		return colorGreen
+	default:
+		return colorDim
+	}
+}
+
+// writePrettyAttr writes an attribute as " key=value", with the key dimmed and the value quoted if it has spaces
+func writePrettyAttr(line *bytes.Buffer, group string, attr slog.Attr) {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+
+	if attr.Value.Kind() == slog.KindGroup {
+		if attr.Key != "" {
+			group += attr.Key + "."
+		}
+		for _, member := range attr.Value.Group() {
+			writePrettyAttr(line, group, member)
+		}
+		return
+	}
+
+	value := attr.Value.String()
+	if value == "" || strings.ContainsFunc(value, func(r rune) bool { return unicode.IsSpace(r) || r == '"' || r == '=' || !unicode.IsPrint(r) }) {
+		value = strconv.Quote(value)
+	}
+	line.WriteString(" " + colorDim + group + attr.Key + "=" + colorReset + value)
+}
+
+// logSampler keeps high-volume debug logs, like one for each message, from flooding the output:
+// only the first logSampleBurst entries of each logSampleInterval are logged
+type logSampler struct {
+	mu          sync.Mutex
+	windowStart time.Time
+	logged      int
+	skipped     int // Entries skipped since the last one logged
+}
+
+// sample reports whether an entry should be logged now, and how many were skipped since the last one that was
+func (ls *logSampler) sample(now time.Time) (bool, int) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	if now.Sub(ls.windowStart) >= logSampleInterval {
+		ls.windowStart = now
+		ls.logged = 0
+	}
+	if ls.logged >= logSampleBurst {
+		ls.skipped++
+		return false, 0
+	}
+
+	ls.logged++
+	skipped := ls.skipped
+	ls.skipped = 0
+	return true, skipped
+}
+
+// debugSampled logs a high-volume entry at debug level through the sampler, adding how many entries were skipped
+// before it. Nothing is done unless the logger logs debug entries, so it's cheap to call for every message.
+func debugSampled(logger *slog.Logger, sampler *logSampler, msg string, args ...any) {
+	if !logger.Enabled(context.Background(), slog.LevelDebug) {
+		return
+	}
+
+	ok, skipped := sampler.sample(time.Now())
+	if !ok {
+		return
+	}
+	if skipped > 0 {
+		args = append(args, "skipped", skipped)
+	}
+	logger.Debug(msg, args...)
+}
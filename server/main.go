@@ -1,12 +1,158 @@
 package main
 
-import "flag"
+import (
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
 
 func main() {
 	host := flag.String("host", "localhost", "The host to listen on")
 	port := flag.String("port", "3000", "The port to listen on")
+	adminPassword := flag.String("admin-password", "", "Password required by /admin to gain admin privileges (admin commands are disabled if empty)")
+	persistentChannels := flag.Bool("persistent-channels", false, "Keep channels once their last member leaves instead of deleting them")
+	sweepInterval := flag.Duration("sweep-interval", time.Hour, "How often stale channels, expired invite codes and mutes are removed (0 to disable)")
+	staleChannelDays := flag.Int("stale-channel-days", 30, "Days a persistent channel can stay empty and unused before the sweep removes it (0 to keep them forever)")
+	inviteCodeRetention := flag.Duration("invite-code-retention", 24*time.Hour, "How long expired invite codes are kept before the sweep removes them")
+	maxChannels := flag.Int("max-channels", 0, "Maximum number of channels on the server (0 for no limit)")
+	floodProtection := flag.Bool("flood-protection", false, "Drop chat messages when the server is overloaded instead of slowing down their senders")
+	broadcastWait := flag.Duration("broadcast-wait", 5*time.Second, "How long a chat message waits for the overloaded server before it's dropped, without -flood-protection")
+	nameCooldown := flag.Duration("name-cooldown", 60*time.Second, "Minimum time between username changes (admins are exempt)")
+	idleAway := flag.Duration("idle-away", 15*time.Minute, "How long clients can be idle before they are automatically marked as away (0 to disable)")
+	idleTimeout := flag.Duration("idle-timeout", 12*time.Hour, "How long registered clients can be idle before they are disconnected (0 to disable)")
+	registrationTimeout := flag.Duration("registration-timeout", 2*time.Minute, "How long clients have to set a username before they are disconnected (0 to disable)")
+	pingInterval := flag.Duration("ping-interval", 30*time.Second, "How often clients are pinged to detect dead connections")
+	keepaliveInterval := flag.Duration("keepalive-interval", 60*time.Second, "How long a client can be sent nothing before it's sent an empty frame to keep NATs from dropping the connection (0 to disable)")
+	tcpKeepalive := flag.Duration("tcp-keepalive", 30*time.Second, "Idle time and interval of TCP keepalive probes on client connections (0 for Go's defaults, negative to disable)")
+	resumeGrace := flag.Duration("resume-grace", 60*time.Second, "How long registered clients whose connection dropped stay in their channels, waiting for them to resume their session (0 to disable)")
+	disableFirstMessageAnnounce := flag.Bool("disable-first-message-announce", false, "Don't announce the first message each client sends to a channel")
+	channelCreateBucket := flag.Int("channel-create-bucket", 3, "Maximum number of channels a client can create in a burst")
+	channelCreateRate := flag.Float64("channel-create-rate", 0.1, "Channel creations per second refilled for each client")
+	whisperBucket := flag.Int("whisper-bucket", 5, "Maximum number of whispers a client can send in a burst")
+	whisperRate := flag.Float64("whisper-rate", 0.2, "Whispers per second refilled for each client")
+	whisperRecipientLimit := flag.Int("whisper-recipient-limit", 20, "Maximum number of whispers a client can receive per minute (0 for no limit)")
+	joinFloodLimit := flag.Int("join-flood-limit", 5, "Maximum number of channels a client can join within the join flood window (0 to disable)")
+	joinFloodWindow := flag.Duration("join-flood-window", 10*time.Second, "Sliding window in which joins are counted")
+	joinFloodCooldown := flag.Duration("join-flood-cooldown", 30*time.Second, "How long joins are refused after exceeding the join flood limit")
+	joinFloodMute := flag.Duration("join-flood-mute", 5*time.Minute, "How long clients that repeatedly exceed the join flood limit are muted")
+	readBuffer := flag.Int("read-buffer", 512, "Size in bytes of each client's read buffer")
+	writeBuffer := flag.Int("write-buffer", 1024, "Size in bytes of each client's write buffer")
+	sendQueue := flag.Int("send-queue", 256, "Number of messages queued for each client before it's disconnected as too slow")
+	maxLineLength := flag.Int("max-line-length", 4096, "Longest line in bytes a client can send before it's disconnected")
+	maxMessageLength := flag.Int("max-message-length", 1024, "Longest chat message in characters, longer ones are refused")
+	persistMessages := flag.Bool("persist-messages", false, "Append every channel message to messages/<channel>.log as JSON lines, for /export and to restore /history after a restart")
+	messageLogSize := flag.Int("message-log-size", 10, "Size in MB past which a channel's message log is rotated to <channel>.log.1")
+	rateLimiter := flag.String("rate-limiter", "token-bucket", "How the messages of each client are rate limited: token-bucket or sliding-window")
+	slowQueueThreshold := flag.Int("slow-queue-threshold", 0, "Number of queued messages above which a client is falling behind (0 for half of -send-queue)")
+	slowClientAfter := flag.Duration("slow-client-after", 10*time.Second, "How long a client's queue stays above -slow-queue-threshold before it's reported as slow")
+	downgradeSlowClients := flag.Bool("downgrade-slow-clients", false, "Only send slow clients the messages of their active channel, skipping their other channels and global announcements")
+	banFile := flag.String("ban-file", "", "File of banned IP addresses, one per line with an optional duration (e.g. 192.168.1.1 24h); reload it with /reloadbans")
+	motdFile := flag.String("motd", "", "File with the message of the day, e.g. the server rules, shown to clients when they connect and with /motd")
+	authFile := flag.String("auth-file", "", "JSON file to save registered usernames to; clients must /login to use a registered username (registrations are kept in memory and not enforced if empty)")
+	moderationFile := flag.String("moderation-file", "", "JSON file to save shadow mutes to, so they survive restarts (kept in memory if empty)")
+	stateFile := flag.String("state-file", "", "JSON file to save channel topics, pins, password hints and hidden flags to, restored when a channel with the same name is created (kept in memory if empty)")
+	eventPipe := flag.String("event-pipe", "", "Path of a named pipe to write server events to as JSON lines (Unix only)")
+	eventPipeBuffer := flag.Int("event-pipe-buffer", 1024, "Number of events queued for the event pipe before new ones are dropped")
+	metricsAddr := flag.String("metrics-addr", "", "Address to serve Prometheus metrics on at /metrics, e.g. localhost:9100 (disabled if empty)")
+	metricsMinMembers := flag.Int("metrics-min-members", 5, "Members a channel needs to get its own metrics, the others are only counted")
+	logLevel := flag.String("log-level", "info", "Least severe level logged: debug, info, warn or error")
+	logFormat := flag.String("log-format", "auto", "How logs are written: pretty (colored and aligned), text (key=value pairs) or auto (pretty in a terminal, text otherwise)")
+	logSubsystems := flag.String("log-subsystems", "", "Comma separated subsystems logged below warn level, e.g. client,storage (all if empty): "+strings.Join(logSubsystems, ", "))
+	debug := flag.Bool("debug", false, "Enable debugging commands like /goroutines for every client (otherwise only clients connecting from localhost can use them)")
+	reservedNames := flag.String("reserved-names", "", "Comma separated usernames nobody can take, e.g. admin,moderator (Server, system and . are always reserved)")
+	commandCooldowns := flag.String("command-cooldowns", "", "Comma separated cooldowns overriding the defaults of commands, e.g. channels=10s,clients=0")
 	flag.Parse()
 
-	server := NewServer(*host, *port)
+	var problems []error
+	cooldowns, err := parseCommandCooldowns(*commandCooldowns)
+	if err != nil {
+		problems = append(problems, fmt.Errorf("-command-cooldowns: %w", err))
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		problems = append(problems, fmt.Errorf("-log-level must be debug, info, warn or error"))
+	}
+
+	// 0 picks the default, which depends on the send queue
+	if *slowQueueThreshold == 0 {
+		*slowQueueThreshold = max(1, *sendQueue/2)
+	}
+
+	config := Config{
+		Host:                        *host,
+		Port:                        *port,
+		AdminPassword:               *adminPassword,
+		PersistentChannels:          *persistentChannels,
+		SweepInterval:               *sweepInterval,
+		StaleChannelAge:             time.Duration(*staleChannelDays) * 24 * time.Hour,
+		InviteCodeRetention:         *inviteCodeRetention,
+		MaxChannels:                 *maxChannels,
+		FloodProtection:             *floodProtection,
+		BroadcastWait:               *broadcastWait,
+		NameCooldown:                *nameCooldown,
+		IdleAway:                    *idleAway,
+		IdleTimeout:                 *idleTimeout,
+		RegistrationTimeout:         *registrationTimeout,
+		PingInterval:                *pingInterval,
+		ResumeGrace:                 *resumeGrace,
+		KeepaliveInterval:           *keepaliveInterval,
+		TCPKeepalive:                *tcpKeepalive,
+		DisableFirstMessageAnnounce: *disableFirstMessageAnnounce,
+		ChannelCreateBucket:         *channelCreateBucket,
+		ChannelCreateRate:           *channelCreateRate,
+		WhisperBucket:               *whisperBucket,
+		WhisperRate:                 *whisperRate,
+		WhisperRecipientLimit:       *whisperRecipientLimit,
+		JoinFloodLimit:              *joinFloodLimit,
+		JoinFloodWindow:             *joinFloodWindow,
+		JoinFloodCooldown:           *joinFloodCooldown,
+		JoinFloodMute:               *joinFloodMute,
+		ReadBufferSize:              *readBuffer,
+		WriteBufferSize:             *writeBuffer,
+		SendQueueSize:               *sendQueue,
+		MaxLineLength:               *maxLineLength,
+		MaxMessageLength:            *maxMessageLength,
+		RateLimiter:                 *rateLimiter,
+		SlowQueueThreshold:          *slowQueueThreshold,
+		SlowClientAfter:             *slowClientAfter,
+		DowngradeSlowClients:        *downgradeSlowClients,
+		PersistMessages:             *persistMessages,
+		MessageLogSize:              int64(*messageLogSize) << 20,
+		BanFile:                     *banFile,
+		MOTDFile:                    *motdFile,
+		AuthFile:                    *authFile,
+		ModerationFile:              *moderationFile,
+		StateFile:                   *stateFile,
+		EventPipe:                   *eventPipe,
+		EventPipeBuffer:             *eventPipeBuffer,
+		MetricsAddr:                 *metricsAddr,
+		MetricsMinMembers:           *metricsMinMembers,
+		LogLevel:                    level,
+		LogFormat:                   *logFormat,
+		LogSubsystems:               parseLogSubsystems(*logSubsystems),
+		Debug:                       *debug,
+		CommandCooldowns:            cooldowns,
+		ReservedNames:               parseReservedNames(*reservedNames),
+	}
+
+	// Every problem is reported at once, so they can all be fixed before trying again
+	problems = append(problems, config.Validate()...)
+	if len(problems) > 0 {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:")
+		for _, problem := range problems {
+			fmt.Fprintln(os.Stderr, "  -", problem)
+		}
+		os.Exit(2)
+	}
+
+	server, err := NewServer(config)
+	if err != nil {
+		log.Fatal(err)
+	}
 	server.Start()
 }
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"time"
+
+	"github.com/muesli/termenv"
+)
+
+// Messenger is the part of a client needed to deliver messages to it.
+// *Client is the only implementation used by the server, but code that only sends messages
+// can accept a Messenger so it can be exercised without a network connection.
+type Messenger interface {
+	ID() string
+	GetUsername() string
+	GetChannel() *Channel
+	SetChannel(ch *Channel)
+	SendMessage(msg string) error
+}
+
+// Session is a client as command handlers, channels and the broadcast path see it, as opposed to its connection.
+// *Client is the implementation used by the server, tests use a recording fake so handlers run without a network.
+// Everything the run loop does with the connection itself, like pinging it or resuming it, takes a *Client instead.
+type Session interface {
+	Messenger
+
+	SendMessageAt(msg string, sentAt time.Time) error
+	SendServerMessage(text string) error
+	SendNotice(key, msg string)
+	SendStream(chunks []string) error
+	Disconnect(reason string)
+
+	RemoteAddr() string
+	Host() string
+	SetUsername(username string)
+	DisplayName() string
+	GetDisplayIcon() string
+	SetDisplayIcon(icon string)
+	clientsKey() string
+
+	IsRegistered() bool
+	SetRegistered(registered bool)
+	IsAdmin() bool
+	SetAdmin(admin bool)
+	IsBridge() bool
+	SetBridge(bridge bool) bool
+
+	GetChannels() []*Channel
+	GetJoinedChannel(name string) *Channel
+	AddChannel(ch *Channel)
+	RemoveChannel(ch *Channel)
+
+	Away() (string, bool)
+	SetAway(message string, auto bool)
+	ClearAway()
+	IdleFor() time.Duration
+	Mute(duration time.Duration)
+	MutedFor() time.Duration
+	clearExpiredMute(now time.Time) bool
+
+	ColorProfile() termenv.Profile
+	uses(feature Feature) bool
+	useCommand(name string, cooldown time.Duration) time.Duration
+	Protocol() int32
+	IsSlow() bool
+	isReflection(msg Message, now time.Time) bool
+	markFirstMessage() bool
+	rejectMessage(channelName, reason string)
+
+	allowChannelCreation() bool
+	allowWhisper(recipients int) bool
+	allowReceivingWhisper() bool
+	allowJoin() bool
+	JoinCooldownRemaining() time.Duration
+
+	State() *SessionState
+	session(now time.Time) sessionSummary
+}
+
+var (
+	_ Messenger = (*Client)(nil)
+	_ Session   = (*Client)(nil)
+)
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Channel activity is counted in one minute buckets covering the last activityWindow, so old activity decays a minute at a time
+const (
+	activityBucketSize = time.Minute
+	activityWindow     = 15 * time.Minute
+	activityBuckets    = int(activityWindow / activityBucketSize)
+	messageRateWindow  = 5 * time.Minute // Window the messages per minute of a channel are averaged over
+	topChannelsShown   = 5               // Channels listed by /top
+)
+
+// activityBucket counts the messages sent to a channel during one minute, and who sent them
+type activityBucket struct {
+	start    time.Time // Start of the minute, zero if the bucket was never used
+	messages int
+	speakers map[string]struct{}
+}
+
+// ChannelActivity is a rolling window of the messages sent to a channel over the last activityWindow.
+// It isn't safe for concurrent use, channels only record activity from the run loop.
+type ChannelActivity struct {
+	buckets [activityBuckets]activityBucket
+}
+
+// Record counts a message sent by the speaker at the given time
+func (a *ChannelActivity) Record(speaker string, at time.Time) {
+	start := at.Truncate(activityBucketSize)
+	bucket := &a.buckets[int(start.Unix()/int64(activityBucketSize/time.Second))%activityBuckets]
+
+	// The bucket last counted a minute that's out of the window by now
+	if !bucket.start.Equal(start) {
+		*bucket = activityBucket{start: start, speakers: make(map[string]struct{})}
+	}
+	bucket.messages++
+	bucket.speakers[speaker] = struct{}{}
+}
+
+// inWindow reports whether the bucket counts minutes within the window before now, the current one included
+func (b *activityBucket) inWindow(now time.Time, window time.Duration) bool {
+	return !b.start.IsZero() && !b.start.After(now) && now.Truncate(activityBucketSize).Sub(b.start) < window
+}
+
+// Messages returns the number of messages sent within the window before now, which can't be longer than activityWindow
+func (a *ChannelActivity) Messages(now time.Time, window time.Duration) int {
+	messages := 0
+	for i := range a.buckets {
+		if a.buckets[i].inWindow(now, window) {
+			messages += a.buckets[i].messages
+		}
+	}
+	return messages
+}
+
+// Speakers returns the number of users who sent messages within the window before now
+func (a *ChannelActivity) Speakers(now time.Time, window time.Duration) int {
+	speakers := make(map[string]struct{})
+	for i := range a.buckets {
+		if a.buckets[i].inWindow(now, window) {
+			for speaker := range a.buckets[i].speakers {
+				speakers[speaker] = struct{}{}
+			}
+		}
+	}
+	return len(speakers)
+}
+
+// MessagesPerMinute returns the average number of messages sent per minute over messageRateWindow
+func (a *ChannelActivity) MessagesPerMinute(now time.Time) float64 {
+	return float64(a.Messages(now, messageRateWindow)) / messageRateWindow.Minutes()
+}
+
+// RecordActivity counts a chat message relayed to the channel for /top and the metrics endpoint
+func (ch *Channel) RecordActivity(speaker string, at time.Time) {
+	ch.activity.Record(speaker, at)
+}
+
+// Activity returns the channel's recent activity
+func (ch *Channel) Activity() *ChannelActivity {
+	return &ch.activity
+}
+
+// topChannels lists the channels with the most messages in the last activityWindow.
+// Hidden channels are only listed to their members, like in /channels.
+func topChannels(name string, args []string, client Session, server *Server) {
+	type ranked struct {
+		channel  *Channel
+		messages int
+	}
+
+	now := time.Now()
+	var ranking []ranked
+	for _, channel := range server.channelIndex {
+		if channel.IsHidden() && client.GetJoinedChannel(channel.Name) == nil {
+			continue
+		}
+		if messages := channel.Activity().Messages(now, activityWindow); messages > 0 {
+			ranking = append(ranking, ranked{channel, messages})
+		}
+	}
+
+	if len(ranking) == 0 {
+		client.SendServerMessage(fmt.Sprintf("No messages were sent to any channel in the last %s.", formatDuration(activityWindow)))
+		return
+	}
+
+	// The channel index is sorted by name, which breaks ties
+	slices.SortStableFunc(ranking, func(a, b ranked) int {
+		return b.messages - a.messages
+	})
+
+	lines := []string{fmt.Sprintf("Most active channels in the last %s:", formatDuration(activityWindow))}
+	for i, entry := range ranking[:min(topChannelsShown, len(ranking))] {
+		lines = append(lines, fmt.Sprintf("%d. %s: %d message(s) from %d speaker(s), %d member(s)", i+1,
+			colorize(entry.channel.Name, entry.channel.Color(), client.ColorProfile()), entry.messages,
+			entry.channel.Activity().Speakers(now, activityWindow), entry.channel.MemberCount()))
+	}
+	client.SendServerMessage(strings.Join(lines, "\n"))
+}
+
+// ChannelMetrics is the activity of a channel exposed by the metrics endpoint
+type ChannelMetrics struct {
+	Name              string
+	Members           int
+	MessagesPerMinute float64
+	Speakers          int // Users who sent messages in the last activityWindow
+}
+
+// MetricsSnapshot is what the metrics endpoint exposes, taken by the run loop
+type MetricsSnapshot struct {
+	Clients      int
+	Channels     []ChannelMetrics // Channels with enough members to be labeled, sorted by name
+	Unlabeled    int              // Channels left out of Channels
+	BytesRead    int64
+	BytesWritten int64
+}
+
+// metricsSnapshot takes a snapshot of the metrics. Only channels with at least -metrics-min-members get their own series,
+// which keeps the number of series bounded however many channels users create. Hidden channels never do.
+func (s *Server) metricsSnapshot(now time.Time) MetricsSnapshot {
+	snapshot := MetricsSnapshot{
+		Clients:      len(s.clients),
+		BytesRead:    s.stats.BytesRead.Load(),
+		BytesWritten: s.stats.BytesWritten.Load(),
+	}
+
+	for _, channel := range s.channelIndex {
+		if channel.IsHidden() || channel.MemberCount() < s.config.MetricsMinMembers {
+			snapshot.Unlabeled++
+			continue
+		}
+
+		snapshot.Channels = append(snapshot.Channels, ChannelMetrics{
+			Name:              channel.Name,
+			Members:           channel.MemberCount(),
+			MessagesPerMinute: channel.Activity().MessagesPerMinute(now),
+			Speakers:          channel.Activity().Speakers(now, activityWindow),
+		})
+	}
+	return snapshot
+}
+
+// serveMetrics serves the metrics in the Prometheus text format on -metrics-addr until the server shuts down.
+// Failing to listen only disables the endpoint, the chat keeps working.
+func (s *Server) serveMetrics() {
+	listener, err := net.Listen("tcp", s.config.MetricsAddr)
+	if err != nil {
+		s.log.listener.Error("Failed to start the metrics endpoint", "address", s.config.MetricsAddr, "error", err)
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", s.handleMetrics)
+	s.metricsServer = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go s.metricsServer.Serve(listener)
+	s.log.listener.Info("Serving metrics", "address", listener.Addr().String())
+}
+
+// handleMetrics asks the run loop, which owns the channels, for a snapshot and writes it
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	response := make(chan MetricsSnapshot, 1)
+	select {
+	case s.snapshots <- response:
+	case <-s.shutdown:
+		http.Error(w, "The server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeMetrics(w, <-response)
+}
+
+// writeMetrics writes the snapshot in the Prometheus text exposition format
+func writeMetrics(w io.Writer, snapshot MetricsSnapshot) {
+	writeMetric(w, "gochat_clients", "gauge", "Connected clients.", fmt.Sprint(snapshot.Clients))
+	writeMetric(w, "gochat_bytes_read_total", "counter", "Bytes read from clients.", fmt.Sprint(snapshot.BytesRead))
+	writeMetric(w, "gochat_bytes_written_total", "counter", "Bytes written to clients.", fmt.Sprint(snapshot.BytesWritten))
+	writeMetric(w, "gochat_unlabeled_channels", "gauge", "Channels without per-channel metrics, for being hidden or having too few members.",
+		fmt.Sprint(snapshot.Unlabeled))
+
+	members := make([]string, len(snapshot.Channels))
+	rates := make([]string, len(snapshot.Channels))
+	speakers := make([]string, len(snapshot.Channels))
+	for i, channel := range snapshot.Channels {
+		label := fmt.Sprintf(`{channel="%s"}`, escapeLabelValue(channel.Name))
+		members[i] = fmt.Sprintf("%s %d", label, channel.Members)
+		rates[i] = fmt.Sprintf("%s %g", label, channel.MessagesPerMinute)
+		speakers[i] = fmt.Sprintf("%s %d", label, channel.Speakers)
+	}
+	writeMetric(w, "gochat_channel_members", "gauge", "Members of the channel.", members...)
+	writeMetric(w, "gochat_channel_messages_per_minute", "gauge",
+		fmt.Sprintf("Chat messages sent to the channel per minute, averaged over the last %s.", formatDuration(messageRateWindow)), rates...)
+	writeMetric(w, "gochat_channel_active_speakers", "gauge",
+		fmt.Sprintf("Users who sent chat messages to the channel in the last %s.", formatDuration(activityWindow)), speakers...)
+}
+
+// writeMetric writes a metric's help and type, then its samples: values, or labels followed by a value
+func writeMetric(w io.Writer, name, kind, help string, samples ...string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	for _, sample := range samples {
+		if strings.HasPrefix(sample, "{") {
+			fmt.Fprintf(w, "%s%s\n", name, sample)
+		} else {
+			fmt.Fprintf(w, "%s %s\n", name, sample)
+		}
+	}
+}
+
+// escapeLabelValue escapes a label value for the Prometheus text format
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
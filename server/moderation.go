@@ -0,0 +1,52 @@
+package main
+
+import (
+	"maps"
+	"slices"
+)
+
+// moderationFile is the file the moderation state is saved to
+type moderationFile struct {
+	jsonFile
+	version uint64 // Incremented every time the moderation state changes, only on the run loop
+}
+
+// moderationState is what's saved to the moderation file
+type moderationState struct {
+	ShadowMuted []string `json:"shadow_muted"` // Usernames whose messages are only shown to themselves
+}
+
+// loadModeration restores the moderation state saved to the moderation file, if there's one
+func (s *Server) loadModeration() error {
+	if s.moderation == nil {
+		return nil
+	}
+
+	var state moderationState
+	if err := readJSONFile(s.moderation.path, &state); err != nil {
+		return err
+	}
+	for _, username := range state.ShadowMuted {
+		s.shadowMuted[username] = struct{}{}
+	}
+	return nil
+}
+
+// saveModeration writes the moderation state to the moderation file off the run loop, if there's one
+func (s *Server) saveModeration() {
+	if s.moderation == nil {
+		return
+	}
+
+	s.moderation.version++
+	version := s.moderation.version
+	state := moderationState{ShadowMuted: slices.Sorted(maps.Keys(s.shadowMuted))}
+	s.runOffLoop(func() func() {
+		err := s.moderation.write(version, state)
+		return func() {
+			if err != nil {
+				s.log.storage.Error("Failed to save moderation file", "file", s.moderation.path, "error", err)
+			}
+		}
+	})
+}
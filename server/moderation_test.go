@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRelayShadowMuted(t *testing.T) {
+	tests := []struct {
+		name      string
+		setup     func(server *Server, alice *fakeSession, channel *Channel)
+		wantAlice []string // Frames alice receives, without the echo's sequence number
+		wantLog   int      // Entries in the channel's history
+	}{
+		{
+			name:      "first message",
+			wantAlice: []string{formatChannelMessage("Server", "general", "👋 alice said their first message!"), "echo general hi"},
+			wantLog:   1,
+		},
+		{
+			name:      "later message",
+			setup:     func(server *Server, alice *fakeSession, channel *Channel) { alice.firstMessageSent = true },
+			wantAlice: []string{"echo general hi"},
+			wantLog:   1,
+		},
+		{
+			name:      "no-spam mode",
+			setup:     func(server *Server, alice *fakeSession, channel *Channel) { channel.SetSpamLock(time.Minute) },
+			wantAlice: []string{formatChannelMessage("Server", "general", "Channel is in no-spam mode."), formatEvent("reject", "general channel is in no-spam mode")},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, nil)
+			alice, bob := connect(server, "alice"), connect(server, "bob")
+			channel := join(t, server, bob, "general") // Alice isn't an operator, who could send in no-spam mode
+			join(t, server, alice, "general")
+			server.shadowMuted["alice"] = struct{}{}
+			if test.setup != nil {
+				test.setup(server, alice, channel)
+			}
+			alice.sent, bob.sent = nil, nil
+
+			server.relayMessage(Message{Sender: alice, SenderName: "alice", Channel: channel, Content: "hi", Chat: true})
+
+			var got []string
+			for _, frame := range alice.sent {
+				if fields := strings.SplitN(frame, "|", 3); len(fields) == 3 && fields[1] == "echo" {
+					_, rest, _ := strings.Cut(fields[2], " ")
+					frame = "echo " + rest
+				}
+				got = append(got, frame)
+			}
+			if !slices.Equal(got, test.wantAlice) {
+				t.Errorf("alice received %q, want %q", got, test.wantAlice)
+			}
+			if len(bob.sent) > 0 {
+				t.Errorf("bob received %q", bob.sent)
+			}
+			if entries := channel.History(historySize); len(entries) != test.wantLog {
+				t.Errorf("the history has %d entries, want %d", len(entries), test.wantLog)
+			}
+		})
+	}
+}
+
+func TestShadowMutedMessagesInHistory(t *testing.T) {
+	tests := []struct {
+		name     string
+		command  string
+		args     []string
+		user     string
+		want     []string
+		wantNone []string
+	}{
+		{name: "history of the sender", command: "history", user: "alice", want: []string{"alice|general|hidden", "bob|general|visible"}},
+		{name: "history of others", command: "history", user: "bob", want: []string{"bob|general|visible"}, wantNone: []string{"hidden"}},
+		{name: "count skips hidden messages", command: "history", args: []string{"1"}, user: "bob", want: []string{"Last 1 message(s)", "bob|general|visible"}},
+		{name: "export of the sender", command: "export", user: "alice", want: []string{`"content":"hidden"`}, wantNone: []string{"visible_to"}},
+		{name: "export of others", command: "export", user: "bob", want: []string{`"content":"visible"`}, wantNone: []string{"hidden"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, nil)
+			alice, bob := connect(server, "alice"), connect(server, "bob")
+			channel := join(t, server, alice, "general")
+			join(t, server, bob, "general")
+
+			server.shadowMuted["alice"] = struct{}{}
+			server.relayMessage(Message{Sender: alice, SenderName: "alice", Channel: channel, Content: "hidden", Chat: true})
+			server.relayMessage(Message{Sender: bob, SenderName: "bob", Channel: channel, Content: "visible", Chat: true})
+
+			client := map[string]*fakeSession{"alice": alice, "bob": bob}[test.user]
+			client.sent = nil
+			server.runCommand(Command{Name: test.command, Args: test.args, Client: client, ReceivedAt: time.Now()})
+			finishWork(t, server)
+
+			for _, want := range test.want {
+				if !client.received(want) {
+					t.Errorf("%s received %q, want %q", test.user, client.sent, want)
+				}
+			}
+			for _, unwanted := range test.wantNone {
+				if client.received(unwanted) {
+					t.Errorf("%s received %q, want nothing with %q", test.user, client.sent, unwanted)
+				}
+			}
+		})
+	}
+}
+
+func TestShadowMutedWhisperReadReceipts(t *testing.T) {
+	server := newTestServer(t, nil)
+	alice, carol := connect(server, "alice"), connect(server, "carol")
+	bob, _ := newPipeClient(t, server, "bob")
+	bob.SetRegistered(true)
+	server.clients[bob.clientsKey()] = bob
+	server.shadowMuted["alice"] = struct{}{}
+
+	server.runCommand(Command{Name: "whisper", Args: []string{"bob", "hidden"}, Client: alice, ReceivedAt: time.Now()})
+	if received := queued(bob); slices.ContainsFunc(received, func(msg string) bool { return strings.Contains(msg, "hidden") }) {
+		t.Fatal("the whisper of a shadow muted user was delivered")
+	}
+	if !alice.received("Whisper sent to 'bob'") {
+		t.Fatalf("alice received %q, want the whisper to look sent", alice.sent)
+	}
+
+	// Bob reads a whisper from carol, which is when alice's would have been read
+	server.runCommand(Command{Name: "whisper", Args: []string{"bob", "visible"}, Client: carol, ReceivedAt: time.Now()})
+	var messageID uint64
+	for id, pending := range server.pendingAcks {
+		if !pending.Shadowed {
+			messageID = id
+		}
+	}
+	server.handleReadAck(bob, messageID)
+
+	for _, sender := range []*fakeSession{alice, carol} {
+		if !sender.received("Your whisper to bob was read.") {
+			t.Errorf("%s received %q, want a read receipt", sender.GetUsername(), sender.sent)
+		}
+	}
+	if len(server.pendingAcks) > 0 {
+		t.Errorf("%d whispers are still waiting to be read", len(server.pendingAcks))
+	}
+}
+
+func TestModerationFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		saved   []string
+		command Command
+		rename  [2]string // Username changed from and to, if set
+		want    []string
+	}{
+		{name: "restored", saved: []string{"alice"}, want: []string{"alice"}},
+		{name: "shadowmute", command: Command{Name: "shadowmute", Args: []string{"bob"}}, want: []string{"bob"}},
+		{name: "unshadowmute", saved: []string{"alice", "bob"}, command: Command{Name: "unshadowmute", Args: []string{"alice"}}, want: []string{"bob"}},
+		{name: "rename", saved: []string{"alice"}, rename: [2]string{"alice", "alicia"}, want: []string{"alicia"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "moderation.json")
+			if test.saved != nil {
+				data, err := json.Marshal(moderationState{ShadowMuted: test.saved})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, data, 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			server := newTestServer(t, func(config *Config) { config.ModerationFile = path })
+			for _, username := range test.saved {
+				if !server.isShadowMuted(newFakeSession(username)) {
+					t.Fatalf("'%s' isn't shadow muted after loading the file", username)
+				}
+			}
+
+			if test.command.Name != "" {
+				admin := connect(server, "admin")
+				admin.SetAdmin(true)
+				test.command.Client, test.command.ReceivedAt = admin, time.Now()
+				server.runCommand(test.command)
+			}
+			if test.rename[0] != "" {
+				client := connect(server, test.rename[0])
+				if err := server.changeUsername(client, client.clientsKey(), test.rename[1]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			finishWork(t, server)
+
+			var state moderationState
+			if err := readJSONFile(path, &state); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(state.ShadowMuted, test.want) {
+				t.Errorf("saved %q, want %q", state.ShadowMuted, test.want)
+			}
+		})
+	}
+}
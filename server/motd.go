@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// maxMOTDSize is the largest message of the day the server loads, in bytes
+const maxMOTDSize = 16 << 10
+
+// loadMOTD reads the message of the day from the file at path, none if the path is empty
+func loadMOTD(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	if len(data) > maxMOTDSize {
+		return "", fmt.Errorf("the message of the day cannot exceed %d bytes", maxMOTDSize)
+	}
+
+	motd := strings.ReplaceAll(string(data), "\r\n", "\n")
+	return strings.TrimSpace(motd), nil
+}
+
+// sendMOTD sends the message of the day as a motd event, so clients can render it as a panel
+func (s *Server) sendMOTD(client Session) {
+	client.SendMessage(formatEvent("motd", s.motd))
+}
+
+func motd(name string, args []string, client Session, server *Server) {
+	if server.motd == "" {
+		client.SendServerMessage("This server has no message of the day.")
+		return
+	}
+
+	server.sendMOTD(client)
+}
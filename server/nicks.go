@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const minNickPasswordLength = 6
+
+var (
+	ErrNickAlreadyRegistered = errors.New("nickname is already registered")
+	ErrNickPasswordTooShort  = errors.New("password is too short")
+)
+
+// nickStore holds the password hashes of registered nicknames, saved to a JSON file if it has a path.
+// Only accessed from the server's run loop, except for save which writes the file from other goroutines.
+// Hashing and checking passwords is slow on purpose, so it's done off the run loop with hashNickPassword
+// and checkNickPassword.
+type nickStore struct {
+	hashes  map[string][]byte
+	file    jsonFile // Its path is empty if registrations are only kept in memory
+	version uint64   // Incremented with every change, so a snapshot is never overwritten by an older one
+}
+
+// nickSnapshot are the registrations of a nickStore as they were at one version
+type nickSnapshot struct {
+	hashes  map[string]string
+	version uint64
+}
+
+// newNickStore creates a nickname store, loading the registrations saved at path if it isn't empty.
+// A missing file is created on the first registration.
+func newNickStore(path string) (*nickStore, error) {
+	n := &nickStore{hashes: make(map[string][]byte), file: jsonFile{path: path}}
+	if path == "" {
+		return n, nil
+	}
+
+	var hashes map[string]string
+	if err := readJSONFile(path, &hashes); err != nil {
+		return nil, err
+	}
+	for nickname, hash := range hashes {
+		n.hashes[nickname] = []byte(hash)
+	}
+	return n, nil
+}
+
+// Persistent reports whether registrations are saved to a file
+func (n *nickStore) Persistent() bool {
+	return n.file.path != ""
+}
+
+// snapshot copies the registrations to be saved by save
+func (n *nickStore) snapshot() nickSnapshot {
+	hashes := make(map[string]string, len(n.hashes))
+	for nickname, hash := range n.hashes {
+		hashes[nickname] = string(hash)
+	}
+	return nickSnapshot{hashes: hashes, version: n.version}
+}
+
+// save writes a snapshot to the store's file. It's safe to call from any goroutine.
+func (n *nickStore) save(snapshot nickSnapshot) error {
+	return n.file.write(snapshot.version, snapshot.hashes)
+}
+
+// hashNickPassword hashes the password a nickname is registered with. It's slow, so it's called off the run loop.
+func hashNickPassword(password string) ([]byte, error) {
+	if len(password) < minNickPasswordLength {
+		return nil, ErrNickPasswordTooShort
+	}
+	return bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+}
+
+// checkNickPassword reports whether the password matches the hash of a registered nickname.
+// It's slow, so it's called off the run loop.
+func checkNickPassword(hash []byte, password string) bool {
+	return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+}
+
+// passwordsEqual compares a password given by a client with the one it must match in constant time,
+// so how long the comparison takes doesn't tell how much of it was right. Both are hashed first
+// so their lengths don't show either.
+func passwordsEqual(given, want string) bool {
+	givenSum, wantSum := sha256.Sum256([]byte(given)), sha256.Sum256([]byte(want))
+	return subtle.ConstantTimeCompare(givenSum[:], wantSum[:]) == 1
+}
+
+// Add registers a nickname with the hash of its password. Registrations are only saved to the file with save.
+func (n *nickStore) Add(nickname string, hash []byte) error {
+	if _, exists := n.hashes[nickname]; exists {
+		return ErrNickAlreadyRegistered
+	}
+
+	n.hashes[nickname] = hash
+	n.version++
+	return nil
+}
+
+// Remove drops the registration of a nickname if it's still the one with the hash, e.g. once saving it failed
+func (n *nickStore) Remove(nickname string, hash []byte) {
+	if existing, exists := n.hashes[nickname]; exists && bytes.Equal(existing, hash) {
+		delete(n.hashes, nickname)
+		n.version++
+	}
+}
+
+// Hash returns the password hash of a registered nickname
+func (n *nickStore) Hash(nickname string) ([]byte, bool) {
+	hash, exists := n.hashes[nickname]
+	return hash, exists
+}
+
+// IsRegistered reports whether the nickname is protected by a password
+func (n *nickStore) IsRegistered(nickname string) bool {
+	_, exists := n.hashes[nickname]
+	return exists
+}
+
+// Lookalike returns a registered nickname that looks like the username without being it, if there is one
+func (n *nickStore) Lookalike(username string) (string, bool) {
+	normalized := normalizeUsername(username)
+	for nickname := range n.hashes {
+		if nickname != username && normalizeUsername(nickname) == normalized {
+			return nickname, true
+		}
+	}
+	return "", false
+}
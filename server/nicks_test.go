@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// savedNicknames returns the nicknames registered in the file
+func savedNicknames(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hashes map[string]string
+	if err := json.Unmarshal(data, &hashes); err != nil {
+		t.Fatal(err)
+	}
+	nicknames := make([]string, 0, len(hashes))
+	for nickname := range hashes {
+		nicknames = append(nicknames, nickname)
+	}
+	slices.Sort(nicknames)
+	return nicknames
+}
+
+func TestNickStoreSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nicks.json")
+	nicks, err := newNickStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nicks.Add("alice", []byte("hash"))
+	older := nicks.snapshot()
+	nicks.Add("bob", []byte("hash"))
+	newer := nicks.snapshot()
+
+	// Saves run in their own goroutines, so the older snapshot can be written last
+	if err := nicks.save(newer); err != nil {
+		t.Fatal(err)
+	}
+	if err := nicks.save(older); err != nil {
+		t.Fatal(err)
+	}
+	if got := savedNicknames(t, path); !slices.Equal(got, []string{"alice", "bob"}) {
+		t.Errorf("saved %q, want the newer snapshot", got)
+	}
+
+	reloaded, err := newNickStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reloaded.IsRegistered("alice") || !reloaded.IsRegistered("bob") {
+		t.Error("the saved nicknames aren't registered once loaded again")
+	}
+}
+
+func TestNickStoreRemove(t *testing.T) {
+	nicks, _ := newNickStore("")
+	nicks.Add("alice", []byte("first"))
+
+	nicks.Remove("alice", []byte("other"))
+	if !nicks.IsRegistered("alice") {
+		t.Error("removed with another hash")
+	}
+	nicks.Remove("alice", []byte("first"))
+	if nicks.IsRegistered("alice") {
+		t.Error("not removed with its hash")
+	}
+}
+
+func TestPasswordsEqual(t *testing.T) {
+	tests := []struct {
+		name  string
+		given string
+		want  string
+		equal bool
+	}{
+		{name: "equal", given: "secret", want: "secret", equal: true},
+		{name: "different", given: "public", want: "secret"},
+		{name: "prefix", given: "secre", want: "secret"},
+		{name: "longer", given: "secrets", want: "secret"},
+		{name: "case", given: "Secret", want: "secret"},
+		{name: "both empty", equal: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if equal := passwordsEqual(test.given, test.want); equal != test.equal {
+				t.Errorf("passwordsEqual(%q, %q) = %t, want %t", test.given, test.want, equal, test.equal)
+			}
+		})
+	}
+}
+
+func TestPasswordsAreCheckedOffTheRunLoop(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		args    []string
+		want    string
+	}{
+		{name: "register", command: "register", args: []string{"correct horse"}, want: "'alice' is now registered."},
+		{name: "login", command: "login", args: []string{"correct horse"}, want: "You are logged in as 'bob'."},
+		{name: "wrong login", command: "login", args: []string{"wrong password"}, want: "Incorrect password."},
+		{name: "ghost", command: "ghost", args: []string{"bob", "correct horse"}, want: "You have reclaimed 'bob'."},
+		{name: "wrong ghost", command: "ghost", args: []string{"bob", "wrong password"}, want: "Invalid username or password."},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, nil)
+			server.nicks.file.path = filepath.Join(t.TempDir(), "nicks.json")
+			registerNickname(t, server, "bob")
+			alice := connect(server, "alice")
+			if err := server.requireLogin(alice, "bob"); err == nil {
+				t.Fatal("bob wasn't required to log in")
+			}
+
+			server.runCommand(Command{Name: test.command, Args: test.args, Client: alice, ReceivedAt: time.Now()})
+			if server.pendingWork == 0 || len(alice.sent) > 0 {
+				t.Fatalf("the command was handled on the run loop, replying %q", alice.sent)
+			}
+
+			finishWork(t, server)
+			if !alice.received(test.want) {
+				t.Errorf("got %q, want %q", alice.sent, test.want)
+			}
+		})
+	}
+}
+
+func TestRegisterSavesOffTheRunLoop(t *testing.T) {
+	tests := []struct {
+		name           string
+		path           func(dir string) string
+		want           string
+		wantRegistered bool
+	}{
+		{name: "saved", path: func(dir string) string { return filepath.Join(dir, "nicks.json") }, want: "'alice' is now registered.", wantRegistered: true},
+		{name: "saving failed", path: func(dir string) string { return filepath.Join(dir, "missing", "nicks.json") }, want: "Failed to register your username."},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, nil)
+			server.nicks.file.path = test.path(t.TempDir())
+			alice := connect(server, "alice")
+
+			server.runCommand(Command{Name: "register", Args: []string{"correct horse"}, Client: alice, ReceivedAt: time.Now()})
+			finishWork(t, server)
+
+			if !alice.received(test.want) {
+				t.Errorf("got %q, want %q", alice.sent, test.want)
+			}
+			if registered := server.nicks.IsRegistered("alice"); registered != test.wantRegistered {
+				t.Errorf("registered %t, want %t", registered, test.wantRegistered)
+			}
+			if test.wantRegistered && !slices.Equal(savedNicknames(t, server.nicks.file.path), []string{"alice"}) {
+				t.Error("alice wasn't saved")
+			}
+		})
+	}
+}
+
+func TestOffLoopResultsForClientsThatLeft(t *testing.T) {
+	server := newTestServer(t, nil)
+	server.nicks.file.path = filepath.Join(t.TempDir(), "nicks.json")
+	registerNickname(t, server, "bob")
+	alice := connect(server, "alice")
+
+	server.runCommand(Command{Name: "ghost", Args: []string{"bob", "correct horse"}, Client: alice, ReceivedAt: time.Now()})
+	delete(server.clients, alice.clientsKey()) // Disconnected while the password was checked
+	alice.sent = nil
+	finishWork(t, server)
+
+	if len(alice.sent) > 0 || strings.Contains(alice.GetUsername(), "bob") {
+		t.Errorf("alice left but got %q and is called %q", alice.sent, alice.GetUsername())
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Identical notices sent to a client within this window after the first one are merged into a single message
+const noticeCoalesceWindow = 2 * time.Second
+
+// noticeCoalescer merges repeated server notices to a client, e.g. rate limit warnings,
+// so they don't fill the client's send queue themselves
+type noticeCoalescer struct {
+	mu      sync.Mutex
+	pending map[string]*pendingNotice // Notices within their window by key
+}
+
+// pendingNotice is a notice that has been sent and the repeats of it held back since
+type pendingNotice struct {
+	msg     string // Latest version of the notice
+	repeats int
+}
+
+// SendNotice sends a server notice, merging it with the notices sent with the same key within noticeCoalesceWindow.
+// The first one is sent right away and the repeats are sent as one message with an "(xN)" suffix once the window closes.
+// The key identifies notices that only differ in details, e.g. the time left before retrying.
+// Only meant for notices generated by the server, never relayed messages.
+func (c *Client) SendNotice(key, msg string) {
+	c.notices.mu.Lock()
+	if c.notices.pending == nil {
+		c.notices.pending = make(map[string]*pendingNotice)
+	}
+	if pending, ok := c.notices.pending[key]; ok {
+		pending.msg = msg
+		pending.repeats++
+		c.notices.mu.Unlock()
+		return
+	}
+	c.notices.pending[key] = &pendingNotice{msg: msg}
+	c.notices.mu.Unlock()
+
+	c.SendMessage(msg)
+	time.AfterFunc(noticeCoalesceWindow, func() {
+		c.flushNotice(key)
+	})
+}
+
+// flushNotice closes the window of the notice, sending its repeats as a single message
+func (c *Client) flushNotice(key string) {
+	c.notices.mu.Lock()
+	pending := c.notices.pending[key]
+	delete(c.notices.pending, key)
+	c.notices.mu.Unlock()
+
+	if pending == nil || pending.repeats == 0 {
+		return
+	}
+
+	// Sent through the run loop since the client may have disconnected in the meantime
+	delivery := Delivery{To: c, Body: fmt.Sprintf("%s (x%d)", pending.msg, pending.repeats)}
+	select {
+	case c.server.deliver <- delivery:
+	case <-c.server.shutdown:
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Role is the level of privileges a client has when running a command
+type Role int
+
+const (
+	RoleUser Role = iota
+	RoleOperator
+	RoleOwner // The channel's owner, who is also one of its operators
+	RoleAdmin
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleOperator:
+		return "operator"
+	case RoleOwner:
+		return "owner"
+	case RoleAdmin:
+		return "admin"
+	default:
+		return "user"
+	}
+}
+
+// ChannelResolver returns the channel a command acts on, or nil if it can't be determined
+type ChannelResolver func(args []string, client Session) *Channel
+
+// CommandSpec declares a command's handler along with the role required to run it
+type CommandSpec struct {
+	Handler CommandFunc
+	Role    Role            // Minimum role required to run the command
+	Channel ChannelResolver // Resolves the channel an operator command applies to
+
+	// Minimum time between two uses of the command by the same client, admins are exempt.
+	// Meant for commands with large outputs that are cheap to spam.
+	Cooldown time.Duration
+}
+
+// channelArg resolves the joined channel named by the argument at the given position,
+// falling back to the active channel when the argument is missing
+func channelArg(position int) ChannelResolver {
+	return func(args []string, client Session) *Channel {
+		if len(args) > position {
+			return client.GetJoinedChannel(args[position])
+		}
+		return client.GetChannel()
+	}
+}
+
+// activeChannel resolves the client's active channel
+func activeChannel(args []string, client Session) *Channel {
+	return client.GetChannel()
+}
+
+// roleIn returns the client's role for a command acting on the given channel, which can be nil
+func roleIn(client Session, channel *Channel) Role {
+	if client.IsAdmin() {
+		return RoleAdmin
+	}
+
+	if channel != nil && channel.IsOwner(client) {
+		return RoleOwner
+	}
+
+	if channel != nil && channel.IsOperator(client) {
+		return RoleOperator
+	}
+
+	return RoleUser
+}
+
+// authorize checks that the client has the role required by the command before it's executed.
+// If the channel of an operator command can't be resolved, the handler is left to report it.
+func (s *Server) authorize(spec CommandSpec, cmd Command) error {
+	if spec.Role == RoleUser {
+		return nil
+	}
+
+	var channel *Channel
+	if spec.Role == RoleOperator && spec.Channel != nil {
+		channel = spec.Channel(cmd.Args, cmd.Client)
+		if channel == nil {
+			return nil
+		}
+	}
+
+	if roleIn(cmd.Client, channel) >= spec.Role {
+		return nil
+	}
+
+	if channel != nil {
+		return fmt.Errorf("you need to be an %s of '%s' to do that", spec.Role, channel.Name)
+	}
+	return fmt.Errorf("you need to be an %s to do that", spec.Role)
+}
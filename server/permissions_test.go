@@ -0,0 +1,187 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRoleIn(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, server *Server, alice *fakeSession) *Channel
+		want  Role
+	}{
+		{
+			name:  "no channel",
+			setup: func(t *testing.T, server *Server, alice *fakeSession) *Channel { return nil },
+			want:  RoleUser,
+		},
+		{
+			name: "member",
+			setup: func(t *testing.T, server *Server, alice *fakeSession) *Channel {
+				join(t, server, connect(server, "bob"), "general")
+				return join(t, server, alice, "general")
+			},
+			want: RoleUser,
+		},
+		{
+			name: "operator",
+			setup: func(t *testing.T, server *Server, alice *fakeSession) *Channel {
+				join(t, server, connect(server, "bob"), "general")
+				channel := join(t, server, alice, "general")
+				channel.AddOperator(alice)
+				return channel
+			},
+			want: RoleOperator,
+		},
+		{
+			name: "owner",
+			setup: func(t *testing.T, server *Server, alice *fakeSession) *Channel {
+				return join(t, server, alice, "general")
+			},
+			want: RoleOwner,
+		},
+		{
+			name: "owner of another channel",
+			setup: func(t *testing.T, server *Server, alice *fakeSession) *Channel {
+				join(t, server, alice, "random")
+				return join(t, server, connect(server, "bob"), "general")
+			},
+			want: RoleUser,
+		},
+		{
+			name: "admin",
+			setup: func(t *testing.T, server *Server, alice *fakeSession) *Channel {
+				alice.SetAdmin(true)
+				return nil
+			},
+			want: RoleAdmin,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, nil)
+			alice := connect(server, "alice")
+			channel := test.setup(t, server, alice)
+			if got := roleIn(alice, channel); got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    CommandSpec
+		admin   bool
+		owner   bool // Whether alice created general, or joined it after bob
+		wantErr string
+	}{
+		{name: "user command", spec: CommandSpec{Role: RoleUser}},
+		{name: "operator command as member", spec: CommandSpec{Role: RoleOperator, Channel: activeChannel}, wantErr: "you need to be an operator of 'general' to do that"},
+		{name: "operator command as owner", spec: CommandSpec{Role: RoleOperator, Channel: activeChannel}, owner: true},
+		{name: "operator command as admin", spec: CommandSpec{Role: RoleOperator, Channel: activeChannel}, admin: true},
+		{name: "operator command without a channel", spec: CommandSpec{Role: RoleOperator, Channel: channelArg(0)}},
+		{name: "admin command", spec: CommandSpec{Role: RoleAdmin}, owner: true, wantErr: "you need to be an admin to do that"},
+		{name: "admin command as admin", spec: CommandSpec{Role: RoleAdmin}, admin: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, nil)
+			alice := connect(server, "alice")
+			alice.SetAdmin(test.admin)
+			if !test.owner {
+				join(t, server, connect(server, "bob"), "general")
+			}
+			join(t, server, alice, "general")
+
+			args := []string{}
+			if test.name == "operator command without a channel" {
+				args = []string{"random"} // A channel alice isn't in, left to the handler to report
+			}
+			err := server.authorize(test.spec, Command{Name: "test", Args: args, Client: alice, ReceivedAt: time.Now()})
+			if test.wantErr == "" && err != nil {
+				t.Errorf("got error %v", err)
+			}
+			if test.wantErr != "" && (err == nil || err.Error() != test.wantErr) {
+				t.Errorf("got error %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestChannelOwnership(t *testing.T) {
+	tests := []struct {
+		name       string
+		persistent bool
+		setup      func(t *testing.T, server *Server, alice, bob *fakeSession)
+		wantOwner  string
+	}{
+		{
+			name: "creator",
+			setup: func(t *testing.T, server *Server, alice, bob *fakeSession) {
+				join(t, server, alice, "general")
+				join(t, server, bob, "general")
+			},
+			wantOwner: "alice",
+		},
+		{
+			name: "renamed owner",
+			setup: func(t *testing.T, server *Server, alice, bob *fakeSession) {
+				join(t, server, alice, "general")
+				join(t, server, bob, "general")
+				if err := server.changeUsername(alice, "alice", "carol"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantOwner: "carol",
+		},
+		{
+			name: "operators aren't owners",
+			setup: func(t *testing.T, server *Server, alice, bob *fakeSession) {
+				join(t, server, alice, "general")
+				join(t, server, bob, "general").AddOperator(bob)
+			},
+			wantOwner: "alice",
+		},
+		{
+			name:       "emptied persistent channel",
+			persistent: true,
+			setup: func(t *testing.T, server *Server, alice, bob *fakeSession) {
+				channel := join(t, server, alice, "general")
+				server.leaveChannel(alice, channel)
+				join(t, server, bob, "general")
+			},
+			wantOwner: "bob",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, func(config *Config) { config.PersistentChannels = test.persistent })
+			alice, bob := connect(server, "alice"), connect(server, "bob")
+			test.setup(t, server, alice, bob)
+
+			channel := server.channels["general"]
+			if channel.Owner() != test.wantOwner {
+				t.Errorf("owner is %q, want %q", channel.Owner(), test.wantOwner)
+			}
+
+			// Only the owner can change the topic
+			for _, member := range []*fakeSession{alice, bob} {
+				if member.GetJoinedChannel("general") == nil {
+					continue
+				}
+				member.sent = nil
+				server.runCommand(Command{Name: "topic", Args: []string{"new", "topic"}, Client: member, ReceivedAt: time.Now()})
+				refused := member.received("Only the channel owner can change the topic.")
+				if isOwner := member.GetUsername() == test.wantOwner; refused == isOwner {
+					t.Errorf("%s changing the topic was refused: %t", member.GetUsername(), refused)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"plugin"
+	"slices"
+
+	"github.com/CDavidSV/Go-TCP-Chat/pluginapi"
+)
+
+// CommandPlugin is implemented by the value exported as "Plugin" from a Go plugin built with -buildmode=plugin.
+// Plugins build against the pluginapi package, since they can't import the server's types in package main.
+type CommandPlugin = pluginapi.CommandPlugin
+
+// pluginServer is the server as plugins see it
+type pluginServer struct {
+	server *Server
+}
+
+var _ pluginapi.Server = pluginServer{}
+
+func (p pluginServer) Usernames() []string {
+	var usernames []string
+	for _, client := range p.server.clients {
+		if client.IsRegistered() {
+			usernames = append(usernames, client.GetUsername())
+		}
+	}
+	slices.Sort(usernames)
+	return usernames
+}
+
+func (p pluginServer) Broadcast(channel, message string) error {
+	ch, exists := p.server.channels[channel]
+	if !exists {
+		return fmt.Errorf("channel '%s' not found", channel)
+	}
+	return p.server.broadcastMessage(nil, ch, message)
+}
+
+// loadPlugin opens the plugin at the given path and registers its command
+func (s *Server) loadPlugin(path string) (CommandPlugin, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	symbol, err := p.Lookup("Plugin")
+	if err != nil {
+		return nil, err
+	}
+
+	cmdPlugin, ok := symbol.(CommandPlugin)
+	if !ok {
+		return nil, fmt.Errorf("'Plugin' does not implement pluginapi.CommandPlugin")
+	}
+
+	if err := s.registerPlugin(cmdPlugin); err != nil {
+		return nil, err
+	}
+	return cmdPlugin, nil
+}
+
+// registerPlugin adds the plugin's command, replacing the one of a plugin with the same name
+func (s *Server) registerPlugin(cmdPlugin CommandPlugin) error {
+	name := cmdPlugin.Name()
+	if _, exists := s.commands[name]; exists {
+		if _, isPlugin := s.plugins[name]; !isPlugin {
+			return fmt.Errorf("'/%s' is a built-in command", name)
+		}
+	}
+
+	s.plugins[name] = cmdPlugin
+	s.commands[name] = CommandSpec{
+		Handler: func(name string, args []string, client Session, server *Server) {
+			cmdPlugin.Exec(args, client, pluginServer{server: server})
+		},
+	}
+	return nil
+}
+
+// unloadPlugin removes the plugin's command. Go plugins can't be unloaded, so its code stays in memory.
+func (s *Server) unloadPlugin(name string) bool {
+	if _, exists := s.plugins[name]; !exists {
+		return false
+	}
+
+	delete(s.plugins, name)
+	delete(s.commands, name)
+	return true
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/CDavidSV/Go-TCP-Chat/pluginapi"
+)
+
+// testPlugin is a command plugin that runs exec, like a plugin loaded from a file would run its Exec
+type testPlugin struct {
+	name string
+	exec func(args []string, client pluginapi.Client, server pluginapi.Server)
+}
+
+func (p testPlugin) Name() string { return p.name }
+func (p testPlugin) Exec(args []string, client pluginapi.Client, server pluginapi.Server) {
+	p.exec(args, client, server)
+}
+
+// withPlugin registers a plugin for the command "dice" that runs exec
+func withPlugin(exec func(args []string, client pluginapi.Client, server pluginapi.Server)) func(t *testing.T, server *Server, alice *fakeSession) {
+	return func(t *testing.T, server *Server, alice *fakeSession) {
+		if err := server.registerPlugin(testPlugin{name: "dice", exec: exec}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPluginCommands(t *testing.T) {
+	runCommandTests(t, []commandTest{
+		{
+			name:    "exec",
+			command: "dice",
+			args:    []string{"roll", "2"},
+			setup: withPlugin(func(args []string, client pluginapi.Client, server pluginapi.Server) {
+				client.SendServerMessage(client.GetUsername() + " ran " + strings.Join(args, " "))
+			}),
+			want: "alice ran roll 2",
+		},
+		{
+			name:    "usernames",
+			command: "dice",
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				connect(server, "carol")
+				connect(server, "bob")
+				withPlugin(func(args []string, client pluginapi.Client, server pluginapi.Server) {
+					client.SendServerMessage(strings.Join(server.Usernames(), ","))
+				})(t, server, alice)
+			},
+			want: "alice,bob,carol",
+		},
+		{
+			name:    "broadcast",
+			command: "dice",
+			setup: func(t *testing.T, server *Server, alice *fakeSession) {
+				join(t, server, alice, "general")
+				withPlugin(func(args []string, client pluginapi.Client, server pluginapi.Server) {
+					server.Broadcast("general", "rolled a 4")
+				})(t, server, alice)
+			},
+			want: "rolled a 4",
+		},
+		{
+			name:    "broadcast to unknown channel",
+			command: "dice",
+			setup: withPlugin(func(args []string, client pluginapi.Client, server pluginapi.Server) {
+				if err := server.Broadcast("general", "rolled a 4"); err != nil {
+					client.SendServerMessage(err.Error())
+				}
+			}),
+			want: "channel 'general' not found",
+		},
+	})
+}
+
+func TestRegisterPlugin(t *testing.T) {
+	tests := []struct {
+		name    string
+		plugin  string
+		wantErr bool
+	}{
+		{name: "new command", plugin: "dice"},
+		{name: "replaces a plugin", plugin: "echoplugin"},
+		{name: "built-in command", plugin: "join", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, nil)
+			if err := server.registerPlugin(testPlugin{name: "echoplugin"}); err != nil {
+				t.Fatal(err)
+			}
+
+			err := server.registerPlugin(testPlugin{name: test.plugin})
+			if (err != nil) != test.wantErr {
+				t.Fatalf("registerPlugin: %v, want an error: %t", err, test.wantErr)
+			}
+			if _, isPlugin := server.plugins[test.plugin]; isPlugin == test.wantErr {
+				t.Errorf("'%s' is a plugin: %t", test.plugin, isPlugin)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version of the protocol spoken by the server, sent to clients along with its features when they connect.
+// Clients whose server is at version 2 or later start with HELLO.
+const protocolVersion = 3
+
+// protocolBridged is the first protocol version whose clients get messages relayed by bridges as bridged events
+const protocolBridged = 3
+
+// Feature is a bitmask of optional protocol features
+type Feature uint32
+
+const (
+	FeatureHeartbeat   Feature = 1 << iota // Pings clients must answer with PONG
+	FeatureCompression                     // Compressed message bodies
+	FeatureE2E                             // End-to-end encrypted whispers
+	FeatureThreading                       // Replies threaded under a message
+	FeatureResume                          // Sessions resumed with a token after the connection drops, see resume.go
+	FeatureChannelList                     // The channel list sent as a channel-list event to /channels --event
+	FeatureKeepalive                       // Empty frames sent to keep idle connections open, see keepalive.go
+)
+
+// supportedFeatures are the features this server implements
+const supportedFeatures = FeatureHeartbeat | FeatureResume | FeatureChannelList | FeatureKeepalive
+
+var featureNames = []struct {
+	Feature Feature
+	Name    string
+}{
+	{FeatureHeartbeat, "heartbeat"},
+	{FeatureCompression, "compression"},
+	{FeatureE2E, "e2e"},
+	{FeatureThreading, "threading"},
+	{FeatureResume, "resume"},
+	{FeatureChannelList, "channel-list"},
+	{FeatureKeepalive, "keepalive"},
+}
+
+// Has reports whether all the given features are set
+func (f Feature) Has(features Feature) bool {
+	return f&features == features
+}
+
+// String lists the names of the features that are set, e.g. "heartbeat, threading"
+func (f Feature) String() string {
+	var names []string
+	for _, feature := range featureNames {
+		if f.Has(feature.Feature) {
+			names = append(names, feature.Name)
+		}
+	}
+
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ", ")
+}
+
+// Protocol levels of clients that didn't send HELLO, decided by the first line they send
+const (
+	protocolUnknown = -1 // The client hasn't sent anything yet
+	protocolLegacy  = 0  // The client predates the handshake and started with its username
+	protocolColors  = 1  // The client predates the handshake but started with TERMCOLOR and answers pings
+)
+
+// parseHello parses the argument of the HELLO line clients start with: "<protocol version> <feature bitmask>"
+func parseHello(argument string) (int, Feature, error) {
+	versionText, featuresText, ok := strings.Cut(argument, " ")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid HELLO '%s'", argument)
+	}
+
+	version, err := strconv.Atoi(versionText)
+	if err != nil || version < 1 {
+		return 0, 0, fmt.Errorf("invalid protocol version '%s'", versionText)
+	}
+
+	features, err := strconv.ParseUint(featuresText, 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid feature bitmask '%s'", featuresText)
+	}
+	return version, Feature(features), nil
+}
+
+// handshakeGrace is how long the server waits for the first line of a client before writing anything to it,
+// since that line decides how frames are written. Clients that speak the handshake send HELLO right away;
+// legacy ones wait for the welcome before sending their username, so they are taken for legacy once it's over.
+const handshakeGrace = 2 * time.Second
+
+// negotiate sets the client's protocol level and features from a line it sent before registering,
+// reporting whether it was a HELLO, which isn't handled any further.
+// Lines that aren't a handshake verb come from legacy clients, which are kept on the protocol they know:
+// their framing, no heartbeats and no read receipts. Their line is then handled as usual, as their username.
+func (c *Client) negotiate(line string) bool {
+	argument, isHello := strings.CutPrefix(line, "HELLO ")
+	level, features := int32(protocolLegacy), Feature(0)
+	switch {
+	case isHello:
+		version, helloFeatures, err := parseHello(argument)
+		if err != nil {
+			c.server.log.client.Warn("Invalid handshake, using the legacy protocol", "ip", c.IP, "error", err)
+			break
+		}
+		level, features = int32(min(version, protocolVersion)), helloFeatures&supportedFeatures
+	case strings.HasPrefix(line, "TERMCOLOR "):
+		level, features = protocolColors, FeatureHeartbeat
+	}
+
+	if !c.settleProtocol(level, features) {
+		if isHello {
+			c.server.log.client.Warn("Handshake sent after the protocol was settled, ignoring it", "ip", c.IP, "level", c.protocol.Load())
+		}
+		return isHello
+	}
+	c.server.log.client.Info("Client protocol", "id", c.ID(), "ip", c.IP, "level", level, "features", features.String())
+	return isHello
+}
+
+// settleProtocol sets the client's protocol level and features unless they were already set, reporting whether they were.
+// Frames are only written to the client once they are, see awaitProtocol.
+func (c *Client) settleProtocol(level int32, features Feature) bool {
+	if !c.protocol.CompareAndSwap(protocolUnknown, level) {
+		return false
+	}
+	c.features.Store(uint32(features))
+	close(c.negotiated)
+	return true
+}
+
+// readHandshake negotiates the protocol with the first line of a client whose lines aren't read otherwise,
+// waiting for it at most handshakeGrace
+func (c *Client) readHandshake() {
+	c.conn.SetReadDeadline(time.Now().Add(handshakeGrace))
+	defer c.conn.SetReadDeadline(time.Time{})
+
+	if line, err := c.readLine(); err == nil {
+		c.negotiate(strings.TrimSpace(line))
+	}
+}
+
+// awaitProtocol blocks until the client's protocol is settled, taking it for a legacy client after handshakeGrace
+func (c *Client) awaitProtocol() {
+	timer := time.NewTimer(handshakeGrace)
+	defer timer.Stop()
+
+	select {
+	case <-c.negotiated:
+	case <-timer.C:
+		if c.settleProtocol(protocolLegacy, 0) {
+			c.server.log.client.Info("Client protocol", "id", c.ID(), "ip", c.IP, "level", protocolLegacy, "features", Feature(0).String())
+		}
+	}
+}
+
+// uses reports whether the feature was negotiated with the client
+func (c *Client) uses(feature Feature) bool {
+	return Feature(c.features.Load()).Has(feature)
+}
+
+// expectsHeartbeat reports whether the client is pinged and disconnected once it stops answering.
+// Clients that haven't sent anything yet are, so connections that never speak are still closed.
+func (c *Client) expectsHeartbeat() bool {
+	return c.protocol.Load() == protocolUnknown || c.uses(FeatureHeartbeat)
+}
+
+// formatFeatures formats the argument of the features event sent to clients when they connect: "<protocol version> <feature bitmask>"
+func formatFeatures() string {
+	return fmt.Sprintf("%d %d", protocolVersion, supportedFeatures)
+}
+
+func version(name string, args []string, client Session, server *Server) {
+	client.SendServerMessage(fmt.Sprintf("Protocol version %d, features: %s (%d)", protocolVersion, supportedFeatures, supportedFeatures))
+}
+
+// legacyBody converts the body of a frame to the "sender|content" format legacy clients know, reporting false for
+// the frames they have no use for. Events are dropped, except the reason the connection is closed, which becomes a
+// server message. Legacy clients can be in several channels too, so the channel of a message is put before its content.
+func legacyBody(body string) (string, bool) {
+	if body == streamStartMarker || body == streamEndMarker {
+		return "", false
+	}
+
+	sender, rest, _ := strings.Cut(body, "|")
+	channelName, content, _ := strings.Cut(rest, "|")
+	switch {
+	case sender == "system" && channelName == "close":
+		sender = "Server"
+	case sender == "system":
+		return "", false
+	case channelName != "":
+		content = "[#" + channelName + "] " + content
+	}
+	return sender + "|" + content, true
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name         string
+		lines        []string // Sent before registering, the first one settles the protocol
+		wantLevel    int32
+		wantFeatures Feature
+		wantHello    bool // Whether the last line was taken for a handshake
+	}{
+		{name: "hello", lines: []string{"HELLO 3 1"}, wantLevel: 3, wantFeatures: FeatureHeartbeat, wantHello: true},
+		{name: "newer client", lines: []string{"HELLO 9 1"}, wantLevel: protocolVersion, wantFeatures: FeatureHeartbeat, wantHello: true},
+		{name: "unsupported features", lines: []string{"HELLO 3 6"}, wantLevel: 3, wantFeatures: 0, wantHello: true},
+		{name: "invalid hello", lines: []string{"HELLO three"}, wantLevel: protocolLegacy, wantHello: true},
+		{name: "termcolor", lines: []string{"TERMCOLOR ANSI256"}, wantLevel: protocolColors, wantFeatures: FeatureHeartbeat},
+		{name: "username", lines: []string{"alice"}, wantLevel: protocolLegacy},
+		{name: "hello after the username", lines: []string{"alice", "HELLO 3 1"}, wantLevel: protocolLegacy, wantHello: true},
+		{name: "second hello", lines: []string{"HELLO 3 1", "HELLO 2 0"}, wantLevel: 3, wantFeatures: FeatureHeartbeat, wantHello: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, nil)
+			client, _ := newPipeClient(t, server, "")
+
+			var hello bool
+			for _, line := range test.lines {
+				hello = client.negotiate(line)
+			}
+			if hello != test.wantHello {
+				t.Errorf("negotiate(%q) = %t, want %t", test.lines[len(test.lines)-1], hello, test.wantHello)
+			}
+			if level := client.Protocol(); level != test.wantLevel {
+				t.Errorf("protocol level %d, want %d", level, test.wantLevel)
+			}
+			if features := Feature(client.features.Load()); features != test.wantFeatures {
+				t.Errorf("features %s, want %s", features, test.wantFeatures)
+			}
+			select {
+			case <-client.negotiated:
+			default:
+				t.Error("the protocol isn't settled")
+			}
+		})
+	}
+}
+
+// readLegacyFrame reads a frame written to a legacy client, which has no timestamp in its header
+func readLegacyFrame(conn net.Conn) (string, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+	body := make([]byte, binary.LittleEndian.Uint32(header))
+	_, err := io.ReadFull(conn, body)
+	return string(body), err
+}
+
+func TestFraming(t *testing.T) {
+	tests := []struct {
+		name      string
+		firstLine string // Nothing is sent if empty
+		legacy    bool
+	}{
+		{name: "hello", firstLine: fmt.Sprintf("HELLO %d %d", protocolVersion, supportedFeatures)},
+		{name: "termcolor", firstLine: "TERMCOLOR ANSI"},
+		{name: "username", firstLine: "alice", legacy: true},
+		{name: "silent", legacy: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, addr := serve(t, nil)
+			defer server.Shutdown()
+
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if test.firstLine != "" {
+				fmt.Fprintln(conn, test.firstLine)
+			}
+
+			conn.SetReadDeadline(time.Now().Add(2 * handshakeGrace))
+			read := func(conn net.Conn) (string, error) {
+				body, _, err := readFrame(conn)
+				return body, err
+			}
+			if test.legacy {
+				read = readLegacyFrame
+			}
+
+			// Every frame up to the welcome must parse with the framing the client expects
+			for {
+				body, err := read(conn)
+				if err != nil {
+					t.Fatalf("reading a frame: %v", err)
+				}
+				if !strings.HasPrefix(body, "Server|") && !strings.HasPrefix(body, "system|") {
+					t.Fatalf("frame %q isn't from the server, the framing is wrong", body)
+				}
+				if test.legacy && (strings.HasPrefix(body, "system|") || strings.HasPrefix(body, "Server||")) {
+					t.Fatalf("frame %q isn't in the legacy format", body)
+				}
+				if strings.Contains(body, "Welcome!") {
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestLegacyBody(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		want   string
+		wantOK bool
+	}{
+		{name: "server message", body: formatMessage("Server", "Welcome!"), want: "Server|Welcome!", wantOK: true},
+		{name: "channel message", body: formatChannelMessage("alice", "general", "hi"), want: "alice|[#general] hi", wantOK: true},
+		{name: "pipes in the content", body: formatChannelMessage("Server", "", "a|b"), want: "Server|a|b", wantOK: true},
+		{name: "no sender", body: formatMessage("", "plain"), want: ".|plain", wantOK: true},
+		{name: "close", body: formatEvent("close", "You are banned from this server."), want: "Server|You are banned from this server.", wantOK: true},
+		{name: "event", body: formatEvent("features", formatFeatures())},
+		{name: "stream start", body: streamStartMarker},
+		{name: "stream end", body: streamEndMarker},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := legacyBody(test.body)
+			if got != test.want || ok != test.wantOK {
+				t.Errorf("legacyBody(%q) = %q, %t, want %q, %t", test.body, got, ok, test.want, test.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// RateLimiter decides whether a client can send another message
+type RateLimiter interface {
+	// Allow records a message sent at now. If it exceeds the limit, it returns false and how long to wait before trying again.
+	Allow(now time.Time) (ok bool, retryAfter time.Duration)
+}
+
+// rateLimiters are the message rate limiters that can be picked with -rate-limiter, by name.
+// All of them allow bursts of maxBucketSize messages and bucketRate messages per second in the long run.
+var rateLimiters = map[string]func() RateLimiter{
+	"token-bucket": func() RateLimiter {
+		return &bucketLimiter{capacity: maxBucketSize, rate: bucketRate}
+	},
+	"sliding-window": func() RateLimiter {
+		return &slidingWindowLimiter{limit: maxBucketSize, window: time.Duration(float64(maxBucketSize) / bucketRate * float64(time.Second))}
+	},
+}
+
+// tokenBucket is a rate limiter that refills at a steady rate up to a maximum number of tokens.
+// Fractional tokens are kept so refill rates below one per second work as expected.
+type tokenBucket struct {
+	tokens      float64
+	lastRequest time.Time
+}
+
+// take refills the bucket for the time elapsed since the last request and takes a token from it,
+// returning false if there are none left
+func (b *tokenBucket) take(capacity int, rate float64) bool {
+	ok, _ := b.allow(time.Now(), capacity, rate)
+	return ok
+}
+
+// takeN takes n tokens at once, or none if there aren't enough, so a whisper to several users counts once per user
+func (b *tokenBucket) takeN(n, capacity int, rate float64) bool {
+	now := time.Now()
+	b.tokens = math.Min(b.tokens+now.Sub(b.lastRequest).Seconds()*rate, float64(capacity))
+	b.lastRequest = now
+
+	if b.tokens < float64(n) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+// allow is take at the given time, also returning how long until the next token if there are none left
+func (b *tokenBucket) allow(now time.Time, capacity int, rate float64) (bool, time.Duration) {
+	elapsed := now.Sub(b.lastRequest).Seconds()
+
+	b.tokens = math.Min(b.tokens+elapsed*rate, float64(capacity))
+	b.lastRequest = now
+
+	if b.tokens < 1 {
+		// Rounded up, so the token is there when the client retries after exactly that long
+		return false, time.Duration(math.Ceil((1 - b.tokens) / rate * float64(time.Second)))
+	}
+
+	b.tokens--
+	return true, 0
+}
+
+// bucketLimiter is a RateLimiter backed by a token bucket
+type bucketLimiter struct {
+	bucket   tokenBucket
+	capacity int
+	rate     float64 // Tokens per second to refill
+}
+
+func (l *bucketLimiter) Allow(now time.Time) (bool, time.Duration) {
+	return l.bucket.allow(now, l.capacity, l.rate)
+}
+
+// slidingWindowLimiter is a RateLimiter that allows up to limit messages within any window of time.
+// It keeps a log of the messages sent within the last window.
+type slidingWindowLimiter struct {
+	limit  int
+	window time.Duration
+	sent   []time.Time // Oldest first
+}
+
+func (l *slidingWindowLimiter) Allow(now time.Time) (bool, time.Duration) {
+	// Forget the messages that are out of the window
+	expired := 0
+	for expired < len(l.sent) && !now.Before(l.sent[expired].Add(l.window)) {
+		expired++
+	}
+	l.sent = l.sent[expired:]
+
+	if len(l.sent) >= l.limit {
+		return false, l.sent[0].Add(l.window).Sub(now)
+	}
+
+	l.sent = append(l.sent, now)
+	return true, 0
+}
+
+// allowAuthAttempt takes a /ghost or /login attempt from the buckets of the client's IP address and of the nickname
+// it claims, returning false if either is empty. Both are kept by the server rather than the connection, so
+// reconnecting doesn't give more guesses, and neither does trying from several addresses.
+func (s *Server) allowAuthAttempt(client Session, nickname string, now time.Time) bool {
+	allowed := true
+	for _, key := range []string{"ip:" + client.Host(), "nick:" + nickname} {
+		bucket, exists := s.authAttempts[key]
+		if !exists {
+			bucket = &tokenBucket{}
+			s.authAttempts[key] = bucket
+		}
+		if ok, _ := bucket.allow(now, maxGhostAttempts, ghostAttemptRate); !ok {
+			allowed = false
+		}
+	}
+	return allowed
+}
+
+// pruneAuthAttempts forgets the attempt buckets that refilled, returning how many were removed
+func (s *Server) pruneAuthAttempts(now time.Time) int {
+	pruned := 0
+	for key, bucket := range s.authAttempts {
+		if bucket.tokens+now.Sub(bucket.lastRequest).Seconds()*ghostAttemptRate >= float64(maxGhostAttempts) {
+			delete(s.authAttempts, key)
+			pruned++
+		}
+	}
+	return pruned
+}
+
+// formatRateLimit formats the argument of the rate-limit event sent to clients when they connect,
+// so they can pace themselves: "<limiter> <burst> <messages per second>"
+func (s *Server) formatRateLimit() string {
+	return fmt.Sprintf("%s %d %g", s.config.RateLimiter, maxBucketSize, bucketRate)
+}
+
+// formatRetryAfter rounds the wait up to whole seconds, at least one, so clients aren't told to retry too early
+// or to wait 0s, e.g. 3s
+func formatRetryAfter(retryAfter time.Duration) string {
+	return max((retryAfter + time.Second - 1).Truncate(time.Second), time.Second).String()
+}
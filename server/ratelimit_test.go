@@ -0,0 +1,199 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	const (
+		capacity = 3
+		rate     = 0.5 // A token every two seconds
+	)
+
+	type step struct {
+		after     time.Duration // Since the previous step
+		want      bool
+		wantRetry time.Duration
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name:  "starts full",
+			steps: []step{{want: true}, {want: true}, {want: true}, {want: false, wantRetry: 2 * time.Second}},
+		},
+		{
+			name: "refills at the rate",
+			steps: []step{
+				{want: true}, {want: true}, {want: true},
+				{after: time.Second, want: false, wantRetry: time.Second},
+				{after: time.Second, want: true},
+				{want: false, wantRetry: 2 * time.Second},
+			},
+		},
+		{
+			name: "refills up to the capacity",
+			steps: []step{
+				{want: true}, {want: true}, {want: true},
+				{after: time.Hour, want: true}, {want: true}, {want: true},
+				{want: false, wantRetry: 2 * time.Second},
+			},
+		},
+		{
+			name: "refused requests don't take tokens",
+			steps: []step{
+				{want: true}, {want: true}, {want: true},
+				{want: false, wantRetry: 2 * time.Second},
+				{want: false, wantRetry: 2 * time.Second},
+				{after: 2 * time.Second, want: true},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var bucket tokenBucket
+			now := time.Now()
+			for i, step := range test.steps {
+				now = now.Add(step.after)
+				ok, retryAfter := bucket.allow(now, capacity, rate)
+				if ok != step.want || retryAfter != step.wantRetry {
+					t.Fatalf("step %d: got %t, retry after %s, want %t, retry after %s", i, ok, retryAfter, step.want, step.wantRetry)
+				}
+			}
+		})
+	}
+}
+
+func TestTokenBucketTakeN(t *testing.T) {
+	tests := []struct {
+		name     string
+		taken    []int // Taken one after the other from a full bucket
+		want     []bool
+		capacity int
+	}{
+		{name: "within capacity", taken: []int{2, 3}, want: []bool{true, true}, capacity: 5},
+		{name: "all or nothing", taken: []int{4, 2, 1}, want: []bool{true, false, true}, capacity: 5},
+		{name: "more than the capacity", taken: []int{6}, want: []bool{false}, capacity: 5},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var bucket tokenBucket
+			for i, n := range test.taken {
+				// The rate is too low for the bucket to refill during the test
+				if got := bucket.takeN(n, test.capacity, 1e-9); got != test.want[i] {
+					t.Errorf("taking %d: got %t, want %t", n, got, test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiters(t *testing.T) {
+	for name, newLimiter := range rateLimiters {
+		t.Run(name, func(t *testing.T) {
+			limiter := newLimiter()
+			now := time.Now()
+			for i := range maxBucketSize {
+				if ok, _ := limiter.Allow(now); !ok {
+					t.Fatalf("message %d of the burst was refused", i)
+				}
+			}
+
+			ok, retryAfter := limiter.Allow(now)
+			if ok || retryAfter <= 0 {
+				t.Fatalf("got %t, retry after %s, want the message past the burst refused", ok, retryAfter)
+			}
+			if ok, _ := limiter.Allow(now.Add(retryAfter)); !ok {
+				t.Errorf("refused after waiting %s", retryAfter)
+			}
+		})
+	}
+}
+
+func TestFormatRetryAfter(t *testing.T) {
+	tests := []struct {
+		retryAfter time.Duration
+		want       string
+	}{
+		{retryAfter: 0, want: "1s"},
+		{retryAfter: -time.Second, want: "1s"},
+		{retryAfter: time.Nanosecond, want: "1s"},
+		{retryAfter: 400 * time.Millisecond, want: "1s"},
+		{retryAfter: time.Second, want: "1s"},
+		{retryAfter: 1500 * time.Millisecond, want: "2s"},
+		{retryAfter: time.Minute, want: "1m0s"},
+	}
+
+	for _, test := range tests {
+		if got := formatRetryAfter(test.retryAfter); got != test.want {
+			t.Errorf("formatRetryAfter(%s) = %q, want %q", test.retryAfter, got, test.want)
+		}
+	}
+}
+
+func TestAuthAttemptLimits(t *testing.T) {
+	type attempt struct {
+		ip       string
+		nickname string
+	}
+	spent := func(ip, nickname string) []attempt {
+		attempts := make([]attempt, maxGhostAttempts)
+		for i := range attempts {
+			attempts[i] = attempt{ip: ip, nickname: nickname}
+		}
+		return attempts
+	}
+
+	tests := []struct {
+		name   string
+		before []attempt // Attempts made right before the one checked
+		wait   time.Duration
+		try    attempt
+		want   bool
+	}{
+		{name: "first attempt", try: attempt{"203.0.113.7", "bob"}, want: true},
+		{name: "within the burst", before: spent("203.0.113.7", "bob")[1:], try: attempt{"203.0.113.7", "bob"}, want: true},
+		{name: "past the burst", before: spent("203.0.113.7", "bob"), try: attempt{"203.0.113.7", "bob"}, want: false},
+		{name: "same address, other nickname", before: spent("203.0.113.7", "bob"), try: attempt{"203.0.113.7", "carol"}, want: false},
+		{name: "same nickname, other address", before: spent("203.0.113.7", "bob"), try: attempt{"198.51.100.1", "bob"}, want: false},
+		{name: "other address and nickname", before: spent("203.0.113.7", "bob"), try: attempt{"198.51.100.1", "carol"}, want: true},
+		{name: "refilled", before: spent("203.0.113.7", "bob"), wait: time.Duration(float64(time.Second) / ghostAttemptRate), try: attempt{"203.0.113.7", "bob"}, want: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, nil)
+			now := time.Now()
+			for _, before := range test.before {
+				// Every attempt comes from a new connection, which doesn't give more attempts
+				session := newFakeSession("guest")
+				session.ip = before.ip + ":50000"
+				server.allowAuthAttempt(session, before.nickname, now)
+			}
+
+			session := newFakeSession("guest")
+			session.ip = test.try.ip + ":50000"
+			if got := server.allowAuthAttempt(session, test.try.nickname, now.Add(test.wait)); got != test.want {
+				t.Errorf("got %t, want %t", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPruneAuthAttempts(t *testing.T) {
+	server := newTestServer(t, nil)
+	now := time.Now()
+	server.allowAuthAttempt(newFakeSession("alice"), "bob", now)
+
+	refilled := time.Duration(float64(time.Second) / ghostAttemptRate)
+	if pruned := server.pruneAuthAttempts(now.Add(refilled / 2)); pruned != 0 {
+		t.Errorf("pruned %d limits before they refilled", pruned)
+	}
+	if pruned := server.pruneAuthAttempts(now.Add(refilled)); pruned != 2 || len(server.authAttempts) != 0 {
+		t.Errorf("pruned %d limits once refilled, %d left, want the address and nickname ones pruned", pruned, len(server.authAttempts))
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	maxQueuedReadAcks = 64   // Read receipts a client can have waiting for the run loop, the rest are dropped
+	readAckQueueSize  = 1024 // Clients with read receipts that can wait for the run loop at once
+)
+
+// readAckQueue holds the read receipts a client sent until the run loop handles them. Clients send them on their
+// own and can't be rate limited for it, so they are handled in batches and the Read goroutine never waits.
+type readAckQueue struct {
+	mu     sync.Mutex
+	ids    []uint64    // Message IDs of the whispers read, guarded by mu
+	queued atomic.Bool // Set while the client waits in the server's readAck channel
+}
+
+// queueReadAck adds the whisper to those the client has read and has the run loop handle them,
+// unless it's already going to
+func (c *Client) queueReadAck(messageID uint64) {
+	c.readAcks.mu.Lock()
+	if len(c.readAcks.ids) < maxQueuedReadAcks {
+		c.readAcks.ids = append(c.readAcks.ids, messageID)
+	}
+	c.readAcks.mu.Unlock()
+
+	if !c.readAcks.queued.CompareAndSwap(false, true) {
+		return
+	}
+	select {
+	case c.server.readAck <- c:
+	default:
+		// The run loop is behind, the receipts are handled along with the next one
+		c.readAcks.queued.Store(false)
+	}
+}
+
+// takeReadAcks returns the whispers the client read since the last call, on the run loop
+func (c *Client) takeReadAcks() []uint64 {
+	// Cleared first so receipts queued from now on wake the run loop again
+	c.readAcks.queued.Store(false)
+
+	c.readAcks.mu.Lock()
+	defer c.readAcks.mu.Unlock()
+	ids := c.readAcks.ids
+	c.readAcks.ids = nil
+	return ids
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	maxRegistrationAttempts = 10 // Lines a client can send before it's disconnected for not managing to register
+	registrationHintAfter   = 2  // Failed attempts after which the username rules are explained
+)
+
+// usernameRules describes the usernames changeUsername accepts
+const usernameRules = "Usernames are 1 to 32 characters long, can't contain spaces or the '|' character, and can't be a reserved name like Server or system."
+
+// countRegistrationAttempt counts a line sent by a client that isn't registered yet, returning false once it used up its attempts.
+// Registering has this limit instead of the rate limit, so fumbling a username doesn't get anyone rate limited before they are in.
+// Only called from the client's Read goroutine.
+func (c *Client) countRegistrationAttempt() bool {
+	c.nameAttempts++
+	if c.nameAttempts == maxRegistrationAttempts+1 {
+		// Only reached by /login, the other attempts disconnect the client as soon as the last one fails
+		c.Disconnect("Too many attempts to set a username.")
+	}
+	return c.nameAttempts <= maxRegistrationAttempts
+}
+
+// registrationFailed tells the client why its username was refused, with more guidance the more attempts it takes,
+// and disconnects it after the last attempt
+func (c *Client) registrationFailed(err error) {
+	if c.nameAttempts >= maxRegistrationAttempts {
+		// The writer closes the connection once the reason is sent, which ends the Read loop
+		c.nameAttempts = maxRegistrationAttempts + 1
+		c.Disconnect(fmt.Sprintf("Failed to set username: %s. Too many attempts to set a username.", err))
+		return
+	}
+
+	lines := []string{fmt.Sprintf("Failed to set username: %s.", err)}
+	if c.nameAttempts >= registrationHintAfter {
+		lines = append(lines, usernameRules)
+	}
+	if left := maxRegistrationAttempts - c.nameAttempts; left == 1 {
+		lines = append(lines, "This is your last attempt before you are disconnected.")
+	} else if left <= maxRegistrationAttempts/2 {
+		lines = append(lines, fmt.Sprintf("%d attempts left before you are disconnected.", left))
+	}
+	c.SendServerMessage(strings.Join(lines, " "))
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Registered clients whose connection drops are suspended for -resume-grace instead of leaving their channels right away,
+// so a network blip doesn't announce them leaving and joining again or cost them their operator status. Meanwhile they
+// keep their username and memberships, and what is sent to them is held. Clients using the resume feature are given
+// a token once registered, and sending "RESUME <token>" in place of a username from a new connection takes the session over.
+// Other clients couldn't resume, so they leave right away as before.
+
+const (
+	maxHeldMessages     = 50              // Messages held for a suspended client, later ones are dropped
+	resumeCheckInterval = 5 * time.Second // How often suspended sessions are checked for the end of their grace period
+)
+
+var ErrSessionNotFound = errors.New("the session to resume has expired or doesn't exist")
+
+// suspendReasons are the ends of a session that look like a dropped connection rather than the client leaving.
+// Clients closing their end of the connection or sending /quit leave right away.
+var suspendReasons = []string{"connection closed", "read timeout"}
+
+// ResumeRequest is sent by a client's Read goroutine when it asks to take over a suspended session
+type ResumeRequest struct {
+	Client   *Client
+	Token    string
+	Response chan error
+}
+
+// newResumeToken returns a random token that's hard enough to guess to stand in for a password
+func newResumeToken() string {
+	token := make([]byte, 32)
+	rand.Read(token)
+	return hex.EncodeToString(token)
+}
+
+// issueResumeToken gives the client a new token to resume its session with, if it uses the resume feature
+func (s *Server) issueResumeToken(session Session) {
+	client, connected := s.connections[session.ID()]
+	if s.config.ResumeGrace <= 0 || !connected || !client.uses(FeatureResume) {
+		return
+	}
+
+	client.resumeToken = newResumeToken()
+	client.SendMessage(formatEvent("session", client.resumeToken))
+}
+
+// suspend keeps the session of a client whose connection dropped until the grace period ends, reporting whether it did.
+// Clients the server already removed, that were never registered or can't resume aren't suspended.
+func (s *Server) suspend(client *Client) bool {
+	if s.stopped || client.resumeToken == "" || !client.IsRegistered() || s.clients[client.clientsKey()] != client {
+		return false
+	}
+
+	reason := client.endReason.Load()
+	if reason == nil || !slices.Contains(suspendReasons, *reason) {
+		return false
+	}
+
+	client.suspendedAt = time.Now()
+	client.suspended.Store(true)
+	s.suspended[client.resumeToken] = client
+	s.log.client.Info("Session suspended", "id", client.ID(), "username", client.GetUsername(), "ip", client.IP, "reason", *reason, "grace", s.config.ResumeGrace)
+	return true
+}
+
+// expireSuspended removes the suspended clients whose grace period is over, which leave their channels as usual
+func (s *Server) expireSuspended(now time.Time) {
+	for _, client := range s.suspended {
+		if now.Sub(client.suspendedAt) >= s.config.ResumeGrace {
+			s.removeClient(client)
+		}
+	}
+}
+
+// resume hands a suspended session over to a client that isn't registered yet, along with the messages held for it.
+// Clients often reconnect before the server notices their old connection is gone, so a session that isn't suspended
+// yet can be resumed too, closing its connection.
+func (s *Server) resume(client *Client, token string) error {
+	suspended, exists := s.suspended[token]
+	if exists {
+		delete(s.suspended, token)
+	} else {
+		suspended = s.clientWithToken(token)
+		if suspended == nil {
+			return ErrSessionNotFound
+		}
+		suspended.suspended.Store(true)
+		suspended.endSession("resumed from another connection")
+		suspended.conn.Close()
+	}
+
+	username := suspended.GetUsername()
+	delete(s.clients, client.clientsKey())
+	client.Username.Store(username)
+	s.clients[username] = client
+	client.takeOver(suspended)
+
+	// The members of its channels are never told, only the client is told what it's a member of
+	for _, channel := range suspended.GetChannels() {
+		channel.ReplaceMember(suspended, client)
+		client.AddChannel(channel)
+		for _, member := range channel.Members() {
+			client.SendMessage(formatEvent("palette", formatPaletteAssignment(channel, member.Username)))
+		}
+	}
+	if active := suspended.GetChannel(); active != nil {
+		client.SetChannel(active)
+	}
+
+	// The suspended client is done with, its writer stops once its queue is closed.
+	// If it was still connected, that's done once its Read goroutine unregisters it.
+	suspended.resumed = true
+	if exists {
+		delete(s.connections, suspended.ID())
+		close(suspended.send)
+	}
+	s.log.client.Info("Session resumed", "id", client.ID(), "username", username, "ip", client.IP, "previous_id", suspended.ID(), "previous_ip", suspended.IP)
+
+	client.SetRegistered(true)
+	held, dropped := suspended.takeHeld()
+	lines := []string{fmt.Sprintf("Your session as '%s' was resumed.", username)}
+	if len(held) > 0 {
+		lines = append(lines, fmt.Sprintf("%d messages were held for you while you were disconnected:", len(held)))
+	}
+	if dropped > 0 {
+		lines = append(lines, fmt.Sprintf("%d more were dropped.", dropped))
+	}
+	client.SendServerMessage(strings.Join(lines, " "))
+	for _, msg := range held {
+		client.SendMessageAt(msg.Body, msg.SentAt)
+	}
+
+	s.issueResumeToken(client)
+	return nil
+}
+
+// clientWithToken returns the connected client that was given the resume token, nil if there is none
+func (s *Server) clientWithToken(token string) *Client {
+	for _, client := range s.connections {
+		if client.resumeToken != "" && client.resumeToken == token {
+			return client
+		}
+	}
+	return nil
+}
+
+// takeOver gives the client the state of the suspended session it resumes, except for its channels,
+// including its limits so reconnecting doesn't reset them. Only called from the run loop.
+func (c *Client) takeOver(suspended *Client) {
+	c.admin.Store(suspended.admin.Load())
+	c.bridge.Store(suspended.bridge.Load())
+	c.displayIcon.Store(suspended.displayIcon.Load())
+	c.mutedUntil.Store(suspended.mutedUntil.Load())
+	c.firstMessageSent.Store(suspended.firstMessageSent.Load())
+
+	c.SessionState = suspended.SessionState
+	// What the suspended connection was in the middle of isn't resumed
+	c.commandReceivedAt, c.pendingLogin, c.originated = time.Time{}, "", originatedLog{}
+
+	suspended.awayMu.Lock()
+	c.awayMessage, c.autoAway, c.awaySince = suspended.awayMessage, suspended.autoAway, suspended.awaySince
+	suspended.awayMu.Unlock()
+}
+
+// hold keeps a message sent to the client while it's suspended, to deliver it if the session is resumed.
+// Pings and keepalives are dropped since there is nobody to answer or keep them, and so are messages past maxHeldMessages.
+func (c *Client) hold(msg OutgoingMessage) {
+	if msg.Body == "" || strings.HasPrefix(msg.Body, formatEvent("ping", "")) {
+		return
+	}
+
+	c.heldMu.Lock()
+	defer c.heldMu.Unlock()
+	if len(c.held) >= maxHeldMessages {
+		c.heldDropped++
+		return
+	}
+	c.held = append(c.held, msg)
+}
+
+// takeHeld returns the messages held while the client was suspended and how many were dropped
+func (c *Client) takeHeld() ([]OutgoingMessage, int) {
+	c.heldMu.Lock()
+	defer c.heldMu.Unlock()
+	held, dropped := c.held, c.heldDropped
+	c.held, c.heldDropped = nil, 0
+	return held, dropped
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// lastSession is what's remembered of a registered user's previous session to welcome them back.
+// It's only kept in memory, so users aren't recognized across server restarts.
+type lastSession struct {
+	SeenAt   time.Time
+	Channels []string // Joined channels in the order they were joined, the active one last
+}
+
+// recordLastSession remembers when a logged in client left and which channels it was in.
+// Must be called before the client leaves its channels.
+func (s *Server) recordLastSession(client Session) {
+	if !s.isLoggedIn(client) {
+		return
+	}
+
+	active := client.GetChannel()
+	var channels []string
+	for _, channel := range client.GetChannels() {
+		if channel != active {
+			channels = append(channels, channel.Name)
+		}
+	}
+	if active != nil {
+		channels = append(channels, active.Name)
+	}
+
+	s.lastSessions[client.GetUsername()] = lastSession{SeenAt: time.Now(), Channels: channels}
+}
+
+// welcomeBack greets a client that just logged in with what it missed since its last session, in a single message
+func (s *Server) welcomeBack(client Session) {
+	lines := []string{fmt.Sprintf("Welcome back, %s!", client.GetUsername())}
+
+	last, exists := s.lastSessions[client.GetUsername()]
+	if !exists {
+		client.SendServerMessage(lines[0])
+		return
+	}
+
+	lines = append(lines, fmt.Sprintf("Last seen %s ago.", formatDuration(time.Since(last.SeenAt))))
+	if len(last.Channels) > 0 {
+		lines = append(lines, fmt.Sprintf("Last time you were in %s. Type /rejoin to join them again.", formatChannelNames(last.Channels)))
+	}
+	client.SendServerMessage(strings.Join(lines, "\n"))
+}
+
+// formatChannelNames formats channel names as "'a', 'b'"
+func formatChannelNames(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = fmt.Sprintf("'%s'", name)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// rejoin joins the channels the client was in during its last session, like /join would,
+// leaving the channel that was active then as the active one
+func rejoin(name string, args []string, client Session, server *Server) {
+	if !server.isLoggedIn(client) {
+		client.SendServerMessage("Log in with /login <password> to rejoin the channels of your last session.")
+		return
+	}
+
+	last, exists := server.lastSessions[client.GetUsername()]
+	if !exists || len(last.Channels) == 0 {
+		client.SendServerMessage("There are no channels to rejoin from your last session.")
+		return
+	}
+
+	for _, channelName := range last.Channels {
+		joinChannel("join", []string{channelName}, client, server)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How often runtime stats are sampled for /stats. Reading memory stats briefly stops the world,
+// so it's done in the background instead of when an admin asks for them.
+const runtimeStatsInterval = 10 * time.Second
+
+const (
+	defaultGoroutineLines = 20  // Lines of goroutine stacks sent by /goroutines without a count
+	maxGoroutineLines     = 500 // The full dump can be megabytes on a busy server
+)
+
+// RuntimeStats is a sample of the Go runtime's state shown to admins in /stats
+type RuntimeStats struct {
+	SampledAt  time.Time
+	Goroutines int
+	HeapInUse  uint64
+	TotalAlloc uint64 // Bytes allocated since the server started, including freed ones
+	NumGC      uint32
+	PauseTotal time.Duration // Time spent in GC stop-the-world pauses since the server started
+	LastPause  time.Duration
+}
+
+func sampleRuntimeStats() *RuntimeStats {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+
+	stats := &RuntimeStats{
+		SampledAt:  time.Now(),
+		Goroutines: runtime.NumGoroutine(),
+		HeapInUse:  memStats.HeapInuse,
+		TotalAlloc: memStats.TotalAlloc,
+		NumGC:      memStats.NumGC,
+		PauseTotal: time.Duration(memStats.PauseTotalNs),
+	}
+	if memStats.NumGC > 0 {
+		stats.LastPause = time.Duration(memStats.PauseNs[(memStats.NumGC+255)%256])
+	}
+	return stats
+}
+
+// sampleRuntime keeps the runtime stats up to date until the server shuts down
+func (s *Server) sampleRuntime() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(runtimeStatsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.runtimeStats.Store(sampleRuntimeStats())
+		case <-s.shutdown:
+			return
+		}
+	}
+}
+
+// formatRuntimeStats formats the latest runtime sample along with the depth of the server's queues
+func (s *Server) formatRuntimeStats() []string {
+	stats := s.runtimeStats.Load()
+
+	// The busiest client shows whether anyone is close to being disconnected for reading too slowly
+	var busiest *Client
+	for _, client := range s.connections {
+		if busiest == nil || len(client.send) > len(busiest.send) {
+			busiest = client
+		}
+	}
+	largestQueue := "none"
+	if busiest != nil {
+		largestQueue = fmt.Sprintf("%d/%d (%s)", len(busiest.send), cap(busiest.send), busiest.GetUsername())
+	}
+
+	return []string{
+		fmt.Sprintf("Runtime (sampled %s ago):", formatDuration(time.Since(stats.SampledAt))),
+		fmt.Sprintf("Goroutines: %d", stats.Goroutines),
+		fmt.Sprintf("Heap in use: %s", formatBytes(int64(stats.HeapInUse))),
+		fmt.Sprintf("Total allocated: %s", formatBytes(int64(stats.TotalAlloc))),
+		fmt.Sprintf("GC runs: %d, total pause: %s, last pause: %s", stats.NumGC, stats.PauseTotal.Round(time.Microsecond), stats.LastPause.Round(time.Microsecond)),
+		fmt.Sprintf("Broadcast queue: %d/%d", len(s.broadcast), cap(s.broadcast)),
+		fmt.Sprintf("Largest send queue: %s", largestQueue),
+	}
+}
+
+// goroutines sends the stacks of the server's goroutines, cut to the given number of lines (20 by default).
+// Anyone can use it on servers started with -debug, otherwise only clients connected from the same machine.
+func goroutines(name string, args []string, client Session, server *Server) {
+	if !server.config.Debug && !isLoopback(client.Host()) {
+		client.SendServerMessage("/goroutines is only available on servers started with -debug or from localhost.")
+		return
+	}
+
+	limit := defaultGoroutineLines
+	if len(args) > 0 {
+		parsed, err := strconv.Atoi(args[0])
+		if err != nil || parsed < 1 {
+			client.SendServerMessage(fmt.Sprintf("Usage: /goroutines [lines] (up to %d)", maxGoroutineLines))
+			return
+		}
+		limit = min(parsed, maxGoroutineLines)
+	}
+
+	// debug=2 prints every stack the way an unrecovered panic does, e.g. "goroutine 1 [running]:"
+	var profile strings.Builder
+	if err := pprof.Lookup("goroutine").WriteTo(&profile, 2); err != nil {
+		server.log.command.Error("Failed to write goroutine profile", "error", err)
+		client.SendServerMessage("Failed to capture the goroutine stacks.")
+		return
+	}
+
+	lines := strings.Split(strings.TrimSpace(profile.String()), "\n")
+	shown := lines[:min(len(lines), limit)]
+	header := fmt.Sprintf("Goroutines: %d, showing %d of %d lines", runtime.NumGoroutine(), len(shown), len(lines))
+	client.SendServerMessage(header + "\n" + strings.Join(shown, "\n"))
+}
+
+// isLoopback reports whether the IP address belongs to the local machine
+func isLoopback(address string) bool {
+	ip := net.ParseIP(address)
+	return ip != nil && ip.IsLoopback()
+}
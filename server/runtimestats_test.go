@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestIsLoopback(t *testing.T) {
+	tests := []struct {
+		address string
+		want    bool
+	}{
+		{address: "127.0.0.1", want: true},
+		{address: "127.8.9.10", want: true},
+		{address: "::1", want: true},
+		{address: "192.0.2.1", want: false},
+		{address: "2001:db8::1", want: false},
+		{address: "localhost", want: false}, // Only addresses are given, never names
+		{address: "", want: false},
+	}
+
+	for _, test := range tests {
+		if got := isLoopback(test.address); got != test.want {
+			t.Errorf("isLoopback(%q) = %t, want %t", test.address, got, test.want)
+		}
+	}
+}
+
+func TestGoroutines(t *testing.T) {
+	tests := []struct {
+		name      string
+		debug     bool
+		ip        string
+		args      []string
+		want      string
+		wantLines int // Stack lines after the header, 0 to not check
+	}{
+		{name: "remote client", ip: "192.0.2.1:50000", want: "/goroutines is only available"},
+		{name: "remote client with -debug", debug: true, ip: "192.0.2.1:50000", want: "Goroutines: ", wantLines: defaultGoroutineLines},
+		{name: "local client", ip: "127.0.0.1:50000", want: "Goroutines: ", wantLines: defaultGoroutineLines},
+		{name: "local IPv6 client", ip: "[::1]:50000", want: "Goroutines: ", wantLines: defaultGoroutineLines},
+		{name: "line count", ip: "127.0.0.1:50000", args: []string{"3"}, want: "showing 3 of", wantLines: 3},
+		{name: "capped line count", ip: "127.0.0.1:50000", args: []string{"100000"}, want: "Goroutines: "},
+		{name: "zero lines", ip: "127.0.0.1:50000", args: []string{"0"}, want: fmt.Sprintf("Usage: /goroutines [lines] (up to %d)", maxGoroutineLines)},
+		{name: "not a number", ip: "127.0.0.1:50000", args: []string{"all"}, want: "Usage: /goroutines"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, func(config *Config) { config.Debug = test.debug })
+			client := connect(server, "alice")
+			client.ip = test.ip
+
+			goroutines("goroutines", test.args, client, server)
+			if len(client.sent) != 1 || !strings.Contains(client.sent[0], test.want) {
+				t.Fatalf("got %q, want %q", client.sent, test.want)
+			}
+
+			lines := strings.Split(client.sent[0], "\n")[1:]
+			if test.wantLines > 0 && len(lines) != test.wantLines {
+				t.Errorf("got %d stack lines, want %d", len(lines), test.wantLines)
+			}
+			if len(lines) > maxGoroutineLines {
+				t.Errorf("got %d stack lines, more than the maximum of %d", len(lines), maxGoroutineLines)
+			}
+		})
+	}
+}
@@ -1,39 +1,143 @@
 package main
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
-	"log/slog"
 	"net"
+	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 )
 
 var (
 	maxBucketSize = 10  // Maximum number of tokens in the bucket
 	bucketRate    = 1.5 // Tokens per second to refill the bucket
 
+	readAckTimeout = 30 * time.Second // How long a whisper waits to be acknowledged as read
+
+	maxStreamChunks  = 50                    // Maximum number of chunks sent in a single stream
+	streamChunkDelay = 10 * time.Millisecond // Delay between each chunk of a stream
+
+	channelModeCheckInterval = 5 * time.Second // How often temporary channel modes are checked for expiry
+
+	shutdownFlushTimeout = 5 * time.Second // How long clients have to receive the shutdown notice before their connections are closed
+
+	dropWarningInterval = 10 * time.Second // Minimum time between warnings about dropped broadcasts
+
+	autoAwayMessage       = "auto-away (idle)"
+	autoAwayCheckInterval = 30 * time.Second // How often idle clients are looked for
+	autoAwayAnnounceAfter = 3 * time.Minute  // Returning clients are announced if they were auto-away for longer
+
+	maxGhostAttempts = 3        // Maximum number of /ghost and /login attempts in a burst, per IP address and per nickname
+	ghostAttemptRate = 1.0 / 60 // Ghost and login attempts per second to refill
+
 	ErrBroadcastChannelFull = errors.New("broadcast channel is full")
 )
 
 type Server struct {
-	clients     map[string]*Client // IP address (unregistered) or Username (registered)
-	channels    map[string]*Channel
-	commands    map[string]CommandFunc
-	command     chan Command
-	register    chan *Client
-	unregister  chan *Client
-	setUsername chan UsernameChange
-	broadcast   chan Message
-	shutdown    chan struct{}
-	url         *url.URL
-	logger      *slog.Logger
-	wg          sync.WaitGroup
-	stopped     bool
+	clients        map[string]Session // IP address (unregistered) or Username (registered)
+	connections    map[string]*Client // Connected clients by ID, including the suspended ones
+	channels       map[string]*Channel
+	channelIndex   []*Channel // Channels sorted by name for listing, kept in sync with channels
+	commands       map[string]CommandSpec
+	plugins        map[string]CommandPlugin // Commands registered by loaded plugins
+	command        chan Command
+	register       chan *Client
+	unregister     chan *Client
+	setUsername    chan UsernameChange
+	broadcast      chan Message
+	shutdown       chan struct{} // Closed to make the run loop disconnect every client and return once they are gone
+	listener       net.Listener
+	readers        sync.Pool     // Buffered readers reused across connections
+	writers        sync.Pool     // Buffered writers reused across connections
+	acceptDone     chan struct{} // Closed once the accept loop has returned
+	url            *url.URL
+	log            loggers
+	messageLogs    logSampler // Samples the debug logs of relayed messages
+	rateLimitLogs  logSampler // Samples the debug logs of rate limited messages
+	wg             sync.WaitGroup
+	stopped        bool // Set by the run loop once it started shutting down
+	config         Config
+	defaultChannel string       // Channel new clients are joined to after registering, none if empty
+	readAck        chan *Client // Clients with read receipts waiting, see queueReadAck
+	resumeRequests chan ResumeRequest
+	suspended      map[string]*Client    // Clients whose connection dropped, waiting to resume their session, by resume token
+	rateLimited    chan *Client          // Clients with rate limit hits waiting, see countRateLimitHit
+	deliver        chan Delivery         // Messages sent to clients from other goroutines, dropped if the client is gone
+	pendingAcks    map[uint64]PendingAck // Whispers waiting to be read, by message ID
+	nextMessageID  uint64
+	messageSeq     uint64 // Sequence number of the last chat message relayed to a channel
+	stats          ServerStats
+	runtimeStats   atomic.Pointer[RuntimeStats] // Latest sample, updated in the background
+	nicks          *nickStore                   // Nicknames registered with a password
+	bans           *banList
+	shadowMuted    map[string]struct{}       // Usernames whose messages are silently only shown to themselves
+	moderation     *moderationFile           // Nil unless shadow mutes are saved to a file
+	state          *stateFile                // Nil unless channel topics, pins, password hints and hidden flags are saved to a file
+	lastSessions   map[string]lastSession    // Previous sessions of registered users, by username
+	events         *eventPipe                // Nil unless an event pipe is configured
+	messageLog     *messageLog               // Nil unless messages are persisted
+	metricsServer  *http.Server              // Nil unless the metrics endpoint is enabled and listening
+	snapshots      chan chan MetricsSnapshot // The metrics endpoint asks the run loop for snapshots
+	completed      chan func()               // Results of work done off the run loop, see runOffLoop
+	pendingWork    int                       // Work started with runOffLoop whose result wasn't handled yet
+	authAttempts   map[string]*tokenBucket   // /ghost and /login attempts, by "ip:<host>" and "nick:<nickname>"
+	motd           string                    // Message of the day sent to clients when they connect, none if empty
+}
+
+// ServerStats are counters shown by /stats. The whisper counters are only updated from the run loop.
+type ServerStats struct {
+	WhispersSent        int
+	WhispersRateLimited int // Rejected because the sender exceeded its whisper rate limit
+	WhispersCapped      int // Rejected because the recipient received too many whispers
+
+	// Traffic of all clients, updated by their read and write goroutines
+	BytesRead    atomic.Int64
+	BytesWritten atomic.Int64
+
+	DroppedBroadcasts atomic.Int64 // Messages dropped because the broadcast channel was full
+	dropWarnedAt      atomic.Int64 // Unix nanoseconds of the last warning about dropped broadcasts
+
+	// Messages dropped because the client's send queue was full, or after it was, by category
+	DroppedReplies  atomic.Int64 // Messages from the server, like the replies to commands
+	DroppedEvents   atomic.Int64 // Control events
+	DroppedMessages atomic.Int64 // Chat messages, whispers and everything else
+}
+
+// countDroppedSend counts a message that couldn't be queued for a client in its category
+func (s *ServerStats) countDroppedSend(msg string) {
+	switch {
+	case strings.HasPrefix(msg, "system|"):
+		s.DroppedEvents.Add(1)
+	case strings.HasPrefix(msg, "Server|"):
+		s.DroppedReplies.Add(1)
+	default:
+		s.DroppedMessages.Add(1)
+	}
+}
+
+// Delivery is a message for a client sent from outside the run loop, e.g. after a delay.
+// Going through the run loop makes sure the client's send channel hasn't been closed.
+type Delivery struct {
+	To   Messenger
+	Body string
+}
+
+// PendingAck is a whisper waiting for its recipient to acknowledge it
+type PendingAck struct {
+	Sender    string
+	Recipient string
+	SentAt    time.Time
+	Shadowed  bool // The whisper of a shadow muted sender, which the recipient never got
 }
 
 type UsernameChange struct {
@@ -43,48 +147,146 @@ type UsernameChange struct {
 	Response    chan error
 }
 
-func NewServer(address, port string) *Server {
-	url, err := url.Parse("tcp://" + address + ":" + port)
+// NewServer creates a server with the configuration, loading the files it names.
+// The configuration should have been validated, but loading can still fail.
+func NewServer(config Config) (*Server, error) {
+	url, err := url.Parse("tcp://" + config.Host + ":" + config.Port)
 	if err != nil {
-		panic("Failed to parse server URL: " + err.Error())
+		return nil, fmt.Errorf("failed to parse server URL: %w", err)
 	}
 
-	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	log := newLoggers(os.Stdout, config)
 
 	server := &Server{
-		clients:     make(map[string]*Client),
-		channels:    make(map[string]*Channel),
-		commands:    make(map[string]CommandFunc),
-		command:     make(chan Command),
-		register:    make(chan *Client),
-		unregister:  make(chan *Client),
-		setUsername: make(chan UsernameChange),
-		broadcast:   make(chan Message, 10000),
-		shutdown:    make(chan struct{}),
-		url:         url,
-		logger:      logger,
-		stopped:     false,
+		clients:        make(map[string]Session),
+		connections:    make(map[string]*Client),
+		channels:       make(map[string]*Channel),
+		commands:       make(map[string]CommandSpec),
+		plugins:        make(map[string]CommandPlugin),
+		command:        make(chan Command),
+		register:       make(chan *Client),
+		unregister:     make(chan *Client),
+		setUsername:    make(chan UsernameChange),
+		readAck:        make(chan *Client, readAckQueueSize),
+		resumeRequests: make(chan ResumeRequest),
+		suspended:      make(map[string]*Client),
+		rateLimited:    make(chan *Client, rateLimitedQueueSize),
+		snapshots:      make(chan chan MetricsSnapshot),
+		deliver:        make(chan Delivery),
+		completed:      make(chan func()),
+		authAttempts:   make(map[string]*tokenBucket),
+		pendingAcks:    make(map[uint64]PendingAck),
+		broadcast:      make(chan Message, 10000),
+		shutdown:       make(chan struct{}),
+		acceptDone:     make(chan struct{}),
+		url:            url,
+		log:            log,
+		config:         config,
+		shadowMuted:    make(map[string]struct{}),
+		lastSessions:   make(map[string]lastSession),
 	}
 
+	server.readers.New = func() any { return bufio.NewReaderSize(nil, config.ReadBufferSize) }
+	server.writers.New = func() any { return bufio.NewWriterSize(nil, config.WriteBufferSize) }
+
+	if config.ModerationFile != "" {
+		server.moderation = &moderationFile{jsonFile: jsonFile{path: config.ModerationFile}}
+		if err := server.loadModeration(); err != nil {
+			return nil, fmt.Errorf("failed to load moderation file: %w", err)
+		}
+	}
+
+	if config.StateFile != "" {
+		server.state = &stateFile{jsonFile: jsonFile{path: config.StateFile}}
+		if err := server.loadState(); err != nil {
+			return nil, fmt.Errorf("failed to load state file: %w", err)
+		}
+	}
+
+	nicks, err := newNickStore(config.AuthFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load auth file: %w", err)
+	}
+	server.nicks = nicks
+
+	bans, reload, err := newBanList(config.BanFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ban file: %w", err)
+	}
+	for _, skipped := range reload.Skipped {
+		log.storage.Warn("Skipped malformed ban", "file", config.BanFile, "error", skipped)
+	}
+	server.bans = bans
+
+	motd, err := loadMOTD(config.MOTDFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load MOTD file: %w", err)
+	}
+	server.motd = motd
+
+	if config.PersistMessages {
+		messageLog, err := newMessageLog(messageLogDir, config.MessageLogSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create message log directory: %w", err)
+		}
+		server.messageLog = messageLog
+	}
+
+	if config.EventPipe != "" {
+		events, err := newEventPipe(config.EventPipe, config.EventPipeBuffer, log.events)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create event pipe: %w", err)
+		}
+		server.events = events
+	}
+
+	server.runtimeStats.Store(sampleRuntimeStats())
 	server.loadCommands()
-	return server
+	return server, nil
 }
 
 func formatMessage(senderName, content string) string {
+	return formatChannelMessage(senderName, "", content)
+}
+
+// formatChannelMessage formats a message sent to a channel as "sender|channel|content".
+// The channel is empty for messages that don't belong to any channel.
+func formatChannelMessage(senderName, channelName, content string) string {
 	if senderName == "" {
 		senderName = "."
 	}
 
 	var builder strings.Builder
-	builder.Grow(len(senderName) + len(content) + 2)
+	builder.Grow(len(senderName) + len(channelName) + len(content) + 2)
 	builder.WriteString(senderName)
 	builder.WriteByte('|')
+	builder.WriteString(channelName)
+	builder.WriteByte('|')
 	builder.WriteString(content)
 	return builder.String()
 }
 
+// formatEvent formats a control event for the client as "system|event|argument"
+func formatEvent(event, argument string) string {
+	return "system|" + event + "|" + argument
+}
+
+// reservedUsernames are the sender names of the server's own messages and control events, which clients trust
+var reservedUsernames = []string{"Server", "system", "."}
+
+// isReservedUsername reports whether the username is reserved or looks like a reserved one, ignoring case
+func (s *Server) isReservedUsername(username string) bool {
+	normalized := normalizeUsername(username)
+	for _, reserved := range slices.Concat(reservedUsernames, s.config.ReservedNames) {
+		if normalizeUsername(reserved) == normalized {
+			return true
+		}
+	}
+	return false
+}
+
 // changeUsername validates and updates a client's username
-func (s *Server) changeUsername(client *Client, oldKey, newUsername string) error {
+func (s *Server) changeUsername(client Session, oldKey, newUsername string) error {
 	// Validate username
 	newUsername = strings.TrimSpace(newUsername)
 	if newUsername == "" {
@@ -95,129 +297,776 @@ func (s *Server) changeUsername(client *Client, oldKey, newUsername string) erro
 		return fmt.Errorf("username cannot exceed 32 characters")
 	}
 
+	if s.isReservedUsername(newUsername) {
+		return fmt.Errorf("'%s' is reserved", newUsername)
+	}
+
 	// Check for duplicate usernames
 	if existingClient, exists := s.clients[newUsername]; exists && existingClient != client {
 		return fmt.Errorf("'%s' is already taken", newUsername)
 	}
 
+	// Check for usernames that look like an existing one to prevent impersonation
+	normalized := normalizeUsername(newUsername)
+	for _, existingClient := range s.clients {
+		if existingClient == client || !existingClient.IsRegistered() {
+			continue
+		}
+
+		if existingUsername := existingClient.GetUsername(); normalizeUsername(existingUsername) == normalized {
+			return fmt.Errorf("'%s' is too similar to the existing user '%s'", newUsername, existingUsername)
+		}
+	}
+
+	// Registered usernames can't be impersonated while their owner is offline either
+	if s.nicks.Persistent() {
+		if nickname, found := s.nicks.Lookalike(newUsername); found && nickname != client.State().loggedInAs {
+			return fmt.Errorf("'%s' is too similar to the registered user '%s'", newUsername, nickname)
+		}
+	}
+
 	// Delete old key from map
 	delete(s.clients, oldKey)
 
 	// Add client with new username as key
 	s.clients[newUsername] = client
+	oldUsername := client.GetUsername()
 	client.SetUsername(newUsername)
 
+	// Channel members are also keyed by username
+	for _, channel := range client.GetChannels() {
+		channel.RenameMember(oldUsername, newUsername)
+		s.broadcastEvent(channel, "palette", formatPaletteAssignment(channel, newUsername))
+	}
+
+	client.State().pendingLogin = ""
+
+	// Changing names doesn't get rid of a shadow mute
+	if _, muted := s.shadowMuted[oldUsername]; muted {
+		delete(s.shadowMuted, oldUsername)
+		s.shadowMuted[newUsername] = struct{}{}
+		s.saveModeration()
+	}
+
+	return nil
+}
+
+// requireLogin refuses registered usernames to clients that haven't logged in as them when an auth file is used,
+// remembering the username so /login can hand it over
+func (s *Server) requireLogin(client Session, username string) error {
+	username = strings.TrimSpace(username)
+	if !s.nicks.Persistent() || !s.nicks.IsRegistered(username) || client.State().loggedInAs == username {
+		return nil
+	}
+
+	client.State().pendingLogin = username
+	return fmt.Errorf("'%s' is registered, use /login <password> to log in as it", username)
+}
+
+// isLoggedIn reports whether the client proved it owns its registered username
+func (s *Server) isLoggedIn(client Session) bool {
+	return client.State().loggedInAs == client.GetUsername() && s.nicks.IsRegistered(client.State().loggedInAs)
+}
+
+// isShadowMuted reports whether the client's messages should only be shown to itself
+func (s *Server) isShadowMuted(client Session) bool {
+	_, muted := s.shadowMuted[client.GetUsername()]
+	return muted
+}
+
+// completeRegistration marks the client as registered once its first username has been set
+// and joins it to the default channel if there is one
+func (s *Server) completeRegistration(client Session) {
+	client.SetRegistered(true)
+	client.SendServerMessage(fmt.Sprintf("Your username has been set to '%s'. Use /join <channel_name> to join a channel.", client.GetUsername()))
+	s.issueResumeToken(client)
+
+	// The auto-join is held to the same limits as /join
+	if s.defaultChannel == "" || !s.admitJoin(client, s.defaultChannel, "") {
+		return
+	}
+
+	channel, err := s.enterChannel(client, s.defaultChannel, "")
+	if err != nil {
+		s.log.runLoop.Warn("Failed to auto-join default channel", "username", client.GetUsername(), "channel", s.defaultChannel, "error", err)
+		return
+	}
+
+	client.SendServerMessage(fmt.Sprintf("You have been auto-joined to #%s.", channel.Name))
+}
+
+// enterChannel adds the client to the channel with the given name, creating it if it doesn't exist,
+// and makes it the client's active channel
+func (s *Server) enterChannel(client Session, channelName, password string) (*Channel, error) {
+	if joined := client.GetJoinedChannel(channelName); joined != nil {
+		client.SetChannel(joined)
+		return joined, nil
+	}
+
+	channel, exists := s.channels[channelName]
+	if !exists {
+		channel = NewChannel(channelName, password)
+		channel.AddOperator(client) // The creator of the channel is its first operator and its owner
+		channel.SetOwner(client.GetUsername())
+		if client.IsRegistered() {
+			channel.SetCreatedBy(client.GetUsername())
+		}
+		s.restoreHistory(channel)
+		s.restoreChannelState(channel)
+		s.addChannel(channel)
+		s.emitEvent(ServerEvent{Event: "channel_create", User: client.GetUsername(), Channel: channelName})
+	}
+
+	if err := checkAccess(client, channel, password); err != nil {
+		return nil, err
+	}
+	if !channel.ValidatePassword(password) {
+		channel.UseInviteCode(password)
+	}
+
+	// Persistent channels are handed to whoever joins them first once emptied
+	if channel.IsEmpty() && !channel.HasOperators() {
+		channel.AddOperator(client)
+		channel.SetOwner(client.GetUsername())
+	}
+
+	channel.AddMember(client)
+	channel.UseInvite(client.GetUsername())
+	client.AddChannel(channel)
+	s.assignChannelColor(client, channel)
+	s.broadcastMessage(client, channel, fmt.Sprintf("%s has joined the channel.", client.GetUsername()))
+
+	// Show new members what the channel is about and what has been pinned so far
+	if channel.Topic().Text != "" {
+		client.SendMessage(formatChannelMessage("Server", channel.Name, formatTopic(channel.Topic())))
+	}
+	if channel.HasPins() {
+		client.SendMessage(formatChannelMessage("Server", channel.Name, formatPins(channel)))
+	}
+	return channel, nil
+}
+
+// checkAccess returns why the client can't join the existing channel with the password, nil if it can.
+// Nothing is used up, invite codes accepted in place of the password are only used by enterChannel.
+func checkAccess(client Session, channel *Channel, password string) error {
+	if channel.IsLocked() && !channel.IsInvited(client.GetUsername()) {
+		return ErrChannelLocked
+	}
+
+	if channel.RequiresPassword() && password == "" {
+		return ErrPasswordRequired
+	}
+
+	if !channel.ValidatePassword(password) {
+		// Invite codes are accepted in place of the password
+		if !channel.RequiresPassword() {
+			return ErrIncorrectPassword
+		}
+
+		switch err := channel.CheckInviteCode(password); {
+		case errors.Is(err, ErrInviteCodeNotFound):
+			return ErrIncorrectPassword
+		case err != nil:
+			return err
+		}
+	}
 	return nil
 }
 
+// assignChannelColor picks the new member's color, sending it to the rest of the channel
+// and the colors of everyone in the channel to the new member
+func (s *Server) assignChannelColor(client Session, channel *Channel) {
+	channel.AssignColor(client.GetUsername())
+	s.broadcastEvent(channel, "palette", formatPaletteAssignment(channel, client.GetUsername()))
+
+	for _, member := range channel.Members() {
+		if member.Client != client {
+			client.SendMessage(formatEvent("palette", formatPaletteAssignment(channel, member.Username)))
+		}
+	}
+}
+
+// formatPaletteAssignment formats the argument of a palette event as "<channel> <index> <username>"
+func formatPaletteAssignment(channel *Channel, username string) string {
+	return fmt.Sprintf("%s %d %s", channel.Name, channel.MemberColor(username), username)
+}
+
+// leaveChannel removes the client from the channel, deleting the channel once it's empty
+// unless channels are persistent
+func (s *Server) leaveChannel(client Session, channel *Channel) {
+	channel.RemoveMember(client)
+	client.RemoveChannel(channel)
+	delete(client.State().ignoredChannels, channel.Name)
+	s.broadcastMessage(client, channel, fmt.Sprintf("%s has left the channel.", client.GetUsername()))
+
+	// Let the remaining members forget about the client once they have seen it leave,
+	// unless they still share another channel with it, see relayMessage
+	s.queueBroadcast(Message{Sender: client, SenderName: "system", Channel: channel, Content: client.DisplayName(), Event: "disconnect"})
+
+	if channel.IsEmpty() && !s.config.PersistentChannels {
+		s.deleteChannel(channel)
+	}
+}
+
+// removeClient removes a client whose Read goroutine is done, or whose suspended session ended,
+// from its channels and the server, which stops its writer
+func (s *Server) removeClient(client *Client) {
+	// Clients disconnected by the server were already recorded and removed
+	if s.clients[client.clientsKey()] == client {
+		s.recordLastSession(client)
+	}
+	if client.suspended.Load() {
+		delete(s.suspended, client.resumeToken)
+	}
+
+	for _, clientChannel := range client.GetChannels() {
+		s.leaveChannel(client, clientChannel)
+	}
+
+	// Delete from clients map using username (if registered) or ID (if not).
+	// A ghosted client's username may already belong to the client that reclaimed it.
+	if key := client.clientsKey(); s.clients[key] == client {
+		delete(s.clients, key)
+	}
+
+	delete(s.connections, client.ID())
+	s.emitEvent(ServerEvent{Event: "disconnect", User: client.GetUsername(), IP: client.IP})
+	close(client.send)
+	s.log.client.Info("Session ended", append(client.session(time.Now()).logAttrs(), "total_clients", len(s.clients))...)
+}
+
+// disconnectClient removes the client from the server right away, telling it why before closing its connection.
+// Its Read goroutine still unregisters it once the connection is closed, unless it's suspended and already did.
+// Suspended clients are removed right away, leaving their channels.
+func (s *Server) disconnectClient(client Session, reason string) {
+	if conn, connected := s.connections[client.ID()]; connected && conn.suspended.Load() {
+		// Sessions resumed from another connection belong to the new client
+		if !conn.resumed {
+			conn.endSession(reason)
+			s.removeClient(conn)
+			s.log.client.Info("Suspended session ended by server", "username", conn.GetUsername(), "ip", conn.IP, "reason", reason)
+		}
+		return
+	}
+
+	s.recordLastSession(client)
+	for _, clientChannel := range client.GetChannels() {
+		s.leaveChannel(client, clientChannel)
+	}
+
+	if key := client.clientsKey(); s.clients[key] == client {
+		delete(s.clients, key)
+	}
+
+	client.Disconnect(reason)
+	s.log.client.Info("Client disconnected by server", "username", client.GetUsername(), "ip", client.RemoteAddr(), "reason", reason)
+}
+
+// addChannel adds the channel to the server, keeping the channel index sorted
+func (s *Server) addChannel(channel *Channel) {
+	s.channels[channel.Name] = channel
+
+	position, _ := slices.BinarySearchFunc(s.channelIndex, channel.Name, compareChannelName)
+	s.channelIndex = slices.Insert(s.channelIndex, position, channel)
+}
+
+func (s *Server) deleteChannel(channel *Channel) {
+	delete(s.channels, channel.Name)
+	s.emitEvent(ServerEvent{Event: "channel_delete", Channel: channel.Name})
+
+	if position, found := slices.BinarySearchFunc(s.channelIndex, channel.Name, compareChannelName); found {
+		s.channelIndex = slices.Delete(s.channelIndex, position, position+1)
+	}
+
+	if s.defaultChannel == channel.Name {
+		s.defaultChannel = ""
+	}
+}
+
+// markIdleClientsAway sets the automatic away status of clients that have been idle for too long.
+// Clients that are already away, including manually, are left alone.
+func (s *Server) markIdleClientsAway() {
+	for _, client := range s.clients {
+		if !client.IsRegistered() || client.IdleFor() < s.config.IdleAway {
+			continue
+		}
+
+		if awayMessage, _ := client.Away(); awayMessage != "" {
+			continue
+		}
+
+		client.SetAway(autoAwayMessage, true)
+		client.SendServerMessage("You have been marked as away after being idle. Send anything to come back.")
+	}
+}
+
+// runCommand checks that the client can use the command and runs its handler
+func (s *Server) runCommand(cmd Command) {
+	spec, exists := s.commands[cmd.Name]
+	if !exists {
+		cmd.Client.SendServerMessage("[Server]: Unknown command. Type /help for a list of commands.")
+		return
+	}
+
+	// Check permissions before executing the command
+	if err := s.authorize(spec, cmd); err != nil {
+		cmd.Client.SendServerMessage(fmt.Sprintf("Permission denied: %s.", err.Error()))
+		return
+	}
+
+	if spec.Cooldown > 0 && !cmd.Client.IsAdmin() {
+		if remaining := cmd.Client.useCommand(cmd.Name, spec.Cooldown); remaining > 0 {
+			cmd.Client.SendNotice("cooldown "+cmd.Name, formatMessage("Server", fmt.Sprintf("Please wait %s before using /%s again.", formatRetryAfter(remaining), cmd.Name)))
+			return
+		}
+	}
+
+	cmd.Client.State().commandReceivedAt = cmd.ReceivedAt
+	spec.Handler(cmd.Name, cmd.Args, cmd.Client, s)
+}
+
+// checkConnections pings every client, so connections that stopped responding time out,
+// and disconnects the ones that have been inactive for too long
+func (s *Server) checkConnections() {
+	for _, client := range s.clients {
+		timeout := s.config.IdleTimeout
+		if !client.IsRegistered() {
+			timeout = s.config.RegistrationTimeout
+		}
+
+		if timeout > 0 && client.IdleFor() > timeout {
+			s.disconnectClient(client, "Disconnected due to inactivity.")
+			continue
+		}
+
+		if conn, connected := s.connections[client.ID()]; connected && conn.expectsHeartbeat() {
+			conn.SendMessage(formatEvent("ping", ""))
+		}
+	}
+}
+
+// expireChannelModes turns off temporary channel modes once they expire
+func (s *Server) expireChannelModes() {
+	for _, channel := range s.channels {
+		if channel.SpamLockExpired() {
+			channel.ClearSpamLock()
+			s.broadcastMessage(nil, channel, "No-spam mode has expired. Everyone can send messages again.")
+		}
+	}
+}
+
+func compareChannelName(channel *Channel, name string) int {
+	return strings.Compare(channel.Name, name)
+}
+
+// trackReadAck registers a whisper so its sender can be told when it's read, returning its message ID.
+// Shadowed whispers were never delivered, so they count as read when the recipient reads any other whisper.
+func (s *Server) trackReadAck(sender, recipient string, shadowed bool) uint64 {
+	// Drop whispers that were never acknowledged
+	now := time.Now()
+	for id, pending := range s.pendingAcks {
+		if now.Sub(pending.SentAt) > readAckTimeout {
+			delete(s.pendingAcks, id)
+		}
+	}
+
+	s.nextMessageID++
+	s.pendingAcks[s.nextMessageID] = PendingAck{
+		Sender:    sender,
+		Recipient: recipient,
+		SentAt:    now,
+		Shadowed:  shadowed,
+	}
+	return s.nextMessageID
+}
+
+// handleReadAck tells the sender of a whisper that its recipient, the reader, has read it
+func (s *Server) handleReadAck(reader Session, messageID uint64) {
+	pending, exists := s.pendingAcks[messageID]
+	if !exists || pending.Recipient != reader.GetUsername() {
+		return
+	}
+	delete(s.pendingAcks, messageID)
+
+	if time.Since(pending.SentAt) > readAckTimeout {
+		return
+	}
+
+	if sender, exists := s.clients[pending.Sender]; exists {
+		sender.SendServerMessage(fmt.Sprintf("Your whisper to %s was read.", pending.Recipient))
+	}
+
+	// The recipient is reading its whispers, so shadow muted senders are told it read theirs too
+	for id, shadowed := range s.pendingAcks {
+		if !shadowed.Shadowed || shadowed.Recipient != pending.Recipient || time.Since(shadowed.SentAt) > readAckTimeout {
+			continue
+		}
+		delete(s.pendingAcks, id)
+		if sender, exists := s.clients[shadowed.Sender]; exists {
+			sender.SendServerMessage(fmt.Sprintf("Your whisper to %s was read.", shadowed.Recipient))
+		}
+	}
+}
+
 func (s *Server) run() {
 	defer s.wg.Done()
 
+	channelModeCheck := time.NewTicker(channelModeCheckInterval)
+	defer channelModeCheck.Stop()
+
+	pingTicker := time.NewTicker(s.config.PingInterval)
+	defer pingTicker.Stop()
+
+	slowClientCheck := time.NewTicker(slowClientCheckInterval)
+	defer slowClientCheck.Stop()
+
+	// Idle clients are only looked for if automatic away is enabled
+	var idleCheck <-chan time.Time
+	if s.config.IdleAway > 0 {
+		ticker := time.NewTicker(autoAwayCheckInterval)
+		defer ticker.Stop()
+		idleCheck = ticker.C
+	}
+
+	var resumeCheck <-chan time.Time
+	if s.config.ResumeGrace > 0 {
+		ticker := time.NewTicker(resumeCheckInterval)
+		defer ticker.Stop()
+		resumeCheck = ticker.C
+	}
+
+	var keepaliveCheck <-chan time.Time
+	if s.config.KeepaliveInterval > 0 {
+		ticker := time.NewTicker(min(keepaliveCheckInterval, s.config.KeepaliveInterval/2))
+		defer ticker.Stop()
+		keepaliveCheck = ticker.C
+	}
+
+	var sweep <-chan time.Time
+	if s.config.SweepInterval > 0 {
+		ticker := time.NewTicker(s.config.SweepInterval)
+		defer ticker.Stop()
+		sweep = ticker.C
+	}
+
+	// Set once shutting down, so the shutdown case only runs once and stragglers are closed after a while
+	shutdown := s.shutdown
+	var flushDeadline <-chan time.Time
+
 	for {
 		select {
 		case client := <-s.register:
-			// Handle new client registration - use IP address as initial key
-			s.clients[client.IP] = client
-			s.logger.Info("Client connected", "ip", client.IP, "total_clients", len(s.clients))
-			client.SendMessage(formatMessage("Server", "Welcome! Please set your username by typing it in."))
-
-			// Start reader and writer goroutines for the client
-			s.wg.Add(1)
-			go func() {
-				defer s.wg.Done()
-				client.Read()
-			}()
-			s.wg.Add(1)
-			go func() {
-				defer s.wg.Done()
-				client.Write()
-			}()
-		case client := <-s.unregister:
-			// Handle client unregistration
-			clientChannel := client.GetChannel()
-			if clientChannel != nil {
-				clientChannel.RemoveMember(client)
-				s.broadcastMessage(client, clientChannel, fmt.Sprintf("%s has left the channel.", client.GetUsername()))
-				if len(clientChannel.members) == 0 {
-					delete(s.channels, clientChannel.Name)
-				}
+			// Banned clients are told why and disconnected without being registered.
+			// Only their writer is started, nothing they send is read but the handshake.
+			if s.bans.IsBanned(client.Host()) {
+				s.log.listener.Info("Refused banned client", "ip", client.IP)
+				client.Disconnect("You are banned from this server.")
+				s.wg.Add(1)
+				go func() {
+					defer s.wg.Done()
+					client.readHandshake()
+					client.reader.Reset(nil)
+					s.readers.Put(client.reader)
+					client.Write()
+				}()
+				continue
+			}
+
+			// Handle new client registration, keyed by its ID until it sets a username
+			client.id = generateUniqueID(s.connections)
+			s.connections[client.ID()] = client
+			s.clients[client.clientsKey()] = client
+			s.log.listener.Info("Client connected", "id", client.ID(), "ip", client.IP, "total_clients", len(s.clients))
+			s.emitEvent(ServerEvent{Event: "connect", IP: client.IP})
+			client.SendMessage(formatEvent("features", formatFeatures()))
+			client.SendMessage(formatEvent("rate-limit", s.formatRateLimit()))
+			client.SendMessage(formatEvent("max-message-length", strconv.Itoa(s.config.MaxMessageLength)))
+			if s.motd != "" {
+				s.sendMOTD(client)
 			}
+			client.SendServerMessage("Welcome! Please set your username by typing it in. " + usernameRules)
 
-			// Delete from clients map using username (if registered) or IP (if not)
-			if client.IsRegistered() {
-				delete(s.clients, client.GetUsername())
-			} else {
-				delete(s.clients, client.IP)
+			s.startClient(client)
+		case client := <-s.unregister:
+			// Clients whose connection dropped stay for a while in case they resume their session.
+			// Those whose session was resumed from another connection only have their ID and queue left.
+			switch {
+			case client.resumed:
+				delete(s.connections, client.ID())
+				close(client.send)
+			case !s.suspend(client):
+				s.removeClient(client)
 			}
 
-			close(client.send)
-			s.logger.Info("Client disconnected", "username", client.GetUsername(), "registered", client.IsRegistered(), "ip", client.IP, "total_clients", len(s.clients))
+			// Every Read goroutine has unregistered its client, nothing is left to send to the run loop
+			if s.stopped && len(s.connections) == 0 {
+				return
+			}
+		case request := <-s.resumeRequests:
+			request.Response <- s.resume(request.Client, request.Token)
+		case now := <-resumeCheck:
+			s.expireSuspended(now)
 		case usernameChange := <-s.setUsername:
 			// Handle username changes from client Read() goroutine
-			err := s.changeUsername(usernameChange.Client, usernameChange.OldKey, usernameChange.NewUsername)
+			err := s.requireLogin(usernameChange.Client, usernameChange.NewUsername)
+			if err == nil {
+				err = s.changeUsername(usernameChange.Client, usernameChange.OldKey, usernameChange.NewUsername)
+			}
+			if err == nil && !usernameChange.Client.IsRegistered() {
+				s.completeRegistration(usernameChange.Client)
+			}
 			usernameChange.Response <- err
-		case cmd := <-s.command:
-			// Handle commands from clients
-			if cmdFunc, exists := s.commands[cmd.Name]; exists {
-				cmdFunc(cmd.Name, cmd.Args, cmd.Client, s) // Execute command if found
-			} else {
-				cmd.Client.SendMessage(formatMessage("Server", "[Server]: Unknown command. Type /help for a list of commands."))
+		case client := <-s.readAck:
+			for _, messageID := range client.takeReadAcks() {
+				s.handleReadAck(client, messageID)
 			}
-		case msg := <-s.broadcast:
-			formattedMsg := formatMessage(msg.SenderName, msg.Content)
-
-			// Handle broadcasting messages to clients
-			if msg.Channel == nil {
-				// Broadcast message to all clients if no channel is specified
-				for _, client := range s.clients {
-					if msg.SenderName != client.GetUsername() {
-						client.SendMessage(formattedMsg)
-					}
+		case client := <-s.rateLimited:
+			for _, hit := range client.takeRateLimitHits() {
+				debugSampled(s.log.client, &s.rateLimitLogs, "Message rate limited", "username", client.GetUsername(), "ip", client.RemoteAddr(), "channel", hit.Channel)
+				if channel, exists := s.channels[hit.Channel]; exists {
+					channel.CountRateLimitHit(client, hit.At)
 				}
-				continue
+			}
+		case response := <-s.snapshots:
+			response <- s.metricsSnapshot(time.Now())
+		case delivery := <-s.deliver:
+			if _, connected := s.connections[delivery.To.ID()]; connected {
+				delivery.To.SendMessage(delivery.Body)
+			}
+		case done := <-s.completed:
+			s.finishOffLoop(done)
+		case <-idleCheck:
+			s.markIdleClientsAway()
+		case <-channelModeCheck.C:
+			s.expireChannelModes()
+		case <-pingTicker.C:
+			s.checkConnections()
+		case now := <-keepaliveCheck:
+			s.sendKeepalives(now)
+		case <-slowClientCheck.C:
+			s.checkSlowClients()
+		case now := <-sweep:
+			s.runSweep(now)
+		case cmd := <-s.command:
+			s.runCommand(cmd)
+		case msg := <-s.broadcast:
+			s.relayMessage(msg)
+		case <-shutdown:
+			// Tell every client why it's being disconnected. Their Write goroutines close the connections
+			// once the notice has been written, which makes their Read goroutines unregister them.
+			s.stopped = true
+			shutdown = nil
+			for _, client := range s.suspended {
+				s.removeClient(client)
+			}
+			for _, client := range s.clients {
+				client.Disconnect("Server is shutting down.")
 			}
 
-			// Broadcast to channel members
-			for _, member := range msg.Channel.members {
-				if msg.SenderName != member.GetUsername() {
-					member.SendMessage(formattedMsg)
-				}
+			if len(s.connections) == 0 {
+				return
 			}
-		case <-s.shutdown:
-			// Handle server shutdown
-			if !s.stopped {
-				for _, client := range s.clients {
-					client.conn.Close()
-				}
-				s.stopped = true
+			flushDeadline = time.After(shutdownFlushTimeout)
+		case <-flushDeadline:
+			// Clients too slow to receive the notice are closed without it
+			for _, client := range s.connections {
+				client.conn.Close()
 			}
+		}
+	}
+}
 
-			if len(s.clients) == 0 {
-				return // Exit if no clients are connected
+// runOffLoop runs slow work, like hashing a password or writing a file, in its own goroutine so the run loop keeps
+// relaying messages meanwhile. The function work returns is then run on the run loop to act on the result, unless the
+// server shut down first. Clients may have left by then, which it must check with hasSession before replying.
+func (s *Server) runOffLoop(work func() func()) {
+	s.pendingWork++
+	go func() {
+		done := work()
+		select {
+		case s.completed <- done:
+		case <-s.shutdown:
+		}
+	}()
+}
+
+// finishOffLoop runs the result of work started with runOffLoop
+func (s *Server) finishOffLoop(done func()) {
+	s.pendingWork--
+	done()
+}
+
+// hasSession reports whether the session is still connected, or suspended waiting to be resumed
+func (s *Server) hasSession(session Session) bool {
+	return s.clients[session.clientsKey()] == session
+}
+
+// relayMessage delivers a message taken from the broadcast queue: to every client if it has no channel,
+// otherwise to the members of its channel once it passed the channel's checks
+func (s *Server) relayMessage(msg Message) {
+	if msg.Channel == nil {
+		formattedMsg := formatMessage(msg.SenderName, msg.Content)
+
+		// Broadcast message to all clients if no channel is specified
+		for _, client := range s.clients {
+			// Slow clients are spared global messages before they fall far enough behind to be disconnected
+			if msg.Sender != client && !s.isDowngraded(client) {
+				client.SendMessage(formattedMsg)
 			}
 		}
+		return
+	}
+
+	if msg.Chat {
+		msg.Content = formatContent(msg.Channel.FormattingMode(), msg.Content)
+	}
+
+	// Only operators can chat in channels that are in no-spam mode
+	if msg.Chat && msg.Channel.IsSpamLocked() && roleIn(msg.Sender, msg.Channel) < RoleOperator {
+		msg.Sender.SendNotice("no-spam "+msg.Channel.Name, formatChannelMessage("Server", msg.Channel.Name, "Channel is in no-spam mode."))
+		msg.Sender.rejectMessage(msg.Channel.Name, "channel is in no-spam mode")
+		return
+	}
+
+	// Bridged names can't pass for users of this server, who may have joined since the bridge first used them
+	if msg.Bridged != "" {
+		if err := s.checkBridgedName(msg.Bridged); err != nil {
+			msg.Sender.SendServerMessage(fmt.Sprintf("Can't relay the message: %s.", err))
+			msg.Sender.rejectMessage(msg.Channel.Name, "name not allowed")
+			return
+		}
+	}
+
+	// Shadow muted clients aren't told, so their message goes through the same checks as any other
+	if msg.Chat && s.isShadowMuted(msg.Sender) {
+		s.relayShadowMuted(msg)
+		return
+	}
+
+	// Broadcast to channel members, including the channel name so clients can tell where it was sent
+	formattedMsg := formatChannelMessage(msg.SenderName, msg.Channel.Name, msg.Content)
+	if msg.Event != "" {
+		formattedMsg = formatEvent(msg.Event, msg.Content)
+	}
+
+	// Clients that know the bridged event style bridged messages themselves, the others get the name with a suffix
+	bridgedMsg := ""
+	if msg.Bridged != "" {
+		bridgedMsg = formatBridged(msg)
+	}
+
+	if msg.Chat && msg.Bridged == "" {
+		s.announceFirstMessage(msg)
+	}
+
+	// Clients with echo off remember what they send, to skip it if it comes back from another sender
+	now := time.Now()
+	if msg.Chat && msg.Sender.State().echoOff {
+		msg.Sender.State().originated.add(msg.Content, now)
+	}
+
+	for member := range msg.Channel.Sessions() {
+		if msg.Sender == member || member.isReflection(msg, now) {
+			continue
+		}
+
+		// Members ignoring the channel still get control events so their client stays in sync
+		if _, ignored := member.State().ignoredChannels[msg.Channel.Name]; ignored && msg.Event == "" {
+			continue
+		}
+		if msg.Event == "" && member.GetChannel() != msg.Channel && s.isDowngraded(member) {
+			continue
+		}
+		// Clients drop the color of a member that left, which they still need if they see it in another channel
+		if msg.Event == "disconnect" && sharesChannel(member, msg.Sender) {
+			continue
+		}
+		if bridgedMsg != "" && member.Protocol() >= protocolBridged {
+			member.SendMessage(bridgedMsg)
+			continue
+		}
+		member.SendMessage(formattedMsg)
+	}
+
+	if msg.Chat {
+		debugSampled(s.log.runLoop, &s.messageLogs, "Message relayed", "username", msg.Sender.GetUsername(), "channel", msg.Channel.Name, "members", msg.Channel.MemberCount())
+		msg.Channel.CountMessage(msg.Sender)
+		msg.Channel.RecordActivity(msg.SenderName, now)
+		s.recordMessage(msg, "")
+		s.echoMessage(msg)
+		s.emitEvent(ServerEvent{Event: "message", User: msg.Sender.GetUsername(), Channel: msg.Channel.Name, Content: msg.Content})
 	}
 }
 
-func (s *Server) Start() {
+// sharesChannel reports whether the other session is a member of one of the client's channels
+func sharesChannel(client, other Session) bool {
+	for _, channel := range client.GetChannels() {
+		if member, isMember := channel.Member(other.GetUsername()); isMember && member == other {
+			return true
+		}
+	}
+	return false
+}
+
+// startClient starts the reader and writer goroutines of the client
+func (s *Server) startClient(client *Client) {
 	s.wg.Add(1)
-	go s.run()
+	go func() {
+		defer s.wg.Done()
+		client.Read()
+	}()
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		client.Write()
+	}()
+}
 
+// Start listens on the server's address and serves until it's interrupted, then shuts down
+func (s *Server) Start() {
 	// Use hostname:port for net.Listen, not the URL string
 	listenAddr := s.url.Hostname() + ":" + s.url.Port()
 	listener, err := net.Listen("tcp", listenAddr)
 	if err != nil {
-		s.logger.Error("Failed to start server", "error", err)
+		s.log.listener.Error("Failed to start server", "error", err)
 		os.Exit(1)
 	}
-	defer listener.Close()
 
-	s.logger.Info("Server is running", "address", s.url.Hostname(), "port", s.url.Port())
+	s.Serve(listener)
+	s.log.listener.Info("Server is running", "address", s.url.Hostname(), "port", s.url.Port())
+
+	// Handle graceful shutdown on interrupt signal
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	<-c
+
+	s.Shutdown()
+}
+
+// Serve starts the server and accepts connections from the listener until Shutdown is called, which closes it.
+// It returns right away. The listener can be anything, e.g. one wrapping TLS or an in-memory one in tests.
+func (s *Server) Serve(listener net.Listener) {
+	s.listener = listener
+
+	s.wg.Add(1)
+	go s.run()
+
+	if s.events != nil {
+		go s.events.run()
+	}
+
+	s.wg.Add(1)
+	go s.sampleRuntime()
+
+	if s.config.MetricsAddr != "" {
+		s.serveMetrics()
+	}
 
 	// Start listening for incoming connections
 	s.wg.Add(1)
 	go func() {
 		defer s.wg.Done()
+		defer close(s.acceptDone)
 		for {
 			conn, err := listener.Accept()
 			if err != nil {
@@ -225,47 +1074,182 @@ func (s *Server) Start() {
 					return // Listener closed, exit the loop
 				}
 
-				s.logger.Error("Failed to accept connection", "error", err)
+				s.log.listener.Error("Failed to accept connection", "error", err)
 				continue
 			}
+			if err := setTCPKeepalive(conn, s.config.TCPKeepalive); err != nil {
+				s.log.listener.Warn("Failed to set TCP keepalive", "ip", conn.RemoteAddr().String(), "error", err)
+			}
 
-			s.register <- NewClient(conn, s, "", maxBucketSize, bucketRate) // Queue new client for registration
+			s.register <- NewClient(conn, s, "", rateLimiters[s.config.RateLimiter]()) // Queue new client for registration
 		}
 	}()
+}
 
-	// Handle graceful shutdown on interrupt signal
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	<-c
-
-	// Initiate shutdown
-	s.logger.Info("Shutting down server...")
-	listener.Close()
+// Shutdown stops the server in phases and returns once all of its goroutines have exited:
+//  1. Stop accepting connections, waiting for the accept loop so no client is registered afterwards.
+//  2. Send every client a close event saying the server is shutting down.
+//  3. Give their Write goroutines up to shutdownFlushTimeout to write it, closing the connections once written.
+//  4. Close the connections of clients that didn't receive it in time.
+//  5. Unregister clients as their Read goroutines exit, after which the run loop returns.
+//
+// Phases 2 to 5 happen in the run loop, which owns the clients.
+func (s *Server) Shutdown() {
+	s.log.listener.Info("Shutting down server...")
+	s.listener.Close()
+	<-s.acceptDone
+	if s.metricsServer != nil {
+		s.metricsServer.Close()
+	}
 
-	s.broadcastMessage(nil, nil, "Server is shutting down. Disconnecting...")
+	close(s.shutdown)
+	s.wg.Wait()
 
-	close(s.shutdown) // Signal shutdown to all goroutines
-	s.wg.Wait()       // Wait for all goroutines to finish
-	s.logger.Info("Server has shut down.")
+	if s.events != nil {
+		s.events.close()
+	}
+	if s.messageLog != nil {
+		s.messageLog.Close()
+	}
+	s.log.listener.Info("Server has shut down.")
 }
 
-func (s *Server) broadcastMessage(client *Client, channel *Channel, msg string) error {
+func (s *Server) broadcastMessage(client Session, channel *Channel, msg string) error {
 	senderName := "Server"
 	if client != nil {
-		senderName = client.GetUsername()
+		senderName = client.DisplayName()
 	}
 
 	message := Message{
+		Sender:     client,
 		SenderName: senderName,
 		Channel:    channel,
 		Content:    msg,
 	}
 
+	return s.queueBroadcast(message)
+}
+
+// broadcastNotice queues a message from the server about the client for the other members of the channel
+func (s *Server) broadcastNotice(client Session, channel *Channel, msg string) error {
+	return s.queueBroadcast(Message{
+		Sender:     client,
+		SenderName: "Server",
+		Channel:    channel,
+		Content:    msg,
+	})
+}
+
+// broadcastChatMessage queues a message typed by the client for the members of the channel.
+// Unless flood protection is enabled, it waits for room in the broadcast channel, slowing down the client
+// instead of losing the message. It must only be called from the client's Read goroutine.
+func (s *Server) broadcastChatMessage(client Session, channel *Channel, msg string) error {
+	return s.queueChatMessage(Message{
+		Sender:     client,
+		SenderName: client.DisplayName(),
+		Channel:    channel,
+		Content:    msg,
+		Chat:       true,
+	})
+}
+
+// queueChatMessage queues a chat message, dropping it with flood protection if the broadcast channel is full,
+// or waiting up to the broadcast wait for room otherwise
+func (s *Server) queueChatMessage(message Message) error {
+	if s.config.FloodProtection {
+		return s.queueBroadcast(message)
+	}
+
+	select {
+	case s.broadcast <- message:
+		return nil
+	default:
+	}
+
+	// Waiting for the run loop forever would leave the client's connection unread if it stalls,
+	// so the message is only held back for a while
+	timer := time.NewTimer(s.config.BroadcastWait)
+	defer timer.Stop()
+	select {
+	case s.broadcast <- message:
+		return nil
+	case <-timer.C:
+		s.dropBroadcast(message)
+		return ErrBroadcastChannelFull
+	}
+}
+
+// echoMessage sends a chat message back to its sender as it was relayed, along with its sequence number,
+// so the client can replace the line it displayed when it was typed
+func (s *Server) echoMessage(msg Message) {
+	s.messageSeq++
+	msg.Sender.SendMessage(formatEvent("echo", fmt.Sprintf("%d %s %s", s.messageSeq, msg.Channel.Name, msg.Content)))
+}
+
+// announceFirstMessage welcomes a client to the channel before relaying the first chat message it ever sent.
+// It's sent from the run loop, once the message is known to be relayed, so it comes right before it.
+func (s *Server) announceFirstMessage(msg Message) {
+	if s.config.DisableFirstMessageAnnounce || !msg.Sender.markFirstMessage() {
+		return
+	}
+
+	announcement := formatFirstMessageAnnouncement(msg)
+	for member := range msg.Channel.Sessions() {
+		if _, ignored := member.State().ignoredChannels[msg.Channel.Name]; ignored {
+			continue
+		}
+		member.SendMessage(announcement)
+	}
+}
+
+func formatFirstMessageAnnouncement(msg Message) string {
+	return formatChannelMessage("Server", msg.Channel.Name, fmt.Sprintf("👋 %s said their first message!", msg.Sender.GetUsername()))
+}
+
+// relayShadowMuted handles the chat message of a shadow muted client as if it was relayed, as far as the client
+// can tell: it gets the first message announcement and the echo, and finds the message in the history and its
+// exports, but nobody else sees any of it
+func (s *Server) relayShadowMuted(msg Message) {
+	if !s.config.DisableFirstMessageAnnounce && msg.Sender.markFirstMessage() {
+		msg.Sender.SendMessage(formatFirstMessageAnnouncement(msg))
+	}
+
+	msg.Channel.CountMessage(msg.Sender)
+	s.recordMessage(msg, msg.Sender.GetUsername())
+	s.echoMessage(msg)
+}
+
+// broadcastEvent queues a control event for the members of the channel
+func (s *Server) broadcastEvent(channel *Channel, event, argument string) error {
+	return s.queueBroadcast(Message{
+		SenderName: "system",
+		Channel:    channel,
+		Content:    argument,
+		Event:      event,
+	})
+}
+
+// queueBroadcast queues the message without blocking, dropping it if the broadcast channel is full.
+// Blocking isn't an option since the run loop, which empties the channel, queues messages too.
+func (s *Server) queueBroadcast(message Message) error {
 	select {
 	case s.broadcast <- message:
 		return nil
 	default:
-		s.logger.Warn("Broadcast channel full, dropping message", "sender", senderName)
+		s.dropBroadcast(message)
 		return ErrBroadcastChannelFull
 	}
 }
+
+// dropBroadcast counts a message dropped because the broadcast channel was full. The warning is logged at most once
+// per dropWarningInterval, with the total so far, so an overloaded server doesn't also flood its log.
+func (s *Server) dropBroadcast(message Message) {
+	dropped := s.stats.DroppedBroadcasts.Add(1)
+
+	now := time.Now().UnixNano()
+	warnedAt := s.stats.dropWarnedAt.Load()
+	if now-warnedAt < int64(dropWarningInterval) || !s.stats.dropWarnedAt.CompareAndSwap(warnedAt, now) {
+		return
+	}
+	s.log.runLoop.Warn("Broadcast channel full, dropping messages", "sender", message.SenderName, "dropped", dropped, "queued", len(s.broadcast))
+}
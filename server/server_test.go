@@ -0,0 +1,673 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serve starts a test server on a random loopback port, returning it and its address
+func serve(t testing.TB, configure func(*Config)) (*Server, string) {
+	t.Helper()
+	server := newTestServer(t, configure)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	server.Serve(listener)
+	return server, listener.Addr().String()
+}
+
+// dial connects to the server at addr with the handshake, registering as username unless it's empty
+func dial(t testing.TB, addr, username string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	fmt.Fprintf(conn, "HELLO %d %d\n", protocolVersion, supportedFeatures)
+	if username == "" {
+		return conn
+	}
+
+	fmt.Fprintf(conn, "%s\n", username)
+	readUntil(t, conn, fmt.Sprintf("Your username has been set to '%s'", username))
+	return conn
+}
+
+// readUntil reads from the connection until the text was received, failing after a few seconds
+func readUntil(t testing.TB, conn net.Conn, text string) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+
+	var received []byte
+	buf := make([]byte, 4096)
+	for !bytes.Contains(received, []byte(text)) {
+		n, err := conn.Read(buf)
+		received = append(received, buf[:n]...)
+		if err != nil {
+			t.Fatalf("waiting for %q: %v", text, err)
+		}
+	}
+}
+
+// openFDs returns how many file descriptors the process has open, or -1 where that can't be told
+func openFDs() int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	return len(entries)
+}
+
+// waitForBaseline waits for the goroutine and file descriptor counts to drop back to what they were, failing if they don't
+func waitForBaseline(t *testing.T, goroutines, fds int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		nowGoroutines, nowFDs := runtime.NumGoroutine(), openFDs()
+		if nowGoroutines <= goroutines && nowFDs <= fds {
+			return
+		}
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			t.Fatalf("%d goroutines and %d fds left, want at most %d and %d\n%s", nowGoroutines, nowFDs, goroutines, fds, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNoLeaks(t *testing.T) {
+	tests := []struct {
+		name       string
+		registered int
+		guests     int // Clients that connect without setting a username
+		end        func(t *testing.T, server *Server, conns []net.Conn)
+		shutdown   bool // Whether the baseline is from before the server started instead of before clients connected
+	}{
+		{
+			name:       "clients disconnect",
+			registered: 20,
+			guests:     5,
+			end: func(t *testing.T, server *Server, conns []net.Conn) {
+				for _, conn := range conns {
+					conn.Close()
+				}
+			},
+		},
+		{
+			name:       "clients quit",
+			registered: 20,
+			end: func(t *testing.T, server *Server, conns []net.Conn) {
+				for _, conn := range conns {
+					fmt.Fprintln(conn, "/quit")
+					io.Copy(io.Discard, conn) // The server closes the connection after saying goodbye
+					conn.Close()
+				}
+			},
+		},
+		{
+			name:       "server shuts down",
+			registered: 20,
+			guests:     5,
+			shutdown:   true,
+			end: func(t *testing.T, server *Server, conns []net.Conn) {
+				// Clients that don't read still have their connection closed
+				for _, conn := range conns[:len(conns)/2] {
+					go io.Copy(io.Discard, conn)
+				}
+				server.Shutdown()
+				for _, conn := range conns {
+					conn.Close()
+				}
+			},
+		},
+		{
+			name:     "server shuts down without clients",
+			shutdown: true,
+			end: func(t *testing.T, server *Server, conns []net.Conn) {
+				server.Shutdown()
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			goroutines, fds := runtime.NumGoroutine(), openFDs()
+
+			server, addr := serve(t, nil)
+			if !test.shutdown {
+				t.Cleanup(server.Shutdown)
+				// Clients disconnecting leave the server as it was once it started
+				time.Sleep(10 * time.Millisecond)
+				goroutines, fds = runtime.NumGoroutine(), openFDs()
+			}
+
+			var conns []net.Conn
+			for i := range test.registered {
+				conns = append(conns, dial(t, addr, fmt.Sprintf("user%d", i)))
+			}
+			for range test.guests {
+				conn := dial(t, addr, "")
+				readUntil(t, conn, "Welcome!")
+				conns = append(conns, conn)
+			}
+
+			test.end(t, server, conns)
+			waitForBaseline(t, goroutines, fds)
+			runtime.KeepAlive(conns) // Connections left open would otherwise be closed once they're garbage collected
+		})
+	}
+}
+
+func TestChannelIndex(t *testing.T) {
+	tests := []struct {
+		name    string
+		add     []string
+		deleted []string
+		want    []string
+	}{
+		{name: "empty"},
+		{name: "sorted on insert", add: []string{"random", "general", "zoo", "alpha"}, want: []string{"alpha", "general", "random", "zoo"}},
+		{name: "deleted", add: []string{"random", "general", "zoo"}, deleted: []string{"general", "zoo"}, want: []string{"random"}},
+		{name: "deleting what isn't there", add: []string{"general"}, deleted: []string{"random"}, want: []string{"general"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, nil)
+			for _, name := range test.add {
+				server.addChannel(NewChannel(name, ""))
+			}
+			for _, name := range test.deleted {
+				server.deleteChannel(NewChannel(name, ""))
+			}
+
+			var names []string
+			for _, channel := range server.channelIndex {
+				names = append(names, channel.Name)
+			}
+			if !slices.Equal(names, test.want) {
+				t.Errorf("index is %q, want %q", names, test.want)
+			}
+			if len(server.channels) != len(test.want) {
+				t.Errorf("%d channels, want %d", len(server.channels), len(test.want))
+			}
+		})
+	}
+}
+
+// pipeListener is a listener handing the server one end of in-memory connections, for tests needing more
+// connections than file descriptors are available
+type pipeListener struct {
+	conns  chan net.Conn
+	closed chan struct{}
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	close(l.closed)
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr { return pipeAddr{} }
+
+// Dial returns the client's end of a new connection to the server
+func (l *pipeListener) Dial() net.Conn {
+	clientEnd, serverEnd := net.Pipe()
+	l.conns <- serverEnd
+	return clientEnd
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+// heapAndStacks returns the bytes of heap and goroutine stacks in use after a garbage collection
+func heapAndStacks() uint64 {
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapInuse + stats.StackInuse
+}
+
+func TestMemoryPerConnection(t *testing.T) {
+	if testing.Short() {
+		t.Skip("connecting thousands of clients")
+	}
+
+	tests := []struct {
+		name       string
+		clients    int
+		register   bool
+		configure  func(config *Config)
+		maxPerConn uint64 // Bytes, including the goroutine reading for the test's end of the connection
+	}{
+		{name: "unregistered", clients: 3000, maxPerConn: 48 << 10},
+		{name: "registered", clients: 3000, register: true, maxPerConn: 48 << 10},
+		{
+			name:     "bigger buffers",
+			clients:  1000,
+			register: true,
+			configure: func(config *Config) {
+				config.ReadBufferSize, config.WriteBufferSize = 16<<10, 16<<10
+			},
+			maxPerConn: 96 << 10,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, test.configure)
+			listener := newPipeListener()
+			server.Serve(listener)
+			defer server.Shutdown()
+
+			before := heapAndStacks()
+
+			conns := make([]net.Conn, test.clients)
+			ready := make(chan struct{}, test.clients)
+			for i := range conns {
+				conns[i] = listener.Dial()
+				want := []byte("Welcome!")
+				if test.register {
+					want = []byte(fmt.Sprintf("Your username has been set to 'user%d'", i))
+				}
+				go func(conn net.Conn) {
+					buf := make([]byte, 512)
+					var received []byte
+					signaled := false
+					for {
+						n, err := conn.Read(buf)
+						if err != nil {
+							return
+						}
+						if !signaled {
+							received = append(received, buf[:n]...)
+							if bytes.Contains(received, want) {
+								signaled, received = true, nil
+								ready <- struct{}{}
+							}
+						}
+					}
+				}(conns[i])
+				fmt.Fprintf(conns[i], "HELLO %d %d\n", protocolVersion, supportedFeatures)
+				if test.register {
+					fmt.Fprintf(conns[i], "user%d\n", i)
+				}
+			}
+			for range test.clients {
+				select {
+				case <-ready:
+				case <-time.After(10 * time.Second):
+					t.Fatal("clients didn't connect in time")
+				}
+			}
+
+			after := heapAndStacks()
+			perConn := (after - min(before, after)) / uint64(test.clients)
+			t.Logf("%d bytes per connection", perConn)
+			if perConn > test.maxPerConn {
+				t.Errorf("%d bytes per connection, want at most %d", perConn, test.maxPerConn)
+			}
+
+			for _, conn := range conns {
+				conn.Close()
+			}
+		})
+	}
+}
+
+func TestMaxLineLength(t *testing.T) {
+	const maxLineLength = 4096
+
+	tests := []struct {
+		name   string
+		length int
+		want   string
+	}{
+		{name: "short", length: 10, want: "Your username has been set"},
+		{name: "at the limit", length: maxLineLength - 1, want: "Failed to set username"},
+		{name: "over the limit", length: maxLineLength + 1, want: fmt.Sprintf("Lines can't be longer than %d bytes.", maxLineLength)},
+		{name: "far over the limit", length: 1 << 20, want: fmt.Sprintf("Lines can't be longer than %d bytes.", maxLineLength)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, func(config *Config) { config.MaxLineLength = maxLineLength })
+			listener := newPipeListener()
+			server.Serve(listener)
+			defer server.Shutdown()
+
+			conn := listener.Dial()
+			defer conn.Close()
+			go conn.Write([]byte(strings.Repeat("x", test.length) + "\n"))
+			readUntil(t, conn, test.want)
+		})
+	}
+}
+
+func TestFirstMessageAnnouncement(t *testing.T) {
+	announcement := formatChannelMessage("Server", "general", "👋 alice said their first message!")
+
+	tests := []struct {
+		name     string
+		disabled bool
+		messages []Message // Sent by alice to general
+		want     []string  // What bob receives
+	}{
+		{
+			name:     "first message",
+			messages: []Message{{Content: "hi", Chat: true}},
+			want:     []string{announcement, "alice|general|hi"},
+		},
+		{
+			name:     "second message",
+			messages: []Message{{Content: "hi", Chat: true}, {Content: "again", Chat: true}},
+			want:     []string{announcement, "alice|general|hi", "alice|general|again"},
+		},
+		{
+			name:     "not a chat message",
+			messages: []Message{{Content: "/me waves"}},
+			want:     []string{"alice|general|/me waves"},
+		},
+		{
+			name:     "bridged",
+			messages: []Message{{Content: "hi", Chat: true, Bridged: "irc"}},
+		},
+		{
+			name:     "disabled",
+			disabled: true,
+			messages: []Message{{Content: "hi", Chat: true}},
+			want:     []string{"alice|general|hi"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, func(config *Config) { config.DisableFirstMessageAnnounce = test.disabled })
+			alice, bob := connect(server, "alice"), connect(server, "bob")
+			channel := join(t, server, alice, "general")
+			join(t, server, bob, "general")
+			bob.sent = nil
+
+			for _, msg := range test.messages {
+				msg.Sender, msg.SenderName, msg.Channel = alice, "alice", channel
+				server.relayMessage(msg)
+			}
+
+			got := bob.sent
+			if test.messages[0].Bridged != "" {
+				// Bridged messages are relayed in their own format, only the announcement is checked
+				got = slices.DeleteFunc(got, func(sent string) bool { return sent != announcement })
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("bob got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMarkFirstMessage(t *testing.T) {
+	server := newTestServer(t, nil)
+	client, _ := newPipeClient(t, server, "alice")
+
+	for i, want := range []bool{true, false, false} {
+		if got := client.markFirstMessage(); got != want {
+			t.Errorf("call %d returned %t, want %t", i+1, got, want)
+		}
+	}
+}
+
+func TestReservedUsernames(t *testing.T) {
+	tests := []struct {
+		username string
+		wantErr  bool
+	}{
+		{username: "Server", wantErr: true},
+		{username: "server", wantErr: true},
+		{username: "SERVER", wantErr: true},
+		{username: " Server ", wantErr: true},
+		{username: "5erver", wantErr: true}, // Looks like "Server"
+		{username: ".", wantErr: true},
+		{username: "system", wantErr: true},
+		{username: "admin", wantErr: true}, // Reserved by the config
+		{username: "Admin", wantErr: true},
+		{username: "Servers"},
+		{username: "alice"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.username, func(t *testing.T) {
+			server := newTestServer(t, func(config *Config) { config.ReservedNames = parseReservedNames("admin, moderator") })
+			session := newFakeSession("guest")
+			session.registered = false
+
+			err := server.changeUsername(session, session.clientsKey(), test.username)
+			if (err != nil) != test.wantErr {
+				t.Errorf("got error %v, want error %t", err, test.wantErr)
+			}
+			if test.wantErr && !strings.Contains(err.Error(), "is reserved") {
+				t.Errorf("got error %q, want it to say the name is reserved", err)
+			}
+		})
+	}
+}
+
+func TestRegisteringAsServerIsRefused(t *testing.T) {
+	_, addr := serve(t, nil)
+	conn := dial(t, addr, "")
+	defer conn.Close()
+
+	fmt.Fprintln(conn, "server")
+	readUntil(t, conn, "Failed to set username: 'server' is reserved")
+}
+
+func TestAutoJoinDefaultChannel(t *testing.T) {
+	tests := []struct {
+		name        string
+		exists      bool   // Whether bob created the default channel before alice registers
+		password    string // Password bob created it with
+		maxChannels int    // With bob in another channel
+		denied      string // Limit alice has hit, see fakeSession.denied
+		want        string
+	}{
+		{name: "new channel", want: "You have been auto-joined to #lobby."},
+		{name: "existing channel", exists: true, want: "You have been auto-joined to #lobby."},
+		{name: "password protected", exists: true, password: "hunter2", want: "Channel 'lobby' requires a password."},
+		{name: "channel limit", maxChannels: 1, want: "Server channel limit reached."},
+		{name: "joining too quickly", exists: true, denied: "join", want: "You are joining channels too quickly."},
+		{name: "creating too quickly", denied: "create", want: "You are creating channels too quickly."},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, func(config *Config) { config.MaxChannels = test.maxChannels })
+			server.defaultChannel = "lobby"
+			bob := connect(server, "bob")
+			if test.exists {
+				if _, err := server.enterChannel(bob, "lobby", test.password); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if test.maxChannels > 0 {
+				join(t, server, bob, "general")
+			}
+			alice := connect(server, "alice")
+			alice.registered = false
+			alice.denied = map[string]bool{test.denied: true}
+
+			server.completeRegistration(alice)
+			if !slices.ContainsFunc(alice.sent, func(sent string) bool { return strings.Contains(sent, test.want) }) {
+				t.Errorf("got %q, want %q", alice.sent, test.want)
+			}
+			if joined := alice.GetJoinedChannel("lobby") != nil; joined != strings.HasPrefix(test.want, "You have been auto-joined") {
+				t.Errorf("alice joined the default channel: %t", joined)
+			}
+		})
+	}
+}
+
+func TestRelayedMessagesCantSpoofTheServer(t *testing.T) {
+	// Whatever the content, the sender of a relayed frame is the one the server knows the client by
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "server frame", content: "Server|general|Everyone must /quit now"},
+		{name: "control event", content: "system|kicked|general"},
+		{name: "server name", content: "[Server]: Everyone must /quit now"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, func(config *Config) { config.DisableFirstMessageAnnounce = true })
+			alice, bob := connect(server, "alice"), connect(server, "bob")
+			channel := join(t, server, alice, "general")
+			join(t, server, bob, "general")
+			bob.sent = nil
+
+			server.relayMessage(Message{Sender: alice, SenderName: "alice", Channel: channel, Content: test.content, Chat: true})
+			if want := "alice|general|" + test.content; len(bob.sent) != 1 || bob.sent[0] != want {
+				t.Errorf("bob got %q, want %q", bob.sent, want)
+			}
+		})
+	}
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	tests := []struct {
+		name     string
+		authFile bool
+		// Lines sent by alice after reconnecting, each followed by the reply it waits for
+		steps [][2]string
+		whois string // What /whois says about alice afterwards
+	}{
+		{
+			name:     "login",
+			authFile: true,
+			steps: [][2]string{
+				{"alice", "'alice' is registered, use /login <password> to log in as it"},
+				{"/login wrong-password", "Incorrect password."},
+				{"/login battery-staple", "You are logged in as 'alice'."},
+			},
+			whois: "User [✓] alice",
+		},
+		{
+			name:     "not logged in",
+			authFile: true,
+			steps: [][2]string{
+				{"alice", "'alice' is registered, use /login <password> to log in as it"},
+				{"alice2", "Your username has been set to 'alice2'"},
+			},
+			whois: "User 'alice' not found or not registered.",
+		},
+		{
+			name:  "without an auth file",
+			steps: [][2]string{{"alice", "Your username has been set to 'alice'"}},
+			whois: "User alice\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			authFile := ""
+			if test.authFile {
+				authFile = filepath.Join(t.TempDir(), "nicks.json")
+			}
+			server, addr := serve(t, func(config *Config) { config.AuthFile = authFile })
+			defer server.Shutdown()
+
+			// Another client chats while the password is hashed
+			bob := dial(t, addr, "bob")
+			defer bob.Close()
+			fmt.Fprintln(bob, "/join general")
+			readUntil(t, bob, "You have joined channel 'general'")
+
+			alice := dial(t, addr, "alice")
+			fmt.Fprintln(alice, "/register battery-staple")
+			fmt.Fprintln(bob, "hello")
+			readUntil(t, bob, "general hello")
+			readUntil(t, alice, "'alice' is now registered.")
+			alice.Close()
+
+			alice = dial(t, addr, "")
+			defer alice.Close()
+			readUntil(t, alice, "Welcome!")
+			for _, step := range test.steps {
+				fmt.Fprintln(alice, step[0])
+				readUntil(t, alice, step[1])
+			}
+
+			fmt.Fprintln(bob, "/whois alice")
+			readUntil(t, bob, test.whois)
+		})
+	}
+}
+
+func TestDisconnectEventOnlyWithoutSharedChannels(t *testing.T) {
+	tests := []struct {
+		name  string
+		leave []string // Channels alice leaves, in order
+		want  map[string]int
+	}{
+		{name: "still shares a channel", leave: []string{"general"}, want: map[string]int{"bob": 0, "carol": 1}},
+		{name: "not in the channel", leave: []string{"random"}, want: map[string]int{"bob": 0, "carol": 0}},
+		{name: "leaves both", leave: []string{"general", "random"}, want: map[string]int{"bob": 1, "carol": 1}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, nil)
+			alice, bob, carol := connect(server, "alice"), connect(server, "bob"), connect(server, "carol")
+			for _, session := range []*fakeSession{alice, bob} {
+				join(t, server, session, "general")
+				join(t, server, session, "random")
+			}
+			join(t, server, carol, "general")
+			drainBroadcasts(server)
+			bob.sent, carol.sent = nil, nil
+
+			for _, channelName := range test.leave {
+				server.leaveChannel(alice, server.channels[channelName])
+				for len(server.broadcast) > 0 {
+					server.relayMessage(<-server.broadcast)
+				}
+			}
+
+			for username, session := range map[string]*fakeSession{"bob": bob, "carol": carol} {
+				var events int
+				for _, msg := range session.sent {
+					if msg == formatEvent("disconnect", "alice") {
+						events++
+					}
+				}
+				if events != test.want[username] {
+					t.Errorf("%s got %d disconnect events, want %d", username, events, test.want[username])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/muesli/termenv"
+)
+
+// fakeSession is a Session without a connection that records everything it's sent
+type fakeSession struct {
+	SessionState
+
+	id               string
+	username         string
+	ip               string
+	registered       bool
+	admin            bool
+	bridge           bool
+	icon             string
+	channels         []*Channel
+	activeChannel    *Channel
+	awayMessage      string
+	autoAway         bool
+	mutedUntil       time.Time
+	features         Feature
+	protocol         int32
+	firstMessageSent bool
+	denied           map[string]bool // Limits refusing the session by name: join, create, whisper and receive
+
+	sent         []string // Every frame sent to the session, in order
+	disconnected string   // Reason the session was disconnected for, empty while it's connected
+}
+
+var _ Session = (*fakeSession)(nil)
+
+var fakeSessionIDs int
+
+// newFakeSession creates a registered session with the username, connected from a documentation address
+func newFakeSession(username string) *fakeSession {
+	fakeSessionIDs++
+	return &fakeSession{
+		SessionState: SessionState{ignoredChannels: make(map[string]struct{})},
+		id:           fmt.Sprintf("fake-%d", fakeSessionIDs),
+		username:     username,
+		ip:           "203.0.113.7:50000",
+		registered:   true,
+		protocol:     protocolVersion,
+		features:     supportedFeatures,
+		denied:       make(map[string]bool),
+	}
+}
+
+func (f *fakeSession) ID() string          { return f.id }
+func (f *fakeSession) GetUsername() string { return f.username }
+func (f *fakeSession) GetChannel() *Channel {
+	return f.activeChannel
+}
+func (f *fakeSession) SetChannel(ch *Channel) { f.activeChannel = ch }
+
+func (f *fakeSession) SendMessage(msg string) error {
+	if f.disconnected != "" {
+		return ErrConnectionClosed
+	}
+	f.sent = append(f.sent, msg)
+	return nil
+}
+
+func (f *fakeSession) SendMessageAt(msg string, sentAt time.Time) error { return f.SendMessage(msg) }
+func (f *fakeSession) SendServerMessage(text string) error {
+	return f.SendMessage(formatMessage("Server", text))
+}
+func (f *fakeSession) SendNotice(key, msg string) { f.SendMessage(msg) }
+
+func (f *fakeSession) SendStream(chunks []string) error {
+	for _, chunk := range slices.Concat([]string{streamStartMarker}, chunks, []string{streamEndMarker}) {
+		if err := f.SendMessage(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fakeSession) Disconnect(reason string) {
+	if f.disconnected == "" {
+		f.SendMessage(formatEvent("close", reason))
+		f.disconnected = reason
+	}
+}
+
+func (f *fakeSession) RemoteAddr() string { return f.ip }
+func (f *fakeSession) Host() string {
+	host, _, err := net.SplitHostPort(f.ip)
+	if err != nil {
+		return f.ip
+	}
+	return host
+}
+func (f *fakeSession) SetUsername(username string) { f.username = username }
+func (f *fakeSession) DisplayName() string {
+	if f.icon != "" {
+		return f.icon + " " + f.username
+	}
+	return f.username
+}
+func (f *fakeSession) GetDisplayIcon() string     { return f.icon }
+func (f *fakeSession) SetDisplayIcon(icon string) { f.icon = icon }
+func (f *fakeSession) clientsKey() string {
+	if f.registered {
+		return f.username
+	}
+	return "unregistered " + f.id
+}
+
+func (f *fakeSession) IsRegistered() bool            { return f.registered }
+func (f *fakeSession) SetRegistered(registered bool) { f.registered = registered }
+func (f *fakeSession) IsAdmin() bool                 { return f.admin }
+func (f *fakeSession) SetAdmin(admin bool)           { f.admin = admin }
+func (f *fakeSession) IsBridge() bool                { return f.bridge }
+func (f *fakeSession) SetBridge(bridge bool) bool {
+	was := f.bridge
+	f.bridge = bridge
+	return was
+}
+
+func (f *fakeSession) GetChannels() []*Channel { return slices.Clone(f.channels) }
+func (f *fakeSession) GetJoinedChannel(name string) *Channel {
+	for _, ch := range f.channels {
+		if ch.Name == name {
+			return ch
+		}
+	}
+	return nil
+}
+
+func (f *fakeSession) AddChannel(ch *Channel) {
+	if !slices.Contains(f.channels, ch) {
+		f.channels = append(f.channels, ch)
+		f.SendMessage(formatEvent("joined", ch.Name))
+	}
+	f.activeChannel = ch
+}
+
+func (f *fakeSession) RemoveChannel(ch *Channel) {
+	if !slices.Contains(f.channels, ch) {
+		return
+	}
+	f.channels = slices.DeleteFunc(f.channels, func(joined *Channel) bool {
+		return joined == ch
+	})
+	f.SendMessage(formatEvent("left", ch.Name))
+
+	if f.activeChannel == ch {
+		f.activeChannel = nil
+		if len(f.channels) > 0 {
+			f.activeChannel = f.channels[len(f.channels)-1]
+		}
+	}
+}
+
+func (f *fakeSession) Away() (string, bool) { return f.awayMessage, f.autoAway }
+func (f *fakeSession) SetAway(message string, auto bool) {
+	f.awayMessage, f.autoAway = message, auto
+}
+func (f *fakeSession) ClearAway()                  { f.awayMessage, f.autoAway = "", false }
+func (f *fakeSession) IdleFor() time.Duration      { return 0 }
+func (f *fakeSession) Mute(duration time.Duration) { f.mutedUntil = time.Now().Add(duration) }
+func (f *fakeSession) MutedFor() time.Duration     { return time.Until(f.mutedUntil) }
+func (f *fakeSession) clearExpiredMute(now time.Time) bool {
+	if f.mutedUntil.IsZero() || f.mutedUntil.After(now) {
+		return false
+	}
+	f.mutedUntil = time.Time{}
+	return true
+}
+
+func (f *fakeSession) ColorProfile() termenv.Profile { return termenv.Ascii }
+func (f *fakeSession) uses(feature Feature) bool     { return f.features.Has(feature) }
+func (f *fakeSession) Protocol() int32               { return f.protocol }
+func (f *fakeSession) IsSlow() bool                  { return false }
+func (f *fakeSession) markFirstMessage() bool {
+	first := !f.firstMessageSent
+	f.firstMessageSent = true
+	return first
+}
+func (f *fakeSession) rejectMessage(channelName, reason string) {
+	f.SendMessage(formatEvent("reject", channelName+" "+reason))
+}
+
+func (f *fakeSession) allowChannelCreation() bool       { return !f.denied["create"] }
+func (f *fakeSession) allowWhisper(recipients int) bool { return !f.denied["whisper"] }
+func (f *fakeSession) allowReceivingWhisper() bool      { return !f.denied["receive"] }
+func (f *fakeSession) allowJoin() bool                  { return !f.denied["join"] }
+func (f *fakeSession) JoinCooldownRemaining() time.Duration {
+	return 30 * time.Second
+}
+
+func (f *fakeSession) State() *SessionState { return &f.SessionState }
+func (f *fakeSession) session(now time.Time) sessionSummary {
+	return sessionSummary{ID: f.id, Username: f.username, Registered: f.registered, IP: f.ip, ConnectedAt: now}
+}
+
+// received reports whether a frame containing the text was sent to the session
+func (f *fakeSession) received(text string) bool {
+	return slices.ContainsFunc(f.sent, func(msg string) bool {
+		return strings.Contains(msg, text)
+	})
+}
+
+// testConfig returns the configuration the server runs with by default
+func testConfig() Config {
+	return Config{
+		Host:                "localhost",
+		Port:                "0",
+		SweepInterval:       time.Hour,
+		StaleChannelAge:     30 * 24 * time.Hour,
+		InviteCodeRetention: 24 * time.Hour,
+		BroadcastWait:       5 * time.Second,
+		NameCooldown:        time.Minute,
+		IdleAway:            15 * time.Minute,
+		IdleTimeout:         12 * time.Hour,
+		RegistrationTimeout: 2 * time.Minute,
+		PingInterval:        30 * time.Second,
+		KeepaliveInterval:   time.Minute,
+		ChannelCreateBucket: 3,
+		ChannelCreateRate:   0.1,
+		WhisperBucket:       5,
+		WhisperRate:         0.2,
+		JoinFloodLimit:      5,
+		JoinFloodWindow:     10 * time.Second,
+		JoinFloodCooldown:   30 * time.Second,
+		JoinFloodMute:       5 * time.Minute,
+		ReadBufferSize:      512,
+		WriteBufferSize:     1024,
+		SendQueueSize:       256,
+		MaxLineLength:       4096,
+		MaxMessageLength:    1024,
+		RateLimiter:         "token-bucket",
+		SlowQueueThreshold:  128,
+		SlowClientAfter:     10 * time.Second,
+		MetricsMinMembers:   5,
+		LogFormat:           "text",
+	}
+}
+
+// newTestServer creates a server that isn't listening, with the configuration changed by configure if it's not nil
+func newTestServer(t testing.TB, configure func(*Config)) *Server {
+	t.Helper()
+	config := testConfig()
+	if configure != nil {
+		configure(&config)
+	}
+
+	server, err := NewServer(config)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	discard := slog.New(slog.DiscardHandler)
+	server.log = loggers{listener: discard, runLoop: discard, client: discard, command: discard, storage: discard, events: discard}
+	return server
+}
+
+// connect adds a registered fake session with the username to the server, like the run loop does for a new client
+func connect(server *Server, username string) *fakeSession {
+	session := newFakeSession(username)
+	server.clients[session.clientsKey()] = session
+	return session
+}
+
+// join makes the session a member of the channel, creating it if needed
+func join(t testing.TB, server *Server, session Session, channelName string) *Channel {
+	t.Helper()
+	channel, err := server.enterChannel(session, channelName, "")
+	if err != nil {
+		t.Fatalf("joining '%s': %v", channelName, err)
+	}
+	return channel
+}
+
+// drainBroadcasts empties the broadcast channel, returning the content of the queued messages
+func drainBroadcasts(server *Server) []string {
+	var queued []string
+	for {
+		select {
+		case msg := <-server.broadcast:
+			queued = append(queued, msg.Content)
+		default:
+			return queued
+		}
+	}
+}
+
+// registerNickname registers the nickname with the password "correct horse", without saving it to a file
+func registerNickname(t testing.TB, server *Server, nickname string) {
+	t.Helper()
+	hash, err := hashNickPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.nicks.Add(nickname, hash); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// finishWork waits for the work started off the run loop, like checking passwords, and handles its results as the
+// run loop would, including those of the work it starts in turn
+func finishWork(t testing.TB, server *Server) {
+	t.Helper()
+	for server.pendingWork > 0 {
+		select {
+		case done := <-server.completed:
+			server.finishOffLoop(done)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d results of work done off the run loop never came", server.pendingWork)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+const maxSessionsListed = 50 // Most sessions /sessions lists
+
+// sessionSummary describes a client's connection from when it connected until now, or until it ended.
+// It's collected in one place for the "Session ended" log line and /sessions.
+type sessionSummary struct {
+	ID               string
+	Username         string
+	Registered       bool
+	IP               string
+	ConnectedAt      time.Time
+	Duration         time.Duration
+	MessagesSent     int64  // Chat messages the client sent to channels
+	MessagesReceived int64  // Messages and events written to the client
+	BytesRead        int64  // Including the newlines ending each line
+	BytesWritten     int64  // Including message headers
+	Reason           string // Why the session ended, empty while it's active
+}
+
+// session summarizes the client's session as of now
+func (c *Client) session(now time.Time) sessionSummary {
+	summary := sessionSummary{
+		ID:               c.ID(),
+		Username:         c.GetUsername(),
+		Registered:       c.IsRegistered(),
+		IP:               c.IP,
+		ConnectedAt:      c.connectedAt,
+		Duration:         now.Sub(c.connectedAt),
+		MessagesSent:     c.messageCount.Load(),
+		MessagesReceived: c.messagesReceived.Load(),
+		BytesRead:        c.bytesRead.Load(),
+		BytesWritten:     c.bytesWritten.Load(),
+	}
+	if reason := c.endReason.Load(); reason != nil {
+		summary.Reason = *reason
+	}
+	return summary
+}
+
+// endSession records why the session ended, keeping the first reason given since the others follow from it
+func (c *Client) endSession(reason string) {
+	c.endReason.CompareAndSwap(nil, &reason)
+}
+
+// traffic is the number of bytes exchanged in the session
+func (s sessionSummary) traffic() int64 {
+	return s.BytesRead + s.BytesWritten
+}
+
+// logAttrs returns the summary as structured log attributes
+func (s sessionSummary) logAttrs() []any {
+	return []any{
+		"id", s.ID, "username", s.Username, "registered", s.Registered, "ip", s.IP,
+		"connected_at", s.ConnectedAt.UTC(), "duration", s.Duration.Round(time.Millisecond),
+		"messages_sent", s.MessagesSent, "messages_received", s.MessagesReceived,
+		"bytes_read", s.BytesRead, "bytes_written", s.BytesWritten, "reason", s.Reason,
+	}
+}
+
+// sessions lists the active sessions, the longest ones first or, with --sort traffic, the busiest ones
+func sessions(name string, args []string, client Session, server *Server) {
+	sortBy := "duration"
+	if len(args) > 0 {
+		if len(args) != 2 || args[0] != "--sort" || (args[1] != "duration" && args[1] != "traffic") {
+			client.SendServerMessage("Usage: /sessions [--sort duration|traffic]")
+			return
+		}
+		sortBy = args[1]
+	}
+
+	now := time.Now()
+	summaries := make([]sessionSummary, 0, len(server.clients))
+	for _, connectedClient := range server.clients {
+		summaries = append(summaries, connectedClient.session(now))
+	}
+
+	slices.SortFunc(summaries, func(a, b sessionSummary) int {
+		if sortBy == "traffic" {
+			return cmp.Compare(b.traffic(), a.traffic())
+		}
+		return cmp.Compare(b.Duration, a.Duration)
+	})
+
+	lines := []string{fmt.Sprintf("Active sessions (%d), sorted by %s:", len(summaries), sortBy)}
+	for _, summary := range summaries[:min(len(summaries), maxSessionsListed)] {
+		username := summary.Username
+		if !summary.Registered {
+			username = "(unregistered)"
+		}
+
+		lines = append(lines, fmt.Sprintf("%s (%s, id %s): connected %s ago, %d sent / %d received, %s in / %s out",
+			username, summary.IP, summary.ID, formatDuration(summary.Duration), summary.MessagesSent, summary.MessagesReceived,
+			formatBytes(summary.BytesRead), formatBytes(summary.BytesWritten)))
+	}
+	if len(summaries) > maxSessionsListed {
+		lines = append(lines, fmt.Sprintf("... and %d more", len(summaries)-maxSessionsListed))
+	}
+
+	client.SendServerMessage(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+const (
+	reflectionWindow = 5 * time.Second // How long after sending a message an identical one counts as its reflection
+	maxOriginated    = 32              // Most messages remembered per client with echo off
+)
+
+// originatedMessage is a chat message sent by a client with echo off
+type originatedMessage struct {
+	Content string
+	SentAt  time.Time
+}
+
+// originatedLog remembers the chat messages a client sent within the reflection window, oldest first,
+// so they aren't delivered back to it when a bridge relays them under another sender.
+// Bounded by maxOriginated, only accessed from the server's run loop.
+type originatedLog struct {
+	entries []originatedMessage
+}
+
+// add remembers a message, forgetting the ones that left the reflection window and the oldest one once full
+func (l *originatedLog) add(content string, now time.Time) {
+	l.expire(now)
+	if len(l.entries) >= maxOriginated {
+		l.entries = slices.Delete(l.entries, 0, 1)
+	}
+	l.entries = append(l.entries, originatedMessage{Content: content, SentAt: now})
+}
+
+// contains reports whether a message with exactly the same content was sent within the reflection window
+func (l *originatedLog) contains(content string, now time.Time) bool {
+	l.expire(now)
+	return slices.ContainsFunc(l.entries, func(entry originatedMessage) bool {
+		return entry.Content == content
+	})
+}
+
+func (l *originatedLog) expire(now time.Time) {
+	l.entries = slices.DeleteFunc(l.entries, func(entry originatedMessage) bool {
+		return now.Sub(entry.SentAt) > reflectionWindow
+	})
+}
+
+// isReflection reports whether a chat message is one the client sent itself a moment ago, coming back from another sender.
+// Only clients with echo off are checked; the others get every message.
+func (s *SessionState) isReflection(msg Message, now time.Time) bool {
+	return msg.Chat && s.echoOff && s.originated.contains(msg.Content, now)
+}
+
+// set changes the client's settings for the session, or shows them
+func set(name string, args []string, client Session, server *Server) {
+	if len(args) == 0 {
+		echo := "on"
+		if client.State().echoOff {
+			echo = "off"
+		}
+		client.SendServerMessage(fmt.Sprintf("Settings:\necho: %s", echo))
+		return
+	}
+
+	if len(args) != 2 || args[0] != "echo" || (args[1] != "on" && args[1] != "off") {
+		client.SendServerMessage("Usage: /set echo <on|off>")
+		return
+	}
+
+	client.State().echoOff = args[1] == "off"
+	if !client.State().echoOff {
+		client.State().originated = originatedLog{}
+		client.SendServerMessage("Echo is on. You receive every message of your channels.")
+		return
+	}
+	client.SendServerMessage(fmt.Sprintf("Echo is off. Messages identical to one you sent in the last %s are not delivered to you.", reflectionWindow))
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+)
+
+// ShuffleMove moves a client from its active channel to a channel it isn't in
+type ShuffleMove struct {
+	Client Session
+	From   *Channel
+	To     *Channel // Nil if there was no other channel to move the client to
+}
+
+// planShuffle picks a channel for each client to move to from its active channel, among the destinations it isn't a member of.
+// Clients without any such destination stay put. pick returns a random number in [0, n).
+func planShuffle(clients []Session, destinations []*Channel, pick func(n int) int) []ShuffleMove {
+	moves := make([]ShuffleMove, 0, len(clients))
+	for _, client := range clients {
+		from := client.GetChannel()
+		if from == nil {
+			continue
+		}
+
+		var viable []*Channel
+		for _, channel := range destinations {
+			if client.GetJoinedChannel(channel.Name) == nil {
+				viable = append(viable, channel)
+			}
+		}
+
+		move := ShuffleMove{Client: client, From: from}
+		if len(viable) > 0 {
+			move.To = viable[pick(len(viable))]
+		}
+		moves = append(moves, move)
+	}
+	return moves
+}
+
+// cryptoIntN returns a uniformly random number in [0, n) from crypto/rand
+func cryptoIntN(n int) int {
+	value, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		panic("crypto/rand failed: " + err.Error())
+	}
+	return int(value.Int64())
+}
+
+// shuffleDestinations are the channels clients can be shuffled into, leaving out those they couldn't join by themselves
+func (s *Server) shuffleDestinations() []*Channel {
+	var destinations []*Channel
+	for _, channel := range s.channels {
+		if channel.IsLocked() || channel.RequiresPassword() || channel.IsHidden() {
+			continue
+		}
+		destinations = append(destinations, channel)
+	}
+	return destinations
+}
+
+// formatShufflePlan lists where each client would be moved, one per line, e.g. "alice: #general -> #random"
+func formatShufflePlan(moves []ShuffleMove) string {
+	lines := []string{"Shuffle plan (dry run):"}
+	for _, move := range moves {
+		if move.To == nil {
+			lines = append(lines, fmt.Sprintf("%s: stays in #%s, no other channel", move.Client.GetUsername(), move.From.Name))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s: #%s -> #%s", move.Client.GetUsername(), move.From.Name, move.To.Name))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func shuffle(name string, args []string, client Session, server *Server) {
+	if len(args) > 1 || (len(args) == 1 && args[0] != "--dry-run" && args[0] != "--confirm") {
+		client.SendServerMessage("Usage: /shuffle [--dry-run|--confirm]")
+		return
+	}
+
+	var clients []Session
+	for _, c := range server.clients {
+		if c.IsRegistered() {
+			clients = append(clients, c)
+		}
+	}
+
+	moves := planShuffle(clients, server.shuffleDestinations(), cryptoIntN)
+	moving := slices.DeleteFunc(slices.Clone(moves), func(move ShuffleMove) bool {
+		return move.To == nil
+	})
+	if len(moving) == 0 {
+		client.SendServerMessage("There is nobody to shuffle.")
+		return
+	}
+
+	switch {
+	case len(args) == 0:
+		client.SendServerMessage(fmt.Sprintf("This will move %d members to other channels. Use /shuffle --confirm to shuffle them, or /shuffle --dry-run to see where they could go.", len(moving)))
+		return
+	case args[0] == "--dry-run":
+		client.SendServerMessage(formatShufflePlan(moves))
+		return
+	}
+
+	// Everyone joins their new channel before anyone leaves, so no destination is deleted for being empty halfway through
+	var moved []ShuffleMove
+	for _, move := range moving {
+		if _, err := server.enterChannel(move.Client, move.To.Name, ""); err != nil {
+			server.log.command.Warn("Failed to shuffle client", "username", move.Client.GetUsername(), "channel", move.To.Name, "error", err)
+			continue
+		}
+		moved = append(moved, move)
+	}
+
+	for _, move := range moved {
+		server.leaveChannel(move.Client, move.From)
+		move.Client.SendServerMessage(fmt.Sprintf("You have been shuffled to #%s!", move.To.Name))
+	}
+
+	server.log.command.Info("Shuffled channel members", "admin", client.GetUsername(), "moved", len(moved))
+	client.SendServerMessage(fmt.Sprintf("Shuffled %d members.", len(moved)))
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPlanShuffle(t *testing.T) {
+	tests := []struct {
+		name     string
+		members  map[string][]string // Channels each client joins, the last being its active one
+		channels []string            // Channels created by someone else, so nobody is in them
+		want     map[string]string   // Where each client can be moved, "" if it stays put
+	}{
+		{
+			name:    "swap",
+			members: map[string][]string{"alice": {"general"}, "bob": {"random"}},
+			want:    map[string]string{"alice": "random", "bob": "general"},
+		},
+		{
+			name:    "only channel",
+			members: map[string][]string{"alice": {"general"}, "bob": {"general"}},
+			want:    map[string]string{"alice": "", "bob": ""},
+		},
+		{
+			name:    "member of every channel",
+			members: map[string][]string{"alice": {"random", "general"}, "bob": {"random"}},
+			want:    map[string]string{"alice": "", "bob": "general"},
+		},
+		{
+			name:     "empty channels are destinations too",
+			members:  map[string][]string{"alice": {"general"}},
+			channels: []string{"quiet"},
+			want:     map[string]string{"alice": "quiet"},
+		},
+		{
+			name:    "not in a channel",
+			members: map[string][]string{"alice": nil, "bob": {"general"}},
+			want:    map[string]string{"bob": ""},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, nil)
+			var clients []Session
+			for username, channelNames := range test.members {
+				session := connect(server, username)
+				for _, channelName := range channelNames {
+					join(t, server, session, channelName)
+				}
+				clients = append(clients, session)
+			}
+			for _, channelName := range test.channels {
+				server.addChannel(NewChannel(channelName, ""))
+			}
+
+			// Every pick is tried, so each destination a client could get is checked
+			for pick := range len(server.channels) {
+				moves := planShuffle(clients, server.shuffleDestinations(), func(n int) int { return pick % n })
+
+				got := make(map[string]string)
+				for _, move := range moves {
+					if move.From != move.Client.GetChannel() {
+						t.Errorf("%s moves from #%s, want their active channel", move.Client.GetUsername(), move.From.Name)
+					}
+					got[move.Client.GetUsername()] = ""
+					if move.To != nil {
+						got[move.Client.GetUsername()] = move.To.Name
+					}
+				}
+				if len(got) != len(test.want) {
+					t.Fatalf("got moves %v, want %v", got, test.want)
+				}
+				for username, to := range test.want {
+					if got[username] != to {
+						t.Errorf("%s moves to %q, want %q", username, got[username], to)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestPlanShuffleNeverKeepsMembersInTheirChannels(t *testing.T) {
+	server := newTestServer(t, nil)
+	channelNames := []string{"general", "random", "games", "music", "news"}
+	var clients []Session
+	for i, username := range []string{"alice", "bob", "carol", "dave", "erin", "frank", "grace"} {
+		session := connect(server, username)
+		// Members of up to four channels, so each has somewhere to go
+		for j := range i%4 + 1 {
+			join(t, server, session, channelNames[(i+j)%len(channelNames)])
+		}
+		clients = append(clients, session)
+	}
+
+	for range 1000 {
+		for _, move := range planShuffle(clients, server.shuffleDestinations(), cryptoIntN) {
+			if move.To == nil {
+				t.Fatalf("%s wasn't moved", move.Client.GetUsername())
+			}
+			if move.Client.GetJoinedChannel(move.To.Name) != nil {
+				t.Fatalf("%s was moved to #%s, which they're already in", move.Client.GetUsername(), move.To.Name)
+			}
+		}
+	}
+}
+
+func TestShuffleDestinations(t *testing.T) {
+	server := newTestServer(t, nil)
+	open := NewChannel("open", "")
+	locked := NewChannel("locked", "")
+	locked.SetLocked(true)
+	private := NewChannel("private", "secret")
+	hidden := NewChannel("hidden", "")
+	hidden.SetHidden(true)
+	for _, channel := range []*Channel{open, locked, private, hidden} {
+		server.addChannel(channel)
+	}
+
+	if got := server.shuffleDestinations(); !slices.Equal(got, []*Channel{open}) {
+		t.Errorf("got %d destinations, want only #open", len(got))
+	}
+}
+
+func TestShuffleDryRun(t *testing.T) {
+	server := newTestServer(t, nil)
+	alice := connect(server, "alice")
+	alice.SetAdmin(true)
+	bob, carol := connect(server, "bob"), connect(server, "carol")
+	join(t, server, alice, "general")
+	join(t, server, bob, "random")
+	join(t, server, carol, "random")
+	join(t, server, carol, "general")
+
+	server.runCommand(Command{Name: "shuffle", Args: []string{"--dry-run"}, Client: alice})
+
+	want := map[string]bool{
+		"Shuffle plan (dry run):":                    true,
+		"alice: #general -> #random":                 true,
+		"bob: #random -> #general":                   true,
+		"carol: stays in #general, no other channel": true,
+	}
+	reply := alice.sent[len(alice.sent)-1]
+	_, plan, _ := strings.Cut(reply, "Shuffle plan")
+	lines := strings.Split("Shuffle plan"+plan, "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("got plan %q, want %d lines", lines, len(want))
+	}
+	if lines[0] != "Shuffle plan (dry run):" {
+		t.Errorf("plan starts with %q", lines[0])
+	}
+	for _, line := range lines {
+		if !want[line] {
+			t.Errorf("unexpected plan line %q", line)
+		}
+	}
+
+	for session, channelName := range map[*fakeSession]string{alice: "general", bob: "random", carol: "general"} {
+		if session.GetChannel().Name != channelName {
+			t.Errorf("%s was moved to #%s by a dry run", session.GetUsername(), session.GetChannel().Name)
+		}
+	}
+}
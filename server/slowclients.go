@@ -0,0 +1,102 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	slowClientCheckInterval = 5 * time.Second // How often clients are checked for a backed up send queue
+	slowClientWarnInterval  = time.Minute     // Minimum time between warnings logged about the same client
+	maxSlowClientsListed    = 10              // Clients listed by /slowclients
+)
+
+// trackQueueDepth records the depth of the send queue after a message was queued,
+// marking when it went over the slow client threshold
+func (c *Client) trackQueueDepth(depth int) {
+	for {
+		highWater := c.queueHighWater.Load()
+		if int64(depth) <= highWater || c.queueHighWater.CompareAndSwap(highWater, int64(depth)) {
+			break
+		}
+	}
+
+	if depth >= c.server.config.SlowQueueThreshold {
+		c.slowSince.CompareAndSwap(0, time.Now().UnixNano())
+	}
+}
+
+// SlowFor returns how long the client's send queue has been over the slow client threshold, 0 if it isn't
+func (c *Client) SlowFor() time.Duration {
+	since := c.slowSince.Load()
+	if since == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, since))
+}
+
+// IsSlow reports whether the client's send queue has been over the threshold for longer than the server allows
+func (c *Client) IsSlow() bool {
+	slowFor := c.SlowFor()
+	return slowFor > 0 && slowFor >= c.server.config.SlowClientAfter
+}
+
+// isDowngraded reports whether the client is slow enough to only be sent messages of its active channel
+func (s *Server) isDowngraded(client Session) bool {
+	return s.config.DowngradeSlowClients && client.IsSlow()
+}
+
+// slowClients returns the clients whose send queue is over the threshold, the ones that have been slow the longest first
+func (s *Server) slowClients() []*Client {
+	var slow []*Client
+	for _, client := range s.connections {
+		if client.SlowFor() > 0 {
+			slow = append(slow, client)
+		}
+	}
+
+	slices.SortFunc(slow, func(a, b *Client) int {
+		return cmp.Compare(a.slowSince.Load(), b.slowSince.Load())
+	})
+	return slow
+}
+
+// checkSlowClients logs a warning about each slow client, at most once per slowClientWarnInterval for each of them
+func (s *Server) checkSlowClients() {
+	now := time.Now()
+	for _, client := range s.connections {
+		if !client.IsSlow() || now.Sub(client.lastSlowWarning) < slowClientWarnInterval {
+			continue
+		}
+
+		client.lastSlowWarning = now
+		s.log.runLoop.Warn("Slow client", "username", client.GetUsername(), "ip", client.IP, "queued", len(client.send),
+			"queue_high_water", client.queueHighWater.Load(), "slow_for", client.SlowFor().Round(time.Second), "downgraded", s.config.DowngradeSlowClients)
+	}
+}
+
+func listSlowClients(name string, args []string, client Session, server *Server) {
+	slow := server.slowClients()
+	if len(slow) == 0 {
+		client.SendServerMessage("There are no slow clients.")
+		return
+	}
+
+	lines := []string{fmt.Sprintf("Slow clients (%d), send queue over %d of %d messages:", len(slow), server.config.SlowQueueThreshold, server.config.SendQueueSize)}
+	for _, slowClient := range slow[:min(len(slow), maxSlowClientsListed)] {
+		line := fmt.Sprintf("%s (%s): %d queued, high-water %d, slow for %s", slowClient.GetUsername(), slowClient.IP,
+			len(slowClient.send), slowClient.queueHighWater.Load(), formatDuration(slowClient.SlowFor()))
+		if server.isDowngraded(slowClient) {
+			line += " [downgraded]"
+		}
+		lines = append(lines, line)
+	}
+	if len(slow) > maxSlowClientsListed {
+		lines = append(lines, fmt.Sprintf("... and %d more", len(slow)-maxSlowClientsListed))
+	}
+
+	client.SendServerMessage(strings.Join(lines, "\n"))
+}
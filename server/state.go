@@ -0,0 +1,88 @@
+package main
+
+import (
+	"maps"
+)
+
+// stateFile is the file the topics, pins, password hints and hidden flags of channels are saved to. Like its history in the message log,
+// a channel gets them back once it's created again with the same name, e.g. after a restart.
+type stateFile struct {
+	jsonFile
+	version  uint64                  // Incremented every time the state changes, only on the run loop
+	channels map[string]channelState // Saved state by channel name, including the channels that were deleted since
+}
+
+// serverState is what's saved to the state file
+type serverState struct {
+	Channels map[string]channelState `json:"channels"`
+}
+
+// channelState is what's saved of a channel
+type channelState struct {
+	Topic        ChannelTopic `json:"topic,omitzero"`
+	Pins         []Pin        `json:"pins,omitempty"`
+	PasswordHint string       `json:"password_hint,omitempty"`
+	Hidden       bool         `json:"hidden,omitempty"`
+}
+
+// isZero reports whether there's nothing to save of the channel
+func (cs channelState) isZero() bool {
+	return cs.Topic.Text == "" && len(cs.Pins) == 0 && cs.PasswordHint == "" && !cs.Hidden
+}
+
+// loadState reads the state saved to the state file, if there's one
+func (s *Server) loadState() error {
+	if s.state == nil {
+		return nil
+	}
+
+	var state serverState
+	if err := readJSONFile(s.state.path, &state); err != nil {
+		return err
+	}
+	s.state.channels = state.Channels
+	if s.state.channels == nil {
+		s.state.channels = make(map[string]channelState)
+	}
+	return nil
+}
+
+// restoreChannelState sets up a new channel with the state saved for a channel with the same name
+func (s *Server) restoreChannelState(channel *Channel) {
+	if s.state == nil {
+		return
+	}
+
+	if saved, exists := s.state.channels[channel.Name]; exists {
+		channel.restoreTopic(saved.Topic)
+		channel.restorePins(saved.Pins)
+		channel.SetPasswordHint(saved.PasswordHint)
+		channel.SetHidden(saved.Hidden)
+	}
+}
+
+// saveChannelState writes the state of the channel to the state file off the run loop, if there's one
+func (s *Server) saveChannelState(channel *Channel) {
+	if s.state == nil {
+		return
+	}
+
+	state := channelState{Topic: channel.Topic(), Pins: channel.Pins(), PasswordHint: channel.PasswordHint(), Hidden: channel.IsHidden()}
+	if state.isZero() {
+		delete(s.state.channels, channel.Name)
+	} else {
+		s.state.channels[channel.Name] = state
+	}
+
+	s.state.version++
+	version := s.state.version
+	snapshot := serverState{Channels: maps.Clone(s.state.channels)}
+	s.runOffLoop(func() func() {
+		err := s.state.write(version, snapshot)
+		return func() {
+			if err != nil {
+				s.log.storage.Error("Failed to save state file", "file", s.state.path, "error", err)
+			}
+		}
+	})
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestStateFile(t *testing.T) {
+	tests := []struct {
+		name       string
+		commands   []Command
+		want       []string // Texts of the pins the channel has once created again
+		wantTopic  string
+		wantHint   string
+		wantHidden bool
+	}{
+		{name: "pin", commands: []Command{{Name: "pin", Args: []string{"first"}}, {Name: "pin", Args: []string{"second"}}}, want: []string{"first", "second"}},
+		{name: "unpin", commands: []Command{{Name: "pin", Args: []string{"first"}}, {Name: "pin", Args: []string{"second"}}, {Name: "unpin", Args: []string{"1"}}}, want: []string{"second"}},
+		{name: "unpin the last one", commands: []Command{{Name: "pin", Args: []string{"first"}}, {Name: "unpin", Args: []string{"1"}}}},
+		{name: "topic", commands: []Command{{Name: "topic", Args: []string{"all", "about", "cats"}}}, wantTopic: "all about cats"},
+		{name: "topic and pins", commands: []Command{{Name: "topic", Args: []string{"cats"}}, {Name: "pin", Args: []string{"first"}}}, want: []string{"first"}, wantTopic: "cats"},
+		{name: "topic cleared", commands: []Command{{Name: "topic", Args: []string{"cats"}}, {Name: "topic-clear"}}},
+		{name: "password hint", commands: []Command{{Name: "sethint", Args: []string{"the", "usual"}}}, wantHint: "the usual"},
+		{name: "password hint removed", commands: []Command{{Name: "sethint", Args: []string{"the", "usual"}}, {Name: "removehint"}}},
+		{name: "hidden", commands: []Command{{Name: "hidechannel"}}, wantHidden: true},
+		{name: "unhidden", commands: []Command{{Name: "hidechannel"}, {Name: "unhidechannel"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "state.json")
+			configure := func(config *Config) { config.StateFile = path }
+
+			server := newTestServer(t, configure)
+			alice := connect(server, "alice")
+			if _, err := server.enterChannel(alice, "general", "hunter2"); err != nil {
+				t.Fatal(err)
+			}
+			for _, command := range test.commands {
+				command.Client, command.ReceivedAt = alice, time.Now()
+				server.runCommand(command)
+			}
+			finishWork(t, server)
+
+			// The topic, pins, hint and hidden flag are restored by a new server, as after a restart
+			restarted := newTestServer(t, configure)
+			channel, err := restarted.enterChannel(connect(restarted, "bob"), "general", "hunter2")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, pin := range channel.Pins() {
+				if pin.PinnedBy != "alice" || pin.PinnedAt.IsZero() {
+					t.Errorf("pin %q was restored as pinned by '%s' at %s", pin.Text, pin.PinnedBy, pin.PinnedAt)
+				}
+				got = append(got, pin.Text)
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("restored pins %q, want %q", got, test.want)
+			}
+			if topic := channel.Topic(); topic.Text != test.wantTopic || (topic.Text != "" && (topic.SetBy != "alice" || topic.SetAt.IsZero())) {
+				t.Errorf("restored the topic %+v, want %q set by alice", topic, test.wantTopic)
+			}
+			if hint := channel.PasswordHint(); hint != test.wantHint {
+				t.Errorf("restored the password hint %q, want %q", hint, test.wantHint)
+			}
+			if hidden := channel.IsHidden(); hidden != test.wantHidden {
+				t.Errorf("restored the channel hidden: %t, want %t", hidden, test.wantHidden)
+			}
+
+			if other := join(t, restarted, connect(restarted, "carol"), "random"); other.HasPins() || other.Topic().Text != "" || other.PasswordHint() != "" || other.IsHidden() {
+				t.Errorf("'random' got the topic %+v, the pins %v, the hint %q and hidden: %t", other.Topic(), other.Pins(), other.PasswordHint(), other.IsHidden())
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"slices"
+	"time"
+)
+
+// sweepReport is what a sweep removed
+type sweepReport struct {
+	Channels    []string // Stale persistent channels that were deleted
+	InviteCodes int      // Invite codes deleted after expiring
+	Mutes       int      // Expired mutes cleared
+	MessageLogs int      // Message log files closed because their channel was deleted
+	AuthLimits  int      // Limits of /ghost and /login attempts forgotten once refilled
+}
+
+// sweep removes what would otherwise pile up forever: persistent channels that have been empty and unused for
+// longer than the stale channel age, invite codes expired for longer than their retention, expired mutes, the
+// open message logs of deleted channels, and the limits of login attempts that refilled. Runs on the run loop, with
+// now passed in so it can be fast-forwarded.
+func (s *Server) sweep(now time.Time) sweepReport {
+	var report sweepReport
+
+	// Channels that aren't persistent are deleted as soon as they are empty
+	if s.config.PersistentChannels && s.config.StaleChannelAge > 0 {
+		for _, channel := range s.channels {
+			if !channel.IsEmpty() || now.Sub(channel.LastActivity()) < s.config.StaleChannelAge {
+				continue
+			}
+
+			s.deleteChannel(channel)
+			report.Channels = append(report.Channels, channel.Name)
+		}
+		slices.Sort(report.Channels)
+	}
+
+	for _, channel := range s.channels {
+		report.InviteCodes += channel.PruneInviteCodes(now.Add(-s.config.InviteCodeRetention))
+	}
+
+	for _, client := range s.clients {
+		if client.clearExpiredMute(now) {
+			report.Mutes++
+		}
+	}
+
+	if s.messageLog != nil {
+		report.MessageLogs = s.messageLog.Prune(func(channel string) bool {
+			_, exists := s.channels[channel]
+			return exists
+		})
+	}
+	report.AuthLimits = s.pruneAuthAttempts(now)
+	return report
+}
+
+// runSweep sweeps and logs what was removed
+func (s *Server) runSweep(now time.Time) {
+	report := s.sweep(now)
+	s.log.runLoop.Info("Swept stale state", "channels", report.Channels, "invite_codes", report.InviteCodes, "mutes", report.Mutes,
+		"message_logs", report.MessageLogs, "auth_limits", report.AuthLimits)
+}